@@ -0,0 +1,449 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Define the settings for passwords and sessions
+const (
+	minPasswordLength  = 8
+	passwordIterations = 100000
+	passwordSaltLength = 16
+	passwordHashLength = 32
+	sessionTokenLength = 32
+	sessionCookie      = "session"
+	sessionLifetime    = 30 * 24 * time.Hour
+	maxRequestBody     = 1 << 20
+)
+
+// dummySalt is the salt used to hash passwords given with unknown
+// usernames.
+var dummySalt = make([]byte, passwordSaltLength)
+
+// usernamePattern matches a valid username.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// Define the errors returned by AccountDb, whose messages are shown to the
+// user
+var (
+	errUsernameInvalid = errors.New("Usernames must be 3 to 32 letters, " +
+		"numbers, dots, dashes or underscores.")
+	errPasswordInvalid = errors.New("Passwords must be at least 8 " +
+		"characters.")
+	errUsernameTaken = errors.New("That username is already taken.")
+	errLoginFailed   = errors.New("The username or password is not correct.")
+	errNoSession     = errors.New("You are not logged in.")
+)
+
+// Account holds the details of a user account.
+type Account struct {
+	ID       int64  `json:"-"`
+	Username string `json:"username"`
+}
+
+// AccountDb encapsulates the sqlite database of user accounts, their
+// sessions and their saved selections.
+type AccountDb struct {
+	db *sql.DB
+}
+
+// NewAccountDb returns a new AccountDb with the database initialised. The
+// database and its tables are created if they do not exist.
+func NewAccountDb(dbPath string) *AccountDb {
+
+	// Create a database handle
+	dbHandle, err := sql.Open("sqlite3", dbPath)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the tables
+	for _, statement := range []string{
+		"CREATE TABLE IF NOT EXISTS users (" +
+			"id INTEGER PRIMARY KEY, " +
+			"username TEXT NOT NULL UNIQUE COLLATE NOCASE, " +
+			"salt BLOB NOT NULL, hash BLOB NOT NULL, " +
+			"created INTEGER NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS sessions (" +
+			"token TEXT PRIMARY KEY, " +
+			"user_id INTEGER NOT NULL REFERENCES users(id), " +
+			"expires INTEGER NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS saved_selections (" +
+			"id INTEGER PRIMARY KEY, " +
+			"user_id INTEGER NOT NULL REFERENCES users(id), " +
+			"name TEXT NOT NULL, zones TEXT NOT NULL, " +
+			"created INTEGER NOT NULL, updated INTEGER NOT NULL, " +
+			"UNIQUE (user_id, name))",
+	} {
+
+		_, err = dbHandle.Exec(statement)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return &AccountDb{db: dbHandle}
+}
+
+// Close closes the database handle held by the AccountDb.
+func (a *AccountDb) Close() {
+
+	a.db.Close()
+}
+
+// Register creates an account with the given username and password.
+func (a *AccountDb) Register(username string,
+	password string) (*Account, error) {
+
+	if !usernamePattern.MatchString(username) {
+		return nil, errUsernameInvalid
+	}
+
+	if len(password) < minPasswordLength {
+		return nil, errPasswordInvalid
+	}
+
+	salt, err := randomBytes(passwordSaltLength)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashPassword(password, salt)
+	result, err := a.db.Exec("INSERT INTO users (username, salt, hash, "+
+		"created) SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM users "+
+		"WHERE username = ?)", username, salt, hash, time.Now().Unix(),
+		username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if count, err := result.RowsAffected(); err != nil || count == 0 {
+		return nil, errUsernameTaken
+	}
+
+	return a.Login(username, password)
+}
+
+// Login returns the account with the given username if the password is
+// correct, or errLoginFailed otherwise. The password is hashed even if there
+// is no such user, so that the time taken does not show which usernames
+// exist.
+func (a *AccountDb) Login(username string, password string) (*Account, error) {
+
+	account := &Account{}
+	var salt, hash []byte
+
+	err := a.db.QueryRow("SELECT id, username, salt, hash FROM users "+
+		"WHERE username = ?", username).Scan(&account.ID, &account.Username,
+		&salt, &hash)
+
+	if err == sql.ErrNoRows {
+
+		hashPassword(password, dummySalt)
+		return nil, errLoginFailed
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(hashPassword(password, salt), hash) != 1 {
+		return nil, errLoginFailed
+	}
+
+	return account, nil
+}
+
+// StartSession creates a session for the account and returns its token and
+// the time at which it expires.
+func (a *AccountDb) StartSession(account *Account) (string, time.Time,
+	error) {
+
+	token, err := randomBytes(sessionTokenLength)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expires := now.Add(sessionLifetime)
+	tokenString := base64.RawURLEncoding.EncodeToString(token)
+
+	// Remove expired sessions while adding the new one
+	_, err = a.db.Exec("DELETE FROM sessions WHERE expires < ?", now.Unix())
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	_, err = a.db.Exec("INSERT INTO sessions (token, user_id, expires) "+
+		"VALUES (?, ?, ?)", tokenString, account.ID, expires.Unix())
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expires, nil
+}
+
+// SessionAccount returns the account logged in with the given session token,
+// or errNoSession if the session does not exist or has expired.
+func (a *AccountDb) SessionAccount(token string) (*Account, error) {
+
+	account := &Account{}
+
+	err := a.db.QueryRow("SELECT users.id, users.username FROM sessions "+
+		"JOIN users ON users.id = sessions.user_id "+
+		"WHERE sessions.token = ? AND sessions.expires >= ?", token,
+		time.Now().Unix()).Scan(&account.ID, &account.Username)
+
+	if err == sql.ErrNoRows {
+		return nil, errNoSession
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// EndSession removes the session with the given token.
+func (a *AccountDb) EndSession(token string) error {
+
+	_, err := a.db.Exec("DELETE FROM sessions WHERE token = ?", token)
+	return err
+}
+
+// requestAccount returns the account logged in with the session cookie sent
+// with the request, or errNoSession if there is none.
+func (a *AccountDb) requestAccount(r *http.Request) (*Account, error) {
+
+	cookie, err := r.Cookie(sessionCookie)
+
+	if err != nil || cookie.Value == "" {
+		return nil, errNoSession
+	}
+
+	return a.SessionAccount(cookie.Value)
+}
+
+// randomBytes returns the given number of random bytes.
+func randomBytes(length int) ([]byte, error) {
+
+	b := make([]byte, length)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// hashPassword derives the hash of a password from the password and salt.
+func hashPassword(password string, salt []byte) []byte {
+
+	return pbkdf2(password, salt, passwordIterations, passwordHashLength)
+}
+
+// pbkdf2 derives a key of the given length from the password and salt with
+// PBKDF2, as in RFC 8018, using HMAC-SHA256 as the pseudorandom function.
+func pbkdf2(password string, salt []byte, iterations int, length int) []byte {
+
+	prf := hmac.New(sha256.New, []byte(password))
+	hash := []byte{}
+
+	for block := uint32(1); len(hash) < length; block++ {
+
+		// The first round hashes the salt and the block number
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+
+		// Each later round hashes the previous round, and the rounds are
+		// combined with xor
+		for i := 1; i < iterations; i++ {
+
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		hash = append(hash, t...)
+	}
+
+	return hash[:length]
+}
+
+// accountRequest is the expected shape of a JSON request body sent to the
+// AccountHandler.
+type accountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// AccountHandler handles requests to register, log in and log out, and to
+// find the account that is logged in.
+type AccountHandler struct {
+	accounts *AccountDb
+	prefix   string
+}
+
+// NewAccountHandler returns a new AccountHandler with the values initialised.
+// Requests are expected at the given path prefix.
+func NewAccountHandler(prefix string, accounts *AccountDb) *AccountHandler {
+
+	return &AccountHandler{
+		accounts: accounts,
+		prefix:   prefix,
+	}
+}
+
+// ServeHTTP expects a POST request with a JSON body holding a username and
+// password at {prefix}/register or {prefix}/login, which starts a session
+// and sets the session cookie, or a POST request at {prefix}/logout, which
+// ends the session. A GET request at the prefix returns the account that is
+// logged in. Responses and errors are sent as JSON.
+func (h *AccountHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, h.prefix), "/")
+
+	switch {
+	case action == "" && r.Method == "GET":
+
+		account, err := h.accounts.requestAccount(r)
+
+		if err != nil {
+
+			serveAccountError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, account)
+
+	case action == "logout" && r.Method == "POST":
+
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+
+			err = h.accounts.EndSession(cookie.Value)
+
+			if err != nil {
+
+				serveAccountError(w, err)
+				return
+			}
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/",
+			MaxAge: -1, HttpOnly: true})
+
+		w.WriteHeader(http.StatusNoContent)
+
+	case (action == "register" || action == "login") && r.Method == "POST":
+
+		h.login(w, r, action == "register")
+
+	case action == "" || action == "logout" || action == "register" ||
+		action == "login":
+
+		writeAPIError(w, http.StatusMethodNotAllowed,
+			"That method is not supported.")
+
+	default:
+
+		writeAPIError(w, http.StatusNotFound, "Not found.")
+	}
+}
+
+// login registers or logs in the user named in the request body, and starts
+// a session for them.
+func (h *AccountHandler) login(w http.ResponseWriter, r *http.Request,
+	register bool) {
+
+	var body accountRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+		maxRequestBody)).Decode(&body)
+
+	if err != nil {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"Could not parse the request: invalid JSON body.")
+
+		return
+	}
+
+	var account *Account
+
+	if register {
+		account, err = h.accounts.Register(body.Username, body.Password)
+	} else {
+		account, err = h.accounts.Login(body.Username, body.Password)
+	}
+
+	if err != nil {
+
+		serveAccountError(w, err)
+		return
+	}
+
+	token, expires, err := h.accounts.StartSession(account)
+
+	if err != nil {
+
+		serveAccountError(w, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	writeJSON(w, http.StatusOK, account)
+}
+
+// serveAccountError writes an APIError for an error returned by the
+// AccountDb, with a status that depends on the error.
+func serveAccountError(w http.ResponseWriter, err error) {
+
+	switch err {
+	case errUsernameInvalid, errPasswordInvalid:
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	case errUsernameTaken:
+		writeAPIError(w, http.StatusConflict, err.Error())
+	case errLoginFailed, errNoSession:
+		writeAPIError(w, http.StatusUnauthorized, err.Error())
+	case errSavedSelectionNotFound:
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	case errSelectionNameTaken:
+		writeAPIError(w, http.StatusConflict, err.Error())
+	case errSelectionNameInvalid, errSelectionZonesInvalid:
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError,
+			"Could not complete the request with the AccountDb.")
+	}
+}
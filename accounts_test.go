@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Test AccountDb registers and logs in users, and rejects invalid usernames
+// and passwords, taken usernames and wrong passwords.
+func TestAccountDb(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "popbuilder")
+
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	adb := NewAccountDb(filepath.Join(dir, "accounts.db"))
+	defer adb.Close()
+
+	account, err := adb.Register("analyst", "correct horse")
+
+	if err != nil || account.Username != "analyst" {
+		t.Fatalf("Expected an account from Register. Got: %+v %v",
+			account, err)
+	}
+
+	for _, test := range []struct {
+		username string
+		password string
+		expected error
+	}{
+		{"an", "correct horse", errUsernameInvalid},
+		{"an alyst", "correct horse", errUsernameInvalid},
+		{"other", "short", errPasswordInvalid},
+		{"Analyst", "correct horse", errUsernameTaken},
+	} {
+
+		if _, err := adb.Register(test.username, test.password); err !=
+			test.expected {
+
+			t.Errorf("Expected %q from Register for %s. Got: %v",
+				test.expected, test.username, err)
+		}
+	}
+
+	if login, err := adb.Login("analyst", "correct horse"); err != nil ||
+		login.ID != account.ID {
+
+		t.Errorf("Expected the account from Login. Got: %+v %v", login, err)
+	}
+
+	if _, err := adb.Login("analyst", "wrong horse"); err != errLoginFailed {
+		t.Errorf("Expected errLoginFailed for a wrong password. Got: %v", err)
+	}
+
+	// Sessions find their account until they are ended
+	token, _, err := adb.StartSession(account)
+
+	if err != nil {
+		t.Fatalf("Could not start a session: %s", err)
+	}
+
+	if session, err := adb.SessionAccount(token); err != nil ||
+		session.ID != account.ID {
+
+		t.Errorf("Expected the account from SessionAccount. Got: %+v %v",
+			session, err)
+	}
+
+	adb.EndSession(token)
+
+	if _, err := adb.SessionAccount(token); err != errNoSession {
+		t.Errorf("Expected errNoSession for an ended session. Got: %v", err)
+	}
+}
+
+// Test pbkdf2 derives the known keys for PBKDF2-HMAC-SHA256 given in RFC 7914
+// and in the test vectors for SHA-256 in the style of RFC 6070.
+func TestPBKDF2(t *testing.T) {
+
+	for _, test := range []struct {
+		password   string
+		salt       string
+		iterations int
+		key        string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605" +
+			"f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef31" +
+			"7c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9" +
+			"641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b317" +
+			"6a272bdebba1d078478f62b397f33c8d"},
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837" +
+			"a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd0" +
+			"2a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d" +
+			"962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt",
+			4096, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1f" +
+				"b8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, "89b69d0516f829893c696226650a8687"},
+	} {
+
+		key := hex.EncodeToString(pbkdf2(test.password, []byte(test.salt),
+			test.iterations, len(test.key)/2))
+
+		if key != test.key {
+			t.Errorf("Expected %s from pbkdf2 for %q and %q. Got: %s",
+				test.key, test.password, test.salt, key)
+		}
+	}
+}
+
+// Test the account and saved selection handlers create, list, rename, update
+// and delete a user's selections, and keep them from other users.
+func TestSavedSelectionsHandler(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "popbuilder")
+
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	adb := NewAccountDb(filepath.Join(dir, "accounts.db"))
+	defer adb.Close()
+
+	ah := NewAccountHandler("/api/v1/account", adb)
+	sh := NewSavedSelectionsHandler("/api/v1/selections", adb, nil)
+
+	// send makes a request with an optional session cookie and JSON body
+	send := func(h http.Handler, method string, path string, cookie string,
+		body interface{}) *httptest.ResponseRecorder {
+
+		var reader *bytes.Reader
+
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			reader = bytes.NewReader(encoded)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+
+		request, _ := http.NewRequest(method, path, reader)
+		request.Header.Set("Content-Type", "application/json")
+
+		if cookie != "" {
+			request.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+		}
+
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		return response
+	}
+
+	// login registers a user and returns their session token
+	login := func(username string) string {
+
+		response := send(ah, "POST", "/api/v1/account/register", "",
+			&accountRequest{Username: username, Password: "password1"})
+
+		for _, cookie := range response.Result().Cookies() {
+
+			if cookie.Name == sessionCookie {
+				return cookie.Value
+			}
+		}
+
+		t.Fatalf("Expected a session cookie from register. Got: %d %s",
+			response.Code, response.Body.String())
+
+		return ""
+	}
+
+	first := login("first")
+	second := login("second")
+
+	if response := send(ah, "GET", "/api/v1/account", first, nil); !strings.
+		Contains(response.Body.String(), `"username":"first"`) {
+
+		t.Errorf("Expected the account from AccountHandler. Got: %s",
+			response.Body.String())
+	}
+
+	// Create a selection and check the list
+	response := send(sh, "POST", "/api/v1/selections", first,
+		&savedSelectionRequest{Name: "Catchment", Zones: []string{
+			"e01000002", "E01000001"}})
+
+	if response.Code != http.StatusCreated {
+		t.Fatalf("Expected StatusCreated from SavedSelectionsHandler. "+
+			"Got: %d %s", response.Code, response.Body.String())
+	}
+
+	created := &SavedSelection{}
+	json.Unmarshal(response.Body.Bytes(), created)
+	path := "/api/v1/selections/" + strconv.FormatInt(created.ID, 10)
+
+	response = send(sh, "GET", "/api/v1/selections", first, nil)
+	list := []*SavedSelection{}
+	json.Unmarshal(response.Body.Bytes(), &list)
+
+	if len(list) != 1 || list[0].Name != "Catchment" ||
+		strings.Join(list[0].Zones, ",") != "E01000001,E01000002" {
+
+		t.Errorf("Expected one normalised selection in the list. Got: %s",
+			response.Body.String())
+	}
+
+	// Rename the selection, then replace its zones
+	send(sh, "PUT", path, first, &savedSelectionRequest{Name: "Renamed"})
+	response = send(sh, "PUT", path, first,
+		&savedSelectionRequest{Zones: []string{"W01000001"}})
+
+	updated := &SavedSelection{}
+	json.Unmarshal(response.Body.Bytes(), updated)
+
+	if updated.Name != "Renamed" || strings.Join(updated.Zones, ",") !=
+		"W01000001" {
+
+		t.Errorf("Expected the renamed and updated selection. Got: %s",
+			response.Body.String())
+	}
+
+	// Check the errors for invalid requests
+	for _, test := range []struct {
+		method   string
+		path     string
+		cookie   string
+		body     interface{}
+		expected int
+	}{
+		{"GET", "/api/v1/selections", "", nil, http.StatusUnauthorized},
+		{"GET", path, second, nil, http.StatusNotFound},
+		{"DELETE", path, second, nil, http.StatusNotFound},
+		{"POST", "/api/v1/selections", first, &savedSelectionRequest{
+			Name: "Renamed", Zones: []string{"E01000001"}},
+			http.StatusConflict},
+		{"POST", "/api/v1/selections", first, &savedSelectionRequest{
+			Name: "Bad", Zones: []string{"E06000001"}},
+			http.StatusBadRequest},
+		{"POST", "/api/v1/selections", first, &savedSelectionRequest{
+			Name: " ", Zones: []string{"E01000001"}},
+			http.StatusBadRequest},
+		{"PATCH", path, first, nil, http.StatusMethodNotAllowed},
+	} {
+
+		response := send(sh, test.method, test.path, test.cookie, test.body)
+
+		if response.Code != test.expected {
+			t.Errorf("Expected status %d for %s %s. Got: %d %s",
+				test.expected, test.method, test.path, response.Code,
+				response.Body.String())
+		}
+	}
+
+	// Delete the selection, then log out
+	if response := send(sh, "DELETE", path, first, nil); response.Code !=
+		http.StatusNoContent {
+
+		t.Errorf("Expected StatusNoContent from DELETE. Got: %d",
+			response.Code)
+	}
+
+	if response := send(sh, "GET", path, first, nil); response.Code !=
+		http.StatusNotFound {
+
+		t.Errorf("Expected StatusNotFound for a deleted selection. Got: %d",
+			response.Code)
+	}
+
+	send(ah, "POST", "/api/v1/account/logout", first, nil)
+
+	if response := send(sh, "GET", "/api/v1/selections", first,
+		nil); response.Code != http.StatusUnauthorized {
+
+		t.Errorf("Expected StatusUnauthorized after logging out. Got: %d",
+			response.Code)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bandPattern matches one age band in a bands parameter: a single age, a
+// range of ages, or an age and over.
+var bandPattern = regexp.MustCompile(`^([0-9]+)(?:-([0-9]+)|(\+))?$`)
+
+// label returns the name of the age band as shown to the user, such as
+// "0-15", "65+" or "5".
+func (b ageBand) label() string {
+
+	switch {
+	case b.max == maxAge:
+		return fmt.Sprintf("%d+", b.min)
+	case b.min == b.max:
+		return strconv.Itoa(b.min)
+	}
+
+	return fmt.Sprintf("%d-%d", b.min, b.max)
+}
+
+// column returns the suffix of the age band used in csv headers, such as
+// "0_15", "65_plus" or "5".
+func (b ageBand) column() string {
+
+	return strings.Replace(strings.Replace(b.label(), "-", "_", 1),
+		"+", "_plus", 1)
+}
+
+// parseAgeBands parses a comma separated list of age bands, such as
+// "0-15,16-64,65+". The bands must be in ascending order and must not
+// overlap, but need not cover every age.
+func parseAgeBands(value string) ([]ageBand, error) {
+
+	bands := []ageBand{}
+
+	for _, part := range strings.Split(value, ",") {
+
+		part = strings.Replace(part, " ", "", -1)
+		match := bandPattern.FindStringSubmatch(part)
+
+		if match == nil {
+			return nil, errors.New("the age band " + part + " is not valid")
+		}
+
+		band := ageBand{}
+		band.min, _ = strconv.Atoi(match[1])
+		band.max = band.min
+
+		switch {
+		case match[2] != "":
+			band.max, _ = strconv.Atoi(match[2])
+		case match[3] != "":
+			band.max = maxAge
+		}
+
+		if band.max < band.min || band.max > maxAge {
+			return nil, errors.New("the age band " + part + " is not valid")
+		}
+
+		if len(bands) > 0 && band.min <= bands[len(bands)-1].max {
+			return nil, errors.New("the age bands must be in ascending " +
+				"order and must not overlap")
+		}
+
+		band.suffix = band.column()
+		bands = append(bands, band)
+	}
+
+	return bands, nil
+}
+
+// AgeData holds the population by single year of age, where the value for
+// maxAge includes everyone of that age and over.
+type AgeData struct {
+	Code   string
+	Year   int
+	Male   [maxAge + 1]int64
+	Female [maxAge + 1]int64
+}
+
+// Bands returns the population in each of the given age bands.
+func (a *AgeData) Bands(bands []ageBand) []PopulationBand {
+
+	result := []PopulationBand{}
+
+	for _, band := range bands {
+
+		male := sumAges(&a.Male, band)
+		female := sumAges(&a.Female, band)
+		result = append(result, PopulationBand{
+			Group:   band.label(),
+			Persons: male + female,
+			Male:    male,
+			Female:  female,
+		})
+	}
+
+	return result
+}
+
+// Indicators returns the summary indicators for the AgeData, calculated from
+// single years of age.
+func (a *AgeData) Indicators() *Indicators {
+
+	return newIndicators(singleYearBands, a.Bands(singleYearBands))
+}
+
+// AgeDb encapsulates the sqlite database of the population by single year of
+// age, which is used to aggregate the population into custom age bands.
+type AgeDb struct {
+	*populationYears
+	db        *sql.DB
+	geography *Geography
+	columns   []string
+}
+
+// NewAgeDb returns a new AgeDb with the database initialised.
+func NewAgeDb(dbPath string) *AgeDb {
+
+	// Create a database handle
+	dbHandle, err := sql.Open("sqlite3", dbPath)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Find the estimate years held in the database
+	years, err := readPopulationYears(dbHandle)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the hierarchy of areas, if the database holds the lookup tables
+	geography, err := readGeography(dbHandle)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// List the columns for males then females at each age
+	columns := []string{}
+
+	for _, prefix := range []string{"m", "f"} {
+
+		for _, band := range singleYearBands {
+			columns = append(columns, prefix+"_"+band.suffix)
+		}
+	}
+
+	return &AgeDb{
+		populationYears: years,
+		db:              dbHandle,
+		geography:       geography,
+		columns:         columns,
+	}
+}
+
+// Close closes the database handle held by the AgeDb.
+func (a *AgeDb) Close() {
+
+	a.db.Close()
+}
+
+// Geography returns the hierarchy of areas held in the database, or nil if
+// the database does not hold the lookup tables.
+func (a *AgeDb) Geography() *Geography {
+
+	return a.geography
+}
+
+// UseGeography sets the hierarchy of areas used to expand the codes of
+// countries, regions and districts into zones, for databases that do not
+// hold the lookup tables.
+func (a *AgeDb) UseGeography(geography *Geography) {
+
+	a.geography = geography
+}
+
+// CheckZones reports which of the given zones are in the population table
+// for the given year. The codes of countries, regions and districts are
+// expanded into their zones.
+func (a *AgeDb) CheckZones(zones []string, year int) (*ZoneReport, error) {
+
+	return checkZones(a.db, a.populationYears, a.geography, year, zones)
+}
+
+// GetYearAgeData returns the total population by single year of age of the
+// given zones for the given year. The zones should already have been
+// checked, and each zone is only counted once.
+func (a *AgeDb) GetYearAgeData(zones []string, year int) (*AgeData, error) {
+
+	total := &AgeData{Year: year}
+	rows, err := a.GetZoneAgeData(zones, year)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+
+		for age := 0; age <= maxAge; age++ {
+
+			total.Male[age] += row.Male[age]
+			total.Female[age] += row.Female[age]
+		}
+	}
+
+	return total, nil
+}
+
+// GetReferenceAgeData returns the population by single year of age of the
+// given reference area for the given year.
+func (a *AgeDb) GetReferenceAgeData(area *ReferenceArea,
+	year int) (*AgeData, error) {
+
+	if area.zones != nil {
+		return a.GetYearAgeData(area.zones, year)
+	}
+
+	// Sum zones by code prefix for Great Britain and the countries
+	sums := []string{}
+
+	for _, column := range a.columns {
+		sums = append(sums, "sum("+column+")")
+	}
+
+	condition, args := a.yearCondition(year)
+	args = append(args, area.prefix+"%")
+
+	values := make([]sql.NullInt64, 2*(maxAge+1))
+	dest := make([]interface{}, len(values))
+
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	query := "SELECT " + strings.Join(sums, ", ") +
+		" FROM population WHERE " + condition + "code LIKE ?"
+	err := a.db.QueryRow(query, args...).Scan(dest...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	total := &AgeData{Year: year}
+
+	for age := 0; age <= maxAge; age++ {
+
+		total.Male[age] = values[age].Int64
+		total.Female[age] = values[maxAge+1+age].Int64
+	}
+
+	return total, nil
+}
+
+// GetZoneAgeData returns the population by single year of age of each of the
+// given zones for the given year.
+func (a *AgeDb) GetZoneAgeData(zones []string, year int) ([]*AgeData, error) {
+
+	results := []*AgeData{}
+
+	// Remove duplicate zones so that no zone is returned twice across chunks
+	unique := []string{}
+	seen := map[string]bool{}
+
+	for _, zone := range zones {
+
+		if !seen[zone] {
+
+			seen[zone] = true
+			unique = append(unique, zone)
+		}
+	}
+
+	// Query each chunk of zones and add the rows to the results
+	condition, conditionArgs := a.yearCondition(year)
+
+	for _, chunk := range chunkZones(unique) {
+
+		query, args := inQuery("SELECT code, "+strings.Join(a.columns, ", ")+
+			" FROM population WHERE "+condition+"code IN (", chunk,
+			conditionArgs...)
+
+		rows, err := a.scanAgeData(query, args, year)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, rows...)
+	}
+
+	return results, nil
+}
+
+// scanAgeData executes a query for a chunk of zones and returns the AgeData
+// for each zone found.
+func (a *AgeDb) scanAgeData(query string, args []interface{},
+	year int) ([]*AgeData, error) {
+
+	rows, err := a.db.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	results := []*AgeData{}
+
+	for rows.Next() {
+
+		row := &AgeData{Year: year}
+		dest := []interface{}{&row.Code}
+
+		for age := 0; age <= maxAge; age++ {
+			dest = append(dest, &row.Male[age])
+		}
+
+		for age := 0; age <= maxAge; age++ {
+			dest = append(dest, &row.Female[age])
+		}
+
+		err = rows.Scan(dest...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, row)
+	}
+
+	return results, rows.Err()
+}
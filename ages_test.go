@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/olihawkins/handlers"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test parseAgeBands with valid and invalid lists of bands.
+func TestParseAgeBands(t *testing.T) {
+
+	bands, err := parseAgeBands("0-15, 16-64,65+")
+
+	if err != nil || len(bands) != 3 {
+		t.Fatalf("Expected three bands from parseAgeBands. Got: %v %v",
+			bands, err)
+	}
+
+	expected := []struct {
+		band   ageBand
+		label  string
+		column string
+	}{
+		{ageBand{"0_15", 0, 15}, "0-15", "0_15"},
+		{ageBand{"16_64", 16, 64}, "16-64", "16_64"},
+		{ageBand{"65_plus", 65, maxAge}, "65+", "65_plus"},
+	}
+
+	for i, e := range expected {
+
+		if bands[i] != e.band || bands[i].label() != e.label ||
+			bands[i].column() != e.column {
+
+			t.Errorf("Expected %+v (%s) from parseAgeBands. Got: %+v (%s)",
+				e.band, e.label, bands[i], bands[i].label())
+		}
+	}
+
+	for _, invalid := range []string{
+		"", "16-64,0-15", "0-15,15-64", "5-3", "91+", "0-91", "a", "0-15,",
+	} {
+
+		if _, err := parseAgeBands(invalid); err == nil {
+			t.Errorf("Expected an error from parseAgeBands for %q.", invalid)
+		}
+	}
+}
+
+// Test ResultsHandler and DownloadHandler re-aggregate the single year of
+// age data into custom bands. The databases are built from test source
+// files.
+func TestAgeBandsHandlers(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	codes := []string{"E01000001", "W01000001"}
+	buildTestDbs(t, dir, codes)
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	ddb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer ddb.Close()
+
+	adb := NewAgeDb(testDbPath(dir, agesDbPath))
+	defer adb.Close()
+
+	errorHandler := handlers.LoadErrorHandler(errorPath, "", true)
+
+	// Males aged 0 to 15 sum to 120 in each zone, and the 90 and over
+	// band holds 90 in each zone
+	form := url.Values{}
+	form.Add("zones", strings.Join(codes, ","))
+	form.Add("bands", "0-15,16-64,65-89,90+")
+
+	rh := NewResultsHandler(resultsPath, rdb, adb, nil, errorHandler)
+	request, _ := http.NewRequest("POST", "/results",
+		strings.NewReader(form.Encode()))
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+
+	rh.ServeHTTP(response, request)
+	bodyString := response.Body.String()
+
+	for _, expected := range []string{
+		"{group: '0-15', male: 240 , female: 240 }",
+		"{group: '90\\u002b', male: 180 , female: 180 }",
+	} {
+
+		if !strings.Contains(bodyString, expected) {
+			t.Errorf("Expected %q in body from ResultsHandler. Got: %s",
+				expected, bodyString)
+		}
+	}
+
+	// Persons aged 0 to 4 are 20 in each zone
+	dh := NewDownloadHandler(downloadPath, ddb, adb, errorHandler)
+	form.Set("bands", "0-4,90+")
+	request, _ = http.NewRequest("POST", "/download",
+		strings.NewReader(form.Encode()))
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response = httptest.NewRecorder()
+
+	dh.ServeHTTP(response, request)
+	lines := strings.Split(response.Body.String(), "\n")
+
+	if len(lines) < 3 || !strings.HasPrefix(lines[0],
+		"year,code,people_0_4,people_90_plus,male_0_4,male_90_plus,"+
+			"female_0_4,female_90_plus,median_age,") ||
+		!strings.HasPrefix(lines[1], "2020,E01000001,20,180,10,90,10,90,") {
+
+		t.Errorf("Unexpected csv from DownloadHandler: %s",
+			response.Body.String())
+	}
+
+	// Custom bands are not available without an AgeDb
+	rh = NewResultsHandler(resultsPath, rdb, nil, nil, errorHandler)
+	form.Set("bands", "0-15,16+")
+	request, _ = http.NewRequest("POST", "/results",
+		strings.NewReader(form.Encode()))
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response = httptest.NewRecorder()
+
+	rh.ServeHTTP(response, request)
+
+	if strings.Contains(response.Body.String(), "The selected population") {
+		t.Errorf("Expected an error from ResultsHandler without an AgeDb.")
+	}
+}
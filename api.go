@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PopulationBand holds the population of one age group for the JSON API.
+type PopulationBand struct {
+	Group   string `json:"group"`
+	Persons int64  `json:"persons"`
+	Male    int64  `json:"male"`
+	Female  int64  `json:"female"`
+}
+
+// APIZone holds the population data for a single zone for the JSON API.
+type APIZone struct {
+	Code       string           `json:"code"`
+	Population int64            `json:"population"`
+	Bands      []PopulationBand `json:"bands"`
+	Indicators *Indicators      `json:"indicators"`
+}
+
+// APIArea holds the total population of a country, region or district that
+// was requested by its code, for the JSON API.
+type APIArea struct {
+	Code       string           `json:"code"`
+	Name       string           `json:"name"`
+	Level      string           `json:"level"`
+	Population int64            `json:"population"`
+	Bands      []PopulationBand `json:"bands"`
+	Indicators *Indicators      `json:"indicators"`
+}
+
+// APIResponse is the body returned by the JSON API for a successful request.
+type APIResponse struct {
+	Year       int              `json:"year"`
+	Zones      []string         `json:"zones"`
+	Report     *ZoneReport      `json:"report"`
+	Population int64            `json:"population"`
+	Bands      []PopulationBand `json:"bands"`
+	Indicators *Indicators      `json:"indicators"`
+	Areas      []*APIArea       `json:"areas"`
+	Rows       []*APIZone       `json:"rows"`
+
+	// Comparison is only set when a year to compare with is requested
+	Comparison *ResultsComparison `json:"comparison,omitempty"`
+}
+
+// APIError is the body returned by the JSON API when a request fails.
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail describes the error reported in an APIError.
+type APIErrorDetail struct {
+	Status  int         `json:"status"`
+	Message string      `json:"message"`
+	Report  *ZoneReport `json:"report,omitempty"`
+}
+
+// apiRequest is the expected shape of a JSON request body. A Year of zero
+// requests the latest year, and a Compare of zero requests no comparison.
+type apiRequest struct {
+	Zones   []string `json:"zones"`
+	Year    int      `json:"year"`
+	Compare int      `json:"compare"`
+}
+
+// Bands returns the 10-year age bands of the ResultsData as PopulationBands.
+func (r *ResultsData) Bands() []PopulationBand {
+
+	return []PopulationBand{
+		{"0-9", r.M0 + r.F0, r.M0, r.F0},
+		{"10-19", r.M10 + r.F10, r.M10, r.F10},
+		{"20-29", r.M20 + r.F20, r.M20, r.F20},
+		{"30-39", r.M30 + r.F30, r.M30, r.F30},
+		{"40-49", r.M40 + r.F40, r.M40, r.F40},
+		{"50-59", r.M50 + r.F50, r.M50, r.F50},
+		{"60-69", r.M60 + r.F60, r.M60, r.F60},
+		{"70-79", r.M70 + r.F70, r.M70, r.F70},
+		{"80-89", r.M80 + r.F80, r.M80, r.F80},
+		{"90+", r.M90 + r.F90, r.M90, r.F90},
+	}
+}
+
+// Bands returns the 5-year age bands of the DownloadData as PopulationBands.
+func (d *DownloadData) Bands() []PopulationBand {
+
+	return []PopulationBand{
+		{"0-4", d.P0, d.M0, d.F0},
+		{"5-9", d.P5, d.M5, d.F5},
+		{"10-14", d.P10, d.M10, d.F10},
+		{"15-19", d.P15, d.M15, d.F15},
+		{"20-24", d.P20, d.M20, d.F20},
+		{"25-29", d.P25, d.M25, d.F25},
+		{"30-34", d.P30, d.M30, d.F30},
+		{"35-39", d.P35, d.M35, d.F35},
+		{"40-44", d.P40, d.M40, d.F40},
+		{"45-49", d.P45, d.M45, d.F45},
+		{"50-54", d.P50, d.M50, d.F50},
+		{"55-59", d.P55, d.M55, d.F55},
+		{"60-64", d.P60, d.M60, d.F60},
+		{"65-69", d.P65, d.M65, d.F65},
+		{"70-74", d.P70, d.M70, d.F70},
+		{"75-79", d.P75, d.M75, d.F75},
+		{"80-84", d.P80, d.M80, d.F80},
+		{"85-89", d.P85, d.M85, d.F85},
+		{"90+", d.P90, d.M90, d.F90},
+	}
+}
+
+// sumPersons returns the total number of persons in a set of PopulationBands.
+func sumPersons(bands []PopulationBand) int64 {
+
+	var total int64
+
+	for _, band := range bands {
+		total += band.Persons
+	}
+
+	return total
+}
+
+// APIHandler serves the population data for a set of zones as JSON.
+type APIHandler struct {
+	rdb         *ResultsDb
+	ddb         *DownloadDb
+	zoneForm    string
+	yearForm    string
+	compareForm string
+}
+
+// NewAPIHandler returns a new APIHandler with the values initialised.
+func NewAPIHandler(resultsDb *ResultsDb, downloadDb *DownloadDb) *APIHandler {
+
+	return &APIHandler{
+		rdb:         resultsDb,
+		ddb:         downloadDb,
+		zoneForm:    "zones",
+		yearForm:    "year",
+		compareForm: "compare",
+	}
+}
+
+// ServeHTTP expects a list of area codes for population zones, either as a
+// comma separated list in the query string or POST form, or as an array in
+// a JSON request body, and optionally the estimate year and a second year to
+// compare with. The codes of countries, regions and districts may be given
+// in place of zone codes, and are expanded into the zones they contain. The
+// totals for the selection, for each requested area and for each zone are
+// returned as JSON, with the change between the two years if requested.
+// Errors are also reported as JSON.
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	// Only GET and POST requests are supported
+	if r.Method != "GET" && r.Method != "POST" {
+
+		w.Header().Set("Allow", "GET, POST")
+		h.serveError(w, http.StatusMethodNotAllowed,
+			"The API only supports GET and POST requests.")
+
+		return
+	}
+
+	// Get the zone codes and year from the request
+	request, err := h.parseRequest(r)
+
+	if err != nil {
+
+		h.serveError(w, http.StatusBadRequest,
+			"Could not parse the request: "+err.Error()+".")
+
+		return
+	}
+
+	zones := request.Zones
+
+	if len(zones) == 0 {
+
+		h.serveError(w, http.StatusBadRequest,
+			"The request did not contain any zone codes.")
+
+		return
+	}
+
+	// Use the latest year unless another year was requested
+	year := request.Year
+
+	if year == 0 {
+		year = h.rdb.LatestYear()
+	}
+
+	if !h.rdb.HasYear(year) ||
+		(request.Compare != 0 && !h.rdb.HasYear(request.Compare)) {
+
+		h.serveError(w, http.StatusBadRequest,
+			"Population estimates are not available for that year.")
+
+		return
+	}
+
+	// Get the totals and the data for each zone
+	resultsData, err := h.rdb.GetYearPopulationData(zones, year)
+
+	if err != nil {
+
+		h.serveError(w, http.StatusInternalServerError,
+			"Could not get population data from the ResultsDb.")
+
+		return
+	}
+
+	// Report an error if none of the zones were found
+	if len(resultsData.Report.Matched) == 0 {
+
+		writeJSON(w, http.StatusBadRequest, &APIError{
+			Error: APIErrorDetail{
+				Status:  http.StatusBadRequest,
+				Message: "None of the requested zones could be found.",
+				Report:  resultsData.Report,
+			},
+		})
+
+		return
+	}
+
+	downloadData, err := h.ddb.GetYearPopulationData(
+		resultsData.Report.Matched, year)
+
+	if err != nil {
+
+		h.serveError(w, http.StatusInternalServerError,
+			"Could not get population data from the DownloadDb.")
+
+		return
+	}
+
+	// Compare the selection with the other year if one was requested
+	var comparison *ResultsComparison
+
+	if request.Compare != 0 && request.Compare != year {
+
+		// Compare the zones that are in both years
+		sameData, compareData, missing, err := h.rdb.GetComparisonData(
+			resultsData, request.Compare)
+
+		if err != nil {
+
+			h.serveError(w, http.StatusInternalServerError,
+				"Could not get population data from the ResultsDb.")
+
+			return
+		}
+
+		if len(sameData.Report.Matched) == 0 {
+
+			fromYear, toYear := orderYears(year, request.Compare)
+			h.serveError(w, http.StatusBadRequest,
+				noCommonZonesMessage(fromYear, toYear))
+
+			return
+		}
+
+		if request.Compare < year {
+			comparison = NewResultsComparison(compareData, sameData)
+		} else {
+			comparison = NewResultsComparison(sameData, compareData)
+		}
+
+		comparison.Missing = missing
+	}
+
+	// Build the response
+	bands := resultsData.Bands()
+	response := &APIResponse{
+		Year:       year,
+		Zones:      zones,
+		Report:     resultsData.Report,
+		Population: sumPersons(bands),
+		Bands:      bands,
+		Indicators: resultsData.Indicators(),
+		Areas:      []*APIArea{},
+		Rows:       []*APIZone{},
+		Comparison: comparison,
+	}
+
+	// Add the totals for each area requested by its code
+	for _, code := range resultsData.Report.Areas {
+
+		area := h.rdb.Geography().Area(code)
+		areaData, err := h.rdb.GetYearPopulationData(area.Zones, year)
+
+		if err != nil {
+
+			h.serveError(w, http.StatusInternalServerError,
+				"Could not get population data from the ResultsDb.")
+
+			return
+		}
+
+		areaBands := areaData.Bands()
+		response.Areas = append(response.Areas, &APIArea{
+			Code:       area.Code,
+			Name:       area.Name,
+			Level:      area.Level,
+			Population: sumPersons(areaBands),
+			Bands:      areaBands,
+			Indicators: areaData.Indicators(),
+		})
+	}
+
+	for _, row := range downloadData {
+
+		zoneBands := row.Bands()
+		response.Rows = append(response.Rows, &APIZone{
+			Code:       row.Code,
+			Population: sumPersons(zoneBands),
+			Bands:      zoneBands,
+			Indicators: row.Indicators(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+	return
+}
+
+// parseRequest returns the zone codes and year sent with the request. A JSON
+// body is used if the request has a JSON content type, otherwise the values
+// are read from the query string or POST form.
+func (h *APIHandler) parseRequest(r *http.Request) (*apiRequest, error) {
+
+	body := &apiRequest{}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if r.Method == "POST" && contentType == "application/json" {
+
+		err := json.NewDecoder(r.Body).Decode(body)
+
+		if err != nil {
+			return nil, errors.New("invalid JSON body")
+		}
+
+	} else {
+
+		body.Zones = strings.Split(r.FormValue(h.zoneForm), ",")
+
+		for _, year := range []struct {
+			form  string
+			value *int
+		}{
+			{h.yearForm, &body.Year},
+			{h.compareForm, &body.Compare},
+		} {
+
+			if yearstr := r.FormValue(year.form); yearstr != "" {
+
+				value, err := strconv.Atoi(yearstr)
+
+				if err != nil {
+					return nil, errors.New("invalid " + year.form)
+				}
+
+				*year.value = value
+			}
+		}
+	}
+
+	// Remove empty zone codes
+	zones := []string{}
+
+	for _, zone := range body.Zones {
+
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+
+	body.Zones = zones
+
+	return body, nil
+}
+
+// serveError writes an APIError with the given status and message.
+func (h *APIHandler) serveError(w http.ResponseWriter, status int,
+	message string) {
+
+	writeAPIError(w, status, message)
+}
+
+// writeAPIError writes an APIError with the given status and message.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+
+	writeJSON(w, status, &APIError{
+		Error: APIErrorDetail{Status: status, Message: message},
+	})
+}
+
+// writeJSON encodes the value as JSON and writes it with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	body, err := json.Marshal(v)
+
+	if err != nil {
+
+		status = http.StatusInternalServerError
+		body = []byte(`{"error":{"status":500,` +
+			`"message":"Could not encode the response."}}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Test APIHandler with a range of inputs. The databases are built from test
+// source files, so that the response can be checked for known totals.
+// This test implicitly tests NewAPIHandler().
+func TestAPIHandler(t *testing.T) {
+
+	var (
+		h        *APIHandler
+		request  *http.Request
+		response *httptest.ResponseRecorder
+		body     *APIResponse
+		apiError *APIError
+		form     url.Values
+	)
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	buildTestDbs(t, dir, []string{"E01004731", "E01004732", "E01004733"})
+
+	// Create the databases
+	resultsDb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer resultsDb.Close()
+
+	downloadDb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer downloadDb.Close()
+
+	// Create an APIHandler to test
+	h = NewAPIHandler(resultsDb, downloadDb)
+
+	codes := []string{
+		// Test each of these zones in separate requests
+		"E01004731",
+		"E01004732",
+		"E01004733",
+		// Then test them all in one request
+		"E01004731,E01004732,E01004733",
+	}
+
+	// Ages 0 to 90 sum to 4095 for each sex in each zone
+	expected := []int64{
+		8190,
+		8190,
+		8190,
+		24570,
+	}
+
+	// Test GET requests with the zones in the query string
+	for i, c := range codes {
+
+		request, _ = http.NewRequest("GET",
+			"/api/v1/population?zones="+url.QueryEscape(c), nil)
+		response = httptest.NewRecorder()
+
+		h.ServeHTTP(response, request)
+
+		// Check status code
+		if response.Code != http.StatusOK {
+			t.Errorf("Expected StatusOK from APIHandler. Got: %d",
+				response.Code)
+		}
+
+		body = &APIResponse{}
+		err := json.Unmarshal(response.Body.Bytes(), body)
+
+		if err != nil {
+			t.Errorf("Could not decode the response from APIHandler.")
+			continue
+		}
+
+		if body.Population != expected[i] {
+			t.Errorf("Expected %d in APIHandler response. Got: %d",
+				expected[i], body.Population)
+		}
+
+		if len(body.Rows) != len(strings.Split(c, ",")) {
+			t.Errorf("Expected a row for each zone from APIHandler. Got: %d",
+				len(body.Rows))
+		}
+	}
+
+	// Test a POST request with the zones in a form
+	form = url.Values{}
+	form.Add(h.zoneForm, codes[3])
+
+	request, _ = http.NewRequest("POST", "/api/v1/population",
+		strings.NewReader(form.Encode()))
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+	response = httptest.NewRecorder()
+
+	h.ServeHTTP(response, request)
+
+	body = &APIResponse{}
+	json.Unmarshal(response.Body.Bytes(), body)
+
+	if response.Code != http.StatusOK || body.Population != expected[3] {
+		t.Errorf("Expected %d from APIHandler with a POST form. Got: %s",
+			expected[3], response.Body.String())
+	}
+
+	// Test a POST request with the zones in a JSON body
+	request, _ = http.NewRequest("POST", "/api/v1/population",
+		strings.NewReader(`{"zones": ["E01004731", "E01004732", "E01004733"]}`))
+	request.Header.Add("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+
+	h.ServeHTTP(response, request)
+
+	body = &APIResponse{}
+	json.Unmarshal(response.Body.Bytes(), body)
+
+	if response.Code != http.StatusOK || body.Population != expected[3] {
+		t.Errorf("Expected %d from APIHandler with a JSON body. Got: %s",
+			expected[3], response.Body.String())
+	}
+
+	// Test the error responses
+	errorRequests := []struct {
+		method string
+		query  string
+		body   string
+		status int
+	}{
+		{"GET", "", "", http.StatusBadRequest},
+		{"GET", "?zones=E01999999,X01004731", "", http.StatusBadRequest},
+		{"POST", "", "{not json", http.StatusBadRequest},
+		{"DELETE", "", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, e := range errorRequests {
+
+		request, _ = http.NewRequest(e.method, "/api/v1/population"+e.query,
+			strings.NewReader(e.body))
+		request.Header.Add("Content-Type", "application/json")
+		response = httptest.NewRecorder()
+
+		h.ServeHTTP(response, request)
+
+		if response.Code != e.status {
+			t.Errorf("Expected %d from APIHandler. Got: %d",
+				e.status, response.Code)
+		}
+
+		apiError = &APIError{}
+		err := json.Unmarshal(response.Body.Bytes(), apiError)
+
+		if err != nil || apiError.Error.Status != e.status {
+			t.Errorf("Expected a JSON error from APIHandler. Got: %s",
+				response.Body.String())
+		}
+	}
+}
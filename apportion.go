@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/olihawkins/popbuilder/spatial"
+	"math"
+	"net/http"
+)
+
+// apportionMethod describes how apportioned estimates are made, and is sent
+// with each estimate so that it is not mistaken for a count.
+const apportionMethod = "Area-weighted apportionment: each zone that " +
+	"overlaps the boundary is clipped to it, and the population of each age " +
+	"band in the zone is multiplied by the share of the zone's area inside " +
+	"the boundary. This assumes that people are spread evenly across each " +
+	"zone, so the estimates are fractional and approximate."
+
+// ApportionedBand holds the estimated population of one age group inside a
+// boundary.
+type ApportionedBand struct {
+	Group   string  `json:"group"`
+	Persons float64 `json:"persons"`
+	Male    float64 `json:"male"`
+	Female  float64 `json:"female"`
+}
+
+// ApportionedZone holds the population of a zone that overlaps a boundary,
+// the share of its area inside the boundary, and the population estimated
+// to live in that share.
+type ApportionedZone struct {
+	Code       string  `json:"code"`
+	Share      float64 `json:"share"`
+	Population int64   `json:"population"`
+	Estimate   float64 `json:"estimate"`
+}
+
+// Apportionment holds the population estimated to live inside a boundary,
+// in total and in each 5-year age band, and the zones it is drawn from.
+type Apportionment struct {
+	Year     int               `json:"year"`
+	Method   string            `json:"method"`
+	Estimate float64           `json:"estimate"`
+	Bands    []ApportionedBand `json:"bands"`
+	Zones    []ApportionedZone `json:"zones"`
+}
+
+// apportionRequest is the expected shape of a JSON request body. Boundary
+// holds a GeoJSON geometry, Feature or FeatureCollection, and a Year of zero
+// requests the latest year.
+type apportionRequest struct {
+	Boundary json.RawMessage `json:"boundary"`
+	Year     int             `json:"year"`
+}
+
+// apportion returns the Apportionment of the population of the zones in the
+// given year, where shares holds the share of the area of each zone inside
+// the boundary. The zones are listed in the order they are given.
+func apportion(ddb *DownloadDb, zones []string, shares map[string]float64,
+	year int) (*Apportionment, error) {
+
+	rows, err := ddb.GetYearPopulationData(zones, year)
+
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]*DownloadData{}
+
+	for _, row := range rows {
+		found[row.Code] = row
+	}
+
+	apportionment := &Apportionment{
+		Year:   year,
+		Method: apportionMethod,
+		Bands:  []ApportionedBand{},
+		Zones:  []ApportionedZone{},
+	}
+
+	for _, zone := range zones {
+
+		row, ok := found[zone]
+
+		if !ok {
+			continue
+		}
+
+		share := shares[zone]
+		bands := row.Bands()
+		population := sumPersons(bands)
+
+		// Add the share of each band to the totals
+		for i, band := range bands {
+
+			if i == len(apportionment.Bands) {
+
+				apportionment.Bands = append(apportionment.Bands,
+					ApportionedBand{Group: band.Group})
+			}
+
+			apportionment.Bands[i].Persons += float64(band.Persons) * share
+			apportionment.Bands[i].Male += float64(band.Male) * share
+			apportionment.Bands[i].Female += float64(band.Female) * share
+		}
+
+		apportionment.Estimate += float64(population) * share
+		apportionment.Zones = append(apportionment.Zones, ApportionedZone{
+			Code:       zone,
+			Share:      roundEstimate(share, 4),
+			Population: population,
+			Estimate:   roundEstimate(float64(population)*share, 2),
+		})
+	}
+
+	// Round the totals once they are summed
+	apportionment.Estimate = roundEstimate(apportionment.Estimate, 2)
+
+	for i, band := range apportionment.Bands {
+
+		apportionment.Bands[i].Persons = roundEstimate(band.Persons, 2)
+		apportionment.Bands[i].Male = roundEstimate(band.Male, 2)
+		apportionment.Bands[i].Female = roundEstimate(band.Female, 2)
+	}
+
+	return apportionment, nil
+}
+
+// roundEstimate returns the estimate rounded to the given number of decimal
+// places.
+func roundEstimate(estimate float64, places int) float64 {
+
+	scale := math.Pow(10, float64(places))
+	return math.Round(estimate*scale) / scale
+}
+
+// ApportionHandler estimates the population inside a boundary by
+// area-weighted apportionment.
+type ApportionHandler struct {
+	index *spatial.Index
+	ddb   *DownloadDb
+}
+
+// NewApportionHandler returns a new ApportionHandler with the values
+// initialised. The index holds the zone boundaries, and may be nil if they
+// could not be loaded.
+func NewApportionHandler(index *spatial.Index,
+	ddb *DownloadDb) *ApportionHandler {
+
+	return &ApportionHandler{
+		index: index,
+		ddb:   ddb,
+	}
+}
+
+// ServeHTTP expects a POST request with a JSON body of the form
+// {"boundary": {"type": "Polygon", "coordinates": [...]}, "year": 2020}
+// and clips each zone to the boundary to estimate the population inside it.
+// It returns the fractional estimates of the total population and of the
+// population in each 5-year age band, the share and estimate for each zone
+// that overlaps the boundary, and a note of the method used. The year is
+// optional. Responses and errors are sent as JSON.
+func (h *ApportionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if h.index == nil {
+
+		writeAPIError(w, http.StatusServiceUnavailable,
+			"The zone boundaries are not available.")
+
+		return
+	}
+
+	if r.Method != "POST" {
+
+		w.Header().Set("Allow", "POST")
+		writeAPIError(w, http.StatusMethodNotAllowed,
+			"That method is not supported.")
+
+		return
+	}
+
+	var request apportionRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+		maxRequestBody)).Decode(&request)
+
+	if err != nil || len(request.Boundary) == 0 {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"Could not parse the request: invalid JSON body.")
+
+		return
+	}
+
+	// Use the latest year unless another year was requested
+	if request.Year == 0 {
+		request.Year = h.ddb.LatestYear()
+	}
+
+	if !h.ddb.HasYear(request.Year) {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"Population estimates are not available for that year.")
+
+		return
+	}
+
+	selection, err := selectBoundary(h.index, request.Boundary, ruleApportion)
+
+	if err != nil {
+
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	apportionment, err := apportion(h.ddb, selection.Zones, selection.Shares,
+		request.Year)
+
+	if err != nil {
+
+		writeAPIError(w, http.StatusInternalServerError,
+			"Could not get population data from the DownloadDb.")
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apportionment)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/olihawkins/popbuilder/spatial"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test ApportionHandler weights the age bands of each zone by the share of
+// its area inside the boundary.
+func TestApportionHandler(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	buildTestDbs(t, dir, []string{"E01000001", "W01000001"})
+
+	ddb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer ddb.Close()
+
+	// Two neighbouring zones, each with 8190 people and 10 males and 10
+	// females aged 0-4
+	square := func(x float64) spatial.MultiPolygon {
+
+		return spatial.MultiPolygon{spatial.Polygon{spatial.Ring{
+			{X: x, Y: 0}, {X: x + 0.01, Y: 0}, {X: x + 0.01, Y: 0.01},
+			{X: x, Y: 0.01}}}}
+	}
+
+	index := spatial.NewIndex([]*spatial.Feature{
+		spatial.NewFeature("E01000001", "", 0, square(0)),
+		spatial.NewFeature("W01000001", "", 0, square(0.01))})
+
+	h := NewApportionHandler(index, ddb)
+
+	// The boundary covers 80% of the first zone and 40% of the second
+	request, _ := http.NewRequest("POST", "/api/v1/apportion",
+		strings.NewReader(`{"boundary": {"type": "Feature", "geometry": `+
+			`{"type": "Polygon", "coordinates": [[[0.002, 0], [0.014, 0], `+
+			`[0.014, 0.01], [0.002, 0.01], [0.002, 0]]]}}}`))
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+
+	for _, expected := range []string{
+		`{"year":2020,"method":"Area-weighted apportionment: `,
+		`"estimate":9828,"bands":[` +
+			`{"group":"0-4","persons":24,"male":12,"female":12},`,
+		`"zones":[{"code":"E01000001","share":0.8,"population":8190,` +
+			`"estimate":6552},{"code":"W01000001","share":0.4,` +
+			`"population":8190,"estimate":3276}]}`,
+	} {
+
+		if !strings.Contains(response.Body.String(), expected) {
+			t.Errorf("Expected %s from ApportionHandler. Got: %s", expected,
+				response.Body.String())
+		}
+	}
+
+	for _, body := range []string{`{"year": 1900, "boundary": {}}`, `[]`} {
+
+		request, _ = http.NewRequest("POST", "/api/v1/apportion",
+			strings.NewReader(body))
+		response = httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected StatusBadRequest for %s. Got: %d", body,
+				response.Code)
+		}
+	}
+}
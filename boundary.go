@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/olihawkins/decimals"
+	"github.com/olihawkins/popbuilder/spatial"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// The rules for deciding which zones a boundary selects. Zones are selected
+// if their centroid is inside the boundary, or if any of their area is
+// inside it. Apportionment also selects every zone that overlaps the
+// boundary, and estimates the population inside the boundary by weighting
+// the population of each zone by the share of its area inside it.
+const (
+	ruleCentroid  = "centroid"
+	ruleOverlap   = "overlap"
+	ruleApportion = "apportion"
+)
+
+// BoundarySelection holds the zones selected with a boundary under one of
+// the rules, and the share of the area of each zone inside the boundary.
+type BoundarySelection struct {
+	Rule   string
+	Zones  []string
+	Shares map[string]float64
+}
+
+// BoundaryEstimate holds the boundary sent to the results page as GeoJSON,
+// the rule used to select its zones, and the population estimated to live
+// inside it when the population is apportioned, with the method used.
+type BoundaryEstimate struct {
+	GeoJSON  string
+	Rule     string
+	Estimate string
+	Method   string
+}
+
+// BoundaryResult holds the zones selected with a boundary and their total
+// population. Estimate and Shares are only set when the population is
+// apportioned.
+type BoundaryResult struct {
+	Rule       string             `json:"rule"`
+	Year       int                `json:"year"`
+	Zones      []string           `json:"zones"`
+	Districts  []string           `json:"districts"`
+	Population int64              `json:"population"`
+	Bands      []PopulationBand   `json:"bands"`
+	Estimate   *float64           `json:"estimate,omitempty"`
+	Shares     map[string]float64 `json:"shares,omitempty"`
+}
+
+// boundaryRequest is the expected shape of a JSON request body. Boundary
+// holds a GeoJSON geometry, Feature or FeatureCollection. An empty Rule
+// selects zones by their centroids, and a Year of zero requests the latest
+// year.
+type boundaryRequest struct {
+	Boundary json.RawMessage `json:"boundary"`
+	Rule     string          `json:"rule"`
+	Year     int             `json:"year"`
+}
+
+// selectBoundary returns the zones selected with the GeoJSON boundary under
+// the given rule, which defaults to the centroid rule. Errors are returned
+// with a message for the user.
+func selectBoundary(index *spatial.Index, data []byte,
+	rule string) (*BoundarySelection, error) {
+
+	if rule == "" {
+		rule = ruleCentroid
+	}
+
+	if rule != ruleCentroid && rule != ruleOverlap && rule != ruleApportion {
+		return nil, errors.New("The rule must be one of centroid, overlap " +
+			"or apportion.")
+	}
+
+	geometry, err := spatial.ParseGeometry(data)
+
+	if err != nil {
+		return nil, errors.New("Could not read the boundary: " + err.Error())
+	}
+
+	boundary, err := spatial.NewBoundary(geometry)
+
+	if err != nil {
+		return nil, errors.New("The boundary is not valid: " + err.Error())
+	}
+
+	selection := &BoundarySelection{
+		Rule:   rule,
+		Zones:  []string{},
+		Shares: map[string]float64{},
+	}
+
+	for _, overlap := range index.Overlapping(boundary) {
+
+		if rule == ruleCentroid && !boundary.Contains(overlap.Feature.Centroid) {
+			continue
+		}
+
+		selection.Zones = append(selection.Zones, overlap.Feature.Zone)
+		selection.Shares[overlap.Feature.Zone] = overlap.Share
+	}
+
+	return selection, nil
+}
+
+// formatEstimate returns a population estimate rounded to a whole number
+// of people, with thousands separated by commas.
+func formatEstimate(estimate float64) string {
+
+	return decimals.FormatThousands(int64(math.Round(estimate)))
+}
+
+// BoundaryHandler finds the zones selected with a boundary, such as a
+// polygon drawn on the map.
+type BoundaryHandler struct {
+	index *spatial.Index
+	rdb   *ResultsDb
+	ddb   *DownloadDb
+}
+
+// NewBoundaryHandler returns a new BoundaryHandler with the values
+// initialised. The index holds the zone boundaries, and may be nil if they
+// could not be loaded.
+func NewBoundaryHandler(index *spatial.Index, rdb *ResultsDb,
+	ddb *DownloadDb) *BoundaryHandler {
+
+	return &BoundaryHandler{
+		index: index,
+		rdb:   rdb,
+		ddb:   ddb,
+	}
+}
+
+// ServeHTTP expects a POST request with a JSON body of the form
+// {"boundary": {"type": "Polygon", "coordinates": [...]}, "rule": "overlap"}
+// and returns the zones selected with the boundary and their total
+// population. The rule is one of centroid, overlap or apportion, and
+// defaults to centroid. When the population is apportioned, the response
+// also holds the estimated population inside the boundary and the share of
+// each zone inside it. A year may also be given. Responses and errors are
+// sent as JSON.
+func (h *BoundaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if h.index == nil {
+
+		writeAPIError(w, http.StatusServiceUnavailable,
+			"The zone boundaries are not available.")
+
+		return
+	}
+
+	if r.Method != "POST" {
+
+		w.Header().Set("Allow", "POST")
+		writeAPIError(w, http.StatusMethodNotAllowed,
+			"That method is not supported.")
+
+		return
+	}
+
+	var request boundaryRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+		maxRequestBody)).Decode(&request)
+
+	if err != nil || len(request.Boundary) == 0 {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"Could not parse the request: invalid JSON body.")
+
+		return
+	}
+
+	// Use the latest year unless another year was requested
+	if request.Year == 0 {
+		request.Year = h.rdb.LatestYear()
+	}
+
+	// Apportioning also needs the single years of age for the year
+	if !h.rdb.HasYear(request.Year) ||
+		(request.Rule == ruleApportion && !h.ddb.HasYear(request.Year)) {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"Population estimates are not available for that year.")
+
+		return
+	}
+
+	selection, err := selectBoundary(h.index, request.Boundary, request.Rule)
+
+	if err != nil {
+
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result := &BoundaryResult{
+		Rule:      selection.Rule,
+		Year:      request.Year,
+		Zones:     selection.Zones,
+		Districts: selectionDistricts(selection.Zones, h.rdb.Geography()),
+		Bands:     []PopulationBand{},
+	}
+
+	if len(selection.Zones) > 0 {
+
+		resultsData, err := h.rdb.GetYearPopulationData(selection.Zones,
+			request.Year)
+
+		if err != nil {
+
+			writeAPIError(w, http.StatusInternalServerError,
+				"Could not get population data from the ResultsDb.")
+
+			return
+		}
+
+		result.Bands = resultsData.Bands()
+		result.Population = sumPersons(result.Bands)
+	}
+
+	if selection.Rule == ruleApportion {
+
+		apportionment, err := apportion(h.ddb, selection.Zones,
+			selection.Shares, request.Year)
+
+		if err != nil {
+
+			writeAPIError(w, http.StatusInternalServerError,
+				"Could not get population data from the DownloadDb.")
+
+			return
+		}
+
+		result.Estimate = &apportionment.Estimate
+		result.Shares = selection.Shares
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// boundaryZones returns the zones selected with a boundary sent to the
+// results page, and the BoundaryEstimate shown with the results if the
+// population is apportioned, which is nil otherwise. Errors are returned
+// with a message for the user.
+func (h *ResultsHandler) boundaryZones(boundary string, rule string,
+	year int) ([]string, *BoundaryEstimate, error) {
+
+	if h.boundaries == nil {
+		return nil, nil, errors.New("The zone boundaries are not available.")
+	}
+
+	selection, err := selectBoundary(h.boundaries, []byte(boundary), rule)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(selection.Zones) == 0 {
+		return nil, nil, errors.New("None of the zones are inside the " +
+			"boundary.")
+	}
+
+	if selection.Rule != ruleApportion {
+		return selection.Zones, nil, nil
+	}
+
+	apportionment, err := apportion(h.ddb, selection.Zones, selection.Shares,
+		year)
+
+	if err != nil {
+		return nil, nil, errors.New("Could not get population data from " +
+			"the DownloadDb.")
+	}
+
+	return selection.Zones, &BoundaryEstimate{
+		GeoJSON:  strings.TrimSpace(boundary),
+		Rule:     selection.Rule,
+		Estimate: formatEstimate(apportionment.Estimate),
+		Method:   apportionment.Method,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/olihawkins/handlers"
+	"github.com/olihawkins/popbuilder/spatial"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test BoundaryHandler selects zones with each rule, and that the results
+// page shows the apportioned population for a boundary.
+func TestBoundaryHandler(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	buildTestDbs(t, dir, []string{"E01000001", "W01000001"})
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	ddb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer ddb.Close()
+
+	// Two neighbouring zones, each with 8190 people
+	square := func(x float64) spatial.MultiPolygon {
+
+		return spatial.MultiPolygon{spatial.Polygon{spatial.Ring{
+			{X: x, Y: 0}, {X: x + 0.01, Y: 0}, {X: x + 0.01, Y: 0.01},
+			{X: x, Y: 0.01}}}}
+	}
+
+	index := spatial.NewIndex([]*spatial.Feature{
+		spatial.NewFeature("E01000001", "", 0, square(0)),
+		spatial.NewFeature("W01000001", "", 0, square(0.01))})
+
+	h := NewBoundaryHandler(index, rdb, ddb)
+
+	// The boundary covers 80% of the first zone and 40% of the second,
+	// which has its centroid outside the boundary
+	boundary := `{"type": "Polygon", "coordinates": [[[0.002, 0], ` +
+		`[0.014, 0], [0.014, 0.01], [0.002, 0.01], [0.002, 0]]]}`
+
+	for _, test := range []struct {
+		rule     string
+		expected string
+	}{
+		{"", `"rule":"centroid","year":2020,"zones":["E01000001"],` +
+			`"districts":[],"population":8190,`},
+		{"overlap", `"zones":["E01000001","W01000001"],"districts":[],` +
+			`"population":16380,`},
+		{"apportion", `"estimate":9828,"shares":{"E01000001":0.8`},
+	} {
+
+		request, _ := http.NewRequest("POST", "/api/v1/boundary",
+			strings.NewReader(`{"boundary": `+boundary+`, "rule": "`+
+				test.rule+`"}`))
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK ||
+			!strings.Contains(response.Body.String(), test.expected) {
+
+			t.Errorf("Expected %s from BoundaryHandler for the %q rule. "+
+				"Got: %d %s", test.expected, test.rule, response.Code,
+				response.Body.String())
+		}
+	}
+
+	for _, body := range []string{
+		`{"boundary": ` + boundary + `, "rule": "nearest"}`,
+		`{"boundary": {"type": "Point", "coordinates": [0, 0]}}`,
+		`{"rule": "overlap"}`,
+	} {
+
+		request, _ := http.NewRequest("POST", "/api/v1/boundary",
+			strings.NewReader(body))
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected StatusBadRequest for %s. Got: %d", body,
+				response.Code)
+		}
+	}
+
+	// Apportioning is refused for a year the DownloadDb does not hold
+	other := testDir(t)
+	defer os.RemoveAll(other)
+
+	buildTestDbs(t, other, []string{"E01000001", "W01000001"}, "-year",
+		"2019")
+
+	otherDdb := NewDownloadDb(testDbPath(other, downloadDbPath))
+	defer otherDdb.Close()
+
+	h = NewBoundaryHandler(index, rdb, otherDdb)
+
+	for rule, status := range map[string]int{
+		"overlap":   http.StatusOK,
+		"apportion": http.StatusBadRequest,
+	} {
+
+		request, _ := http.NewRequest("POST", "/api/v1/boundary",
+			strings.NewReader(`{"boundary": `+boundary+`, "rule": "`+
+				rule+`", "year": 2020}`))
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != status {
+			t.Errorf("Expected status %d for the %q rule without the year "+
+				"in the DownloadDb. Got: %d", status, rule, response.Code)
+		}
+	}
+
+	// Request the results page for the boundary
+	errorHandler := handlers.LoadErrorHandler(errorPath, "", true)
+	rh := NewResultsHandler(resultsPath, rdb, nil, nil, errorHandler)
+	rh.UseBoundaries(index, ddb)
+
+	form := url.Values{"boundary": {boundary}, "rule": {"apportion"}}
+	request, _ := http.NewRequest("POST", "/results",
+		strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+	rh.ServeHTTP(response, request)
+
+	if !strings.Contains(response.Body.String(),
+		"The selected population is <b>16,380</b>") ||
+		!strings.Contains(response.Body.String(),
+			"estimated to live inside the boundary is <b>9,828</b>") ||
+		!strings.Contains(response.Body.String(),
+			"whole of the zones that overlap the boundary, whose "+
+				"population is <b>16,380</b>") {
+
+		t.Errorf("Expected the apportioned population from ResultsHandler. "+
+			"Got: %s", response.Body.String())
+	}
+}
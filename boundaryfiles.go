@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/olihawkins/popbuilder/spatial"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// topoJSONPrecision is the precision in degrees, about ten centimetres, that
+// the points of full resolution TopoJSON files are quantized to.
+const topoJSONPrecision = 1e-6
+
+// topoJSONType is the media type that requests for TopoJSON files accept.
+const topoJSONType = "application/topo+json"
+
+// fullResolution is the zoom level given for boundaries that are not
+// simplified.
+const fullResolution = -1
+
+// districtBoundaries holds the zone boundaries of each district in a
+// Topology, so that the boundaries of a district can be simplified along
+// the lines its zones share with their neighbours.
+type districtBoundaries struct {
+	topology  *spatial.Topology
+	features  []*spatial.Feature
+	districts map[string][]int
+}
+
+// newDistrictBoundaries returns the districtBoundaries for the Features.
+func newDistrictBoundaries(features []*spatial.Feature) *districtBoundaries {
+
+	b := &districtBoundaries{
+		features:  features,
+		districts: map[string][]int{},
+	}
+
+	geometries := make([]spatial.MultiPolygon, len(features))
+
+	for i, feature := range features {
+
+		geometries[i] = feature.Geometry
+		b.districts[feature.District] = append(b.districts[feature.District],
+			i)
+	}
+
+	b.topology = spatial.NewTopology(geometries, zonePrecision)
+	return b
+}
+
+// properties returns the properties of the zone with the given index, the
+// zone code and population, as in the popzones files.
+func (b *districtBoundaries) properties(i int) map[string]interface{} {
+
+	return map[string]interface{}{
+		"zone":       b.features[i].Zone,
+		"population": strconv.FormatInt(b.features[i].Population, 10),
+	}
+}
+
+// geoJSON returns a FeatureCollection of the zones in the district, with
+// their boundaries simplified for the zoom level.
+func (b *districtBoundaries) geoJSON(district string,
+	zoom int) *GeoJSONCollection {
+
+	collection := &GeoJSONCollection{
+		Type:     "FeatureCollection",
+		Features: []*GeoJSONFeature{},
+	}
+
+	tolerance := spatial.ZoomTolerance(zoom)
+
+	for _, i := range b.districts[district] {
+
+		geometry := b.topology.SimplifyGeometry(i, tolerance)
+
+		collection.Features = append(collection.Features, &GeoJSONFeature{
+			Type:       "Feature",
+			Geometry:   &geometry,
+			Properties: b.properties(i),
+		})
+	}
+
+	return collection
+}
+
+// topoJSON returns a TopoJSON Topology of the zones in the district, in a
+// GeometryCollection named "zones", with their boundaries simplified for
+// the zoom level, or at full resolution. The arcs are quantized to a
+// precision that is finer than the simplification, and which is the same
+// for every district at the zoom level, so the arcs of neighbouring
+// districts still meet.
+func (b *districtBoundaries) topoJSON(district string,
+	zoom int) *spatial.TopoJSON {
+
+	tolerance, precision := 0.0, topoJSONPrecision
+
+	if zoom != fullResolution {
+
+		tolerance = spatial.ZoomTolerance(zoom)
+		precision = math.Max(precision, tolerance/4)
+	}
+
+	zones := b.districts[district]
+	properties := make([]map[string]interface{}, len(zones))
+
+	for n, i := range zones {
+		properties[n] = b.properties(i)
+	}
+
+	subset := b.topology.Subset(zones, tolerance)
+	return subset.TopoJSON("zones", properties, precision)
+}
+
+// BoundaryFileHandler serves the popzones file of a district with its zone
+// boundaries simplified for the zoom level of the map, as GeoJSON or as
+// TopoJSON.
+type BoundaryFileHandler struct {
+	boundaries  *districtBoundaries
+	prefix      string
+	dir         string
+	topoDir     string
+	cacheDir    string
+	fileHandler http.Handler
+	zoomForm    string
+}
+
+// NewBoundaryFileHandler returns a new BoundaryFileHandler with the values
+// initialised. Requests are expected at the given path prefix, for the
+// popzones files in dir, and the TopoJSON files converted from them in
+// topoDir. The index holds the zone boundaries, and may be nil if they
+// could not be loaded. Simplified files are cached in cacheDir, and
+// requests for GeoJSON without a zoom level, or for files that are not
+// district boundaries, are passed to the fileHandler.
+func NewBoundaryFileHandler(prefix string, index *spatial.Index, dir string,
+	topoDir string, cacheDir string,
+	fileHandler http.Handler) *BoundaryFileHandler {
+
+	h := &BoundaryFileHandler{
+		prefix:      prefix,
+		dir:         dir,
+		topoDir:     topoDir,
+		cacheDir:    cacheDir,
+		fileHandler: fileHandler,
+		zoomForm:    "zoom",
+	}
+
+	if index != nil {
+		h.boundaries = newDistrictBoundaries(index.Features())
+	}
+
+	return h
+}
+
+// ServeHTTP serves the popzones file for the district named in the path
+// after the prefix, as in /resources/popzones/{district}.json?zoom=12, with
+// each zone boundary simplified to the detail that can be seen at the zoom
+// level. Boundaries are simplified along the lines shared by neighbouring
+// zones, so that neighbours still meet without gaps or overlaps. Requests
+// that accept application/topo+json are served TopoJSON, which stores each
+// shared line once, with quantized coordinates. Simplified files are kept
+// in the cache directory until the popzones file changes. Requests without
+// a zoom level are served the file at full resolution, from the converted
+// TopoJSON files if they are up to date.
+func (h *BoundaryFileHandler) ServeHTTP(w http.ResponseWriter,
+	r *http.Request) {
+
+	district := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, h.prefix),
+		".json")
+	zoomParam := r.URL.Query().Get(h.zoomForm)
+	topoJSON := acceptsTopoJSON(r)
+	ok := false
+
+	if h.boundaries != nil {
+		_, ok = h.boundaries.districts[district]
+	}
+
+	// The same path may be served as GeoJSON or TopoJSON
+	w.Header().Set("Vary", "Accept")
+
+	if (zoomParam == "" && !topoJSON) || !ok ||
+		r.URL.Path != h.prefix+district+".json" {
+
+		h.fileHandler.ServeHTTP(w, r)
+		return
+	}
+
+	zoom := fullResolution
+
+	if zoomParam != "" {
+
+		var err error
+		zoom, err = strconv.Atoi(zoomParam)
+
+		if err != nil || zoom < 0 || zoom > spatial.MaxTileZoom {
+
+			http.Error(w, "The zoom level must be a whole number from 0 to "+
+				strconv.Itoa(spatial.MaxTileZoom)+".", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	// Find the converted or cached file for the request
+	var path string
+
+	switch {
+	case zoom == fullResolution:
+		path = filepath.Join(h.topoDir, district+".json")
+	case topoJSON:
+		path = filepath.Join(h.cacheDir, "topojson", strconv.Itoa(zoom),
+			district+".json")
+	default:
+		path = filepath.Join(h.cacheDir, strconv.Itoa(zoom),
+			district+".json")
+	}
+
+	contentType := "application/json"
+
+	if topoJSON {
+		contentType = topoJSONType
+	}
+
+	// Serve the file if it is newer than the popzones file
+	if h.isCurrent(path, district) {
+
+		w.Header().Set("Content-Type", contentType)
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	var data []byte
+	var err error
+
+	if topoJSON {
+		data, err = json.Marshal(h.boundaries.topoJSON(district, zoom))
+	} else {
+		data, err = json.Marshal(h.boundaries.geoJSON(district, zoom))
+	}
+
+	if err != nil {
+
+		http.Error(w, "The district boundaries could not be simplified.",
+			http.StatusInternalServerError)
+
+		return
+	}
+
+	// Cache simplified files, but leave converting full resolution files
+	// to the build-topojson command
+	if zoom != fullResolution {
+
+		err = writeFileAtomically(path, data)
+
+		if err != nil {
+			log.Print("Simplified boundaries could not be cached: ", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// isCurrent returns true if the file at the path exists and is newer than
+// the popzones file for the district.
+func (h *BoundaryFileHandler) isCurrent(path string, district string) bool {
+
+	file, err := os.Stat(path)
+
+	if err != nil {
+		return false
+	}
+
+	source, err := os.Stat(filepath.Join(h.dir, district+".json"))
+
+	return err == nil && file.ModTime().After(source.ModTime())
+}
+
+// acceptsTopoJSON returns true if the request accepts TopoJSON.
+func acceptsTopoJSON(r *http.Request) bool {
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+
+		if strings.EqualFold(mediaType, topoJSONType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeFileAtomically writes the data to the file at the given path,
+// creating its directory if needed. The data is written to a temporary file
+// that is then renamed, so that a file being written is never served.
+func writeFileAtomically(path string, data []byte) error {
+
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/olihawkins/popbuilder/spatial"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test BoundaryFileHandler simplifies district boundaries for a zoom level
+// and caches them, and passes other requests to the file handler.
+func TestBoundaryFileHandler(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "popzones")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// A zone with a small wiggle in one edge, which is removed at low zoom
+	// levels and kept at high zoom levels
+	ring := spatial.Ring{{X: 0, Y: 0}, {X: 0.01, Y: 0}, {X: 0.01, Y: 0.005},
+		{X: 0.01001, Y: 0.006}, {X: 0.01, Y: 0.007}, {X: 0.01, Y: 0.01},
+		{X: 0, Y: 0.01}, {X: 0, Y: 0}}
+
+	index := spatial.NewIndex([]*spatial.Feature{spatial.NewFeature(
+		"E01000001", "E09000001", 1500, spatial.MultiPolygon{{ring}})})
+
+	sourcePath := filepath.Join(dir, "E09000001.json")
+	ioutil.WriteFile(sourcePath, []byte("{}"), 0644)
+
+	// The file handler reports the paths it is asked for
+	fileHandler := http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		w.Write([]byte("file " + r.URL.Path))
+	})
+
+	cacheDir := filepath.Join(dir, "cache")
+	topoDir := filepath.Join(dir, "topozones")
+	h := NewBoundaryFileHandler("/resources/popzones/", index, dir, topoDir,
+		cacheDir, fileHandler)
+
+	// serve requests a path with the given Accept header
+	serve := func(path string, accept string) *httptest.ResponseRecorder {
+
+		request, _ := http.NewRequest("GET", path, nil)
+		request.Header.Set("Accept", accept)
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		return response
+	}
+
+	// points returns the number of points in the popzones file served for
+	// the zoom level
+	points := func(zoom string) int {
+
+		response := serve("/resources/popzones/E09000001.json?zoom="+zoom,
+			"")
+
+		var collection struct {
+			Features []struct {
+				Properties map[string]string `json:"properties"`
+				Geometry   struct {
+					Coordinates [][][][]float64 `json:"coordinates"`
+				} `json:"geometry"`
+			} `json:"features"`
+		}
+
+		err := json.Unmarshal(response.Body.Bytes(), &collection)
+
+		if err != nil || len(collection.Features) != 1 ||
+			collection.Features[0].Properties["zone"] != "E01000001" ||
+			collection.Features[0].Properties["population"] != "1500" {
+
+			t.Fatalf("Expected a popzones file from BoundaryFileHandler. "+
+				"Got: %d %q", response.Code, response.Body.String())
+		}
+
+		n := 0
+
+		for _, polygon := range collection.Features[0].Geometry.Coordinates {
+
+			for _, positions := range polygon {
+				n += len(positions)
+			}
+		}
+
+		return n
+	}
+
+	if n := points("8"); n != 5 {
+		t.Errorf("Expected 5 points at zoom level 8. Got: %d", n)
+	}
+
+	if n := points("18"); n != 8 {
+		t.Errorf("Expected 8 points at zoom level 18. Got: %d", n)
+	}
+
+	// The simplified file is cached, and served from the cache
+	cachePath := filepath.Join(cacheDir, "8", "E09000001.json")
+	_, err = os.Stat(cachePath)
+
+	if err != nil {
+		t.Errorf("Expected the simplified file to be cached.")
+	}
+
+	if n := points("8"); n != 5 {
+		t.Errorf("Expected 5 points from the cache. Got: %d", n)
+	}
+
+	// Requests that accept TopoJSON are served a Topology, which is cached
+	// if it is simplified
+	for _, zoom := range []string{"8", ""} {
+
+		response := serve("/resources/popzones/E09000001.json?zoom="+zoom,
+			"application/topo+json, application/json")
+
+		var topology struct {
+			Type    string
+			Objects map[string]struct {
+				Geometries []struct {
+					Properties map[string]string
+				}
+			}
+		}
+
+		err := json.Unmarshal(response.Body.Bytes(), &topology)
+
+		if err != nil || topology.Type != "Topology" ||
+			len(topology.Objects["zones"].Geometries) != 1 ||
+			topology.Objects["zones"].Geometries[0].Properties["zone"] !=
+				"E01000001" ||
+			response.Header().Get("Content-Type") != topoJSONType {
+
+			t.Errorf("Expected a TopoJSON file at zoom level %q. Got: %q",
+				zoom, response.Body.String())
+		}
+	}
+
+	_, err = os.Stat(filepath.Join(cacheDir, "topojson", "8",
+		"E09000001.json"))
+
+	if err != nil {
+		t.Errorf("Expected the simplified TopoJSON file to be cached.")
+	}
+
+	// Full resolution TopoJSON is served from the converted files
+	os.MkdirAll(topoDir, 0755)
+	ioutil.WriteFile(filepath.Join(topoDir, "E09000001.json"),
+		[]byte(`{"type": "Topology", "converted": true}`), 0644)
+
+	response := serve("/resources/popzones/E09000001.json",
+		"application/topo+json")
+
+	if !strings.Contains(response.Body.String(), "converted") {
+		t.Errorf("Expected the converted TopoJSON file. Got: %q",
+			response.Body.String())
+	}
+
+	// Requests without a zoom level or for other files go to the file handler
+	for _, request := range []string{
+		"/resources/popzones/E09000001.json",
+		"/resources/popzones/E09000002.json?zoom=8",
+		"/resources/popzones/E09000001.json/?zoom=8",
+	} {
+
+		response := serve(request, "")
+		path := strings.Split(request, "?")[0]
+
+		if response.Body.String() != "file "+path {
+
+			t.Errorf("Expected %s to be served by the file handler. Got: %q",
+				request, response.Body.String())
+		}
+	}
+
+	response = serve("/resources/popzones/E09000001.json?zoom=high", "")
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected a bad request for an invalid zoom level. Got: %d",
+			response.Code)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/olihawkins/popbuilder/spatial"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// errTooLargeZip is returned for zip archives whose shapefile is larger
+// than an upload once it is uncompressed.
+var errTooLargeZip = errors.New("the zip file is too large once it is " +
+	"uncompressed")
+
+// parseBoundaryFile reads a boundary from a GeoJSON, KML or shapefile, and
+// returns it in WGS84. The format is recognised from the contents, so KML
+// files are those that start with an XML element. Shapefiles may be
+// uploaded as a .shp file alone, or as a zip archive holding the .shp file
+// with its .shx, .dbf and .prj files, the last of which names its
+// projection.
+func parseBoundaryFile(file io.Reader) (spatial.MultiPolygon, error) {
+
+	content, err := ioutil.ReadAll(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(content, []byte("PK\x03\x04")) {
+		return parseShapefileZip(content)
+	}
+
+	if spatial.IsShapefile(content) {
+		return shapefileBoundary(content, nil, nil, nil)
+	}
+
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	content = bytes.TrimSpace(content)
+
+	if bytes.HasPrefix(content, []byte("<")) {
+		return spatial.ParseKML(bytes.NewReader(content))
+	}
+
+	geometry, err := spatial.ParseGeometry(content)
+
+	if err != nil {
+
+		if _, ok := err.(*json.SyntaxError); ok {
+			return nil, errors.New("the file could not be read as GeoJSON " +
+				"or KML")
+		}
+
+		return nil, err
+	}
+
+	return geometry, nil
+}
+
+// parseShapefileZip reads a boundary from the shapefile in a zip archive.
+// The .shx, .dbf and .prj files are those with the same name as the first
+// .shp file, in any case.
+func parseShapefileZip(content []byte) (spatial.MultiPolygon, error) {
+
+	archive, err := zip.NewReader(bytes.NewReader(content),
+		int64(len(content)))
+
+	if err != nil {
+		return nil, errors.New("the zip file could not be read")
+	}
+
+	// Read the first .shp file and the other files with the same name, up
+	// to the size of an upload in total once they are uncompressed
+	files := map[string][]byte{}
+	remaining := int64(maxUploadSize)
+	var base string
+
+	for _, ext := range []string{".shp", ".shx", ".dbf", ".prj"} {
+
+		for _, f := range archive.File {
+
+			name := strings.TrimSuffix(f.Name, filepath.Ext(f.Name))
+
+			if !strings.EqualFold(filepath.Ext(f.Name), ext) ||
+				(ext != ".shp" && !strings.EqualFold(name, base)) {
+
+				continue
+			}
+
+			if f.UncompressedSize64 > uint64(remaining) {
+				return nil, errTooLargeZip
+			}
+
+			r, err := f.Open()
+
+			if err != nil {
+				return nil, err
+			}
+
+			// The size in the header may not be true, so limit the reader
+			data, err := ioutil.ReadAll(io.LimitReader(r, remaining+1))
+			r.Close()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if int64(len(data)) > remaining {
+				return nil, errTooLargeZip
+			}
+
+			remaining -= int64(len(data))
+
+			if ext == ".shp" {
+				base = name
+			}
+
+			files[ext] = data
+			break
+		}
+	}
+
+	if files[".shp"] == nil {
+		return nil, errors.New("the zip file does not hold a shapefile")
+	}
+
+	return shapefileBoundary(files[".shp"], files[".shx"], files[".dbf"],
+		files[".prj"])
+}
+
+// shapefileBoundary returns the boundary made up of every polygon in the
+// shapefile, given its .shp file and its .shx, .dbf and .prj files, which
+// may be nil.
+func shapefileBoundary(shp []byte, shx []byte, dbf []byte,
+	prj []byte) (spatial.MultiPolygon, error) {
+
+	records, err := spatial.ParseShapefile(shp, shx, dbf, prj)
+
+	if err != nil {
+		return nil, err
+	}
+
+	geometry := spatial.MultiPolygon{}
+
+	for _, record := range records {
+		geometry = append(geometry, record.Geometry...)
+	}
+
+	if len(geometry) == 0 {
+		return nil, errors.New("the shapefile does not hold any polygons")
+	}
+
+	return geometry, nil
+}
+
+// BoundaryUpload holds the zones selected with an uploaded boundary, the
+// districts containing them, and the boundary as a GeoJSON geometry in
+// WGS84, so that the map can draw the boundary and select the zones.
+type BoundaryUpload struct {
+	Rule      string          `json:"rule"`
+	Zones     []string        `json:"zones"`
+	Districts []string        `json:"districts"`
+	Boundary  json.RawMessage `json:"boundary"`
+}
+
+// BoundaryUploadHandler handles uploads of boundary files.
+type BoundaryUploadHandler struct {
+	index          *spatial.Index
+	rdb            *ResultsDb
+	results        *ResultsHandler
+	download       *DownloadHandler
+	fileForm       string
+	ruleForm       string
+	targetForm     string
+	mapTarget      string
+	downloadTarget string
+}
+
+// NewBoundaryUploadHandler returns a new BoundaryUploadHandler with the
+// values initialised. The index holds the zone boundaries, and may be nil if
+// they could not be loaded.
+func NewBoundaryUploadHandler(index *spatial.Index, database *ResultsDb,
+	results *ResultsHandler,
+	download *DownloadHandler) *BoundaryUploadHandler {
+
+	return &BoundaryUploadHandler{
+		index:          index,
+		rdb:            database,
+		results:        results,
+		download:       download,
+		fileForm:       "file",
+		ruleForm:       "rule",
+		targetForm:     "target",
+		mapTarget:      "map",
+		downloadTarget: "download",
+	}
+}
+
+// ServeHTTP expects a GeoJSON, KML or shapefile boundary as a multipart POST
+// form, and selects the zones that overlap the boundary, or the zones chosen
+// by another rule if one is given. GeoJSON and shapefiles in British
+// National Grid coordinates are converted to WGS84. By default the results
+// page is served for the boundary, and any other results parameters such as
+// the year may be given in the form. If the target is "download", the csv
+// download is served for the zones. If the target is "map", the zones, the
+// districts containing them and the boundary are returned as JSON for the
+// map to show, and errors are also reported as JSON.
+func (h *BoundaryUploadHandler) ServeHTTP(w http.ResponseWriter,
+	r *http.Request) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	target := r.FormValue(h.targetForm)
+
+	// serveError reports an error in the form expected by the target
+	serveError := func(status int, message string) {
+
+		if target == h.mapTarget {
+			writeAPIError(w, status, message)
+		} else {
+			h.results.errorHandler.ServeError(w, message)
+		}
+	}
+
+	if r.Method != "POST" {
+
+		w.Header().Set("Allow", "POST")
+		serveError(http.StatusMethodNotAllowed,
+			"Boundary files must be uploaded with a POST request.")
+
+		return
+	}
+
+	if h.index == nil {
+
+		serveError(http.StatusServiceUnavailable,
+			"The zone boundaries are not available.")
+
+		return
+	}
+
+	// Read the boundary from the uploaded file
+	file, _, err := r.FormFile(h.fileForm)
+
+	if err != nil {
+
+		serveError(http.StatusBadRequest,
+			"Could not read the uploaded boundary file.")
+
+		return
+	}
+
+	defer file.Close()
+
+	geometry, err := parseBoundaryFile(file)
+
+	if err != nil {
+
+		serveError(http.StatusBadRequest,
+			"Could not read a boundary from the file: "+err.Error()+".")
+
+		return
+	}
+
+	boundary, err := json.Marshal(geometry)
+
+	if err != nil {
+
+		serveError(http.StatusInternalServerError,
+			"Could not encode the boundary.")
+
+		return
+	}
+
+	// Select the zones that overlap the boundary by default
+	rule := r.FormValue(h.ruleForm)
+
+	if rule == "" {
+		rule = ruleOverlap
+	}
+
+	// Serve the results page for the boundary
+	if target != h.mapTarget && target != h.downloadTarget {
+
+		r.Form.Set(h.results.boundaryForm, string(boundary))
+		r.Form.Set(h.results.ruleForm, rule)
+		h.results.ServeHTTP(w, r)
+		return
+	}
+
+	// Otherwise find the zones selected with the boundary
+	selection, err := selectBoundary(h.index, boundary, rule)
+
+	if err != nil {
+
+		serveError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(selection.Zones) == 0 {
+
+		serveError(http.StatusBadRequest,
+			"None of the zones are inside the boundary.")
+
+		return
+	}
+
+	// Serve the download for the zones
+	if target == h.downloadTarget {
+
+		if r.PostForm == nil {
+			r.PostForm = url.Values{}
+		}
+
+		r.PostForm.Set(h.download.zoneForm, strings.Join(selection.Zones, ","))
+		h.download.ServeHTTP(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &BoundaryUpload{
+		Rule:      selection.Rule,
+		Zones:     selection.Zones,
+		Districts: selectionDistricts(selection.Zones, h.rdb.Geography()),
+		Boundary:  boundary,
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"github.com/olihawkins/handlers"
+	"github.com/olihawkins/popbuilder/spatial"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test BoundaryUploadHandler reads GeoJSON, KML and shapefile boundaries,
+// and serves the results page, the csv download and the zones for the map.
+func TestBoundaryUploadHandler(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	buildTestDbs(t, dir, []string{"E01000001", "W01000001"})
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	ddb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer ddb.Close()
+
+	// Two neighbouring zones, each with 8190 people
+	square := func(x float64) spatial.MultiPolygon {
+
+		return spatial.MultiPolygon{spatial.Polygon{spatial.Ring{
+			{X: x, Y: 0}, {X: x + 0.01, Y: 0}, {X: x + 0.01, Y: 0.01},
+			{X: x, Y: 0.01}}}}
+	}
+
+	index := spatial.NewIndex([]*spatial.Feature{
+		spatial.NewFeature("E01000001", "", 0, square(0)),
+		spatial.NewFeature("W01000001", "", 0, square(0.01))})
+
+	errorHandler := handlers.LoadErrorHandler(errorPath, "", true)
+	rh := NewResultsHandler(resultsPath, rdb, nil, nil, errorHandler)
+	rh.UseBoundaries(index, ddb)
+	dh := NewDownloadHandler(downloadPath, ddb, nil, errorHandler)
+	h := NewBoundaryUploadHandler(index, rdb, rh, dh)
+
+	// upload sends the file contents with the given target and rule
+	upload := func(contents string, target string,
+		rule string) *httptest.ResponseRecorder {
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField(h.targetForm, target)
+		writer.WriteField(h.ruleForm, rule)
+		part, _ := writer.CreateFormFile(h.fileForm, "boundary")
+		part.Write([]byte(contents))
+		writer.Close()
+
+		request, _ := http.NewRequest("POST", "/upload/boundary", &body)
+		request.Header.Set("Content-Type", writer.FormDataContentType())
+		response := httptest.NewRecorder()
+
+		h.ServeHTTP(response, request)
+		return response
+	}
+
+	// The boundary covers 80% of the first zone and 40% of the second
+	geoJSON := `{"type": "FeatureCollection", "features": [{"type": ` +
+		`"Feature", "properties": {}, "geometry": {"type": "Polygon", ` +
+		`"coordinates": [[[0.002, 0], [0.014, 0], [0.014, 0.01], ` +
+		`[0.002, 0.01], [0.002, 0]]]}}]}`
+
+	kml := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<kml xmlns="http://www.opengis.net/kml/2.2"><Placemark><Polygon>` +
+		`<outerBoundaryIs><LinearRing><coordinates>0.002,0 0.014,0 ` +
+		`0.014,0.01 0.002,0.01 0.002,0</coordinates></LinearRing>` +
+		`</outerBoundaryIs></Polygon></Placemark></kml>`
+
+	response := upload(geoJSON, "", "apportion")
+
+	if !strings.Contains(response.Body.String(),
+		"The selected population is <b>16,380</b>") ||
+		!strings.Contains(response.Body.String(), "<b>9,828</b>") {
+
+		t.Errorf("Expected the results page from BoundaryUploadHandler. "+
+			"Got: %s", response.Body.String())
+	}
+
+	response = upload(kml, h.mapTarget, "centroid")
+	expected := `{"rule":"centroid","zones":["E01000001"],"districts":[],` +
+		`"boundary":{"type":"MultiPolygon","coordinates":[[[[0.002,0],`
+
+	if !strings.HasPrefix(response.Body.String(), expected) {
+		t.Errorf("Expected %s from BoundaryUploadHandler. Got: %s", expected,
+			response.Body.String())
+	}
+
+	// The same boundary as a clockwise ring in a zipped shapefile
+	ring := [][2]float64{{0.002, 0}, {0.002, 0.01}, {0.014, 0.01},
+		{0.014, 0}, {0.002, 0}}
+
+	var content bytes.Buffer
+	binary.Write(&content, binary.LittleEndian, uint32(5))
+	binary.Write(&content, binary.LittleEndian, [4]float64{})
+	binary.Write(&content, binary.LittleEndian, [3]uint32{1, 5, 0})
+	binary.Write(&content, binary.LittleEndian, ring)
+
+	shp := make([]byte, 100)
+	binary.BigEndian.PutUint32(shp, 9994)
+	binary.BigEndian.PutUint32(shp[24:], uint32(54+content.Len()/2))
+	binary.LittleEndian.PutUint32(shp[28:], 1000)
+	binary.LittleEndian.PutUint32(shp[32:], 5)
+	shp = append(shp, 0, 0, 0, 1, 0, 0, 0, byte(content.Len()/2))
+	shp = append(shp, content.Bytes()...)
+
+	// zipFiles returns a zip archive holding the files
+	zipFiles := func(files map[string][]byte) string {
+
+		var archive bytes.Buffer
+		writer := zip.NewWriter(&archive)
+
+		for name, data := range files {
+
+			f, _ := writer.Create(name)
+			f.Write(data)
+		}
+
+		writer.Close()
+		return archive.String()
+	}
+
+	archive := zipFiles(map[string][]byte{
+		"boundary/area.shp": shp,
+		"boundary/area.prj": []byte(`GEOGCS["GCS_WGS_1984",` +
+			`DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,` +
+			`298.257223563]]]`),
+	})
+
+	for _, file := range []string{archive, string(shp)} {
+
+		response = upload(file, h.mapTarget, "centroid")
+
+		if !strings.HasPrefix(response.Body.String(), expected) {
+			t.Errorf("Expected %s for a shapefile. Got: %s", expected,
+				response.Body.String())
+		}
+	}
+
+	// The other files of the shapefile are read whatever the case of their
+	// names, so these projection and index files are rejected
+	mercator := []byte(`PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",` +
+		`GEOGCS["GCS_WGS_1984"]]`)
+	shx := append(append([]byte{}, shp[:100]...), make([]byte, 8)...)
+
+	for name, data := range map[string][]byte{
+		"boundary/AREA.PRJ": mercator,
+		"boundary/Area.shx": shx,
+	} {
+
+		response = upload(zipFiles(map[string][]byte{
+			"boundary/area.shp": shp,
+			name:                data,
+		}), h.mapTarget, "centroid")
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected StatusBadRequest for a shapefile with %s. "+
+				"Got: %d %s", name, response.Code, response.Body.String())
+		}
+	}
+
+	// A zipped shapefile larger than an upload once uncompressed is rejected
+	response = upload(zipFiles(map[string][]byte{
+		"boundary/area.shp": append(shp, make([]byte, maxUploadSize)...),
+	}), h.mapTarget, "centroid")
+
+	if response.Code != http.StatusBadRequest ||
+		!strings.Contains(response.Body.String(), "too large") {
+
+		t.Errorf("Expected StatusBadRequest for a large zip file. Got: %d %s",
+			response.Code, response.Body.String())
+	}
+
+	response = upload(kml, h.downloadTarget, "")
+
+	if response.Header().Get("Content-Type") != "text/csv; charset=utf-8" ||
+		!strings.Contains(response.Body.String(), "E01000001") ||
+		!strings.Contains(response.Body.String(), "W01000001") {
+
+		t.Errorf("Expected the csv download from BoundaryUploadHandler. "+
+			"Got: %s", response.Body.String())
+	}
+
+	for _, invalid := range []string{"E01000001\n", "<kml></kml>",
+		`{"type": "Polygon", "coordinates": [[[5, 5], [6, 5], [6, 6], ` +
+			`[5, 5]]]}`} {
+
+		response = upload(invalid, h.mapTarget, "")
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("Expected StatusBadRequest for %q. Got: %d %s", invalid,
+				response.Code, response.Body.String())
+		}
+	}
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/olihawkins/popbuilder/spatial"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nationRegions are the areas that stand in for regions in Scotland and
+// Wales, which have no regions, keyed by the first letter of their codes.
+var nationRegions = map[string]struct {
+	code string
+	name string
+}{
+	"S": {"S15000001", "Scotland"},
+	"W": {"W08000001", "Wales"},
+}
+
+// zoneLookup is the district and region of a zone in a lookup file.
+type zoneLookup struct {
+	district     string
+	districtName string
+	region       string
+	regionName   string
+}
+
+// boundsArea is a region or district in the bounds.json file, with its
+// bounds as the south west and north east corners in latitude and
+// longitude. Regions hold their districts.
+type boundsArea struct {
+	Code      string                 `json:"code"`
+	Name      string                 `json:"name"`
+	Bounds    [2][2]float64          `json:"bounds"`
+	Districts map[string]*boundsArea `json:"districts,omitempty"`
+}
+
+// extend extends the bounds of the area to include the Bounds.
+func (a *boundsArea) extend(b spatial.Bounds) {
+
+	a.Bounds[0][0] = math.Min(a.Bounds[0][0], b.Min.Y)
+	a.Bounds[0][1] = math.Min(a.Bounds[0][1], b.Min.X)
+	a.Bounds[1][0] = math.Max(a.Bounds[1][0], b.Max.Y)
+	a.Bounds[1][1] = math.Max(a.Bounds[1][1], b.Max.X)
+}
+
+// newBoundsArea returns a boundsArea with empty bounds.
+func newBoundsArea(code string, name string) *boundsArea {
+
+	return &boundsArea{
+		Code:   code,
+		Name:   name,
+		Bounds: [2][2]float64{{90, 180}, {-90, -180}},
+	}
+}
+
+// runBuildBoundaries implements the build-boundaries command, which writes
+// the bounds.json file and the popzones file of each district from a
+// national boundary file of zones and a lookup of the district and region
+// of each zone, with the population of each zone read from the results
+// database.
+func runBuildBoundaries(args []string) error {
+
+	flags := flag.NewFlagSet("build-boundaries", flag.ContinueOnError)
+	boundaries := flags.String("boundaries", "",
+		"GeoJSON file or shapefile of the zone boundaries (required)")
+	lookup := flags.String("lookup", "",
+		"csv file of the district and region of each zone (required)")
+	zoneField := flags.String("zone-field", "",
+		"the property of the boundaries holding the zone code, found from "+
+			"its name if empty")
+	dbPath := flags.String("db", resultsDbPath,
+		"results database to read the population of each zone from")
+	year := flags.String("year", "",
+		"the year of the population estimates, or empty for the latest")
+	bounds := flags.String("bounds", boundsPath,
+		"bounds file to write")
+	popzones := flags.String("popzones", popzonesDir,
+		"directory to write the popzones files to")
+	prune := flags.Bool("prune", false,
+		"remove the popzones files of districts that are not in the lookup")
+
+	flags.Usage = func() {
+
+		fmt.Fprintln(flags.Output(), "Usage: popbuilder build-boundaries "+
+			"-boundaries FILE -lookup FILE [-zone-field NAME] [-db FILE] "+
+			"[-year YEAR] [-bounds FILE] [-popzones DIR] [-prune]")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	if *boundaries == "" || *lookup == "" {
+
+		flags.Usage()
+		return errors.New("build-boundaries: -boundaries and -lookup are " +
+			"required")
+	}
+
+	lookups, err := readZoneLookups(*lookup)
+
+	if err != nil {
+		return fmt.Errorf("build-boundaries: %s: %v", *lookup, err)
+	}
+
+	populations, populationYear, err := readZonePopulations(*dbPath, *year)
+
+	if err != nil {
+		return fmt.Errorf("build-boundaries: %s: %v", *dbPath, err)
+	}
+
+	records, err := readBoundaryRecords(*boundaries)
+
+	if err != nil {
+		return fmt.Errorf("build-boundaries: %s: %v", *boundaries, err)
+	}
+
+	field := *zoneField
+
+	if field == "" {
+		field = findZoneField(records)
+	}
+
+	if field == "" {
+		return fmt.Errorf("build-boundaries: %s has no zone code property, "+
+			"name it with -zone-field", *boundaries)
+	}
+
+	// Add each zone to its district and region
+	regions := map[string]*boundsArea{}
+	collections := map[string]*GeoJSONCollection{}
+	found := map[string]bool{}
+	unknown, unpopulated := 0, 0
+
+	for i := range records {
+
+		zone := strings.TrimSpace(records[i].Attributes[field])
+		area, ok := lookups[zone]
+
+		if !ok {
+
+			unknown++
+			continue
+		}
+
+		if found[zone] {
+			return fmt.Errorf("build-boundaries: zone %s is in %s twice",
+				zone, *boundaries)
+		}
+
+		found[zone] = true
+		population, ok := populations[zone]
+
+		if !ok {
+			unpopulated++
+		}
+
+		region, ok := regions[area.region]
+
+		if !ok {
+
+			region = newBoundsArea(area.region, area.regionName)
+			region.Districts = map[string]*boundsArea{}
+			regions[area.region] = region
+		}
+
+		district, ok := region.Districts[area.district]
+
+		if !ok {
+
+			district = newBoundsArea(area.district, area.districtName)
+			region.Districts[area.district] = district
+			collections[area.district] = &GeoJSONCollection{
+				Type:     "FeatureCollection",
+				Features: []*GeoJSONFeature{},
+			}
+		}
+
+		geometry := records[i].Geometry
+		district.extend(geometry.Bounds())
+		region.extend(geometry.Bounds())
+
+		collections[area.district].Features = append(
+			collections[area.district].Features, &GeoJSONFeature{
+				Type:     "Feature",
+				Geometry: &geometry,
+				Properties: map[string]interface{}{
+					"zone":       zone,
+					"population": strconv.FormatInt(population, 10),
+				},
+			})
+	}
+
+	if len(found) == 0 {
+		return fmt.Errorf("build-boundaries: none of the zones in %s are "+
+			"in %s", *boundaries, *lookup)
+	}
+
+	err = writePopzonesFiles(*popzones, collections, *prune)
+
+	if err != nil {
+		return fmt.Errorf("build-boundaries: %v", err)
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"regions": regions})
+
+	if err == nil {
+		err = writeFileAtomically(*bounds, data)
+	}
+
+	if err != nil {
+		return fmt.Errorf("build-boundaries: %v", err)
+	}
+
+	log.Printf("Wrote %d zones in %d districts to %s with the populations "+
+		"for %d, and %d regions to %s", len(found), len(collections),
+		*popzones, populationYear, len(regions), *bounds)
+
+	if unknown > 0 {
+		log.Printf("Skipped %d zones that are not in %s", unknown, *lookup)
+	}
+
+	if missing := len(lookups) - len(found); missing > 0 {
+		log.Printf("%d zones in %s have no boundary", missing, *lookup)
+	}
+
+	if unpopulated > 0 {
+		log.Printf("%d zones have no population for %d, and were given a "+
+			"population of 0", unpopulated, populationYear)
+	}
+
+	return nil
+}
+
+// readBoundaryRecords returns the records in a boundary file, which is read
+// as a shapefile if it has the .shp extension, and as GeoJSON otherwise.
+func readBoundaryRecords(path string) ([]spatial.Record, error) {
+
+	if strings.EqualFold(filepath.Ext(path), ".shp") {
+		return spatial.ReadShapefile(path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return spatial.ReadRecords(data)
+}
+
+// findZoneField returns the name of the attribute of the records that holds
+// zone codes, found from the names used by ONS and NRS, or an empty string
+// if there is none.
+func findZoneField(records []spatial.Record) string {
+
+	if len(records) == 0 {
+		return ""
+	}
+
+	names := []string{}
+
+	for name := range records[0].Attributes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	i := findZoneColumn(names)
+
+	if i < 0 {
+		return ""
+	}
+
+	return names[i]
+}
+
+// findZoneColumn returns the index of the column of zone codes in a header,
+// such as LSOA21CD or DataZone, or -1 if there is none.
+func findZoneColumn(header []string) int {
+
+	i := findAffixColumn(header, []string{"lsoa", "dz", "datazone"},
+		[]string{"cd", "code"})
+
+	if i >= 0 {
+		return i
+	}
+
+	for i, column := range header {
+
+		switch normaliseColumn(column) {
+		case "datazone", "zone":
+			return i
+		}
+	}
+
+	return -1
+}
+
+// findAffixColumn returns the index of the first column in the header whose
+// name starts with one of the prefixes and ends with one of the suffixes,
+// ignoring case, such as LAD22CD, or -1 if there is none.
+func findAffixColumn(header []string, prefixes []string,
+	suffixes []string) int {
+
+	for _, suffix := range suffixes {
+
+		for i, column := range header {
+
+			column = normaliseColumn(column)
+
+			if !strings.HasSuffix(column, suffix) {
+				continue
+			}
+
+			for _, prefix := range prefixes {
+
+				if strings.HasPrefix(column, prefix) {
+					return i
+				}
+			}
+		}
+	}
+
+	return -1
+}
+
+// normaliseColumn returns the name of a column in lower case, without any
+// byte order mark, spaces, underscores or hyphens.
+func normaliseColumn(name string) string {
+
+	name = strings.ToLower(strings.TrimPrefix(name, "\ufeff"))
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name)
+}
+
+// readZoneLookups returns the district and region of each zone in a lookup
+// csv, with columns for the zone code, and the code and name of the
+// district and region, named as in the ONS lookups, such as LSOA21CD,
+// LAD22CD, LAD22NM, RGN22CD and RGN22NM. Zones in Scotland and Wales, which
+// have no region, are given the areas that stand for their regions in the
+// bounds file.
+func readZoneLookups(path string) (map[string]zoneLookup, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	zoneColumn := findZoneColumn(header)
+	districtColumn := findAffixColumn(header, []string{"lad", "la"},
+		[]string{"cd", "code"})
+	districtNameColumn := findAffixColumn(header, []string{"lad", "la"},
+		[]string{"nm", "name"})
+	regionColumn := findAffixColumn(header, []string{"rgn", "region"},
+		[]string{"cd", "code"})
+	regionNameColumn := findAffixColumn(header, []string{"rgn", "region"},
+		[]string{"nm", "name"})
+
+	if zoneColumn < 0 || districtColumn < 0 || districtNameColumn < 0 {
+		return nil, errors.New("no zone, district code and district name " +
+			"columns in the header")
+	}
+
+	// field returns the value of a column of the record, if it has one
+	field := func(record []string, column int) string {
+
+		if column < 0 || column >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[column])
+	}
+
+	lookups := map[string]zoneLookup{}
+
+	for {
+
+		record, err := reader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		zone := field(record, zoneColumn)
+
+		if zone == "" {
+			continue
+		}
+
+		area := zoneLookup{
+			district:     field(record, districtColumn),
+			districtName: field(record, districtNameColumn),
+			region:       field(record, regionColumn),
+			regionName:   field(record, regionNameColumn),
+		}
+
+		if area.region == "" {
+
+			nation, ok := nationRegions[strings.ToUpper(zone[:1])]
+
+			if !ok {
+				return nil, errors.New("zone " + zone + " has no region")
+			}
+
+			area.region, area.regionName = nation.code, nation.name
+		}
+
+		if area.district == "" {
+			return nil, errors.New("zone " + zone + " has no district")
+		}
+
+		lookups[zone] = area
+	}
+
+	return lookups, nil
+}
+
+// readZonePopulations returns the total population of each zone in the
+// results database for the year, or the latest year if it is empty, and
+// the year that was read.
+func readZonePopulations(dbPath string,
+	yearValue string) (map[string]int64, int, error) {
+
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, 0, err
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer db.Close()
+
+	years, err := readPopulationYears(db)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	year, err := years.ParseYear(yearValue)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	terms := []string{}
+
+	for _, prefix := range []string{"m", "f"} {
+
+		for _, band := range tenYearBands {
+			terms = append(terms, prefix+"_"+band.suffix)
+		}
+	}
+
+	condition, args := years.yearCondition(year)
+	rows, err := db.Query("SELECT code, "+strings.Join(terms, " + ")+
+		" FROM population WHERE "+condition+"code IS NOT NULL", args...)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+	populations := map[string]int64{}
+
+	for rows.Next() {
+
+		var code string
+		var population int64
+		err = rows.Scan(&code, &population)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		populations[code] = population
+	}
+
+	return populations, year, rows.Err()
+}
+
+// writePopzonesFiles writes the popzones file for each district to the
+// directory, with the zones in order of their codes. If prune is true, it
+// also removes the popzones files of any other districts, which would
+// otherwise be read with the new files.
+func writePopzonesFiles(dir string,
+	collections map[string]*GeoJSONCollection, prune bool) error {
+
+	for district, collection := range collections {
+
+		sort.Slice(collection.Features, func(i, j int) bool {
+			return collection.Features[i].Properties["zone"].(string) <
+				collection.Features[j].Properties["zone"].(string)
+		})
+
+		data, err := json.Marshal(collection)
+
+		if err != nil {
+			return err
+		}
+
+		err = writeFileAtomically(filepath.Join(dir, district+".json"), data)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !prune {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+
+		district := strings.TrimSuffix(filepath.Base(path), ".json")
+
+		if _, ok := collections[district]; ok {
+			continue
+		}
+
+		err = os.Remove(path)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Removed %s, which is not a district in the lookup", path)
+	}
+
+	return nil
+}
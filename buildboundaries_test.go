@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test runBuildBoundaries writes the bounds file and a popzones file for each
+// district from a boundary file and a lookup.
+func TestRunBuildBoundaries(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "boundaries")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// Build a results database with a population of 8190 in each zone
+	codes := []string{"E01000001", "E01000002", "S01000001"}
+	males := filepath.Join(dir, "males.csv")
+	females := filepath.Join(dir, "females.csv")
+	writeSourceCSV(t, males, codes, 0)
+	writeSourceCSV(t, females, codes, 0)
+
+	err = runBuildDb([]string{"-year", "2020", "-bounds", "",
+		"-males", males, "-females", females, "-out", dir})
+
+	if err != nil {
+		t.Fatalf("Could not build the databases: %s", err)
+	}
+
+	// Three zones in two districts, and a zone missing from the lookup
+	boundaries := filepath.Join(dir, "boundaries.geojson")
+	features := ""
+
+	for i, zone := range []string{"E01000001", "E01000002", "S01000001",
+		"E01000009"} {
+
+		if i > 0 {
+			features += ", "
+		}
+
+		x := []string{"0", "1", "2", "3", "4"}
+
+		features += `{"type": "Feature", "properties": {"LSOA21CD": "` +
+			zone + `", "OBJECTID": ` + x[i+1] + `}, "geometry": {"type": ` +
+			`"Polygon", "coordinates": [[[` + x[i] + `, 50], [` + x[i+1] +
+			`, 50], [` + x[i+1] + `, 51], [` + x[i] + `, 51], [` + x[i] +
+			`, 50]]]}}`
+	}
+
+	ioutil.WriteFile(boundaries, []byte(`{"type": "FeatureCollection", `+
+		`"features": [`+features+`]}`), 0644)
+
+	lookup := filepath.Join(dir, "lookup.csv")
+	ioutil.WriteFile(lookup, []byte("\ufeffLSOA21CD,LAD22CD,LAD22NM,"+
+		"RGN22CD,RGN22NM\n"+
+		"E01000001,E09000001,City of London,E12000007,London\n"+
+		"E01000002,E09000001,City of London,E12000007,London\n"+
+		"S01000001,S12000036,City of Edinburgh,,\n"), 0644)
+
+	// A file for a district that is not in the lookup is kept unless the
+	// files are pruned
+	popzones := filepath.Join(dir, "popzones")
+	os.MkdirAll(popzones, 0755)
+	ioutil.WriteFile(filepath.Join(popzones, "E09000099.json"), []byte("{}"),
+		0644)
+
+	bounds := filepath.Join(dir, "bounds.json")
+	err = runBuildBoundaries([]string{"-boundaries", boundaries,
+		"-lookup", lookup, "-db", filepath.Join(dir, "popzones-10.db"),
+		"-bounds", bounds, "-popzones", popzones})
+
+	if err != nil {
+		t.Fatalf("Could not build the boundaries: %s", err)
+	}
+
+	var file struct {
+		Regions map[string]boundsArea
+	}
+
+	data, err := ioutil.ReadFile(bounds)
+
+	if err == nil {
+		err = json.Unmarshal(data, &file)
+	}
+
+	london := file.Regions["E12000007"].Districts["E09000001"]
+
+	if err != nil || len(file.Regions) != 2 || london == nil ||
+		london.Name != "City of London" ||
+		london.Bounds != [2][2]float64{{50, 0}, {51, 2}} ||
+		file.Regions["S15000001"].Name != "Scotland" {
+
+		t.Errorf("Expected bounds for London and Scotland. Got: %s %v",
+			data, err)
+	}
+
+	var collection struct {
+		Features []struct {
+			Properties map[string]string
+		}
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(popzones, "E09000001.json"))
+
+	if err == nil {
+		err = json.Unmarshal(data, &collection)
+	}
+
+	if err != nil || len(collection.Features) != 2 ||
+		collection.Features[0].Properties["zone"] != "E01000001" ||
+		collection.Features[1].Properties["population"] != "8190" {
+
+		t.Errorf("Expected a popzones file for E09000001. Got: %s %v",
+			data, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(popzones, "S12000036.json")); err != nil {
+		t.Errorf("Expected a popzones file for S12000036.")
+	}
+
+	if _, err = os.Stat(filepath.Join(popzones, "E09000099.json")); err != nil {
+		t.Errorf("Expected the popzones file for E09000099 to be kept.")
+	}
+
+	err = runBuildBoundaries([]string{"-boundaries", boundaries,
+		"-lookup", lookup, "-db", filepath.Join(dir, "popzones-10.db"),
+		"-bounds", bounds, "-popzones", popzones, "-prune"})
+
+	if err != nil {
+		t.Fatalf("Could not build the boundaries: %s", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(popzones, "E09000099.json")); err == nil {
+		t.Errorf("Expected the popzones file for E09000099 to be removed.")
+	}
+
+	if _, err = os.Stat(filepath.Join(popzones, "E09000001.json")); err != nil {
+		t.Errorf("Expected the popzones file for E09000001 to be kept.")
+	}
+
+	// A lookup without district columns is an error
+	ioutil.WriteFile(lookup, []byte("LSOA21CD,RGN22CD\n"), 0644)
+	err = runBuildBoundaries([]string{"-boundaries", boundaries,
+		"-lookup", lookup, "-db", filepath.Join(dir, "popzones-10.db"),
+		"-bounds", bounds, "-popzones", popzones})
+
+	if err == nil {
+		t.Errorf("Expected an error for a lookup without districts.")
+	}
+}
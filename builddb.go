@@ -0,0 +1,885 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxAge is the oldest single year of age in the source data. The value for
+// this age includes everyone of this age and over.
+const maxAge = 90
+
+// ageBand is a range of single years of age used as a column in a database.
+type ageBand struct {
+	suffix string
+	min    int
+	max    int
+}
+
+// tenYearBands are the age bands in the results database.
+var tenYearBands = []ageBand{
+	{"0_9", 0, 9}, {"10_19", 10, 19}, {"20_29", 20, 29}, {"30_39", 30, 39},
+	{"40_49", 40, 49}, {"50_59", 50, 59}, {"60_69", 60, 69},
+	{"70_79", 70, 79}, {"80_89", 80, 89}, {"90", 90, 90},
+}
+
+// fiveYearBands are the age bands in the download database.
+var fiveYearBands = []ageBand{
+	{"0_4", 0, 4}, {"5_9", 5, 9}, {"10_14", 10, 14}, {"15_19", 15, 19},
+	{"20_24", 20, 24}, {"25_29", 25, 29}, {"30_34", 30, 34},
+	{"35_39", 35, 39}, {"40_44", 40, 44}, {"45_49", 45, 49},
+	{"50_54", 50, 54}, {"55_59", 55, 59}, {"60_64", 60, 64},
+	{"65_69", 65, 69}, {"70_74", 70, 74}, {"75_79", 75, 79},
+	{"80_84", 80, 84}, {"85_89", 85, 89}, {"90", 90, 90},
+}
+
+// singleYearBands are the single years of age in the ages database.
+var singleYearBands = func() []ageBand {
+
+	bands := []ageBand{}
+
+	for age := 0; age <= maxAge; age++ {
+		bands = append(bands, ageBand{strconv.Itoa(age), age, age})
+	}
+
+	return bands
+}()
+
+// Sexes of the population in a source file.
+const (
+	sexMale    = "male"
+	sexFemale  = "female"
+	sexPersons = "persons"
+)
+
+// fileList is a flag.Value holding the paths given in a repeatable flag.
+type fileList []string
+
+// String returns the paths as a comma separated list.
+func (f *fileList) String() string {
+
+	return strings.Join(*f, ",")
+}
+
+// Set adds a path to the list.
+func (f *fileList) Set(value string) error {
+
+	*f = append(*f, value)
+	return nil
+}
+
+// zoneAges holds the single year of age population of one zone by sex.
+type zoneAges struct {
+	male      [maxAge + 1]int64
+	female    [maxAge + 1]int64
+	persons   [maxAge + 1]int64
+	hasMale   bool
+	hasFemale bool
+	hasPerson bool
+}
+
+// sumAges returns the population of the ages in the band.
+func sumAges(counts *[maxAge + 1]int64, band ageBand) int64 {
+
+	var total int64
+
+	for age := band.min; age <= band.max; age++ {
+		total += counts[age]
+	}
+
+	return total
+}
+
+// sourceData holds the population of every zone read from the source files.
+type sourceData struct {
+	zones map[string]*zoneAges
+	codes []string
+}
+
+// newSourceData returns a new empty sourceData.
+func newSourceData() *sourceData {
+
+	return &sourceData{zones: map[string]*zoneAges{}}
+}
+
+// zone returns the zoneAges for the code, creating it if necessary.
+func (s *sourceData) zone(code string) *zoneAges {
+
+	z, ok := s.zones[code]
+
+	if !ok {
+
+		z = &zoneAges{}
+		s.zones[code] = z
+		s.codes = append(s.codes, code)
+	}
+
+	return z
+}
+
+// runBuildDb implements the build-db command, which builds the population
+// databases from the ONS and NRS small area population estimates.
+func runBuildDb(args []string) error {
+
+	var males, females, persons fileList
+
+	flags := flag.NewFlagSet("build-db", flag.ContinueOnError)
+	flags.Var(&males, "males",
+		"csv or xlsx file of males by single year of age (repeatable)")
+	flags.Var(&females, "females",
+		"csv or xlsx file of females by single year of age (repeatable)")
+	flags.Var(&persons, "persons",
+		"optional csv or xlsx file of persons used to check the totals (repeatable)")
+	year := flags.Int("year", 0, "the mid-year of the estimates (required)")
+	replace := flags.Bool("replace", false,
+		"replace the databases rather than adding or updating the year")
+	outDir := flags.String("out", dbDir, "directory to write the databases to")
+	bounds := flags.String("bounds", boundsPath,
+		"bounds file listing the regions and districts, or empty to skip "+
+			"the lookup tables")
+	popzones := flags.String("popzones", popzonesDir,
+		"directory of popzones files listing the zones in each district")
+	geographyOnly := flags.Bool("geography", false,
+		"only write the lookup tables to the existing databases")
+	postcodes := flags.String("postcodes", "",
+		"ONS Postcode Directory csv to load into the results database for "+
+			"the postcode lookup")
+
+	flags.Usage = func() {
+
+		fmt.Fprintln(flags.Output(), "Usage: popbuilder build-db -year YEAR "+
+			"-males FILE -females FILE [-persons FILE] [-replace] [-out DIR] "+
+			"[-bounds FILE] [-popzones DIR] [-postcodes FILE]")
+		fmt.Fprintln(flags.Output(), "       popbuilder build-db -geography "+
+			"[-out DIR] [-bounds FILE] [-popzones DIR] [-postcodes FILE]")
+		fmt.Fprintln(flags.Output(), "       popbuilder build-db -postcodes "+
+			"FILE [-out DIR]")
+		fmt.Fprintln(flags.Output(), "A sheet of an xlsx file can be "+
+			"chosen with FILE#SHEET.")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	dbPaths := []string{
+		filepath.Join(*outDir, filepath.Base(resultsDbPath)),
+		filepath.Join(*outDir, filepath.Base(downloadDbPath)),
+		filepath.Join(*outDir, filepath.Base(agesDbPath)),
+	}
+
+	// loadPostcodes writes the postcode lookup to the results database, if
+	// a postcode file was given
+	loadPostcodes := func() error {
+
+		if *postcodes == "" {
+			return nil
+		}
+
+		if _, err := os.Stat(dbPaths[0]); err != nil {
+			return fmt.Errorf("build-db: %s does not exist", dbPaths[0])
+		}
+
+		count, err := updatePostcodesDb(dbPaths[0], *postcodes)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Wrote %d postcodes to %s", count, dbPaths[0])
+		return nil
+	}
+
+	// Load the hierarchy of areas for the lookup tables
+	var geography *Geography
+
+	if *bounds != "" {
+
+		geography, err = LoadGeography(*bounds, *popzones)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Read %d zones in %d districts from %s",
+			len(geography.zoneDistricts), len(geography.districts), *bounds)
+	}
+
+	if *geographyOnly {
+
+		if geography == nil {
+			return errors.New("build-db: -geography requires -bounds")
+		}
+
+		for _, dbPath := range dbPaths {
+
+			if _, err := os.Stat(dbPath); err != nil {
+
+				log.Printf("Skipped %s, which does not exist", dbPath)
+				continue
+			}
+
+			err = writePopulationDb(dbPath, nil, nil, 0, false, geography)
+
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Wrote the lookup tables to %s", dbPath)
+		}
+
+		return loadPostcodes()
+	}
+
+	// Only load the postcodes if no population estimates were given
+	if *postcodes != "" && len(males) == 0 && len(females) == 0 &&
+		*year == 0 {
+
+		return loadPostcodes()
+	}
+
+	if len(males) == 0 || len(females) == 0 || *year <= 0 {
+
+		flags.Usage()
+		return errors.New("build-db: -year, -males and -females are required")
+	}
+
+	// Read the source files
+	source := newSourceData()
+
+	for _, sexFiles := range []struct {
+		sex   string
+		files fileList
+	}{
+		{sexMale, males},
+		{sexFemale, females},
+		{sexPersons, persons},
+	} {
+
+		for _, spec := range sexFiles.files {
+
+			count, err := source.load(spec, sexFiles.sex)
+
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Read %d zones of %s data from %s", count,
+				sexFiles.sex, spec)
+		}
+	}
+
+	// Check the data is complete and consistent
+	err = source.validate()
+
+	if err != nil {
+		return err
+	}
+
+	// Write the databases
+	for _, db := range []struct {
+		path  string
+		bands []ageBand
+	}{
+		{dbPaths[0], tenYearBands},
+		{dbPaths[1], fiveYearBands},
+		{dbPaths[2], singleYearBands},
+	} {
+
+		err = writePopulationDb(db.path, db.bands, source, *year, *replace,
+			geography)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Wrote %d zones for %d to %s", len(source.codes), *year,
+			db.path)
+	}
+
+	return loadPostcodes()
+}
+
+// load reads the population of each zone from a source file for one sex,
+// and returns the number of zones read.
+func (s *sourceData) load(spec string, sex string) (int, error) {
+
+	rows, err := readTable(spec, sex)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Find the header row and the columns for each age
+	header := -1
+	var ages map[int]int
+	totalColumn, sexColumn := -1, -1
+
+	for i, row := range rows {
+
+		ages, totalColumn, sexColumn = parseSourceHeader(row)
+
+		if len(ages) == maxAge+1 {
+
+			header = i
+			break
+		}
+	}
+
+	if header < 0 {
+		return 0, fmt.Errorf("build-db: %s: no header row with a column "+
+			"for each age from 0 to %d+", spec, maxAge)
+	}
+
+	count := 0
+
+	for _, row := range rows[header+1:] {
+
+		// Skip rows that are not for a zone, such as national totals
+		code := ""
+
+		for _, cell := range row {
+
+			cell = strings.ToUpper(strings.TrimSpace(cell))
+
+			if zonePattern.MatchString(cell) {
+
+				code = cell
+				break
+			}
+		}
+
+		if code == "" {
+			continue
+		}
+
+		// Skip rows for other sexes in files with a sex column
+		if sexColumn >= 0 && sexColumn < len(row) &&
+			parseSex(row[sexColumn]) != sex {
+
+			continue
+		}
+
+		z := s.zone(code)
+		var counts *[maxAge + 1]int64
+		var seen *bool
+
+		switch sex {
+		case sexMale:
+			counts, seen = &z.male, &z.hasMale
+		case sexFemale:
+			counts, seen = &z.female, &z.hasFemale
+		default:
+			counts, seen = &z.persons, &z.hasPerson
+		}
+
+		if *seen {
+			return 0, fmt.Errorf("build-db: %s: %s data for %s is given "+
+				"more than once", spec, sex, code)
+		}
+
+		*seen = true
+
+		// Read the population of each age and check against the total
+		var sum int64
+
+		for age, column := range ages {
+
+			value, err := parseCount(row, column)
+
+			if err != nil {
+				return 0, fmt.Errorf("build-db: %s: %s age %d: %s",
+					spec, code, age, err)
+			}
+
+			counts[age] = value
+			sum += value
+		}
+
+		if totalColumn >= 0 {
+
+			total, err := parseCount(row, totalColumn)
+
+			if err != nil {
+				return 0, fmt.Errorf("build-db: %s: %s total: %s",
+					spec, code, err)
+			}
+
+			if total != sum {
+				return 0, fmt.Errorf("build-db: %s: %s ages sum to %d "+
+					"but the total is %d", spec, code, sum, total)
+			}
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// validate checks that every zone has data for both sexes, and that the
+// sum of males and females matches the persons data where it was given.
+func (s *sourceData) validate() error {
+
+	if len(s.codes) == 0 {
+		return errors.New("build-db: no zones were found in the source files")
+	}
+
+	for _, code := range s.codes {
+
+		z := s.zones[code]
+
+		if !z.hasMale || !z.hasFemale {
+			return fmt.Errorf("build-db: %s does not have data for "+
+				"both males and females", code)
+		}
+
+		if !z.hasPerson {
+			continue
+		}
+
+		for age := 0; age <= maxAge; age++ {
+
+			if z.male[age]+z.female[age] != z.persons[age] {
+				return fmt.Errorf("build-db: %s age %d: males and "+
+					"females sum to %d but persons is %d", code, age,
+					z.male[age]+z.female[age], z.persons[age])
+			}
+		}
+	}
+
+	return nil
+}
+
+// writePopulationDb writes the estimates for a year to the population table
+// of a database with the given age bands. Other years already in the
+// database are kept unless replace is true. If the geography is not nil the
+// lookup tables are also replaced, and if the source is nil only the lookup
+// tables are written. The database is written to a temporary file and then
+// moved into place, so the existing database is kept if anything fails.
+func writePopulationDb(dbPath string, bands []ageBand, source *sourceData,
+	year int, replace bool, geography *Geography) error {
+
+	tmpPath := dbPath + ".tmp"
+	os.Remove(tmpPath)
+
+	// Start from a copy of the existing database to keep its other years
+	if _, err := os.Stat(dbPath); err == nil && !replace {
+
+		err = copyFile(dbPath, tmpPath)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+
+	if source != nil {
+		err = updatePopulationDb(tmpPath, bands, source, year)
+	}
+
+	if err == nil && geography != nil {
+		err = updateGeographyDb(tmpPath, geography)
+	}
+
+	if err != nil {
+
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, dbPath)
+}
+
+// updateGeographyDb replaces the lookup tables in the database at dbPath.
+func updateGeographyDb(dbPath string, geography *Geography) error {
+
+	dbHandle, err := sql.Open("sqlite3", dbPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer dbHandle.Close()
+
+	return writeGeography(dbHandle, geography)
+}
+
+// updatePopulationDb replaces the estimates for a year in the database at
+// dbPath, creating the population table if necessary, and checks the total
+// it holds for the year against the source.
+func updatePopulationDb(dbPath string, bands []ageBand, source *sourceData,
+	year int) error {
+
+	dbHandle, err := sql.Open("sqlite3", dbPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer dbHandle.Close()
+
+	// Build the column definitions, persons then males then females
+	columns := []string{"year", "code"}
+	definitions := []string{"year integer", "code text"}
+	placeholders := []string{"?", "?"}
+
+	for _, prefix := range []string{"p", "m", "f"} {
+
+		for _, band := range bands {
+
+			column := prefix + "_" + band.suffix
+			columns = append(columns, column)
+			definitions = append(definitions, column+" integer")
+			placeholders = append(placeholders, "?")
+		}
+	}
+
+	// Create the table if it does not exist, or check it has a year column
+	var tables int
+
+	err = dbHandle.QueryRow("SELECT count(*) FROM sqlite_master " +
+		"WHERE type = 'table' AND name = 'population'").Scan(&tables)
+
+	if err != nil {
+		return err
+	}
+
+	if tables == 0 {
+
+		_, err = dbHandle.Exec("CREATE TABLE population (" +
+			strings.Join(definitions, ", ") + ")")
+
+		if err != nil {
+			return err
+		}
+
+		_, err = dbHandle.Exec("CREATE UNIQUE INDEX population_year_code " +
+			"ON population (year, code)")
+
+		if err != nil {
+			return err
+		}
+
+	} else {
+
+		years, err := readPopulationYears(dbHandle)
+
+		if err != nil {
+			return err
+		}
+
+		if !years.hasYearColumn {
+			return fmt.Errorf("build-db: %s has no year column, "+
+				"rebuild it with -replace", dbPath)
+		}
+	}
+
+	// Replace the zones for the year in one transaction
+	tx, err := dbHandle.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM population WHERE year = ?", year)
+
+	if err != nil {
+
+		tx.Rollback()
+		return err
+	}
+
+	statement, err := tx.Prepare("INSERT INTO population (" +
+		strings.Join(columns, ", ") + ") VALUES (" +
+		strings.Join(placeholders, ", ") + ")")
+
+	if err != nil {
+
+		tx.Rollback()
+		return err
+	}
+
+	var expected int64
+
+	for _, code := range source.codes {
+
+		z := source.zones[code]
+		values := []interface{}{year, code}
+
+		for _, band := range bands {
+			values = append(values,
+				sumAges(&z.male, band)+sumAges(&z.female, band))
+		}
+
+		for _, band := range bands {
+			values = append(values, sumAges(&z.male, band))
+		}
+
+		for _, band := range bands {
+			values = append(values, sumAges(&z.female, band))
+		}
+
+		for age := 0; age <= maxAge; age++ {
+			expected += z.male[age] + z.female[age]
+		}
+
+		_, err = statement.Exec(values...)
+
+		if err != nil {
+
+			statement.Close()
+			tx.Rollback()
+			return err
+		}
+	}
+
+	statement.Close()
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+
+	// Check the persons columns, and the males and females columns, in the
+	// database each sum to the total of the source data
+	for _, prefixes := range [][]string{{"p"}, {"m", "f"}} {
+
+		var sum int64
+		terms := []string{}
+
+		for _, prefix := range prefixes {
+
+			for _, band := range bands {
+				terms = append(terms, prefix+"_"+band.suffix)
+			}
+		}
+
+		err = dbHandle.QueryRow("SELECT sum("+strings.Join(terms, " + ")+
+			") FROM population WHERE year = ?", year).Scan(&sum)
+
+		if err != nil {
+			return err
+		}
+
+		if sum != expected {
+			return fmt.Errorf("build-db: %s holds a total of %d for %d "+
+				"but the source data sums to %d", dbPath, sum, year, expected)
+		}
+	}
+
+	return nil
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src string, dst string) error {
+
+	in, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(dst)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+
+	if err != nil {
+
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// readTable reads the rows of a csv or xlsx file. The spec is a path,
+// optionally followed by #SHEET to choose a sheet of an xlsx file. If no
+// sheet is given the sheet is chosen by matching its name to the sex.
+func readTable(spec string, sex string) ([][]string, error) {
+
+	filePath, sheet := spec, ""
+
+	if i := strings.LastIndex(spec, "#"); i >= 0 {
+		filePath, sheet = spec[:i], spec[i+1:]
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+
+	case ".csv":
+
+		f, err := os.Open(filePath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+
+		return reader.ReadAll()
+
+	case ".xlsx":
+
+		x, err := openXLSX(filePath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer x.Close()
+
+		if sheet == "" {
+			sheet, err = chooseSheet(x.SheetNames(), sex)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("build-db: %s: %s", filePath, err)
+		}
+
+		return x.ReadSheet(sheet)
+
+	default:
+
+		return nil, fmt.Errorf("build-db: %s: expected a csv or xlsx file",
+			filePath)
+	}
+}
+
+// chooseSheet returns the name of the only sheet, or the sheet whose name
+// refers to the given sex, such as "Mid-2020 Females".
+func chooseSheet(names []string, sex string) (string, error) {
+
+	if len(names) == 1 {
+		return names[0], nil
+	}
+
+	for _, name := range names {
+
+		if parseSex(name) == sex {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("no sheet for %s data, choose one with #SHEET", sex)
+}
+
+// parseSex returns the sex referred to by a value such as "M", "Males" or
+// "Mid-2020 Females", or an empty string if none is recognised.
+func parseSex(value string) string {
+
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	switch {
+	case value == "f" || strings.Contains(value, "female"):
+		return sexFemale
+	case value == "m" || strings.Contains(value, "male"):
+		return sexMale
+	case value == "p" || strings.Contains(value, "person") ||
+		strings.Contains(value, "all"):
+		return sexPersons
+	}
+
+	return ""
+}
+
+// parseSourceHeader returns the column index of each age, the total and the
+// sex in a header row. Missing columns are returned as -1.
+func parseSourceHeader(row []string) (map[int]int, int, int) {
+
+	ages := map[int]int{}
+	totalColumn, sexColumn := -1, -1
+
+	for i, cell := range row {
+
+		name := strings.ToLower(cell)
+		name = strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name)
+
+		switch name {
+		case "allages", "total", "totalpopulation", "allpeople":
+			totalColumn = i
+			continue
+		case "sex", "gender":
+			sexColumn = i
+			continue
+		}
+
+		if age, ok := parseAge(name); ok {
+
+			if _, seen := ages[age]; !seen {
+				ages[age] = i
+			}
+		}
+	}
+
+	return ages, totalColumn, sexColumn
+}
+
+// parseAge returns the age named by a normalised column header such as "0",
+// "age45", "90+" or "age90andover".
+func parseAge(name string) (int, bool) {
+
+	name = strings.TrimPrefix(name, "aged")
+	name = strings.TrimPrefix(name, "age")
+
+	for _, suffix := range []string{"+", "plus", "andover", "over"} {
+
+		if strings.HasSuffix(name, suffix) {
+
+			if strings.TrimSuffix(name, suffix) == strconv.Itoa(maxAge) {
+				return maxAge, true
+			}
+
+			return 0, false
+		}
+	}
+
+	age, err := strconv.Atoi(name)
+
+	if err != nil || age < 0 || age >= maxAge {
+		return 0, false
+	}
+
+	return age, true
+}
+
+// parseCount returns the population count in the given column of a row.
+// Counts may be written as floats in xlsx files.
+func parseCount(row []string, column int) (int64, error) {
+
+	if column >= len(row) {
+		return 0, errors.New("missing value")
+	}
+
+	value := strings.Replace(strings.TrimSpace(row[column]), ",", "", -1)
+	count, err := strconv.ParseFloat(value, 64)
+
+	if err != nil || count < 0 || count != math.Trunc(count) {
+		return 0, fmt.Errorf("invalid count %q", row[column])
+	}
+
+	return int64(count), nil
+}
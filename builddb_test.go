@@ -0,0 +1,312 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// writeSourceCSV writes a csv file in the layout of the ONS small area
+// population estimates, with a title row before the header. The population
+// of each age in a zone is the age plus the given offset.
+func writeSourceCSV(t *testing.T, path string, codes []string, offset int) {
+
+	header := []string{"LSOA Code", "LSOA Name", "All Ages"}
+
+	for age := 0; age < maxAge; age++ {
+		header = append(header, strconv.Itoa(age))
+	}
+
+	header = append(header, "90+")
+	lines := []string{"Mid-year population estimates", strings.Join(header, ",")}
+
+	for _, code := range codes {
+
+		total := 0
+		values := []string{}
+
+		for age := 0; age <= maxAge; age++ {
+
+			total += age + offset
+			values = append(values, strconv.Itoa(age+offset))
+		}
+
+		lines = append(lines, code+",Name,"+strconv.Itoa(total)+","+
+			strings.Join(values, ","))
+	}
+
+	err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
+
+	if err != nil {
+		t.Fatalf("Could not write test source file %s", path)
+	}
+}
+
+// testDir returns a new temporary directory for a test, which the test
+// removes.
+func testDir(t *testing.T) string {
+
+	dir, err := ioutil.TempDir("", "popbuilder")
+
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory.")
+	}
+
+	return dir
+}
+
+// buildTestDbs builds the databases in the directory for 2020 from a test
+// source file of the zones, with the same population for males and
+// females. The lookup tables are skipped. Any other arguments are passed to
+// runBuildDb after these, so they can add source files or replace the
+// year or the bounds file.
+func buildTestDbs(t *testing.T, dir string, codes []string,
+	args ...string) {
+
+	males := filepath.Join(dir, "males.csv")
+	writeSourceCSV(t, males, codes, 0)
+
+	err := runBuildDb(append([]string{"-year", "2020", "-bounds", "",
+		"-males", males, "-females", males, "-out", dir}, args...))
+
+	if err != nil {
+		t.Fatalf("Could not build the databases: %s", err)
+	}
+}
+
+// testDbPath returns the path in the directory of the database whose
+// default path is given.
+func testDbPath(dir string, path string) string {
+
+	return filepath.Join(dir, filepath.Base(path))
+}
+
+// Test runBuildDb builds both databases from csv source files and that the
+// databases can be read by ResultsDb and DownloadDb.
+func TestRunBuildDb(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "popbuilder")
+
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	codes := []string{"E01000001", "W01000001", "S01006506"}
+	males := filepath.Join(dir, "males.csv")
+	females := filepath.Join(dir, "females.csv")
+
+	writeSourceCSV(t, males, codes, 0)
+	writeSourceCSV(t, females, codes, 1)
+
+	err = runBuildDb([]string{"-year", "2020",
+		"-males", males, "-females", females, "-out", dir})
+
+	if err != nil {
+		t.Fatalf("Could not build the databases: %s", err)
+	}
+
+	// Ages 0 to 90 sum to 4095 for males, plus 91 for females
+	rdb := NewResultsDb(filepath.Join(dir, filepath.Base(resultsDbPath)))
+	defer rdb.Close()
+
+	results, err := rdb.GetPopulationData(codes)
+
+	if err != nil {
+		t.Fatalf("Could not read the built ResultsDb: %s", err)
+	}
+
+	if results.Population != "24,843" {
+		t.Errorf("Expected 24,843 in the built ResultsDb. Got: %s",
+			results.Population)
+	}
+
+	// Males aged 0 to 9 sum to 45 in each zone
+	if results.M0 != 135 || results.F90 != 273 {
+		t.Errorf("Expected M0 of 135 and F90 of 273 in the built ResultsDb. "+
+			"Got: %d and %d", results.M0, results.F90)
+	}
+
+	ddb := NewDownloadDb(filepath.Join(dir, filepath.Base(downloadDbPath)))
+	defer ddb.Close()
+
+	rows, err := ddb.GetPopulationData(codes)
+
+	if err != nil || len(rows) != len(codes) {
+		t.Fatalf("Could not read the built DownloadDb: %v", err)
+	}
+
+	// Persons aged 5 to 9 sum to 35 for males and 40 for females
+	if rows[0].P5 != 75 || rows[0].M85 != 435 || rows[0].F90 != 91 {
+		t.Errorf("Unexpected values in the built DownloadDb: %+v", rows[0])
+	}
+
+	// Check that a source file with an inconsistent total is rejected
+	bad := filepath.Join(dir, "bad.csv")
+	writeSourceCSV(t, bad, codes, 0)
+	source, _ := ioutil.ReadFile(bad)
+	ioutil.WriteFile(bad, []byte(strings.Replace(string(source),
+		",4095,", ",4096,", 1)), 0644)
+
+	err = runBuildDb([]string{"-year", "2021",
+		"-males", bad, "-females", females, "-out", dir})
+
+	if err == nil {
+		t.Errorf("Expected an error from runBuildDb for a bad total.")
+	}
+}
+
+// Test runBuildDb adds a second year to existing databases, and that each
+// year can be queried separately.
+func TestRunBuildDbYears(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "popbuilder")
+
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	codes := []string{"E01000001", "W01000001"}
+	males := filepath.Join(dir, "males.csv")
+	females := filepath.Join(dir, "females.csv")
+
+	// Build 2020, then 2021 with one more person of each sex at each age
+	for _, year := range []int{2020, 2021} {
+
+		writeSourceCSV(t, males, codes, year-2020)
+		writeSourceCSV(t, females, codes, year-2020)
+
+		err = runBuildDb([]string{"-year", strconv.Itoa(year),
+			"-males", males, "-females", females, "-out", dir})
+
+		if err != nil {
+			t.Fatalf("Could not build the databases for %d: %s", year, err)
+		}
+	}
+
+	rdb := NewResultsDb(filepath.Join(dir, filepath.Base(resultsDbPath)))
+	defer rdb.Close()
+
+	if len(rdb.Years()) != 2 || rdb.LatestYear() != 2021 {
+		t.Fatalf("Expected 2020 and 2021 in the built ResultsDb. Got: %v",
+			rdb.Years())
+	}
+
+	expected := map[int]string{2020: "16,380", 2021: "16,744"}
+
+	for year, population := range expected {
+
+		results, err := rdb.GetYearPopulationData(codes, year)
+
+		if err != nil || results.Population != population {
+			t.Errorf("Expected %s for %d in the built ResultsDb. Got: %v",
+				population, year, results)
+		}
+	}
+
+	if _, err := rdb.ParseYear("2019"); err == nil {
+		t.Errorf("Expected an error from ParseYear for a missing year.")
+	}
+
+	// Check that rebuilding with -replace removes the other years
+	err = runBuildDb([]string{"-year", "2022", "-replace",
+		"-males", males, "-females", females, "-out", dir})
+
+	if err != nil {
+		t.Fatalf("Could not replace the databases: %s", err)
+	}
+
+	ddb := NewDownloadDb(filepath.Join(dir, filepath.Base(downloadDbPath)))
+	defer ddb.Close()
+
+	if len(ddb.Years()) != 1 || ddb.LatestYear() != 2022 {
+		t.Errorf("Expected only 2022 in the replaced DownloadDb. Got: %v",
+			ddb.Years())
+	}
+
+	rows, err := ddb.GetPopulationData(codes)
+
+	if err != nil || len(rows) != 2 || rows[0].Year != 2022 {
+		t.Errorf("Expected two rows for 2022 from the DownloadDb. Got: %v",
+			rows)
+	}
+}
+
+// Test openXLSX reads shared and inline strings, numbers and gaps from a
+// minimal workbook.
+func TestReadXLSX(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "popbuilder")
+
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory.")
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.xlsx")
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+			`<sheet name="Mid-2020 Males" r:id="rId1"/>` +
+			`<sheet name="Mid-2020 Females" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships>` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Target="worksheets/sheet2.xml"/>` +
+			`</Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>LSOA Code</t></si>` +
+			`<si><r><t>E0100</t></r><r><t>0001</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>` +
+			`<row r="1"><c r="A1" t="s"><v>0</v></c></row>` +
+			`<row r="2"><c r="A2" t="s"><v>1</v></c>` +
+			`<c r="C2"><v>12</v></c></row></sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<worksheet><sheetData>` +
+			`<row r="1"><c r="B1" t="inlineStr"><is><t>Females</t></is></c></row>` +
+			`</sheetData></worksheet>`,
+	}
+
+	f, err := os.Create(path)
+
+	if err != nil {
+		t.Fatalf("Could not create the test workbook.")
+	}
+
+	w := zip.NewWriter(f)
+
+	for name, content := range files {
+
+		entry, _ := w.Create(name)
+		entry.Write([]byte(content))
+	}
+
+	w.Close()
+	f.Close()
+
+	// Read the females sheet, choosing it by sex
+	rows, err := readTable(path, sexFemale)
+
+	if err != nil || len(rows) != 1 || rows[0][1] != "Females" {
+		t.Errorf("Expected the females sheet from readTable. Got: %v %v",
+			rows, err)
+	}
+
+	// Read the males sheet, choosing it by name
+	rows, err = readTable(path+"#Mid-2020 Males", sexFemale)
+
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("Expected two rows from the males sheet. Got: %v %v",
+			rows, err)
+	}
+
+	if rows[0][0] != "LSOA Code" || rows[1][0] != "E01000001" ||
+		rows[1][1] != "" || rows[1][2] != "12" {
+
+		t.Errorf("Unexpected values from the males sheet: %v", rows)
+	}
+}
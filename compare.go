@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"github.com/olihawkins/decimals"
+)
+
+// Change holds the population in two years and the change between them.
+type Change struct {
+	From     int64 `json:"from"`
+	To       int64 `json:"to"`
+	Absolute int64 `json:"absolute"`
+	// Percent is nil when the population in the earlier year is zero
+	Percent *float64 `json:"percent"`
+}
+
+// newChange returns the Change between two population counts.
+func newChange(from int64, to int64) Change {
+
+	change := Change{From: from, To: to, Absolute: to - from}
+
+	if from != 0 {
+
+		percent := float64(to-from) / float64(from) * 100
+		change.Percent = &percent
+	}
+
+	return change
+}
+
+// AbsoluteString returns the absolute change formatted with a sign and
+// thousands separators.
+func (c Change) AbsoluteString() string {
+
+	switch {
+	case c.Absolute > 0:
+		return "+" + decimals.FormatThousands(c.Absolute)
+	case c.Absolute < 0:
+		return "-" + decimals.FormatThousands(-c.Absolute)
+	}
+
+	return "0"
+}
+
+// PercentString returns the percentage change to one decimal place with a
+// sign, or "n/a" if the population in the earlier year is zero.
+func (c Change) PercentString() string {
+
+	if c.Percent == nil {
+		return "n/a"
+	}
+
+	return fmt.Sprintf("%+.1f%%", *c.Percent)
+}
+
+// BandChange holds the change in the population of one age band.
+type BandChange struct {
+	Group   string `json:"group"`
+	Persons Change `json:"persons"`
+	Male    Change `json:"male"`
+	Female  Change `json:"female"`
+}
+
+// ResultsComparison holds the change in the population of a selection
+// between an earlier and a later estimate year.
+type ResultsComparison struct {
+	FromYear   int          `json:"from_year"`
+	ToYear     int          `json:"to_year"`
+	From       *ResultsData `json:"-"`
+	Population Change       `json:"population"`
+	Bands      []BandChange `json:"bands"`
+	// Missing holds the codes of the selected zones that are only in the
+	// estimates for one of the years, and are left out of both
+	Missing []string `json:"missing"`
+}
+
+// NewResultsComparison returns the comparison of the ResultsData for the
+// same selection in an earlier and a later year.
+func NewResultsComparison(from *ResultsData,
+	to *ResultsData) *ResultsComparison {
+
+	comparison := newBandsComparison(from.Year, to.Year, from.Bands(),
+		to.Bands())
+
+	comparison.From = from
+	return comparison
+}
+
+// GetComparisonData returns the population data for the zones matched in
+// the ResultsData, both in its own year and in the other year, counting
+// only the zones that are in the estimates for both years so that the
+// change is for the same area. The codes of the zones that are only in one
+// of the years are also returned. The report of the data for its own year
+// keeps the codes that were requested, with only the zones in both years
+// matched, and without the zones in the other year among the unknown codes.
+func (r *ResultsDb) GetComparisonData(data *ResultsData,
+	year int) (*ResultsData, *ResultsData, []string, error) {
+
+	other, err := r.GetYearPopulationData(data.Report.Matched, year)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Find the zones that are only in the other year
+	missing := append([]string{}, other.Report.Unknown...)
+	unknown := data.Report.Unknown
+
+	if len(unknown) > 0 {
+
+		extra, err := r.GetYearPopulationData(unknown, year)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		missing = append(missing, extra.Report.Matched...)
+		unknown = extra.Report.Unknown
+	}
+
+	if len(missing) == 0 {
+		return data, other, missing, nil
+	}
+
+	same := data
+
+	if len(other.Report.Unknown) > 0 {
+
+		same, err = r.GetYearPopulationData(other.Report.Matched, data.Year)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	report := *data.Report
+	report.Matched = same.Report.Matched
+	report.Unknown = unknown
+	same.Report = &report
+
+	return same, other, missing, nil
+}
+
+// noCommonZonesMessage returns the error shown when none of the zones
+// found for a selection are in the estimates for both years compared.
+func noCommonZonesMessage(fromYear int, toYear int) string {
+
+	return fmt.Sprintf("The selection has no zones in common between "+
+		"mid-%d and mid-%d.", fromYear, toYear)
+}
+
+// newBandsComparison returns the comparison of the population of the same
+// selection in the same age bands in an earlier and a later year.
+func newBandsComparison(fromYear int, toYear int, fromBands []PopulationBand,
+	toBands []PopulationBand) *ResultsComparison {
+
+	comparison := &ResultsComparison{
+		FromYear:   fromYear,
+		ToYear:     toYear,
+		Population: newChange(sumPersons(fromBands), sumPersons(toBands)),
+		Bands:      []BandChange{},
+		Missing:    []string{},
+	}
+
+	for i := range toBands {
+
+		comparison.Bands = append(comparison.Bands, BandChange{
+			Group:   toBands[i].Group,
+			Persons: newChange(fromBands[i].Persons, toBands[i].Persons),
+			Male:    newChange(fromBands[i].Male, toBands[i].Male),
+			Female:  newChange(fromBands[i].Female, toBands[i].Female),
+		})
+	}
+
+	return comparison
+}
+
+// orderYears returns the two years with the earlier year first.
+func orderYears(a int, b int) (int, int) {
+
+	if a > b {
+		return b, a
+	}
+
+	return a, b
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/olihawkins/handlers"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test NewResultsComparison calculates the change in each band.
+func TestNewResultsComparison(t *testing.T) {
+
+	from := &ResultsData{Year: 2019, M0: 100, F0: 0, M90: 50, F90: 40}
+	to := &ResultsData{Year: 2020, M0: 110, F0: 5, M90: 45, F90: 40}
+
+	comparison := NewResultsComparison(from, to)
+
+	if comparison.FromYear != 2019 || comparison.ToYear != 2020 {
+		t.Errorf("Expected a comparison from 2019 to 2020. Got: %d to %d",
+			comparison.FromYear, comparison.ToYear)
+	}
+
+	expected := []struct {
+		change   Change
+		absolute string
+		percent  string
+	}{
+		{comparison.Population, "+10", "+5.3%"},
+		{comparison.Bands[0].Male, "+10", "+10.0%"},
+		{comparison.Bands[0].Female, "+5", "n/a"},
+		{comparison.Bands[9].Male, "-5", "-10.0%"},
+		{comparison.Bands[9].Female, "0", "+0.0%"},
+	}
+
+	for _, e := range expected {
+
+		if e.change.AbsoluteString() != e.absolute ||
+			e.change.PercentString() != e.percent {
+
+			t.Errorf("Expected %s (%s) in the comparison. Got: %s (%s)",
+				e.absolute, e.percent, e.change.AbsoluteString(),
+				e.change.PercentString())
+		}
+	}
+}
+
+// Test ResultsHandler shows the change between two years when a year to
+// compare with is given. The databases are built from test source files.
+func TestResultsHandlerCompare(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	// Each age has one more person in 2020 than in 2019
+	codes := []string{"E01000001", "W01000001"}
+	buildTestDbs(t, dir, codes, "-year", "2019")
+
+	// E01000002 is only in the estimates for 2020
+	later := filepath.Join(dir, "later.csv")
+	writeSourceCSV(t, later, append(codes, "E01000002"), 1)
+
+	err := runBuildDb([]string{"-year", "2020", "-bounds", "",
+		"-males", later, "-females", later, "-out", dir})
+
+	if err != nil {
+		t.Fatalf("Could not build the databases for 2020: %s", err)
+	}
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	errorHandler := handlers.LoadErrorHandler(errorPath, "", true)
+	h := NewResultsHandler(resultsPath, rdb, nil, nil, errorHandler)
+
+	// Request the earlier year compared with the later year, which should
+	// show the later year with the change from the earlier year for the
+	// zones in both years
+	form := url.Values{}
+	form.Add(h.zoneForm, strings.Join(codes, ",")+",E01000002")
+	form.Add(h.yearForm, "2019")
+	form.Add(h.compareForm, "2020")
+
+	request, _ := http.NewRequest("POST", "/results",
+		strings.NewReader(form.Encode()))
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+
+	h.ServeHTTP(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected StatusOK from ResultsHandler. Got: %d",
+			response.Code)
+	}
+
+	bodyString := response.Body.String()
+
+	for _, expected := range []string{
+		"The selected population is <b>16,744</b>",
+		"Change from mid-2019 to mid-2020",
+		"changed by <b>&#43;364</b> (&#43;2.2%)",
+		"not in the estimates for both years: E01000002.",
+	} {
+
+		if !strings.Contains(bodyString, expected) {
+			t.Errorf("Expected %q in body from ResultsHandler. Got: %s",
+				expected, bodyString)
+		}
+	}
+
+	// Request a zone that is only in the later year
+	form.Set(h.zoneForm, "E01000002")
+	form.Set(h.yearForm, "2020")
+	form.Set(h.compareForm, "2019")
+	request, _ = http.NewRequest("POST", "/results",
+		strings.NewReader(form.Encode()))
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response = httptest.NewRecorder()
+
+	h.ServeHTTP(response, request)
+
+	expected := "The selection has no zones in common between mid-2019 " +
+		"and mid-2020."
+
+	if !strings.Contains(response.Body.String(), expected) {
+		t.Errorf("Expected %q from ResultsHandler. Got: %s", expected,
+			response.Body.String())
+	}
+
+	// The API reports the same error
+	ddb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer ddb.Close()
+
+	request, _ = http.NewRequest("GET",
+		"/api/v1/population?zones=E01000002&year=2020&compare=2019", nil)
+	response = httptest.NewRecorder()
+
+	NewAPIHandler(rdb, ddb).ServeHTTP(response, request)
+
+	if response.Code != http.StatusBadRequest ||
+		!strings.Contains(response.Body.String(), expected) {
+
+		t.Errorf("Expected %q from APIHandler. Got: %d %s", expected,
+			response.Code, response.Body.String())
+	}
+
+	// Request a year that is not in the database
+	form.Set(h.zoneForm, strings.Join(codes, ",")+",E01000002")
+	form.Set(h.compareForm, "2018")
+	request, _ = http.NewRequest("POST", "/results",
+		strings.NewReader(form.Encode()))
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response = httptest.NewRecorder()
+
+	h.ServeHTTP(response, request)
+
+	if strings.Contains(response.Body.String(), "The selected population") {
+		t.Errorf("Expected an error from ResultsHandler for a missing year.")
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GeographyArea is a country, region or local authority district, with the
+// codes of the zones it contains.
+type GeographyArea struct {
+	Code   string
+	Name   string
+	Level  string
+	Parent string
+	Zones  []string
+}
+
+// gbCode and gbName identify Great Britain, which contains every zone.
+const (
+	gbCode string = "K03000001"
+	gbName string = "Great Britain"
+)
+
+// countries are the countries of Great Britain keyed by the first letter of
+// the codes of the areas they contain.
+var countries = map[string]*GeographyArea{
+	"E": {Code: "E92000001", Name: "England"},
+	"S": {Code: "S92000003", Name: "Scotland"},
+	"W": {Code: "W92000004", Name: "Wales"},
+}
+
+// boundsData is the structure of the bounds.json file used by the client.
+type boundsData struct {
+	Regions map[string]struct {
+		Code      string `json:"code"`
+		Name      string `json:"name"`
+		Districts map[string]struct {
+			Code string `json:"code"`
+			Name string `json:"name"`
+		} `json:"districts"`
+	} `json:"regions"`
+}
+
+// popzonesData is the part of a popzones file needed to find its zones.
+type popzonesData struct {
+	Features []struct {
+		Properties struct {
+			Zone string `json:"zone"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// Geography holds the hierarchy of zones, districts, regions and countries.
+type Geography struct {
+	gb            *GeographyArea
+	countries     map[string]*GeographyArea
+	regions       map[string]*GeographyArea
+	districts     map[string]*GeographyArea
+	zoneDistricts map[string]string
+}
+
+// LoadGeography returns a new Geography built from the regions and districts
+// in the bounds file, and the zones in each district's popzones file.
+func LoadGeography(boundsPath string, popzonesDir string) (*Geography, error) {
+
+	g := newGeography()
+
+	// Load the regions and districts
+	boundsFile, err := ioutil.ReadFile(boundsPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var bounds boundsData
+	err = json.Unmarshal(boundsFile, &bounds)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for regionCode, region := range bounds.Regions {
+
+		g.regions[regionCode] = &GeographyArea{
+			Code:  regionCode,
+			Name:  region.Name,
+			Level: referenceRegion,
+		}
+
+		for districtCode, district := range region.Districts {
+
+			g.districts[districtCode] = &GeographyArea{
+				Code:   districtCode,
+				Name:   district.Name,
+				Level:  referenceDistrict,
+				Parent: regionCode,
+			}
+		}
+	}
+
+	// Load the zones in each district, skipping districts without a file
+	for districtCode, district := range g.districts {
+
+		zones, err := readPopzonesFile(
+			filepath.Join(popzonesDir, districtCode+".json"))
+
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, zone := range zones {
+
+			g.zoneDistricts[zone] = districtCode
+			district.Zones = append(district.Zones, zone)
+		}
+	}
+
+	g.index()
+	return g, nil
+}
+
+// newGeography returns an empty Geography.
+func newGeography() *Geography {
+
+	return &Geography{
+		countries:     map[string]*GeographyArea{},
+		regions:       map[string]*GeographyArea{},
+		districts:     map[string]*GeographyArea{},
+		zoneDistricts: map[string]string{},
+	}
+}
+
+// index adds the zones in each district to the region, country and Great
+// Britain containing it, and sorts the zones in every area so that queries
+// are repeatable.
+func (g *Geography) index() {
+
+	g.gb = &GeographyArea{Code: gbCode, Name: gbName, Level: referenceGB}
+
+	for _, country := range countries {
+
+		g.countries[country.Code] = &GeographyArea{
+			Code:   country.Code,
+			Name:   country.Name,
+			Level:  referenceCountry,
+			Parent: gbCode,
+		}
+	}
+
+	for _, region := range g.regions {
+
+		region.Parent = countryCode(region.Code)
+		region.Zones = nil
+	}
+
+	for _, district := range g.districts {
+
+		sort.Strings(district.Zones)
+		region := g.regions[district.Parent]
+
+		if region == nil {
+			continue
+		}
+
+		region.Zones = append(region.Zones, district.Zones...)
+
+		if country := g.countries[region.Parent]; country != nil {
+			country.Zones = append(country.Zones, district.Zones...)
+		}
+
+		g.gb.Zones = append(g.gb.Zones, district.Zones...)
+	}
+
+	for _, areas := range []map[string]*GeographyArea{g.countries, g.regions} {
+
+		for _, area := range areas {
+			sort.Strings(area.Zones)
+		}
+	}
+
+	sort.Strings(g.gb.Zones)
+}
+
+// readGeography returns the Geography held in the lookup tables of a
+// database, or nil if the database does not have the lookup tables.
+func readGeography(db *sql.DB) (*Geography, error) {
+
+	var tables int
+
+	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE " +
+		"type = 'table' AND name IN ('area_names', 'zone_lookup')").Scan(&tables)
+
+	if err != nil || tables < 2 {
+		return nil, err
+	}
+
+	g := newGeography()
+
+	// Read the names of the regions and districts
+	rows, err := db.Query("SELECT code, name, level FROM area_names " +
+		"WHERE level IN ('region', 'district')")
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+
+		var code, name, level string
+		err = rows.Scan(&code, &name, &level)
+
+		if err != nil {
+
+			rows.Close()
+			return nil, err
+		}
+
+		area := &GeographyArea{Code: code, Name: name, Level: level}
+
+		if level == referenceRegion {
+			g.regions[code] = area
+		} else {
+			g.districts[code] = area
+		}
+	}
+
+	rows.Close()
+
+	// Read the district and region of each zone
+	rows, err = db.Query("SELECT zone, district, region FROM zone_lookup " +
+		"ORDER BY zone")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var zone, districtCode, regionCode string
+		err = rows.Scan(&zone, &districtCode, &regionCode)
+
+		if err != nil {
+			return nil, err
+		}
+
+		district := g.districts[districtCode]
+
+		if district == nil {
+			continue
+		}
+
+		district.Parent = regionCode
+		district.Zones = append(district.Zones, zone)
+		g.zoneDistricts[zone] = districtCode
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	g.index()
+	return g, nil
+}
+
+// writeGeography replaces the lookup tables of a database with the given
+// Geography. The area_names table holds the code, name and level of each
+// country, region and district, and the zone_lookup table holds the district,
+// region and country of each zone.
+func writeGeography(db *sql.DB, g *Geography) error {
+
+	for _, statement := range []string{
+		"CREATE TABLE IF NOT EXISTS area_names " +
+			"(code text PRIMARY KEY, name text, level text)",
+		"CREATE TABLE IF NOT EXISTS zone_lookup " +
+			"(zone text PRIMARY KEY, district text, region text, country text)",
+		"CREATE INDEX IF NOT EXISTS zone_lookup_district " +
+			"ON zone_lookup (district)",
+		"CREATE INDEX IF NOT EXISTS zone_lookup_region ON zone_lookup (region)",
+	} {
+
+		_, err := db.Exec(statement)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range []string{
+		"DELETE FROM area_names", "DELETE FROM zone_lookup"} {
+
+		_, err = tx.Exec(statement)
+
+		if err != nil {
+
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Write the names of the areas at each level
+	for _, areas := range []map[string]*GeographyArea{
+		g.countries, g.regions, g.districts} {
+
+		for _, area := range areas {
+
+			_, err = tx.Exec("INSERT INTO area_names (code, name, level) "+
+				"VALUES (?, ?, ?)", area.Code, area.Name, area.Level)
+
+			if err != nil {
+
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	// Write the areas containing each zone
+	statement, err := tx.Prepare("INSERT INTO zone_lookup " +
+		"(zone, district, region, country) VALUES (?, ?, ?, ?)")
+
+	if err != nil {
+
+		tx.Rollback()
+		return err
+	}
+
+	for zone, districtCode := range g.zoneDistricts {
+
+		regionCode := g.districts[districtCode].Parent
+		_, err = statement.Exec(zone, districtCode, regionCode,
+			countryCode(regionCode))
+
+		if err != nil {
+
+			statement.Close()
+			tx.Rollback()
+			return err
+		}
+	}
+
+	statement.Close()
+	return tx.Commit()
+}
+
+// readPopzonesFile returns the codes of the zones in a popzones file.
+func readPopzonesFile(path string) ([]string, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var data popzonesData
+	err = json.NewDecoder(f).Decode(&data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	zones := []string{}
+
+	for _, feature := range data.Features {
+		zones = append(zones, feature.Properties.Zone)
+	}
+
+	return zones, nil
+}
+
+// District returns the district with the given code, or nil if there is none.
+func (g *Geography) District(code string) *GeographyArea {
+
+	return g.districts[code]
+}
+
+// Region returns the region with the given code, or nil if there is none.
+func (g *Geography) Region(code string) *GeographyArea {
+
+	return g.regions[code]
+}
+
+// Area returns the country, region or district with the given code, or
+// Great Britain, or nil if there is no area with that code. A nil Geography
+// has no areas.
+func (g *Geography) Area(code string) *GeographyArea {
+
+	if g == nil {
+		return nil
+	}
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	if code == gbCode {
+		return g.gb
+	}
+
+	for _, areas := range []map[string]*GeographyArea{
+		g.countries, g.regions, g.districts} {
+
+		if area, ok := areas[code]; ok {
+			return area
+		}
+	}
+
+	return nil
+}
+
+// ExpandCodes replaces the codes of any countries, regions and districts in a
+// list of codes with the codes of the zones they contain. It returns the
+// expanded list and the codes of the areas that were expanded. Other codes
+// are returned unchanged. A nil Geography expands nothing.
+func (g *Geography) ExpandCodes(codes []string) ([]string, []string) {
+
+	if g == nil {
+		return codes, []string{}
+	}
+
+	zones := []string{}
+	areas := []string{}
+
+	for _, code := range codes {
+
+		if area := g.Area(code); area != nil {
+
+			zones = append(zones, area.Zones...)
+			areas = append(areas, area.Code)
+			continue
+		}
+
+		zones = append(zones, code)
+	}
+
+	return zones, areas
+}
+
+// ZoneDistrict returns the district containing the zone, or nil if the zone
+// is not in any district. A nil Geography has no districts.
+func (g *Geography) ZoneDistrict(zone string) *GeographyArea {
+
+	if g == nil {
+		return nil
+	}
+
+	return g.districts[g.zoneDistricts[zone]]
+}
+
+// Country returns the country with the given code, or nil if there is none.
+func Country(code string) *GeographyArea {
+
+	for _, country := range countries {
+
+		if country.Code == code {
+			return country
+		}
+	}
+
+	return nil
+}
+
+// countryCode returns the code of the country containing the area with the
+// given code, or an empty string if it is not in Great Britain.
+func countryCode(code string) string {
+
+	if len(code) == 0 {
+		return ""
+	}
+
+	if country, ok := countries[strings.ToUpper(code[:1])]; ok {
+		return country.Code
+	}
+
+	return ""
+}
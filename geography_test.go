@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Test ExpandCodes replaces area codes with their zones and keeps others.
+func TestExpandCodes(t *testing.T) {
+
+	g := newGeography()
+	g.regions["E15000001"] = &GeographyArea{Code: "E15000001",
+		Name: "North East", Level: referenceRegion}
+	g.districts["E06000001"] = &GeographyArea{Code: "E06000001",
+		Name: "Hartlepool", Level: referenceDistrict, Parent: "E15000001",
+		Zones: []string{"E01000002", "E01000001"}}
+	g.index()
+
+	zones, areas := g.ExpandCodes([]string{"e06000001 ", "W01000001", "X"})
+	expected := []string{"E01000001", "E01000002", "W01000001", "X"}
+
+	if !reflect.DeepEqual(zones, expected) ||
+		!reflect.DeepEqual(areas, []string{"E06000001"}) {
+
+		t.Errorf("Expected %v from ExpandCodes. Got: %v %v", expected,
+			zones, areas)
+	}
+
+	for _, code := range []string{"E15000001", "E92000001", gbCode} {
+
+		if area := g.Area(code); area == nil || len(area.Zones) != 2 {
+			t.Errorf("Expected two zones in %s. Got: %+v", code, area)
+		}
+	}
+
+	// A nil Geography expands nothing
+	var none *Geography
+	zones, areas = none.ExpandCodes([]string{"E06000001"})
+
+	if len(zones) != 1 || len(areas) != 0 {
+		t.Errorf("Expected no expansion from a nil Geography. Got: %v %v",
+			zones, areas)
+	}
+}
+
+// Test runBuildDb writes the lookup tables, and that ResultsDb and the API
+// expand a district code into its zones. The databases are built from test
+// source files.
+func TestGeographyDb(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	// Two zones in Hartlepool and one in Wales
+	codes := []string{"E01011949", "E01011950", "W01000001"}
+	buildTestDbs(t, dir, codes, "-bounds", boundsPath)
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	ddb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer ddb.Close()
+
+	district := rdb.Geography().Area("E06000001")
+
+	if district == nil || district.Name != "Hartlepool" ||
+		district.Parent != "E15000001" || len(district.Zones) != 58 {
+
+		t.Fatalf("Expected Hartlepool in the lookup tables. Got: %+v",
+			district)
+	}
+
+	// Ages 0 to 90 sum to 4095 for each sex in each zone
+	results, err := rdb.GetPopulationData([]string{"E06000001", "W01000001"})
+
+	if err != nil || results.Population != "24,570" {
+		t.Fatalf("Expected 24,570 for Hartlepool and W01000001. Got: %v %v",
+			results, err)
+	}
+
+	if !reflect.DeepEqual(results.Report.Areas, []string{"E06000001"}) ||
+		len(results.Report.Matched) != 3 || len(results.Report.Unknown) != 56 {
+
+		t.Errorf("Unexpected report for Hartlepool and W01000001: %+v",
+			results.Report)
+	}
+
+	// Request the total for the North East from the API
+	h := NewAPIHandler(rdb, ddb)
+	request, _ := http.NewRequest("GET", "/api/v1/population?zones=E15000001",
+		nil)
+	response := httptest.NewRecorder()
+
+	h.ServeHTTP(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected StatusOK from APIHandler. Got: %d %s",
+			response.Code, response.Body.String())
+	}
+
+	var body APIResponse
+	err = json.Unmarshal(response.Body.Bytes(), &body)
+
+	if err != nil || len(body.Areas) != 1 || len(body.Rows) != 2 {
+		t.Fatalf("Expected one area and two rows from APIHandler. Got: %s",
+			response.Body.String())
+	}
+
+	area := body.Areas[0]
+
+	if area.Name != "North East" || area.Level != referenceRegion ||
+		area.Population != 16380 {
+
+		t.Errorf("Expected 16,380 in the North East from APIHandler. Got: %+v",
+			area)
+	}
+}
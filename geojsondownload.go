@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/olihawkins/popbuilder/spatial"
+	"net/http"
+)
+
+// zonePrecision is the precision in degrees, about a centimetre, that the
+// points of the zone boundaries are rounded to when dissolving them into
+// their outline, or finding the boundaries that neighbouring zones share.
+const zonePrecision = 1e-7
+
+// GeoJSONFeature is a GeoJSON Feature holding the boundary of one or more
+// zones and their population. Geometry is null for zones without a boundary.
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   *spatial.MultiPolygon  `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSONCollection is a GeoJSON FeatureCollection.
+type GeoJSONCollection struct {
+	Type     string            `json:"type"`
+	Features []*GeoJSONFeature `json:"features"`
+}
+
+// featureProperties returns the properties of a GeoJSON Feature for the
+// population in the given age bands. The properties are named as the
+// columns of the csv download, and the indicators are rounded to one
+// decimal place as they are in the csv.
+func featureProperties(year int, columns []string, bands []PopulationBand,
+	indicators *Indicators) map[string]interface{} {
+
+	properties := map[string]interface{}{
+		"year":       year,
+		"population": sumPersons(bands),
+	}
+
+	for i, band := range bands {
+
+		properties["people_"+columns[i]] = band.Persons
+		properties["male_"+columns[i]] = band.Male
+		properties["female_"+columns[i]] = band.Female
+	}
+
+	if indicators != nil {
+
+		properties["median_age"] = roundEstimate(indicators.MedianAge, 1)
+		properties["mean_age"] = roundEstimate(indicators.MeanAge, 1)
+		properties["child_dependency_ratio"] = roundEstimate(
+			indicators.ChildDependency, 1)
+		properties["old_age_dependency_ratio"] = roundEstimate(
+			indicators.OldAgeDependency, 1)
+		properties["sex_ratio"] = roundEstimate(indicators.SexRatio, 1)
+		properties["percent_under_16"] = roundEstimate(indicators.Under16, 1)
+		properties["percent_65_and_over"] = roundEstimate(indicators.Over65, 1)
+	}
+
+	return properties
+}
+
+// zoneCollection returns a FeatureCollection with a Feature for each zone
+// in the DownloadPage. Zones that are missing from the index of boundaries
+// have a null geometry.
+func zoneCollection(index *spatial.Index,
+	page *DownloadPage) *GeoJSONCollection {
+
+	collection := &GeoJSONCollection{
+		Type:     "FeatureCollection",
+		Features: []*GeoJSONFeature{},
+	}
+
+	for _, row := range page.Rows {
+
+		properties := featureProperties(row.Year, page.Columns, row.Bands,
+			row.Indicators)
+		properties["code"] = row.Code
+		properties["status"] = page.Report.Status(row.Code)
+
+		feature := &GeoJSONFeature{
+			Type:       "Feature",
+			Properties: properties,
+		}
+
+		if zone := index.Feature(row.Code); zone != nil {
+			feature.Geometry = &zone.Geometry
+		}
+
+		collection.Features = append(collection.Features, feature)
+	}
+
+	return collection
+}
+
+// dissolvedCollection returns a FeatureCollection with a single Feature
+// holding the outline of the zones in the DownloadPage dissolved together,
+// and their total population in each age band. The codes of the zones are
+// listed in its properties, and any zones missing from the index of
+// boundaries are left out of the outline.
+func dissolvedCollection(index *spatial.Index,
+	page *DownloadPage) *GeoJSONCollection {
+
+	geometries := []spatial.MultiPolygon{}
+	codes := []string{}
+	bands := []PopulationBand{}
+
+	for _, row := range page.Rows {
+
+		codes = append(codes, row.Code)
+
+		if zone := index.Feature(row.Code); zone != nil {
+			geometries = append(geometries, zone.Geometry)
+		}
+
+		// Add the population of each band to the totals
+		for i, band := range row.Bands {
+
+			if i == len(bands) {
+				bands = append(bands, PopulationBand{Group: band.Group})
+			}
+
+			bands[i].Persons += band.Persons
+			bands[i].Male += band.Male
+			bands[i].Female += band.Female
+		}
+	}
+
+	var indicators *Indicators
+
+	if len(bands) > 0 {
+		indicators = newIndicators(page.ages, bands)
+	}
+
+	properties := featureProperties(page.Year, page.Columns, bands, indicators)
+	properties["zones"] = codes
+
+	feature := &GeoJSONFeature{
+		Type:       "Feature",
+		Properties: properties,
+	}
+
+	if len(geometries) > 0 {
+
+		outline := spatial.Dissolve(geometries, zonePrecision)
+		feature.Geometry = &outline
+	}
+
+	return &GeoJSONCollection{
+		Type:     "FeatureCollection",
+		Features: []*GeoJSONFeature{feature},
+	}
+}
+
+// serveGeoJSON sends the zones in the DownloadPage as a GeoJSON download,
+// with a Feature for each zone, or a single Feature for their dissolved
+// outline if dissolve is true.
+func (h *DownloadHandler) serveGeoJSON(w http.ResponseWriter,
+	page *DownloadPage, dissolve bool) {
+
+	if h.boundaries == nil {
+
+		h.errorHandler.ServeError(w, "The zone boundaries are not available.")
+		return
+	}
+
+	var collection *GeoJSONCollection
+
+	if dissolve {
+		collection = dissolvedCollection(h.boundaries, page)
+	} else {
+		collection = zoneCollection(h.boundaries, page)
+	}
+
+	data, err := json.Marshal(collection)
+
+	if err != nil {
+
+		h.errorHandler.ServeError(w, "Could not encode the zone boundaries.")
+		return
+	}
+
+	// Set headers to mark it as a file download
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=download-%d.geojson", page.Year))
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Cache-Control", "must-revalidate, post-check=0, pre-check=0")
+	w.Header().Set("Pragma", "public")
+
+	w.Write(data)
+}
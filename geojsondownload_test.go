@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/olihawkins/handlers"
+	"github.com/olihawkins/popbuilder/spatial"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test DownloadHandler serves the selected zones as GeoJSON, and their
+// dissolved outline.
+func TestDownloadHandlerGeoJSON(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	buildTestDbs(t, dir, []string{"E01000001", "W01000001"})
+
+	ddb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer ddb.Close()
+
+	// Two neighbouring zones, each with 8190 people
+	square := func(x float64) spatial.MultiPolygon {
+
+		return spatial.MultiPolygon{spatial.Polygon{spatial.Ring{
+			{X: x, Y: 0}, {X: x + 0.01, Y: 0}, {X: x + 0.01, Y: 0.01},
+			{X: x, Y: 0.01}}}}
+	}
+
+	index := spatial.NewIndex([]*spatial.Feature{
+		spatial.NewFeature("E01000001", "", 0, square(0)),
+		spatial.NewFeature("W01000001", "", 0, square(0.01))})
+
+	errorHandler := handlers.LoadErrorHandler(errorPath, "", true)
+	h := NewDownloadHandler(downloadPath, ddb, nil, errorHandler)
+
+	// collection is the shape of a decoded GeoJSON download
+	type collection struct {
+		Features []struct {
+			Geometry *struct {
+				Type        string          `json:"type"`
+				Coordinates [][][][]float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+
+	// download posts the zones with the given format and dissolve values
+	download := func(format string, dissolve string) (
+		*httptest.ResponseRecorder, *collection) {
+
+		form := url.Values{}
+		form.Set(h.zoneForm, "E01000001,W01000001")
+		form.Set(h.formatForm, format)
+		form.Set(h.dissolveForm, dissolve)
+
+		request, _ := http.NewRequest("POST", "/download",
+			strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type",
+			"application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		decoded := &collection{}
+		json.Unmarshal(response.Body.Bytes(), decoded)
+
+		return response, decoded
+	}
+
+	// The boundaries are needed for GeoJSON downloads
+	response, _ := download("geojson", "")
+
+	if response.Header().Get("Content-Type") == "application/geo+json" {
+		t.Errorf("Expected an error without the zone boundaries.")
+	}
+
+	h.UseBoundaries(index)
+	response, zones := download("geojson", "")
+
+	if response.Header().Get("Content-Type") != "application/geo+json" ||
+		len(zones.Features) != 2 {
+
+		t.Fatalf("Expected a FeatureCollection of two zones. Got: %s",
+			response.Body.String())
+	}
+
+	for _, feature := range zones.Features {
+
+		properties := feature.Properties
+
+		if feature.Geometry == nil || feature.Geometry.Type != "MultiPolygon" ||
+			properties["population"] != 8190.0 ||
+			properties["people_0_4"] == nil ||
+			properties["median_age"] == nil ||
+			properties["status"] != "matched" {
+
+			t.Errorf("Unexpected zone in the GeoJSON download: %v",
+				properties)
+		}
+	}
+
+	response, dissolved := download("geojson", "true")
+
+	if len(dissolved.Features) != 1 {
+
+		t.Fatalf("Expected a single dissolved feature. Got: %s",
+			response.Body.String())
+	}
+
+	outline := dissolved.Features[0]
+
+	if outline.Geometry == nil || len(outline.Geometry.Coordinates) != 1 ||
+		len(outline.Geometry.Coordinates[0]) != 1 ||
+		outline.Properties["population"] != 16380.0 {
+
+		t.Errorf("Expected a single outline with 16380 people. Got: %s",
+			response.Body.String())
+	}
+
+	// Without a format the csv is served
+	response, _ = download("", "")
+
+	if response.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("Expected the csv download. Got: %s",
+			response.Body.String())
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+// openBandWidth is the width in years assumed for the oldest age band, which
+// includes everyone aged 90 and over, when interpolating within it.
+const openBandWidth = 10
+
+// indicatorFields is the number of fields in Indicators, which are each
+// written as a column of the download.
+const indicatorFields = 7
+
+// Define the ages that divide children, working age adults and older people
+const (
+	childMaxAge   = 15
+	workingMinAge = childMaxAge + 1
+	workingMaxAge = 64
+	olderMinAge   = workingMaxAge + 1
+)
+
+// Indicators holds summary demographic indicators for a population. Ages
+// within each age band are assumed to be evenly distributed, so indicators
+// using ages that fall inside a band are interpolated. Ratios and shares are
+// zero when the population they are calculated from is zero.
+type Indicators struct {
+	// MedianAge and MeanAge are in years
+	MedianAge float64 `json:"median_age"`
+	MeanAge   float64 `json:"mean_age"`
+	// ChildDependency is the number aged under 16 per 100 aged 16 to 64
+	ChildDependency float64 `json:"child_dependency_ratio"`
+	// OldAgeDependency is the number aged 65 and over per 100 aged 16 to 64
+	OldAgeDependency float64 `json:"old_age_dependency_ratio"`
+	// SexRatio is the number of males per 100 females
+	SexRatio float64 `json:"sex_ratio"`
+	// Under16 and Over65 are percentages of the population
+	Under16 float64 `json:"percent_under_16"`
+	Over65  float64 `json:"percent_65_and_over"`
+}
+
+// Indicators returns the summary indicators for the ResultsData, calculated
+// from its 10-year age bands.
+func (r *ResultsData) Indicators() *Indicators {
+
+	return newIndicators(tenYearBands, r.Bands())
+}
+
+// Indicators returns the summary indicators for the DownloadData, calculated
+// from its 5-year age bands.
+func (d *DownloadData) Indicators() *Indicators {
+
+	return newIndicators(fiveYearBands, d.Bands())
+}
+
+// newIndicators returns the Indicators for a population given as counts in
+// each of the given age bands.
+func newIndicators(ages []ageBand, bands []PopulationBand) *Indicators {
+
+	indicators := &Indicators{}
+
+	var total, male, female float64
+
+	for _, band := range bands {
+
+		total += float64(band.Persons)
+		male += float64(band.Male)
+		female += float64(band.Female)
+	}
+
+	if female > 0 {
+		indicators.SexRatio = male / female * 100
+	}
+
+	if total == 0 {
+		return indicators
+	}
+
+	// Find the mean from the midpoint of each band, and the median by
+	// interpolating within the band containing the middle of the population
+	var ageSum, cumulative float64
+	half := total / 2
+	medianFound := false
+
+	for i, age := range ages {
+
+		count := float64(bands[i].Persons)
+		width := bandWidth(age)
+		ageSum += count * (float64(age.min) + width/2)
+
+		if !medianFound && count > 0 && cumulative+count >= half {
+
+			indicators.MedianAge = float64(age.min) +
+				(half-cumulative)/count*width
+
+			medianFound = true
+		}
+
+		cumulative += count
+	}
+
+	indicators.MeanAge = ageSum / total
+
+	// Find the dependency ratios and shares
+	children := countAges(ages, bands, 0, childMaxAge)
+	working := countAges(ages, bands, workingMinAge, workingMaxAge)
+	older := countAges(ages, bands, olderMinAge, maxAge)
+
+	indicators.Under16 = children / total * 100
+	indicators.Over65 = older / total * 100
+
+	if working > 0 {
+
+		indicators.ChildDependency = children / working * 100
+		indicators.OldAgeDependency = older / working * 100
+	}
+
+	return indicators
+}
+
+// bandWidth returns the width of an age band in years.
+func bandWidth(age ageBand) float64 {
+
+	if age.max == maxAge {
+		return float64(maxAge - age.min + openBandWidth)
+	}
+
+	return float64(age.max - age.min + 1)
+}
+
+// countAges returns the number of persons aged from min to max inclusive,
+// taking the share of each band that overlaps those ages. A max of maxAge
+// includes everyone in the oldest band.
+func countAges(ages []ageBand, bands []PopulationBand, min int,
+	max int) float64 {
+
+	var count float64
+
+	for i, age := range ages {
+
+		lower := float64(age.min)
+		upper := lower + bandWidth(age)
+		from := float64(min)
+		to := float64(max + 1)
+
+		if max == maxAge {
+			to = upper
+		}
+
+		if from < lower {
+			from = lower
+		}
+
+		if to > upper {
+			to = upper
+		}
+
+		if to > from {
+			count += float64(bands[i].Persons) * (to - from) /
+				(upper - lower)
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// Test the indicators for a population with ten males and ten females at
+// each age from 0 to 89, which should be the same from 10-year and 5-year
+// bands.
+func TestIndicators(t *testing.T) {
+
+	results := &ResultsData{
+		M0: 100, M10: 100, M20: 100, M30: 100, M40: 100,
+		M50: 100, M60: 100, M70: 100, M80: 100,
+		F0: 100, F10: 100, F20: 100, F30: 100, F40: 100,
+		F50: 100, F60: 100, F70: 100, F80: 100,
+	}
+
+	download := &DownloadData{}
+	fields := []*int64{
+		&download.M0, &download.M5, &download.M10, &download.M15,
+		&download.M20, &download.M25, &download.M30, &download.M35,
+		&download.M40, &download.M45, &download.M50, &download.M55,
+		&download.M60, &download.M65, &download.M70, &download.M75,
+		&download.M80, &download.M85,
+		&download.F0, &download.F5, &download.F10, &download.F15,
+		&download.F20, &download.F25, &download.F30, &download.F35,
+		&download.F40, &download.F45, &download.F50, &download.F55,
+		&download.F60, &download.F65, &download.F70, &download.F75,
+		&download.F80, &download.F85,
+		&download.P0, &download.P5, &download.P10, &download.P15,
+		&download.P20, &download.P25, &download.P30, &download.P35,
+		&download.P40, &download.P45, &download.P50, &download.P55,
+		&download.P60, &download.P65, &download.P70, &download.P75,
+		&download.P80, &download.P85,
+	}
+
+	for i, field := range fields {
+
+		if i < 36 {
+			*field = 50
+		} else {
+			*field = 100
+		}
+	}
+
+	// 1,800 people with 320 under 16, 500 aged 65 and over and 980 between
+	expected := Indicators{
+		MedianAge:        45,
+		MeanAge:          45,
+		ChildDependency:  320.0 / 980 * 100,
+		OldAgeDependency: 500.0 / 980 * 100,
+		SexRatio:         100,
+		Under16:          320.0 / 1800 * 100,
+		Over65:           500.0 / 1800 * 100,
+	}
+
+	for _, indicators := range []*Indicators{
+		results.Indicators(), download.Indicators()} {
+
+		for _, value := range []struct {
+			name     string
+			expected float64
+			got      float64
+		}{
+			{"MedianAge", expected.MedianAge, indicators.MedianAge},
+			{"MeanAge", expected.MeanAge, indicators.MeanAge},
+			{"ChildDependency", expected.ChildDependency,
+				indicators.ChildDependency},
+			{"OldAgeDependency", expected.OldAgeDependency,
+				indicators.OldAgeDependency},
+			{"SexRatio", expected.SexRatio, indicators.SexRatio},
+			{"Under16", expected.Under16, indicators.Under16},
+			{"Over65", expected.Over65, indicators.Over65},
+		} {
+
+			if math.Abs(value.got-value.expected) > 1e-9 {
+				t.Errorf("Expected %s of %.3f. Got: %.3f", value.name,
+					value.expected, value.got)
+			}
+		}
+	}
+
+	// An empty population has zero indicators
+	if indicators := (&ResultsData{}).Indicators(); *indicators !=
+		(Indicators{}) {
+
+		t.Errorf("Expected zero indicators for no population. Got: %+v",
+			indicators)
+	}
+}
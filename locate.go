@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/olihawkins/popbuilder/spatial"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// maxLocatePoints is the largest number of points that can be located in a
+// single request.
+const maxLocatePoints = 10000
+
+// LocateArea holds the code and name of an area containing a point.
+type LocateArea struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// LocateResult holds the zone containing a point, and the district, region
+// and country containing the zone. Zone is empty and the areas are nil if
+// the point is not in any zone.
+type LocateResult struct {
+	Lat      float64     `json:"lat"`
+	Lon      float64     `json:"lon"`
+	Zone     string      `json:"zone"`
+	District *LocateArea `json:"district"`
+	Region   *LocateArea `json:"region"`
+	Country  *LocateArea `json:"country"`
+}
+
+// locatePoint is a point sent to be located.
+type locatePoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// locateRequest is the expected shape of a JSON request body sent to locate
+// a batch of points.
+type locateRequest struct {
+	Points []locatePoint `json:"points"`
+}
+
+// locateResponse is the body returned for a batch of points, with a result
+// for each point in the order requested.
+type locateResponse struct {
+	Results []*LocateResult `json:"results"`
+}
+
+// LocateHandler finds the zones containing points.
+type LocateHandler struct {
+	index     *spatial.Index
+	geography *Geography
+	latForm   string
+	lonForm   string
+}
+
+// NewLocateHandler returns a new LocateHandler with the values initialised.
+// The index holds the zone boundaries, and may be nil if they could not be
+// loaded. The geography is used to find the areas containing each zone, and
+// may also be nil.
+func NewLocateHandler(index *spatial.Index,
+	geography *Geography) *LocateHandler {
+
+	return &LocateHandler{
+		index:     index,
+		geography: geography,
+		latForm:   "lat",
+		lonForm:   "lon",
+	}
+}
+
+// ServeHTTP expects a latitude and longitude in the query string of a GET
+// request, and returns the zone containing the point and the district,
+// region and country containing the zone, or a 404 error if the point is
+// not in any zone. A POST request with a JSON body of the form
+// {"points": [{"lat": 51.5, "lon": -0.12}]} locates a batch of points, and
+// returns a result for each point, with an empty zone for points that are
+// not in any zone. Points that are not valid coordinates are rejected with
+// a 400 error. Responses and errors are sent as JSON.
+func (h *LocateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if h.index == nil {
+
+		writeAPIError(w, http.StatusServiceUnavailable,
+			"The zone boundaries are not available.")
+
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+
+		lat, latErr := strconv.ParseFloat(r.FormValue(h.latForm), 64)
+		lon, lonErr := strconv.ParseFloat(r.FormValue(h.lonForm), 64)
+
+		if latErr != nil || lonErr != nil || !validLatLon(lat, lon) {
+
+			writeAPIError(w, http.StatusBadRequest,
+				"Could not parse the request: invalid lat or lon.")
+
+			return
+		}
+
+		result := h.locate(lat, lon)
+
+		if result.Zone == "" {
+
+			writeAPIError(w, http.StatusNotFound,
+				"No zone contains that point.")
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+
+	case "POST":
+
+		var body locateRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+			maxRequestBody)).Decode(&body)
+
+		if err != nil {
+
+			writeAPIError(w, http.StatusBadRequest,
+				"Could not parse the request: invalid JSON body.")
+
+			return
+		}
+
+		if len(body.Points) > maxLocatePoints {
+
+			writeAPIError(w, http.StatusBadRequest,
+				"Too many points: the limit is "+
+					strconv.Itoa(maxLocatePoints)+" per request.")
+
+			return
+		}
+
+		for i, point := range body.Points {
+
+			if !validLatLon(point.Lat, point.Lon) {
+
+				writeAPIError(w, http.StatusBadRequest,
+					"Could not parse the request: invalid lat or lon for "+
+						"point "+strconv.Itoa(i+1)+".")
+
+				return
+			}
+		}
+
+		response := &locateResponse{Results: []*LocateResult{}}
+
+		for _, point := range body.Points {
+
+			response.Results = append(response.Results,
+				h.locate(point.Lat, point.Lon))
+		}
+
+		writeJSON(w, http.StatusOK, response)
+
+	default:
+
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed,
+			"That method is not supported.")
+	}
+}
+
+// validLatLon returns true if the latitude and longitude are finite and
+// within -90 to 90 and -180 to 180 degrees respectively.
+func validLatLon(lat float64, lon float64) bool {
+
+	return !math.IsNaN(lat) && !math.IsNaN(lon) &&
+		lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// locate returns the LocateResult for a point.
+func (h *LocateHandler) locate(lat float64, lon float64) *LocateResult {
+
+	result := &LocateResult{Lat: lat, Lon: lon}
+	feature := h.index.Locate(spatial.Point{X: lon, Y: lat})
+
+	if feature == nil {
+		return result
+	}
+
+	result.Zone = feature.Zone
+
+	// Use the district of the boundary file if the geography has no record
+	// of the zone
+	district := h.geography.ZoneDistrict(feature.Zone)
+
+	if district == nil {
+
+		result.District = &LocateArea{Code: feature.District}
+		return result
+	}
+
+	result.District = &LocateArea{Code: district.Code, Name: district.Name}
+
+	if region := h.geography.Area(district.Parent); region != nil {
+
+		result.Region = &LocateArea{Code: region.Code, Name: region.Name}
+
+		if country := h.geography.Area(region.Parent); country != nil {
+			result.Country = &LocateArea{Code: country.Code, Name: country.Name}
+		}
+	}
+
+	return result
+}
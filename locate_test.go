@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/olihawkins/popbuilder/spatial"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test the zone boundaries in a popzones file are found at the centroid of
+// each zone, where the centroid falls inside the zone.
+func TestLocateZones(t *testing.T) {
+
+	f, err := os.Open(filepath.Join(popzonesDir, "E06000001.json"))
+
+	if err != nil {
+		t.Fatalf("Could not open the popzones file: %s", err)
+	}
+
+	defer f.Close()
+
+	features, err := spatial.ReadFeatures(f, "E06000001")
+
+	if err != nil || len(features) != 58 {
+		t.Fatalf("Expected 58 zones in Hartlepool. Got: %d %v",
+			len(features), err)
+	}
+
+	index := spatial.NewIndex(features)
+	tested := 0
+
+	for _, feature := range features {
+
+		centroid := feature.Geometry.Centroid()
+
+		if !feature.Geometry.Contains(centroid) {
+			continue
+		}
+
+		tested++
+
+		if found := index.Locate(centroid); found != feature {
+			t.Errorf("Expected %s at its centroid. Got: %v", feature.Zone,
+				found)
+		}
+	}
+
+	if tested < len(features)/2 {
+		t.Errorf("Expected most centroids to be inside their zones. Got: %d",
+			tested)
+	}
+}
+
+// Test LocateHandler finds the zone and areas for single points and
+// batches.
+func TestLocateHandler(t *testing.T) {
+
+	geometry := spatial.MultiPolygon{spatial.Polygon{spatial.Ring{
+		{X: -1.3, Y: 54.6}, {X: -1.2, Y: 54.6}, {X: -1.2, Y: 54.7},
+		{X: -1.3, Y: 54.7}}}}
+
+	index := spatial.NewIndex([]*spatial.Feature{
+		spatial.NewFeature("E01011949", "E06000001", 1944, geometry)})
+
+	geography := newGeography()
+	geography.regions["E15000001"] = &GeographyArea{Code: "E15000001",
+		Name: "North East", Level: referenceRegion}
+	geography.districts["E06000001"] = &GeographyArea{Code: "E06000001",
+		Name: "Hartlepool", Level: referenceDistrict, Parent: "E15000001",
+		Zones: []string{"E01011949"}}
+	geography.zoneDistricts["E01011949"] = "E06000001"
+	geography.index()
+
+	h := NewLocateHandler(index, geography)
+
+	request, _ := http.NewRequest("GET",
+		"/api/v1/locate?lat=54.65&lon=-1.25", nil)
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+
+	expected := `{"lat":54.65,"lon":-1.25,"zone":"E01011949",` +
+		`"district":{"code":"E06000001","name":"Hartlepool"},` +
+		`"region":{"code":"E15000001","name":"North East"},` +
+		`"country":{"code":"E92000001","name":"England"}}`
+
+	if response.Body.String() != expected {
+		t.Errorf("Expected %s from LocateHandler. Got: %s", expected,
+			response.Body.String())
+	}
+
+	for path, status := range map[string]int{
+		"/api/v1/locate?lat=51.5&lon=-0.12": http.StatusNotFound,
+		"/api/v1/locate?lat=north&lon=0":    http.StatusBadRequest,
+		"/api/v1/locate?lat=NaN&lon=0":      http.StatusBadRequest,
+		"/api/v1/locate?lat=Inf&lon=0":      http.StatusBadRequest,
+		"/api/v1/locate?lat=90.5&lon=0":     http.StatusBadRequest,
+		"/api/v1/locate?lat=-91&lon=0":      http.StatusBadRequest,
+		"/api/v1/locate?lat=51.5&lon=180.1": http.StatusBadRequest,
+		"/api/v1/locate?lat=51.5&lon=-181":  http.StatusBadRequest,
+	} {
+
+		request, _ = http.NewRequest("GET", path, nil)
+		response = httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != status {
+			t.Errorf("Expected status %d for %s. Got: %d", status, path,
+				response.Code)
+		}
+	}
+
+	// Locate a batch of points
+	request, _ = http.NewRequest("POST", "/api/v1/locate", strings.NewReader(
+		`{"points": [{"lat": 51.5, "lon": -0.12}, {"lat": 54.65, "lon": -1.25}]}`))
+	request.Header.Set("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+
+	if !strings.HasPrefix(response.Body.String(), `{"results":[`+
+		`{"lat":51.5,"lon":-0.12,"zone":"","district":null,"region":null,`+
+		`"country":null},{"lat":54.65,"lon":-1.25,"zone":"E01011949",`) {
+
+		t.Errorf("Unexpected batch response from LocateHandler: %s",
+			response.Body.String())
+	}
+
+	// A batch with any point that is out of range is rejected
+	request, _ = http.NewRequest("POST", "/api/v1/locate", strings.NewReader(
+		`{"points": [{"lat": 54.65, "lon": -1.25}, {"lat": 95, "lon": 0}]}`))
+	request.Header.Set("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest for a point out of range. "+
+			"Got: %d", response.Code)
+	}
+
+	// Without boundaries the service is unavailable
+	h = NewLocateHandler(nil, nil)
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected StatusServiceUnavailable without boundaries. "+
+			"Got: %d", response.Code)
+	}
+}
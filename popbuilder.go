@@ -8,16 +8,21 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"errors"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/olihawkins/decimals"
 	"github.com/olihawkins/handlers"
+	"github.com/olihawkins/popbuilder/spatial"
 	htmlTemplate "html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	textTemplate "text/template"
 	"time"
 )
@@ -31,12 +36,20 @@ const (
 	resourcesDir   string = "resources"
 	resultsDbPath  string = dbDir + sep + "popzones-10.db"
 	downloadDbPath string = dbDir + sep + "popzones-5.db"
+	agesDbPath     string = dbDir + sep + "popzones-1.db"
+	selectionsPath string = dbDir + sep + "selections.db"
+	accountsPath   string = dbDir + sep + "accounts.db"
 	introPath      string = templateDir + sep + "intro.html"
 	mapPath        string = templateDir + sep + "map.html"
 	resultsPath    string = templateDir + sep + "results.html"
 	downloadPath   string = templateDir + sep + "download.txt"
 	notFoundPath   string = templateDir + sep + "notfound.html"
 	errorPath      string = templateDir + sep + "error.html"
+	boundsPath     string = resourcesDir + sep + "app" + sep + "bounds.json"
+	popzonesDir    string = resourcesDir + sep + "popzones"
+	topozonesDir   string = resourcesDir + sep + "topozones"
+	cacheDir       string = "cache"
+	popzonesCache  string = cacheDir + sep + "popzones"
 	defaultError   string = "Sorry! An error has occurred."
 )
 
@@ -48,6 +61,7 @@ type HomeHandler struct {
 	skipCookie      string
 	postedForm      string
 	skipForm        string
+	selectionQuery  string
 	notFoundHandler *handlers.NotFoundHandler
 }
 
@@ -76,6 +90,7 @@ func NewHomeHandler(introPath string, mapPath string,
 		skipCookie:      "skip",
 		postedForm:      "posted",
 		skipForm:        "skipintro",
+		selectionQuery:  "s",
 		notFoundHandler: notFoundHandler,
 	}
 }
@@ -118,8 +133,13 @@ func (h *HomeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 
 		// If the form was not posted, check if the intro should be skipped
-		// If skipCookie has been set, send the map
-		if _, err := r.Cookie(h.skipCookie); err == nil {
+		// If a saved selection is being reloaded, or skipCookie has been
+		// set, send the map
+		if r.URL.Query().Get(h.selectionQuery) != "" {
+
+			page = h.mapPage
+
+		} else if _, err := r.Cookie(h.skipCookie); err == nil {
 
 			page = h.mapPage
 
@@ -149,14 +169,38 @@ func (h *HomeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type ResultsData struct {
 	Population string
 	Zones      string
+	Year       int
+	Years      []int
+	Report     *ZoneReport
+	Comparison *ResultsComparison
+	Reference  *ReferencePopulation
+	// Pyramid and Outline are the age bands drawn in the pyramid, which are
+	// either the 10-year bands or the custom bands listed in AgeBands
+	Pyramid  []PopulationBand
+	Outline  []PopulationBand
+	AgeBands string
+	HasAges  bool
+	// SelectionID is the ID of the saved selection shown in permalinks, or
+	// an empty string if the results are not for a saved selection. If
+	// Permalinks is true a permalink to the results can be requested.
+	SelectionID string
+	Permalinks  bool
+	// Boundary is the boundary used to apportion the population, or nil if
+	// the zones were not selected with a boundary or not apportioned
+	Boundary *BoundaryEstimate
 	M0, M10, M20, M30, M40, M50, M60, M70, M80, M90,
 	F0, F10, F20, F30, F40, F50, F60, F70, F80, F90 int64
 }
 
 // ResultsDb encapsulates the sqlite database used by resultsHandler.
 type ResultsDb struct {
-	db        *sql.DB
-	baseQuery string
+	*populationYears
+	db             *sql.DB
+	geography      *Geography
+	postcodes      bool
+	baseQuery      string
+	referenceCache map[string]*ResultsData
+	referenceLock  sync.Mutex
 }
 
 // NewResultsDb returns a new resultsDB with the database initialised.
@@ -169,9 +213,34 @@ func NewResultsDb(dbPath string) *ResultsDb {
 		log.Fatal(err)
 	}
 
+	// Find the estimate years held in the database
+	years, err := readPopulationYears(dbHandle)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the hierarchy of areas, if the database holds the lookup tables
+	geography, err := readGeography(dbHandle)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Check whether the database holds the postcode lookup
+	postcodes, err := hasPostcodes(dbHandle)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Create a new resultsDB with the database handle and return a pointer
 	return &ResultsDb{
-		db: dbHandle,
+		populationYears: years,
+		db:              dbHandle,
+		geography:       geography,
+		postcodes:       postcodes,
+		referenceCache:  map[string]*ResultsData{},
 		baseQuery: `
 SELECT
 	sum(m_0_9), sum(m_10_19), sum(m_20_29), sum(m_30_39), sum(m_40_49), 
@@ -181,7 +250,7 @@ SELECT
 FROM 
 	population 
 WHERE 
-	code IN (`,
+	`,
 	}
 }
 
@@ -191,61 +260,207 @@ func (r *ResultsDb) Close() {
 	r.db.Close()
 }
 
-// GetPopulationData returns the population data for the given zones.
+// Geography returns the hierarchy of areas held in the database, or nil if
+// the database does not hold the lookup tables.
+func (r *ResultsDb) Geography() *Geography {
+
+	return r.geography
+}
+
+// UseGeography sets the hierarchy of areas used to expand the codes of
+// countries, regions and districts into zones, for databases that do not
+// hold the lookup tables.
+func (r *ResultsDb) UseGeography(geography *Geography) {
+
+	r.geography = geography
+}
+
+// CheckZones reports which of the given zones are in the population table
+// for the given year. The codes of countries, regions and districts are
+// expanded into their zones.
+func (r *ResultsDb) CheckZones(zones []string, year int) (*ZoneReport, error) {
+
+	return checkZones(r.db, r.populationYears, r.geography, year, zones)
+}
+
+// GetPopulationData returns the population data for the given zones for the
+// latest year in the database.
 func (r *ResultsDb) GetPopulationData(zones []string) (*ResultsData, error) {
 
-	// Declare variables to hold query results
-	var m0, m10, m20, m30, m40, m50, m60, m70, m80, m90,
-		f0, f10, f20, f30, f40, f50, f60, f70, f80, f90 int64
+	return r.GetYearPopulationData(zones, r.LatestYear())
+}
+
+// GetYearPopulationData returns the population data for the given zones for
+// the given year. Only the zones listed as matched in the returned
+// ResultsData.Report are counted.
+func (r *ResultsDb) GetYearPopulationData(zones []string,
+	year int) (*ResultsData, error) {
 
-	// Build the query string and an interface slice of args to pass to Query
-	query := r.baseQuery
-	args := []interface{}{}
+	// Declare variables to hold the query results for each chunk of zones
+	// and the totals across all chunks, with males first then females
+	var sums, totals [20]int64
 
-	for i := 0; i < len(zones); i++ {
+	// Check the zones and return an empty result if none were matched
+	report, err := r.CheckZones(zones, year)
 
-		query += "?,"
-		args = append(args, zones[i])
+	if err != nil {
+		return nil, err
 	}
 
-	query = query[:len(query)-1] + ")"
+	if len(report.Matched) == 0 {
 
-	// Execute the query and scan the results
-	err := r.db.QueryRow(query, args...).Scan(
-		&m0, &m10, &m20, &m30, &m40, &m50, &m60, &m70, &m80, &m90,
-		&f0, &f10, &f20, &f30, &f40, &f50, &f60, &f70, &f80, &f90)
+		return &ResultsData{
+			Population: "0",
+			Year:       year,
+			Years:      r.Years(),
+			Report:     report,
+		}, nil
+	}
+
+	// Query each chunk of zones and add the sums to the totals
+	condition, conditionArgs := r.yearCondition(year)
+
+	for _, chunk := range chunkZones(report.Matched) {
+
+		query, args := inQuery(r.baseQuery+condition+"code IN (", chunk,
+			conditionArgs...)
+
+		err = r.db.QueryRow(query, args...).Scan(
+			&sums[0], &sums[1], &sums[2], &sums[3], &sums[4],
+			&sums[5], &sums[6], &sums[7], &sums[8], &sums[9],
+			&sums[10], &sums[11], &sums[12], &sums[13], &sums[14],
+			&sums[15], &sums[16], &sums[17], &sums[18], &sums[19])
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range sums {
+			totals[i] += sums[i]
+		}
+	}
+
+	return r.newResultsData(totals, year, report), nil
+}
+
+// GetReferencePopulationData returns the population data for the given
+// reference area for the given year. Results are cached as the population
+// of a reference area is shared by every selection within it.
+func (r *ResultsDb) GetReferencePopulationData(area *ReferenceArea,
+	year int) (*ResultsData, error) {
+
+	key := fmt.Sprintf("%s:%s:%d", area.Level, area.Code, year)
+
+	r.referenceLock.Lock()
+	results, ok := r.referenceCache[key]
+	r.referenceLock.Unlock()
+
+	if ok {
+		return results, nil
+	}
+
+	// Sum zones by code prefix for Great Britain and the countries, and by
+	// listing the zones for regions and districts
+	var err error
+
+	if area.zones == nil {
+		results, err = r.getPrefixPopulationData(area.prefix, year)
+	} else {
+		results, err = r.GetYearPopulationData(area.zones, year)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.referenceLock.Lock()
+	r.referenceCache[key] = results
+	r.referenceLock.Unlock()
+
+	return results, nil
+}
+
+// getPrefixPopulationData returns the population data for all of the zones
+// whose codes start with the given prefix for the given year.
+func (r *ResultsDb) getPrefixPopulationData(prefix string,
+	year int) (*ResultsData, error) {
+
+	var totals [20]int64
+
+	condition, args := r.yearCondition(year)
+	args = append(args, prefix+"%")
+
+	// Sums are null if no zones match, so scan into nullable values
+	var sums [20]sql.NullInt64
+	err := r.db.QueryRow(r.baseQuery+condition+"code LIKE ?", args...).Scan(
+		&sums[0], &sums[1], &sums[2], &sums[3], &sums[4],
+		&sums[5], &sums[6], &sums[7], &sums[8], &sums[9],
+		&sums[10], &sums[11], &sums[12], &sums[13], &sums[14],
+		&sums[15], &sums[16], &sums[17], &sums[18], &sums[19])
 
 	if err != nil {
 		return nil, err
 	}
 
+	for i := range sums {
+		totals[i] = sums[i].Int64
+	}
+
+	return r.newResultsData(totals, year, &ZoneReport{}), nil
+}
+
+// newResultsData returns a ResultsData for the given totals for each band,
+// with males first then females.
+func (r *ResultsDb) newResultsData(totals [20]int64, year int,
+	report *ZoneReport) *ResultsData {
+
 	// Calculate the total population
-	population :=
-		m0 + m10 + m20 + m30 + m40 + m50 + m60 + m70 + m80 + m90 +
-			f0 + f10 + f20 + f30 + f40 + f50 + f60 + f70 + f80 + f90
+	var population int64
 
-	results := &ResultsData{
-		Population: decimals.FormatThousands(population),
-		M0:         m0, M10: m10, M20: m20, M30: m30, M40: m40,
-		M50: m50, M60: m60, M70: m70, M80: m80, M90: m90,
-		F0: f0, F10: f10, F20: f20, F30: f30, F40: f40,
-		F50: f50, F60: f60, F70: f70, F80: f80, F90: f90,
+	for _, total := range totals {
+		population += total
 	}
 
-	return results, nil
+	return &ResultsData{
+		Population: decimals.FormatThousands(population),
+		Year:       year,
+		Years:      r.Years(),
+		Report:     report,
+		M0:         totals[0], M10: totals[1], M20: totals[2], M30: totals[3],
+		M40: totals[4], M50: totals[5], M60: totals[6], M70: totals[7],
+		M80: totals[8], M90: totals[9],
+		F0: totals[10], F10: totals[11], F20: totals[12], F30: totals[13],
+		F40: totals[14], F50: totals[15], F60: totals[16], F70: totals[17],
+		F80: totals[18], F90: totals[19],
+	}
 }
 
 // ResultsHandler handles requests sent to the results page.
 type ResultsHandler struct {
-	rdb          *ResultsDb
-	errorHandler *handlers.ErrorHandler
-	template     *htmlTemplate.Template
-	zoneForm     string
+	rdb           *ResultsDb
+	ages          *AgeDb
+	geography     *Geography
+	errorHandler  *handlers.ErrorHandler
+	template      *htmlTemplate.Template
+	zoneForm      string
+	yearForm      string
+	compareForm   string
+	referenceForm string
+	bandsForm     string
+	boundaryForm  string
+	ruleForm      string
+	permalinkForm string
+	selections    *SelectionDb
+	boundaries    *spatial.Index
+	ddb           *DownloadDb
 }
 
 // NewResultsHandler returns a new ResultsHandler with the values initialised.
-func NewResultsHandler(templatePath string, database *ResultsDb,
-	errorHandler *handlers.ErrorHandler) *ResultsHandler {
+// The AgeDb is used to show custom age bands, and the geography is used to
+// find the region and district containing each selection. Either may be nil
+// if these are not needed.
+func NewResultsHandler(templatePath string, database *ResultsDb, ages *AgeDb,
+	geography *Geography, errorHandler *handlers.ErrorHandler) *ResultsHandler {
 
 	templateFile, err := htmlTemplate.ParseFiles(templatePath)
 
@@ -254,26 +469,123 @@ func NewResultsHandler(templatePath string, database *ResultsDb,
 	}
 
 	return &ResultsHandler{
-		rdb:          database,
-		errorHandler: errorHandler,
-		template:     templateFile,
-		zoneForm:     "zones",
+		rdb:           database,
+		ages:          ages,
+		geography:     geography,
+		errorHandler:  errorHandler,
+		template:      templateFile,
+		zoneForm:      "zones",
+		yearForm:      "year",
+		compareForm:   "compare",
+		referenceForm: "reference",
+		bandsForm:     "bands",
+		boundaryForm:  "boundary",
+		ruleForm:      "rule",
+		permalinkForm: "permalink",
 	}
 }
 
-// ServeHTTP expects a list of area codes for population zones as POST data.
+// UseSelections sets the SelectionDb used to save selections for which a
+// permalink is requested. Permalinks are not offered if it is not set.
+func (h *ResultsHandler) UseSelections(selections *SelectionDb) {
+
+	h.selections = selections
+}
+
+// UseBoundaries sets the index of zone boundaries used to find the zones
+// selected with a boundary, and the DownloadDb used to apportion the
+// population of those zones. Boundaries are not accepted if it is not set.
+func (h *ResultsHandler) UseBoundaries(index *spatial.Index, ddb *DownloadDb) {
+
+	h.boundaries = index
+	h.ddb = ddb
+}
+
+// ServeHTTP expects a list of area codes for population zones as POST data
+// or in the query string, and optionally the estimate year, which defaults
+// to the latest year.
 // The population data for the given areas is retrieved from a sqlite database
 // and is inserted into the template for display in a d3 population pyramid.
+// If a second year is given to compare with, the pyramid shows the later year
+// with the earlier year in outline, alongside the change in each age band.
+// Otherwise the outline shows the area chosen as the reference, which is
+// Great Britain or the country, region or district containing the selection.
+// The pyramid uses 10-year age bands unless a list of custom age bands such
+// as "0-15,16-64,65+" is given.
+// Instead of a list of zones, a GeoJSON boundary may be given with a rule for
+// selecting the zones inside it. If the population is apportioned, the
+// population estimated to live inside the boundary is shown with the results.
+// If a permalink is requested, the matched zones are saved with any boundary
+// and rule, and the request is redirected to the permalink.
 func (h *ResultsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	h.serve(w, r, "")
+}
+
+// serve serves the results page as in ServeHTTP. The selectionID is the ID
+// of the saved selection being shown, which is an empty string unless the
+// request came from its permalink.
+func (h *ResultsHandler) serve(w http.ResponseWriter, r *http.Request,
+	selectionID string) {
+
 	var buffer bytes.Buffer
+	zonestr := r.FormValue(h.zoneForm)
+	boundarystr := r.FormValue(h.boundaryForm)
 
-	// Check the form contains the expected zone data
-	if zonestr := r.PostFormValue(h.zoneForm); zonestr != "" {
+	// Check the form contains the expected zone data or a boundary
+	if zonestr != "" || boundarystr != "" {
+
+		// Check the requested year is in the database
+		year, err := h.rdb.ParseYear(r.FormValue(h.yearForm))
+
+		if err != nil {
+
+			h.errorHandler.ServeError(w,
+				"Population estimates are not available for that year.")
+
+			return
+		}
+
+		// Check the year to compare with, if one was requested
+		compareYear := year
+
+		if comparestr := r.FormValue(h.compareForm); comparestr != "" {
+
+			compareYear, err = h.rdb.ParseYear(comparestr)
+
+			if err != nil {
+
+				h.errorHandler.ServeError(w,
+					"Population estimates are not available for that year.")
+
+				return
+			}
+		}
+
+		// Show the later year when comparing two years
+		fromYear, toYear := orderYears(year, compareYear)
+
+		// Find the zones selected with the boundary if one was given
+		var estimate *BoundaryEstimate
+
+		if boundarystr != "" {
+
+			var boundaryZones []string
+			boundaryZones, estimate, err = h.boundaryZones(boundarystr,
+				r.FormValue(h.ruleForm), toYear)
+
+			if err != nil {
+
+				h.errorHandler.ServeError(w, err.Error())
+				return
+			}
+
+			zonestr = strings.Join(boundaryZones, ",")
+		}
 
 		// Parse the zone ids and use them to query the database
 		zones := strings.Split(zonestr, ",")
-		templateData, err := h.rdb.GetPopulationData(zones)
+		templateData, err := h.rdb.GetYearPopulationData(zones, toYear)
 
 		// If the database query fails report an error
 		if err != nil {
@@ -284,8 +596,147 @@ func (h *ResultsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Add the zones to the template data
+		matched := templateData.Report.Matched
+
+		// Get the data for the earlier year and compare the two, for the
+		// zones that are in both years
+		if fromYear != toYear {
+
+			var fromData *ResultsData
+			var missing []string
+			templateData, fromData, missing, err = h.rdb.GetComparisonData(
+				templateData, fromYear)
+
+			if err != nil {
+
+				h.errorHandler.ServeError(w,
+					"Could not get population data from the ResultsDb.")
+
+				return
+			}
+
+			// Report an error if the zones that were found are all only in
+			// one of the years
+			if len(matched) > 0 && len(templateData.Report.Matched) == 0 {
+
+				h.errorHandler.ServeError(w, noCommonZonesMessage(fromYear,
+					toYear))
+
+				return
+			}
+
+			templateData.Comparison = NewResultsComparison(fromData,
+				templateData)
+			templateData.Comparison.Missing = missing
+		}
+
+		// If none of the zones were found report an error
+		if len(templateData.Report.Matched) == 0 {
+
+			h.errorHandler.ServeError(w,
+				"None of the selected zones could be found.")
+
+			return
+		}
+
+		// Get the data for the area to compare with, defaulting to GB
+		level := r.FormValue(h.referenceForm)
+
+		if level == "" {
+			level = referenceGB
+		}
+
+		areas := referenceAreas(h.geography, templateData.Report.Matched)
+		area := findReferenceArea(areas, level)
+
+		if area == nil {
+
+			h.errorHandler.ServeError(w,
+				"The selected population cannot be compared with that area.")
+
+			return
+		}
+
+		referenceData, err := h.rdb.GetReferencePopulationData(area, toYear)
+
+		if err != nil {
+
+			h.errorHandler.ServeError(w,
+				"Could not get population data from the ResultsDb.")
+
+			return
+		}
+
+		templateData.Reference = &ReferencePopulation{
+			Area:  area,
+			Areas: areas,
+			Data:  referenceData,
+		}
+
+		// Set the bands shown in the pyramid and in outline
+		templateData.Pyramid = templateData.Bands()
+		templateData.Outline = referenceData.Bands()
+		templateData.HasAges = h.ages != nil
+
+		if templateData.Comparison != nil {
+			templateData.Outline = templateData.Comparison.From.Bands()
+		}
+
+		// Re-aggregate the single year of age data into custom bands if
+		// they were requested
+		if bandstr := r.FormValue(h.bandsForm); bandstr != "" {
+
+			err = h.setCustomBands(templateData, bandstr, fromYear, area)
+
+			if err != nil {
+
+				h.errorHandler.ServeError(w, err.Error())
+				return
+			}
+		}
+
+		// Save the selection and redirect to its permalink if one was
+		// requested, keeping the other results parameters
+		if h.selections != nil && r.FormValue(h.permalinkForm) != "" {
+
+			id, err := h.selections.Save(&Selection{
+				Zones:    matched,
+				Boundary: boundarystr,
+				Rule:     r.FormValue(h.ruleForm),
+			})
+
+			if err != nil {
+
+				log.Print(err)
+				h.errorHandler.ServeError(w, "Could not save the selection.")
+				return
+			}
+
+			query := url.Values{}
+
+			for _, name := range []string{h.yearForm, h.compareForm,
+				h.referenceForm, h.bandsForm} {
+
+				if value := r.FormValue(name); value != "" {
+					query.Set(name, value)
+				}
+			}
+
+			permalink := "/s/" + id
+
+			if len(query) > 0 {
+				permalink += "?" + query.Encode()
+			}
+
+			http.Redirect(w, r, permalink, http.StatusSeeOther)
+			return
+		}
+
+		// Add the zones, the boundary and the permalink to the template data
 		templateData.Zones = zonestr
+		templateData.Boundary = estimate
+		templateData.SelectionID = selectionID
+		templateData.Permalinks = h.selections != nil
 
 		// Execute template into buffer
 		err = h.template.Execute(&buffer, templateData)
@@ -304,16 +755,74 @@ func (h *ResultsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	} else {
 
-		// Post data is missing so redirect to the homepage
+		// Zone data is missing so redirect to the homepage
 		http.Redirect(w, r, baseURL, http.StatusFound)
 	}
 
 	return
 }
 
+// setCustomBands sets the bands shown in the pyramid and in outline, and
+// the change in each band if two years are compared, to the population in
+// the custom age bands listed in bandstr. Errors are returned with a message
+// for the user.
+func (h *ResultsHandler) setCustomBands(data *ResultsData, bandstr string,
+	fromYear int, area *ReferenceArea) error {
+
+	bands, err := parseAgeBands(bandstr)
+
+	if err != nil || h.ages == nil || !h.ages.HasYear(data.Year) ||
+		!h.ages.HasYear(fromYear) {
+
+		return errors.New("The data is not available in those age bands.")
+	}
+
+	ages, err := h.ages.GetYearAgeData(data.Report.Matched, data.Year)
+
+	if err != nil {
+		return errors.New("Could not get population data from the AgeDb.")
+	}
+
+	data.Pyramid = ages.Bands(bands)
+
+	// Find the outline from the earlier year or the reference area
+	var outline *AgeData
+
+	if data.Comparison != nil {
+		outline, err = h.ages.GetYearAgeData(data.Report.Matched, fromYear)
+	} else {
+		outline, err = h.ages.GetReferenceAgeData(area, data.Year)
+	}
+
+	if err != nil {
+		return errors.New("Could not get population data from the AgeDb.")
+	}
+
+	data.Outline = outline.Bands(bands)
+
+	if data.Comparison != nil {
+
+		from := data.Comparison.From
+		data.Comparison = newBandsComparison(fromYear, data.Year,
+			data.Outline, data.Pyramid)
+		data.Comparison.From = from
+	}
+
+	// Record the bands in a canonical form for the links on the page
+	labels := []string{}
+
+	for _, band := range bands {
+		labels = append(labels, band.label())
+	}
+
+	data.AgeBands = strings.Join(labels, ",")
+	return nil
+}
+
 // DownloadData holds population data for each zone for the download page.
 type DownloadData struct {
 	Code string
+	Year int
 	P0, P5, P10, P15, P20, P25, P30, P35, P40, P45,
 	P50, P55, P60, P65, P70, P75, P80, P85, P90,
 	M0, M5, M10, M15, M20, M25, M30, M35, M40, M45,
@@ -322,9 +831,56 @@ type DownloadData struct {
 	F50, F55, F60, F65, F70, F75, F80, F85, F90 int64
 }
 
+// DownloadRow holds the population of one zone in each age band, with its
+// indicators, for the download page.
+type DownloadRow struct {
+	Code       string
+	Year       int
+	Bands      []PopulationBand
+	Indicators *Indicators
+}
+
+// DownloadPage holds the data for each zone and the ZoneReport for the
+// requested zones for the download page. Columns holds the name of each age
+// band used in the csv header, and Blank holds the empty fields written for
+// zones that have no data.
+type DownloadPage struct {
+	Year    int
+	Columns []string
+	Rows    []*DownloadRow
+	Report  *ZoneReport
+	Blank   string
+	ages    []ageBand
+}
+
+// newDownloadPage returns a DownloadPage with no rows for the given age bands.
+func newDownloadPage(year int, bands []ageBand,
+	report *ZoneReport) *DownloadPage {
+
+	page := &DownloadPage{
+		Year:    year,
+		Columns: []string{},
+		Rows:    []*DownloadRow{},
+		Report:  report,
+		ages:    bands,
+	}
+
+	for _, band := range bands {
+		page.Columns = append(page.Columns, band.column())
+	}
+
+	// Leave the persons, males and females in each band and the indicators
+	// empty for zones without data
+	page.Blank = strings.Repeat(",", 3*len(bands)+indicatorFields)
+
+	return page
+}
+
 // DownloadDb encapsulates the sqlite database used by DownloadHandler
 type DownloadDb struct {
+	*populationYears
 	db        *sql.DB
+	geography *Geography
 	baseQuery string
 }
 
@@ -338,9 +894,25 @@ func NewDownloadDb(dbPath string) *DownloadDb {
 		log.Fatal(err)
 	}
 
+	// Find the estimate years held in the database
+	years, err := readPopulationYears(dbHandle)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the hierarchy of areas, if the database holds the lookup tables
+	geography, err := readGeography(dbHandle)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Create a new DownloadDb with the database handle and return a pointer
 	return &DownloadDb{
-		db: dbHandle,
+		populationYears: years,
+		db:              dbHandle,
+		geography:       geography,
 		baseQuery: `
 SELECT
 	code, p_0_4, p_5_9, p_10_14, p_15_19, p_20_24, p_25_29, p_30_34, p_35_39, 
@@ -353,7 +925,7 @@ SELECT
 FROM 
 	population 
 WHERE 
-	code IN (`,
+	`,
 	}
 }
 
@@ -363,9 +935,81 @@ func (d *DownloadDb) Close() {
 	d.db.Close()
 }
 
-// GetPopulationData returns the population data for the given zones.
+// Geography returns the hierarchy of areas held in the database, or nil if
+// the database does not hold the lookup tables.
+func (d *DownloadDb) Geography() *Geography {
+
+	return d.geography
+}
+
+// UseGeography sets the hierarchy of areas used to expand the codes of
+// countries, regions and districts into zones, for databases that do not
+// hold the lookup tables.
+func (d *DownloadDb) UseGeography(geography *Geography) {
+
+	d.geography = geography
+}
+
+// CheckZones reports which of the given zones are in the population table
+// for the given year. The codes of countries, regions and districts are
+// expanded into their zones.
+func (d *DownloadDb) CheckZones(zones []string, year int) (*ZoneReport, error) {
+
+	return checkZones(d.db, d.populationYears, d.geography, year, zones)
+}
+
+// GetPopulationData returns the population data for the given zones for the
+// latest year in the database.
 func (d *DownloadDb) GetPopulationData(zones []string) ([]*DownloadData, error) {
 
+	return d.GetYearPopulationData(zones, d.LatestYear())
+}
+
+// GetYearPopulationData returns the population data for the given zones for
+// the given year.
+func (d *DownloadDb) GetYearPopulationData(zones []string,
+	year int) ([]*DownloadData, error) {
+
+	// Create the results slice
+	results := []*DownloadData{}
+
+	// Remove duplicate zones so that no zone is returned twice across chunks
+	unique := []string{}
+	seen := map[string]bool{}
+
+	for _, zone := range zones {
+
+		if !seen[zone] {
+
+			seen[zone] = true
+			unique = append(unique, zone)
+		}
+	}
+
+	// Query each chunk of zones and add the rows to the results
+	condition, conditionArgs := d.yearCondition(year)
+
+	for _, chunk := range chunkZones(unique) {
+
+		query, args := inQuery(d.baseQuery+condition+"code IN (", chunk,
+			conditionArgs...)
+		rows, err := d.scanPopulationData(query, args, year)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, rows...)
+	}
+
+	return results, nil
+}
+
+// scanPopulationData executes a query for a chunk of zones and returns a
+// slice of DownloadData for the zones found in the given year.
+func (d *DownloadDb) scanPopulationData(query string, args []interface{},
+	year int) ([]*DownloadData, error) {
+
 	// Declare variables to hold query results
 	var code string
 	var row *DownloadData
@@ -376,18 +1020,6 @@ func (d *DownloadDb) GetPopulationData(zones []string) ([]*DownloadData, error)
 		f0, f5, f10, f15, f20, f25, f30, f35, f40, f45,
 		f50, f55, f60, f65, f70, f75, f80, f85, f90 int64
 
-	// Build the query string and an interface slice of args to pass to Query
-	query := d.baseQuery
-	args := []interface{}{}
-
-	for i := 0; i < len(zones); i++ {
-
-		query += "?,"
-		args = append(args, zones[i])
-	}
-
-	query = query[:len(query)-1] + ")"
-
 	// Execute the query and scan the results
 	rows, err := d.db.Query(query, args...)
 
@@ -397,7 +1029,7 @@ func (d *DownloadDb) GetPopulationData(zones []string) ([]*DownloadData, error)
 
 	defer rows.Close()
 
-	// Create the results map
+	// Create the results slice
 	results := []*DownloadData{}
 
 	// Scan the results
@@ -417,6 +1049,7 @@ func (d *DownloadDb) GetPopulationData(zones []string) ([]*DownloadData, error)
 
 		row = &DownloadData{
 			Code: code,
+			Year: year,
 			P0:   p0, P5: p5, P10: p10, P15: p15, P20: p20,
 			P25: p25, P30: p30, P35: p35, P40: p40, P45: p45,
 			P50: p50, P55: p55, P60: p60, P65: p65, P70: p70,
@@ -446,14 +1079,22 @@ func (d *DownloadDb) GetPopulationData(zones []string) ([]*DownloadData, error)
 // DownloadHandler handles requests sent to the results page.
 type DownloadHandler struct {
 	ddb          *DownloadDb
+	ages         *AgeDb
 	errorHandler *handlers.ErrorHandler
 	template     *textTemplate.Template
+	boundaries   *spatial.Index
 	zoneForm     string
+	yearForm     string
+	bandsForm    string
+	formatForm   string
+	dissolveForm string
 }
 
 // DownloadHandler returns a new homeHandler with the values initialised.
+// The AgeDb is used for downloads in custom age bands, and may be nil if
+// these are not available.
 func NewDownloadHandler(templatePath string, database *DownloadDb,
-	errorHandler *handlers.ErrorHandler) *DownloadHandler {
+	ages *AgeDb, errorHandler *handlers.ErrorHandler) *DownloadHandler {
 
 	templateFile, err := textTemplate.ParseFiles(templatePath)
 
@@ -463,37 +1104,85 @@ func NewDownloadHandler(templatePath string, database *DownloadDb,
 
 	return &DownloadHandler{
 		ddb:          database,
+		ages:         ages,
 		errorHandler: errorHandler,
 		template:     templateFile,
 		zoneForm:     "zones",
+		yearForm:     "year",
+		bandsForm:    "bands",
+		formatForm:   "format",
+		dissolveForm: "dissolve",
 	}
 }
 
-// ServeHTTP expects a list of area codes for population zones as POST data.
+// UseBoundaries sets the index of zone boundaries used for downloads in
+// GeoJSON. These downloads are not available if it is not set.
+func (h *DownloadHandler) UseBoundaries(index *spatial.Index) {
+
+	h.boundaries = index
+}
+
+// ServeHTTP expects a list of area codes for population zones as POST data,
+// and optionally the estimate year, which defaults to the latest year, and
+// a list of custom age bands such as "0-15,16-64,65+".
 // The population data for the given areas is retrieved from a sqlite database
-// and is sent to the browser as a csv download.
+// and is sent to the browser as a csv download, in 5-year age bands unless
+// custom age bands were requested. If the format is "geojson", the data is
+// sent as a GeoJSON FeatureCollection of the zone boundaries instead, and if
+// dissolve is also set the zones are dissolved into a single outline.
 func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	var buffer bytes.Buffer
+	var templateData *DownloadPage
 
 	// Check the form contains the expected zone data
 	if zonestr := r.PostFormValue(h.zoneForm); zonestr != "" {
 
-		// Parse the zone ids and use them to query the database
+		// Parse the zone ids and get the data for the requested year, using
+		// the single year of age data if custom bands were requested
 		zones := strings.Split(zonestr, ",")
-		templateData, err := h.ddb.GetPopulationData(zones)
+		yearstr := r.PostFormValue(h.yearForm)
+		var err error
 
-		// If the database query fails report an error
+		if bandstr := r.PostFormValue(h.bandsForm); bandstr != "" {
+
+			bands, bandsErr := parseAgeBands(bandstr)
+
+			if bandsErr != nil || h.ages == nil {
+
+				h.errorHandler.ServeError(w,
+					"The data is not available in those age bands.")
+
+				return
+			}
+
+			templateData, err = h.getBandedData(zones, yearstr, bands)
+
+		} else {
+
+			templateData, err = h.getData(zones, yearstr)
+		}
+
+		// If the year or the database query fails report an error
 		if err != nil {
 
-			h.errorHandler.ServeError(w,
-				"Could not get population data from the DownloadDb.")
+			h.errorHandler.ServeError(w, err.Error())
+			return
+		}
+
+		// Serve the zone boundaries if GeoJSON was requested
+		if r.PostFormValue(h.formatForm) == "geojson" {
+
+			h.serveGeoJSON(w, templateData,
+				r.PostFormValue(h.dissolveForm) != "")
 
 			return
 		}
 
 		// Set headers to mark it as a file download
-		w.Header().Set("Content-Disposition", "attachment; filename=download.csv")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=download-%d.csv",
+				templateData.Year))
 		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
 
 		// These headers are needed for the download to work in older versions
@@ -525,8 +1214,135 @@ func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// getData returns the DownloadPage for the given zones and year in 5-year
+// age bands. Errors are returned with a message for the user.
+func (h *DownloadHandler) getData(zones []string,
+	yearstr string) (*DownloadPage, error) {
+
+	// Check the requested year is in the database
+	year, err := h.ddb.ParseYear(yearstr)
+
+	if err != nil {
+		return nil, errors.New(
+			"Population estimates are not available for that year.")
+	}
+
+	// Check the zones against the database
+	report, err := h.ddb.CheckZones(zones, year)
+
+	if err != nil {
+		return nil, errors.New("Could not check zones against the DownloadDb.")
+	}
+
+	// Get the data for the matched zones
+	page := newDownloadPage(year, fiveYearBands, report)
+
+	if len(report.Matched) == 0 {
+		return page, nil
+	}
+
+	rows, err := h.ddb.GetYearPopulationData(report.Matched, year)
+
+	if err != nil {
+		return nil, errors.New(
+			"Could not get population data from the DownloadDb.")
+	}
+
+	for _, row := range rows {
+
+		page.Rows = append(page.Rows, &DownloadRow{
+			Code:       row.Code,
+			Year:       row.Year,
+			Bands:      row.Bands(),
+			Indicators: row.Indicators(),
+		})
+	}
+
+	return page, nil
+}
+
+// getBandedData returns the DownloadPage for the given zones and year in the
+// given age bands. Errors are returned with a message for the user.
+func (h *DownloadHandler) getBandedData(zones []string, yearstr string,
+	bands []ageBand) (*DownloadPage, error) {
+
+	// Check the requested year is in the database
+	year, err := h.ages.ParseYear(yearstr)
+
+	if err != nil {
+		return nil, errors.New(
+			"Population estimates are not available for that year.")
+	}
+
+	// Check the zones against the database
+	report, err := h.ages.CheckZones(zones, year)
+
+	if err != nil {
+		return nil, errors.New("Could not check zones against the AgeDb.")
+	}
+
+	// Get the data for the matched zones
+	page := newDownloadPage(year, bands, report)
+
+	if len(report.Matched) == 0 {
+		return page, nil
+	}
+
+	rows, err := h.ages.GetZoneAgeData(report.Matched, year)
+
+	if err != nil {
+		return nil, errors.New("Could not get population data from the AgeDb.")
+	}
+
+	for _, row := range rows {
+
+		page.Rows = append(page.Rows, &DownloadRow{
+			Code:       row.Code,
+			Year:       row.Year,
+			Bands:      row.Bands(bands),
+			Indicators: row.Indicators(),
+		})
+	}
+
+	return page, nil
+}
+
 func main() {
 
+	// Run a subcommand if one is given
+	if len(os.Args) > 1 && os.Args[1] == "build-db" {
+
+		err := runBuildDb(os.Args[2:])
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "build-boundaries" {
+
+		err := runBuildBoundaries(os.Args[2:])
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "build-topojson" {
+
+		err := runBuildTopoJSON(os.Args[2:])
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	// Set the port number
 	portNumber := 3000
 	portString := fmt.Sprint(":", portNumber)
@@ -539,22 +1355,130 @@ func main() {
 	downloadDb := NewDownloadDb(downloadDbPath)
 	defer downloadDb.Close()
 
+	// Create an AgeDb for custom age bands, if the database has been built
+	var ageDb *AgeDb
+
+	if _, err := os.Stat(agesDbPath); err == nil {
+
+		ageDb = NewAgeDb(agesDbPath)
+		defer ageDb.Close()
+	}
+
+	// Create a SelectionDb for permalinks to saved selections
+	selectionDb := NewSelectionDb(selectionsPath)
+	defer selectionDb.Close()
+
+	// Create an AccountDb for user accounts and their saved selections
+	accountDb := NewAccountDb(accountsPath)
+	defer accountDb.Close()
+
+	// Use the hierarchy of areas in the database, or load it from the
+	// resources if the database does not hold the lookup tables
+	geography := resultsDb.Geography()
+	var err error
+
+	if geography == nil {
+
+		geography, err = LoadGeography(boundsPath, popzonesDir)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		resultsDb.UseGeography(geography)
+	}
+
+	if downloadDb.Geography() == nil {
+		downloadDb.UseGeography(geography)
+	}
+
+	if ageDb != nil && ageDb.Geography() == nil {
+		ageDb.UseGeography(geography)
+	}
+
+	// Load the zone boundaries for spatial queries, which are not available
+	// if the boundary files cannot be read
+	var boundaries *spatial.Index
+	features, err := spatial.LoadFeatures(popzonesDir)
+
+	if err != nil || len(features) == 0 {
+		log.Print("Zone boundaries could not be loaded from ", popzonesDir)
+	} else {
+		boundaries = spatial.NewIndex(features)
+	}
+
 	// Create the utility handlers
 	notFoundHandler := handlers.LoadNotFoundHandler(notFoundPath)
 	errorHandler := handlers.LoadErrorHandler(errorPath, defaultError, true)
 
 	// Create the the page handlers for home, results and download pages
 	http.Handle("/", NewHomeHandler(introPath, mapPath, notFoundHandler))
-	http.Handle("/results", NewResultsHandler(resultsPath, resultsDb, errorHandler))
-	http.Handle("/download", NewDownloadHandler(downloadPath, downloadDb, errorHandler))
+	resultsHandler := NewResultsHandler(resultsPath, resultsDb, ageDb,
+		geography, errorHandler)
+	resultsHandler.UseSelections(selectionDb)
+	resultsHandler.UseBoundaries(boundaries, downloadDb)
+	http.Handle("/results", resultsHandler)
+	downloadHandler := NewDownloadHandler(downloadPath, downloadDb, ageDb,
+		errorHandler)
+	downloadHandler.UseBoundaries(boundaries)
+	http.Handle("/download", downloadHandler)
+
+	// Create the handlers for uploaded files of zone codes and boundaries
+	http.Handle("/upload", NewUploadHandler(resultsDb, resultsHandler))
+	http.Handle("/upload/boundary", NewBoundaryUploadHandler(boundaries,
+		resultsDb, resultsHandler, downloadHandler))
+
+	// Create the handler for permalinks to saved selections
+	http.Handle("/s/", NewSelectionHandler("/s/", selectionDb,
+		resultsHandler, notFoundHandler))
+
+	// Create the handler for vector tiles of the zone boundaries
+	http.Handle("/tiles/", NewTileHandler("/tiles/", boundaries,
+		notFoundHandler))
+
+	// Create the handler for the JSON API
+	http.Handle("/api/v1/population", NewAPIHandler(resultsDb, downloadDb))
+
+	// Create the handler for finding the zones containing points
+	http.Handle("/api/v1/locate", NewLocateHandler(boundaries, geography))
+
+	// Create the handler for finding the zones containing postcodes
+	http.Handle("/api/v1/postcode", NewPostcodeHandler(resultsDb))
+
+	// Create the handler for selecting the zones within a radius of a point
+	http.Handle("/api/v1/radius", NewRadiusHandler(boundaries, resultsDb))
+
+	// Create the handler for selecting the zones within a boundary
+	http.Handle("/api/v1/boundary", NewBoundaryHandler(boundaries, resultsDb,
+		downloadDb))
+
+	// Create the handler for apportioning the population inside a boundary
+	http.Handle("/api/v1/apportion", NewApportionHandler(boundaries,
+		downloadDb))
+
+	// Create the handlers for accounts and their saved selections
+	accountHandler := NewAccountHandler("/api/v1/account", accountDb)
+	http.Handle("/api/v1/account", accountHandler)
+	http.Handle("/api/v1/account/", accountHandler)
+
+	savedSelectionsHandler := NewSavedSelectionsHandler("/api/v1/selections",
+		accountDb, geography)
+	http.Handle("/api/v1/selections", savedSelectionsHandler)
+	http.Handle("/api/v1/selections/", savedSelectionsHandler)
 
 	// Create a filehandler to a static directory
 	fileHandler := handlers.NewFileHandler("/resources/", resourcesDir, notFoundHandler)
 	http.Handle("/resources/", fileHandler)
 
+	// Create the handler for the district boundary files, which simplifies
+	// the boundaries for the zoom level of the map and serves TopoJSON
+	http.Handle("/resources/popzones/", NewBoundaryFileHandler(
+		"/resources/popzones/", boundaries, popzonesDir, topozonesDir,
+		popzonesCache, fileHandler))
+
 	// Start server
 	log.Print("Server starting on port ", portNumber, " ...")
-	err := http.ListenAndServe(portString, nil)
+	err = http.ListenAndServe(portString, nil)
 
 	if err != nil {
 		log.Fatal(err)
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
+	"github.com/olihawkins/decimals"
 	"github.com/olihawkins/handlers"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
@@ -285,7 +289,7 @@ func TestResultsHandler(t *testing.T) {
 	errorHandler = handlers.LoadErrorHandler(errorPath, "", true)
 
 	// Create a ResultsHandler to test
-	h = NewResultsHandler(resultsPath, resultsDb, errorHandler)
+	h = NewResultsHandler(resultsPath, resultsDb, nil, nil, errorHandler)
 
 	codes := []string{
 		// Test each of these zones in separate page requests
@@ -452,7 +456,7 @@ func TestDownloadHandler(t *testing.T) {
 	errorHandler = handlers.LoadErrorHandler(errorPath, "", true)
 
 	// Create a DownloadHandler to test
-	h = NewDownloadHandler(downloadPath, downloadDb, errorHandler)
+	h = NewDownloadHandler(downloadPath, downloadDb, nil, errorHandler)
 
 	codes := []string{
 		// Test each of these zones in separate page requests
@@ -514,3 +518,126 @@ func TestDownloadHandler(t *testing.T) {
 		}
 	}
 }
+
+// Test ResultsDb.GetPopulationData with a selection of every zone in the
+// database, which is larger than SQLite's limit on bound parameters. The
+// total is checked against a sum over the whole population table.
+func TestResultsDbGetPopulationDataAllZones(t *testing.T) {
+
+	var expected int64
+
+	// Create a ResultsDb
+	rdb := NewResultsDb(resultsDbPath)
+	defer rdb.Close()
+
+	// Get every zone code and the total population from the database
+	zones := getAllZoneCodes(t, rdb.db)
+
+	err := rdb.db.QueryRow(`
+SELECT
+	sum(m_0_9 + m_10_19 + m_20_29 + m_30_39 + m_40_49 + 
+		m_50_59 + m_60_69 + m_70_79 + m_80_89 + m_90 + 
+		f_0_9 + f_10_19 + f_20_29 + f_30_39 + f_40_49 + 
+		f_50_59 + f_60_69 + f_70_79 + f_80_89 + f_90)
+FROM 
+	population`).Scan(&expected)
+
+	if err != nil {
+		t.Fatalf("Could not get the total population from ResultsDb.")
+	}
+
+	results, err := rdb.GetPopulationData(zones)
+
+	if err != nil {
+		t.Fatalf("Could not get data for all zones from ResultsDb: %s", err)
+	}
+
+	if results.Population != decimals.FormatThousands(expected) {
+		t.Errorf("Expected %s in ResultsDb.GetPopulationData. Got: %s",
+			decimals.FormatThousands(expected), results.Population)
+	}
+
+	if len(results.Report.Matched) != len(zones) {
+		t.Errorf("Expected %d matched zones in ResultsDb.GetPopulationData. "+
+			"Got: %d", len(zones), len(results.Report.Matched))
+	}
+}
+
+// Test DownloadDb.GetPopulationData with a selection of every zone in the
+// database, which is larger than SQLite's limit on bound parameters. Each
+// zone should be returned exactly once, even when zones are repeated. The
+// database is built from a test source file with more zones than are
+// queried at once.
+func TestDownloadDbGetPopulationDataAllZones(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	codes := []string{}
+
+	for i := 1; i <= maxQueryZones*2+1; i++ {
+		codes = append(codes, fmt.Sprintf("E%08d", 1000000+i))
+	}
+
+	buildTestDbs(t, dir, codes)
+
+	// Create a DownloadDb
+	ddb := NewDownloadDb(testDbPath(dir, downloadDbPath))
+	defer ddb.Close()
+
+	// Get every zone code and repeat the list to include duplicates
+	zones := getAllZoneCodes(t, ddb.db)
+	populationData, err := ddb.GetPopulationData(append(zones, zones...))
+
+	if err != nil {
+		t.Fatalf("Could not get data for all zones from DownloadDb: %s", err)
+	}
+
+	if len(populationData) != len(zones) {
+		t.Errorf("Expected %d rows in DownloadDb.GetPopulationData. Got: %d",
+			len(zones), len(populationData))
+	}
+
+	returned := map[string]bool{}
+
+	for _, zoneData := range populationData {
+
+		if returned[zoneData.Code] {
+			t.Errorf("Zone %s was returned more than once by "+
+				"DownloadDb.GetPopulationData.", zoneData.Code)
+		}
+
+		returned[zoneData.Code] = true
+	}
+}
+
+// getAllZoneCodes returns the code of every zone in the population table.
+func getAllZoneCodes(t *testing.T, db *sql.DB) []string {
+
+	zones := []string{}
+	rows, err := db.Query("SELECT code FROM population")
+
+	if err != nil {
+		t.Fatalf("Could not get the zone codes from the database.")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var code string
+
+		if err := rows.Scan(&code); err != nil {
+			t.Fatalf("Could not scan a zone code from the database.")
+		}
+
+		zones = append(zones, code)
+	}
+
+	if len(zones) <= maxQueryZones {
+		t.Fatalf("Expected more than %d zones in the database. Got: %d",
+			maxQueryZones, len(zones))
+	}
+
+	return zones
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPostcodeMatches is the largest number of postcodes returned for a
+// partial postcode.
+const maxPostcodeMatches = 100
+
+// maxBulkPostcodes is the largest number of postcodes that can be converted
+// in a single request.
+const maxBulkPostcodes = 10000
+
+// postcodePattern matches a full postcode once it is in upper case with its
+// spaces removed.
+var postcodePattern = regexp.MustCompile(
+	`^[A-Z]{1,2}[0-9][A-Z0-9]?[0-9][A-Z]{2}$`)
+
+// partialPostcodePattern matches the start of a postcode, such as an
+// outward code or a postcode sector, once it is normalised.
+var partialPostcodePattern = regexp.MustCompile(
+	`^[A-Z]{1,2}([0-9][A-Z0-9]?( [0-9][A-Z]?)?)?$`)
+
+// noPostcodeLat is the latitude given in the ONS Postcode Directory for
+// postcodes without coordinates.
+const noPostcodeLat = 99.999999
+
+// The columns of an ONS Postcode Directory extract that hold each postcode,
+// the zone containing it, its coordinates and the date it was terminated,
+// in order of preference.
+var (
+	postcodeColumns = []string{"pcds", "pcd", "pcd2", "postcode"}
+	zoneColumns     = []string{"lsoa21", "lsoa21cd", "lsoa11", "lsoa11cd",
+		"lsoa"}
+	latColumns  = []string{"lat", "latitude"}
+	lonColumns  = []string{"long", "lon", "longitude"}
+	termColumns = []string{"doterm"}
+)
+
+// normalisePostcode returns a postcode in upper case with a single space
+// before the inward code, and true if it is a full postcode. Otherwise it
+// returns the query in upper case with its spaces collapsed, and false, so
+// that it can be matched against the start of full postcodes. A partial
+// postcode written without a space, such as SW1A1, has the space put back
+// before the inward code if it is not an outward code on its own. An error
+// is returned if the query cannot be the start of a postcode.
+func normalisePostcode(query string) (string, bool, error) {
+
+	fields := strings.Fields(strings.ToUpper(query))
+	compact := strings.Join(fields, "")
+
+	if postcodePattern.MatchString(compact) {
+
+		inward := len(compact) - 3
+		return compact[:inward] + " " + compact[inward:], true, nil
+	}
+
+	partial := strings.Join(fields, " ")
+
+	// The inward code starts with a digit and may have one letter after it
+	if len(fields) == 1 && !partialPostcodePattern.MatchString(partial) {
+
+		inward := len(compact) - 1
+
+		if inward > 0 && compact[inward] >= 'A' && compact[inward] <= 'Z' {
+			inward--
+		}
+
+		partial = compact[:inward] + " " + compact[inward:]
+	}
+
+	if !partialPostcodePattern.MatchString(partial) {
+		return "", false, errors.New("not a postcode")
+	}
+
+	return partial, false, nil
+}
+
+// Postcode holds the zone containing a postcode and the coordinates of its
+// centre. Lat and Lon are nil if the postcode has no coordinates.
+type Postcode struct {
+	Postcode string   `json:"postcode"`
+	Zone     string   `json:"zone"`
+	Lat      *float64 `json:"lat"`
+	Lon      *float64 `json:"lon"`
+}
+
+// findColumn returns the index of the first of the names found in the
+// header, or -1 if none of them are found.
+func findColumn(header []string, names []string) int {
+
+	for _, name := range names {
+
+		for i, column := range header {
+
+			column = strings.TrimPrefix(column, "\ufeff")
+			column = strings.ToLower(strings.TrimSpace(column))
+
+			if column == name {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// writePostcodes replaces the postcodes table of a database with the
+// postcodes read from an ONS Postcode Directory csv, and returns the number
+// written. Terminated postcodes and those outside the zones are skipped.
+func writePostcodes(db *sql.DB, r io.Reader) (int, error) {
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+
+	if err != nil {
+		return 0, err
+	}
+
+	header = append([]string{}, header...)
+	postcodeColumn := findColumn(header, postcodeColumns)
+	zoneColumn := findColumn(header, zoneColumns)
+	latColumn := findColumn(header, latColumns)
+	lonColumn := findColumn(header, lonColumns)
+	termColumn := findColumn(header, termColumns)
+
+	if postcodeColumn < 0 || zoneColumn < 0 {
+		return 0, errors.New("no postcode and lsoa columns in the header")
+	}
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, statement := range []string{
+		"DROP TABLE IF EXISTS postcodes",
+		"CREATE TABLE postcodes " +
+			"(postcode text PRIMARY KEY, zone text, lat real, lon real)",
+	} {
+
+		_, err = tx.Exec(statement)
+
+		if err != nil {
+
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	statement, err := tx.Prepare("INSERT OR REPLACE INTO postcodes " +
+		"(postcode, zone, lat, lon) VALUES (?, ?, ?, ?)")
+
+	if err != nil {
+
+		tx.Rollback()
+		return 0, err
+	}
+
+	// cell returns the trimmed value of a column, or an empty string
+	cell := func(row []string, column int) string {
+
+		if column < 0 || column >= len(row) {
+			return ""
+		}
+
+		return strings.TrimSpace(row[column])
+	}
+
+	count := 0
+
+	for {
+
+		row, err := reader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+
+			statement.Close()
+			tx.Rollback()
+			return 0, err
+		}
+
+		postcode, full, _ := normalisePostcode(cell(row, postcodeColumn))
+		zone := strings.ToUpper(cell(row, zoneColumn))
+
+		if !full || !zonePattern.MatchString(zone) ||
+			cell(row, termColumn) != "" {
+
+			continue
+		}
+
+		// Leave the coordinates empty if they are missing
+		var lat, lon interface{}
+		latValue, latErr := strconv.ParseFloat(cell(row, latColumn), 64)
+		lonValue, lonErr := strconv.ParseFloat(cell(row, lonColumn), 64)
+
+		if latErr == nil && lonErr == nil && latValue != noPostcodeLat {
+			lat, lon = latValue, lonValue
+		}
+
+		_, err = statement.Exec(postcode, zone, lat, lon)
+
+		if err != nil {
+
+			statement.Close()
+			tx.Rollback()
+			return 0, err
+		}
+
+		count++
+	}
+
+	statement.Close()
+	return count, tx.Commit()
+}
+
+// updatePostcodesDb replaces the postcodes table in the database at dbPath
+// with the postcodes in the csv at csvPath, and returns the number written.
+func updatePostcodesDb(dbPath string, csvPath string) (int, error) {
+
+	f, err := os.Open(csvPath)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	dbHandle, err := sql.Open("sqlite3", dbPath)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer dbHandle.Close()
+
+	count, err := writePostcodes(dbHandle, f)
+
+	if err != nil {
+		return 0, fmt.Errorf("build-db: %s: %s", csvPath, err)
+	}
+
+	return count, nil
+}
+
+// hasPostcodes returns true if the database has a postcodes table.
+func hasPostcodes(db *sql.DB) (bool, error) {
+
+	var tables int
+
+	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE " +
+		"type = 'table' AND name = 'postcodes'").Scan(&tables)
+
+	return tables > 0, err
+}
+
+// HasPostcodes returns true if the database holds the postcodes table.
+func (r *ResultsDb) HasPostcodes() bool {
+
+	return r.postcodes
+}
+
+// FindPostcodes returns the postcodes matching a normalised full postcode,
+// or starting with a normalised partial postcode, in order, up to the given
+// limit.
+func (r *ResultsDb) FindPostcodes(postcode string, full bool,
+	limit int) ([]*Postcode, error) {
+
+	query := "SELECT postcode, zone, lat, lon FROM postcodes " +
+		"WHERE postcode = ? LIMIT ?"
+	args := []interface{}{postcode, limit}
+
+	// Postcodes hold only letters, digits and spaces, which all sort
+	// before a tilde
+	if !full {
+
+		query = "SELECT postcode, zone, lat, lon FROM postcodes " +
+			"WHERE postcode >= ? AND postcode < ? ORDER BY postcode LIMIT ?"
+		args = []interface{}{postcode, postcode + "~", limit}
+	}
+
+	rows, err := r.db.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	postcodes := []*Postcode{}
+
+	for rows.Next() {
+
+		var lat, lon sql.NullFloat64
+		p := &Postcode{}
+		err = rows.Scan(&p.Postcode, &p.Zone, &lat, &lon)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if lat.Valid && lon.Valid {
+			p.Lat, p.Lon = &lat.Float64, &lon.Float64
+		}
+
+		postcodes = append(postcodes, p)
+	}
+
+	return postcodes, rows.Err()
+}
+
+// PostcodeResult holds the postcodes found for a full or partial postcode,
+// the zones containing them and the districts containing the zones. More
+// is true if more postcodes match than are listed.
+type PostcodeResult struct {
+	Query     string      `json:"query"`
+	Postcodes []*Postcode `json:"postcodes"`
+	Zones     []string    `json:"zones"`
+	Districts []string    `json:"districts"`
+	More      bool        `json:"more"`
+}
+
+// BulkPostcodeResult holds the zones containing a list of full postcodes,
+// with the postcode found for each query in the order requested, or nil for
+// queries that were not found. Unknown lists the queries that were not
+// found or were not full postcodes.
+type BulkPostcodeResult struct {
+	Postcodes []*Postcode `json:"postcodes"`
+	Zones     []string    `json:"zones"`
+	Districts []string    `json:"districts"`
+	Unknown   []string    `json:"unknown"`
+}
+
+// postcodeRequest is the expected shape of a JSON request body sent to
+// convert a list of postcodes.
+type postcodeRequest struct {
+	Postcodes []string `json:"postcodes"`
+}
+
+// PostcodeHandler finds the zones containing postcodes.
+type PostcodeHandler struct {
+	rdb          *ResultsDb
+	postcodeForm string
+}
+
+// NewPostcodeHandler returns a new PostcodeHandler with the values
+// initialised.
+func NewPostcodeHandler(rdb *ResultsDb) *PostcodeHandler {
+
+	return &PostcodeHandler{
+		rdb:          rdb,
+		postcodeForm: "postcode",
+	}
+}
+
+// ServeHTTP expects a full or partial postcode in the query string of a GET
+// request, and returns the matching postcodes with their zones and
+// coordinates, or a 404 error if none match. A partial postcode, such as an
+// outward code or a sector, returns the first postcodes that start with it.
+// A POST request with a JSON body of the form
+// {"postcodes": ["SW1A 1AA", "EH1 1YZ"]} converts a list of full postcodes
+// to the zones containing them, with the postcodes that were not found.
+// Responses and errors are sent as JSON.
+func (h *PostcodeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if !h.rdb.HasPostcodes() {
+
+		writeAPIError(w, http.StatusServiceUnavailable,
+			"The postcode lookup is not available.")
+
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+
+		query := r.FormValue(h.postcodeForm)
+		postcode, full, err := normalisePostcode(query)
+
+		if err != nil {
+
+			writeAPIError(w, http.StatusBadRequest,
+				"Could not parse the request: invalid postcode.")
+
+			return
+		}
+
+		postcodes, err := h.rdb.FindPostcodes(postcode, full,
+			maxPostcodeMatches+1)
+
+		if err != nil {
+
+			writeAPIError(w, http.StatusInternalServerError,
+				"Could not get postcodes from the ResultsDb.")
+
+			return
+		}
+
+		if len(postcodes) == 0 {
+
+			writeAPIError(w, http.StatusNotFound,
+				"No postcodes match "+postcode+".")
+
+			return
+		}
+
+		result := &PostcodeResult{Query: postcode}
+
+		if len(postcodes) > maxPostcodeMatches {
+
+			postcodes = postcodes[:maxPostcodeMatches]
+			result.More = true
+		}
+
+		result.Postcodes = postcodes
+		result.Zones = postcodeZones(postcodes)
+		result.Districts = selectionDistricts(result.Zones,
+			h.rdb.Geography())
+
+		writeJSON(w, http.StatusOK, result)
+
+	case "POST":
+
+		var body postcodeRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+			maxRequestBody)).Decode(&body)
+
+		if err != nil {
+
+			writeAPIError(w, http.StatusBadRequest,
+				"Could not parse the request: invalid JSON body.")
+
+			return
+		}
+
+		if len(body.Postcodes) > maxBulkPostcodes {
+
+			writeAPIError(w, http.StatusBadRequest,
+				"Too many postcodes: the limit is "+
+					strconv.Itoa(maxBulkPostcodes)+" per request.")
+
+			return
+		}
+
+		result := &BulkPostcodeResult{
+			Postcodes: []*Postcode{},
+			Unknown:   []string{},
+		}
+
+		for _, query := range body.Postcodes {
+
+			var found *Postcode
+			postcode, full, err := normalisePostcode(query)
+
+			if err == nil && full {
+
+				postcodes, err := h.rdb.FindPostcodes(postcode, true, 1)
+
+				if err != nil {
+
+					writeAPIError(w, http.StatusInternalServerError,
+						"Could not get postcodes from the ResultsDb.")
+
+					return
+				}
+
+				if len(postcodes) > 0 {
+					found = postcodes[0]
+				}
+			}
+
+			if found == nil {
+				result.Unknown = append(result.Unknown, query)
+			}
+
+			result.Postcodes = append(result.Postcodes, found)
+		}
+
+		result.Zones = postcodeZones(result.Postcodes)
+		result.Districts = selectionDistricts(result.Zones,
+			h.rdb.Geography())
+
+		writeJSON(w, http.StatusOK, result)
+
+	default:
+
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed,
+			"That method is not supported.")
+	}
+}
+
+// postcodeZones returns the zones containing the postcodes, each listed
+// once in the order first found. Nil postcodes are skipped.
+func postcodeZones(postcodes []*Postcode) []string {
+
+	zones := []string{}
+	seen := map[string]bool{}
+
+	for _, postcode := range postcodes {
+
+		if postcode != nil && !seen[postcode.Zone] {
+
+			seen[postcode.Zone] = true
+			zones = append(zones, postcode.Zone)
+		}
+	}
+
+	return zones
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test postcodes are normalised whether they are full or partial.
+func TestNormalisePostcode(t *testing.T) {
+
+	for _, test := range []struct {
+		query    string
+		expected string
+		full     bool
+	}{
+		{"sw1a1aa", "SW1A 1AA", true},
+		{" SW1A  1AA ", "SW1A 1AA", true},
+		{"E1 6AN", "E1 6AN", true},
+		{"e1", "E1", false},
+		{"SW1A 1", "SW1A 1", false},
+		{"EH", "EH", false},
+		{"sw1a1", "SW1A 1", false},
+		{"SW1A1A", "SW1A 1A", false},
+		{"EH111", "EH11 1", false},
+		{"e11a", "E1 1A", false},
+		{"E11", "E11", false},
+	} {
+
+		postcode, full, err := normalisePostcode(test.query)
+
+		if err != nil || postcode != test.expected || full != test.full {
+			t.Errorf("Expected %q %t from normalisePostcode for %q. "+
+				"Got: %q %t %v", test.expected, test.full, test.query,
+				postcode, full, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "E01000001", "1AA", "SW1A 1AAA",
+		"SW1AA1", "E1AAA"} {
+
+		if _, _, err := normalisePostcode(invalid); err == nil {
+			t.Errorf("Expected an error from normalisePostcode for %q.",
+				invalid)
+		}
+	}
+}
+
+// Test build-db loads a postcode directory into the results database, and
+// PostcodeHandler finds full and partial postcodes and converts lists.
+func TestPostcodeHandler(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	buildTestDbs(t, dir, []string{"E01000001", "W01000001"})
+
+	// A terminated postcode, a postcode without coordinates and a postcode
+	// outside the zones are included with the current postcodes
+	postcodes := filepath.Join(dir, "onspd.csv")
+	err := ioutil.WriteFile(postcodes, []byte(
+		"pcd,pcds,doterm,lsoa11,lat,long\n"+
+			"AB1 2CD,AB1 2CD,,E01000001,51.5,-0.1\n"+
+			"AB1 2CE,AB1 2CE,,W01000001,51.6,-0.2\n"+
+			"AB1 2CF,AB1 2CF,202001,E01000001,51.5,-0.1\n"+
+			"AB1 2CG,AB1 2CG,,E01000001,99.999999,0.000000\n"+
+			"AB12 3CD,AB12 3CD,,E01000001,51.4,-0.3\n"+
+			"ZZ9 9ZZ,ZZ9 9ZZ,,L99999999,51.4,-0.3\n"), 0644)
+
+	if err != nil {
+		t.Fatalf("Could not write the postcode directory.")
+	}
+
+	rdbPath := testDbPath(dir, resultsDbPath)
+	rdb := NewResultsDb(rdbPath)
+	h := NewPostcodeHandler(rdb)
+
+	// The lookup is not available until the postcodes are loaded
+	request, _ := http.NewRequest("GET", "/api/v1/postcode?postcode=AB1", nil)
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	rdb.Close()
+
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected StatusServiceUnavailable without postcodes. "+
+			"Got: %d", response.Code)
+	}
+
+	err = runBuildDb([]string{"-postcodes", postcodes, "-out", dir})
+
+	if err != nil {
+		t.Fatalf("Could not load the postcodes: %s", err)
+	}
+
+	rdb = NewResultsDb(rdbPath)
+	defer rdb.Close()
+	h = NewPostcodeHandler(rdb)
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		body     string
+		expected string
+	}{
+		{"GET", "/api/v1/postcode?postcode=ab12ce", "",
+			`{"query":"AB1 2CE","postcodes":[{"postcode":"AB1 2CE",` +
+				`"zone":"W01000001","lat":51.6,"lon":-0.2}],` +
+				`"zones":["W01000001"],"districts":[],"more":false}`},
+		{"GET", "/api/v1/postcode?postcode=AB1+2", "",
+			`"postcodes":[{"postcode":"AB1 2CD","zone":"E01000001",` +
+				`"lat":51.5,"lon":-0.1},{"postcode":"AB1 2CE",` +
+				`"zone":"W01000001","lat":51.6,"lon":-0.2},` +
+				`{"postcode":"AB1 2CG","zone":"E01000001","lat":null,` +
+				`"lon":null}],"zones":["E01000001","W01000001"]`},
+		{"GET", "/api/v1/postcode?postcode=AB1", "", `"AB12 3CD"`},
+		{"POST", "/api/v1/postcode",
+			`{"postcodes": ["ab1 2ce", "AB1 2CF", "AB1", "AB12 3CD"]}`,
+			`"zones":["W01000001","E01000001"],"districts":[],` +
+				`"unknown":["AB1 2CF","AB1"]}`},
+	} {
+
+		request, _ := http.NewRequest(test.method, test.path,
+			strings.NewReader(test.body))
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK ||
+			!strings.Contains(response.Body.String(), test.expected) {
+
+			t.Errorf("Expected %s from PostcodeHandler for %s %s. Got: %d %s",
+				test.expected, test.path, test.body, response.Code,
+				response.Body.String())
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/api/v1/postcode?postcode=AB1+2CF":   http.StatusNotFound,
+		"/api/v1/postcode?postcode=ZZ9+9ZZ":   http.StatusNotFound,
+		"/api/v1/postcode?postcode=E01000001": http.StatusBadRequest,
+		"/api/v1/postcode":                    http.StatusBadRequest,
+	} {
+
+		request, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != status {
+			t.Errorf("Expected status %d for %s. Got: %d", status, path,
+				response.Code)
+		}
+	}
+}
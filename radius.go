@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/olihawkins/popbuilder/spatial"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// maxRadius is the largest radius in kilometres that can be requested.
+const maxRadius = 100
+
+// RadiusResult holds the zones within a radius of a point and their
+// aggregated population.
+type RadiusResult struct {
+	Lat        float64          `json:"lat"`
+	Lon        float64          `json:"lon"`
+	Radius     float64          `json:"radius"`
+	Share      float64          `json:"share"`
+	Year       int              `json:"year"`
+	Zones      []string         `json:"zones"`
+	Districts  []string         `json:"districts"`
+	Population int64            `json:"population"`
+	Bands      []PopulationBand `json:"bands"`
+}
+
+// radiusRequest is the expected shape of a JSON request body. A Share of
+// zero selects zones by their centroids, and a Year of zero requests the
+// latest year.
+type radiusRequest struct {
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Radius float64 `json:"radius"`
+	Share  float64 `json:"share"`
+	Year   int     `json:"year"`
+}
+
+// RadiusHandler finds the zones within a radius of a point.
+type RadiusHandler struct {
+	index      *spatial.Index
+	rdb        *ResultsDb
+	latForm    string
+	lonForm    string
+	radiusForm string
+	shareForm  string
+	yearForm   string
+}
+
+// NewRadiusHandler returns a new RadiusHandler with the values initialised.
+// The index holds the zone boundaries, and may be nil if they could not be
+// loaded.
+func NewRadiusHandler(index *spatial.Index, rdb *ResultsDb) *RadiusHandler {
+
+	return &RadiusHandler{
+		index:      index,
+		rdb:        rdb,
+		latForm:    "lat",
+		lonForm:    "lon",
+		radiusForm: "radius",
+		shareForm:  "share",
+		yearForm:   "year",
+	}
+}
+
+// ServeHTTP expects a latitude, longitude and radius in kilometres in the
+// query string of a GET request, or in a JSON body of the form
+// {"lat": 51.5, "lon": -0.12, "radius": 2} in a POST request. It returns
+// the zones whose centroids are within the radius of the point, and their
+// total population. If a share from 0 to 1 is given, it returns the zones
+// with at least that share of their area within the radius instead. A year
+// may also be given. Responses and errors are sent as JSON.
+func (h *RadiusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if h.index == nil {
+
+		writeAPIError(w, http.StatusServiceUnavailable,
+			"The zone boundaries are not available.")
+
+		return
+	}
+
+	var request radiusRequest
+
+	switch r.Method {
+	case "GET":
+
+		var latErr, lonErr, radiusErr, shareErr, yearErr error
+		request.Lat, latErr = strconv.ParseFloat(r.FormValue(h.latForm), 64)
+		request.Lon, lonErr = strconv.ParseFloat(r.FormValue(h.lonForm), 64)
+		request.Radius, radiusErr = strconv.ParseFloat(
+			r.FormValue(h.radiusForm), 64)
+
+		if share := r.FormValue(h.shareForm); share != "" {
+			request.Share, shareErr = strconv.ParseFloat(share, 64)
+		}
+
+		if year := r.FormValue(h.yearForm); year != "" {
+			request.Year, yearErr = strconv.Atoi(year)
+		}
+
+		if latErr != nil || lonErr != nil || radiusErr != nil ||
+			shareErr != nil || yearErr != nil {
+
+			writeAPIError(w, http.StatusBadRequest,
+				"Could not parse the request: invalid lat, lon, radius, "+
+					"share or year.")
+
+			return
+		}
+
+	case "POST":
+
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+			maxRequestBody)).Decode(&request)
+
+		if err != nil {
+
+			writeAPIError(w, http.StatusBadRequest,
+				"Could not parse the request: invalid JSON body.")
+
+			return
+		}
+
+	default:
+
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed,
+			"That method is not supported.")
+
+		return
+	}
+
+	if !validLatLon(request.Lat, request.Lon) {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"The lat must be from -90 to 90 and the lon from -180 to 180.")
+
+		return
+	}
+
+	if math.IsNaN(request.Radius) || request.Radius <= 0 ||
+		request.Radius > maxRadius {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"The radius must be more than 0 and no more than "+
+				strconv.Itoa(maxRadius)+"km.")
+
+		return
+	}
+
+	if math.IsNaN(request.Share) || request.Share < 0 || request.Share > 1 {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"The share must be from 0 to 1.")
+
+		return
+	}
+
+	// Use the latest year unless another year was requested
+	if request.Year == 0 {
+		request.Year = h.rdb.LatestYear()
+	}
+
+	if !h.rdb.HasYear(request.Year) {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"Population estimates are not available for that year.")
+
+		return
+	}
+
+	result, err := h.search(&request)
+
+	if err != nil {
+
+		writeAPIError(w, http.StatusInternalServerError,
+			"Could not get population data from the ResultsDb.")
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// search returns the RadiusResult for a request.
+func (h *RadiusHandler) search(request *radiusRequest) (*RadiusResult, error) {
+
+	circle := spatial.Circle{
+		Centre: spatial.Point{X: request.Lon, Y: request.Lat},
+		Radius: request.Radius,
+	}
+
+	zones := []string{}
+
+	for _, feature := range h.index.WithinCircle(circle, request.Share) {
+		zones = append(zones, feature.Zone)
+	}
+
+	result := &RadiusResult{
+		Lat:       request.Lat,
+		Lon:       request.Lon,
+		Radius:    request.Radius,
+		Share:     request.Share,
+		Year:      request.Year,
+		Zones:     zones,
+		Districts: selectionDistricts(zones, h.rdb.Geography()),
+		Bands:     []PopulationBand{},
+	}
+
+	if len(zones) == 0 {
+		return result, nil
+	}
+
+	resultsData, err := h.rdb.GetYearPopulationData(zones, request.Year)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.Bands = resultsData.Bands()
+	result.Population = sumPersons(result.Bands)
+	return result, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/olihawkins/popbuilder/spatial"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test RadiusHandler finds the zones within a radius and sums their
+// population, for GET and POST requests.
+func TestRadiusHandler(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	buildTestDbs(t, dir, []string{"E01000001", "W01000001"})
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	// Two small zones about 6.4km apart
+	square := func(x float64, y float64) spatial.MultiPolygon {
+
+		return spatial.MultiPolygon{spatial.Polygon{spatial.Ring{
+			{X: x, Y: y}, {X: x + 0.01, Y: y}, {X: x + 0.01, Y: y + 0.01},
+			{X: x, Y: y + 0.01}}}}
+	}
+
+	index := spatial.NewIndex([]*spatial.Feature{
+		spatial.NewFeature("E01000001", "", 0, square(-1.255, 54.645)),
+		spatial.NewFeature("W01000001", "", 0, square(-1.155, 54.645))})
+
+	h := NewRadiusHandler(index, rdb)
+
+	// Each zone has 4095 males and 4095 females
+	for _, test := range []struct {
+		method   string
+		path     string
+		body     string
+		expected string
+	}{
+		{"GET", "/api/v1/radius?lat=54.65&lon=-1.25&radius=2", "",
+			`"zones":["E01000001"],"districts":[],"population":8190,`},
+		{"GET", "/api/v1/radius?lat=54.65&lon=-1.25&radius=10&share=0.5", "",
+			`"zones":["E01000001","W01000001"],"districts":[],` +
+				`"population":16380,`},
+		{"POST", "/api/v1/radius", `{"lat": 54.65, "lon": -1.2, "radius": 1}`,
+			`"zones":[],"districts":[],"population":0,"bands":[]}`},
+	} {
+
+		request, _ := http.NewRequest(test.method, test.path,
+			strings.NewReader(test.body))
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK ||
+			!strings.Contains(response.Body.String(), test.expected) {
+
+			t.Errorf("Expected %s from RadiusHandler for %s. Got: %d %s",
+				test.expected, test.path, response.Code,
+				response.Body.String())
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/api/v1/radius?lat=54.65&lon=-1.25":                    http.StatusBadRequest,
+		"/api/v1/radius?lat=54.65&lon=-1.25&radius=500":         http.StatusBadRequest,
+		"/api/v1/radius?lat=54.65&lon=-1.25&radius=1&share=2":   http.StatusBadRequest,
+		"/api/v1/radius?lat=54.65&lon=-1.25&radius=1&year=1900": http.StatusBadRequest,
+		"/api/v1/radius?lat=NaN&lon=-1.25&radius=1":             http.StatusBadRequest,
+		"/api/v1/radius?lat=54.65&lon=Inf&radius=1":             http.StatusBadRequest,
+		"/api/v1/radius?lat=91&lon=-1.25&radius=1":              http.StatusBadRequest,
+		"/api/v1/radius?lat=-90.5&lon=-1.25&radius=1":           http.StatusBadRequest,
+		"/api/v1/radius?lat=54.65&lon=181&radius=1":             http.StatusBadRequest,
+		"/api/v1/radius?lat=54.65&lon=-180.5&radius=1":          http.StatusBadRequest,
+		"/api/v1/radius?lat=54.65&lon=-1.25&radius=NaN":         http.StatusBadRequest,
+		"/api/v1/radius?lat=54.65&lon=-1.25&radius=1&share=NaN": http.StatusBadRequest,
+	} {
+
+		request, _ := http.NewRequest("GET", path, nil)
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		if response.Code != status {
+			t.Errorf("Expected status %d for %s. Got: %d", status, path,
+				response.Code)
+		}
+	}
+}
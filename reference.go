@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sort"
+)
+
+// Define the levels of the areas a selection can be compared with
+const (
+	referenceGB       string = "gb"
+	referenceCountry  string = "country"
+	referenceRegion   string = "region"
+	referenceDistrict string = "district"
+)
+
+// ReferenceArea is an area whose age distribution can be shown alongside
+// a selection: Great Britain, or the country, region or district
+// containing the selection.
+type ReferenceArea struct {
+	Level string `json:"level"`
+	Code  string `json:"code"`
+	Name  string `json:"name"`
+	// prefix selects the zones in Great Britain or a country by code, and
+	// zones lists the zones in a region or district
+	prefix string
+	zones  []string
+}
+
+// ReferencePopulation holds the population data for the area a selection is
+// compared with, and the areas that could have been chosen instead.
+type ReferencePopulation struct {
+	Area  *ReferenceArea
+	Areas []*ReferenceArea
+	Data  *ResultsData
+}
+
+// Name returns the name of the area the selection is compared with.
+func (p *ReferencePopulation) Name() string {
+
+	return p.Area.Name
+}
+
+// referenceAreas returns the areas that a selection of zones can be compared
+// with. Where the zones span more than one area, the area containing the
+// most zones is used. The region and district are only available if the
+// Geography is not nil.
+func referenceAreas(g *Geography, zones []string) []*ReferenceArea {
+
+	areas := []*ReferenceArea{{
+		Level:  referenceGB,
+		Code:   gbCode,
+		Name:   gbName,
+		prefix: "",
+	}}
+
+	if len(zones) == 0 {
+		return areas
+	}
+
+	// Find the country containing most of the zones
+	countryCodes := []string{}
+
+	for _, zone := range zones {
+		countryCodes = append(countryCodes, countryCode(zone))
+	}
+
+	if country := Country(mostCommon(countryCodes)); country != nil {
+
+		areas = append(areas, &ReferenceArea{
+			Level:  referenceCountry,
+			Code:   country.Code,
+			Name:   country.Name,
+			prefix: country.Code[:1],
+		})
+	}
+
+	if g == nil {
+		return areas
+	}
+
+	// Find the district and region containing most of the zones
+	districtCodes := []string{}
+	regionCodes := []string{}
+
+	for _, zone := range zones {
+
+		if district := g.ZoneDistrict(zone); district != nil {
+
+			districtCodes = append(districtCodes, district.Code)
+			regionCodes = append(regionCodes, district.Parent)
+		}
+	}
+
+	if region := g.Region(mostCommon(regionCodes)); region != nil {
+
+		areas = append(areas, &ReferenceArea{
+			Level: referenceRegion,
+			Code:  region.Code,
+			Name:  region.Name,
+			zones: region.Zones,
+		})
+	}
+
+	if district := g.District(mostCommon(districtCodes)); district != nil {
+
+		areas = append(areas, &ReferenceArea{
+			Level: referenceDistrict,
+			Code:  district.Code,
+			Name:  district.Name,
+			zones: district.Zones,
+		})
+	}
+
+	return areas
+}
+
+// findReferenceArea returns the area at the given level, or nil if there is
+// no area at that level.
+func findReferenceArea(areas []*ReferenceArea, level string) *ReferenceArea {
+
+	for _, area := range areas {
+
+		if area.Level == level {
+			return area
+		}
+	}
+
+	return nil
+}
+
+// mostCommon returns the most common non-empty string in a list, choosing
+// the first in sort order if there is a tie.
+func mostCommon(values []string) string {
+
+	counts := map[string]int{}
+	keys := []string{}
+
+	for _, value := range values {
+
+		if value == "" {
+			continue
+		}
+
+		if _, ok := counts[value]; !ok {
+			keys = append(keys, value)
+		}
+
+		counts[value]++
+	}
+
+	sort.Strings(keys)
+	result := ""
+
+	for _, key := range keys {
+
+		if counts[key] > counts[result] {
+			result = key
+		}
+	}
+
+	return result
+}
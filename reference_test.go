@@ -0,0 +1,156 @@
+package main
+
+import (
+	"github.com/olihawkins/handlers"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test LoadGeography finds the district and region containing a zone.
+func TestLoadGeography(t *testing.T) {
+
+	g, err := LoadGeography(boundsPath, popzonesDir)
+
+	if err != nil {
+		t.Fatalf("Could not load the geography: %s", err)
+	}
+
+	district := g.ZoneDistrict("E01011949")
+
+	if district == nil || district.Name != "Hartlepool" ||
+		district.Parent != "E15000001" || len(district.Zones) != 58 {
+
+		t.Fatalf("Expected E01011949 to be in Hartlepool. Got: %+v", district)
+	}
+
+	region := g.Region(district.Parent)
+
+	if region.Name != "North East" || region.Parent != "E92000001" {
+		t.Errorf("Expected Hartlepool to be in the North East. Got: %+v", region)
+	}
+}
+
+// Test referenceAreas chooses the areas containing most of the zones.
+func TestReferenceAreas(t *testing.T) {
+
+	g := &Geography{
+		regions: map[string]*GeographyArea{
+			"E15000001": {Code: "E15000001", Name: "North East",
+				Parent: "E92000001", Zones: []string{"A", "B", "C"}},
+		},
+		districts: map[string]*GeographyArea{
+			"E06000001": {Code: "E06000001", Name: "Hartlepool",
+				Parent: "E15000001", Zones: []string{"A"}},
+			"E06000002": {Code: "E06000002", Name: "Middlesbrough",
+				Parent: "E15000001", Zones: []string{"B", "C"}},
+		},
+		zoneDistricts: map[string]string{
+			"E01000001": "E06000001",
+			"E01000002": "E06000002",
+			"E01000003": "E06000002",
+		},
+	}
+
+	zones := []string{"E01000001", "E01000002", "E01000003", "W01000001"}
+	areas := referenceAreas(g, zones)
+	expected := map[string]string{
+		referenceGB:       "Great Britain",
+		referenceCountry:  "England",
+		referenceRegion:   "North East",
+		referenceDistrict: "Middlesbrough",
+	}
+
+	if len(areas) != len(expected) {
+		t.Fatalf("Expected %d reference areas. Got: %d", len(expected),
+			len(areas))
+	}
+
+	for level, name := range expected {
+
+		if area := findReferenceArea(areas, level); area == nil ||
+			area.Name != name {
+
+			t.Errorf("Expected %s as the %s reference area. Got: %+v",
+				name, level, area)
+		}
+	}
+
+	// Without a geography only GB and the country are available
+	areas = referenceAreas(nil, []string{"W01000001"})
+
+	if len(areas) != 2 || areas[1].Name != "Wales" {
+		t.Errorf("Expected GB and Wales as reference areas. Got: %+v",
+			areas)
+	}
+}
+
+// Test ResultsHandler shows the population of the chosen reference area in
+// outline. The databases are built from test source files.
+func TestResultsHandlerReference(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	// Give each zone in Wales one more person at each age than in England
+	welsh := filepath.Join(dir, "welsh.csv")
+	writeSourceCSV(t, welsh, []string{"W01000001", "W01000002"}, 1)
+	buildTestDbs(t, dir, []string{"E01000001"}, "-bounds", boundsPath,
+		"-males", welsh, "-females", welsh)
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	errorHandler := handlers.LoadErrorHandler(errorPath, "", true)
+	h := NewResultsHandler(resultsPath, rdb, nil, nil, errorHandler)
+
+	// Males aged 0 to 9 are 45 in England and 55 in each zone in Wales
+	expected := map[string]string{
+		referenceGB:      "male: 155 ",
+		referenceCountry: "male: 110 ",
+	}
+
+	for level, values := range expected {
+
+		form := url.Values{}
+		form.Add(h.zoneForm, "W01000001")
+		form.Add(h.referenceForm, level)
+
+		request, _ := http.NewRequest("POST", "/results",
+			strings.NewReader(form.Encode()))
+		request.Header.Add("Content-Type",
+			"application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+
+		h.ServeHTTP(response, request)
+		bodyString := response.Body.String()
+
+		if !strings.Contains(bodyString, "{group: '0-9', "+values) {
+			t.Errorf("Expected %s for the %s reference area. Got: %s",
+				values, level, bodyString)
+		}
+	}
+
+	// Request a reference area that is not available without a geography
+	form := url.Values{}
+	form.Add(h.zoneForm, "W01000001")
+	form.Add(h.referenceForm, referenceDistrict)
+
+	request, _ := http.NewRequest("POST", "/results",
+		strings.NewReader(form.Encode()))
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+
+	h.ServeHTTP(response, request)
+
+	if !strings.Contains(response.Body.String(),
+		"The selected population cannot be compared with that area.") {
+
+		t.Errorf("Expected an error from ResultsHandler for a missing area. "+
+			"Got: %s", response.Body.String())
+	}
+}
@@ -59,8 +59,400 @@ pb.BoundarySearch = function(regions) {
 
 		this.districtsInView = districtsInView;
 	};
+
+	// Returns the bounds of the district with the given code, or null
+	this.getDistrictBounds = function(districtCode) {
+
+		var regionCodes = Object.keys(this.regions),
+			districts;
+
+		for (var i = 0; i < regionCodes.length; i++) {
+
+			districts = this.regions[regionCodes[i]].districts;
+
+			if (districts.hasOwnProperty(districtCode)) {
+
+				return districts[districtCode].bounds;
+			}
+		}
+
+		return null;
+	};
+};
+
+/* Returns a GeoJSON FeatureCollection of the MultiPolygons in the 
+GeometryCollection with the given name in a TopoJSON Topology. Quantized 
+arcs are decoded with the transform of the Topology. */
+pb.topoFeatures = function(topology, name) {
+
+	var transform = topology.transform,
+		arcs = [],
+		features = [],
+		geometries = topology.objects[name].geometries;
+
+	// Decode the positions of each arc
+	for (var i = 0; i < topology.arcs.length; i++) {
+
+		var arc = [],
+			x = 0,
+			y = 0;
+
+		for (var j = 0; j < topology.arcs[i].length; j++) {
+
+			var position = topology.arcs[i][j];
+
+			if (transform) {
+
+				x += position[0];
+				y += position[1];
+				arc.push([x * transform.scale[0] + transform.translate[0], 
+					y * transform.scale[1] + transform.translate[1]]);
+
+			} else {
+
+				arc.push(position);
+			}
+		}
+
+		arcs.push(arc);
+	}
+
+	// Join the arcs of a ring, each starting where the last ends
+	var ring = function(refs) {
+
+		var points = [];
+
+		for (var k = 0; k < refs.length; k++) {
+
+			var ref = refs[k],
+				arcPoints = ref < 0 ? arcs[~ref].slice().reverse() : arcs[ref];
+
+			if (points.length > 0) arcPoints = arcPoints.slice(1);
+			points = points.concat(arcPoints);
+		}
+
+		return points;
+	};
+
+	for (var g = 0; g < geometries.length; g++) {
+
+		var coordinates = [];
+
+		for (var p = 0; p < geometries[g].arcs.length; p++) {
+
+			coordinates.push(geometries[g].arcs[p].map(ring));
+		}
+
+		features.push({
+			type: 'Feature',
+			properties: geometries[g].properties,
+			geometry: {type: 'MultiPolygon', coordinates: coordinates}
+		});
+	}
+
+	return {type: 'FeatureCollection', features: features};
+};
+
+/* Decodes the features of the first layer of a Mapbox Vector Tile, given as 
+an ArrayBuffer. Each feature is returned with its properties and the rings 
+of its geometry as arrays of [x, y] positions in tile coordinates, from 0 to 
+the extent of the layer. Only the parts of the format used by the zone tiles 
+are read. */
+pb.decodeTile = function(buffer) {
+
+	var bytes = new Uint8Array(buffer);
+
+	// Reads the fields of the message between start and end
+	var readFields = function(start, end) {
+
+		var fields = {},
+			pos = start;
+
+		var readVarint = function() {
+
+			var value = 0, 
+				shift = 1, 
+				b;
+
+			do {
+
+				b = bytes[pos++];
+				value += (b & 0x7f) * shift;
+				shift *= 128;
+
+			} while (b >= 0x80);
+
+			return value;
+		};
+
+		while (pos < end) {
+
+			var key = readVarint(),
+				field = Math.floor(key / 8),
+				value;
+
+			if ((key & 7) === 2) {
+
+				var length = readVarint();
+				value = {start: pos, end: pos + length};
+				pos += length;
+
+			} else {
+
+				value = readVarint();
+			}
+
+			if (!fields.hasOwnProperty(field)) fields[field] = [];
+			fields[field].push(value);
+		}
+
+		fields.varints = function(field) {
+
+			var values = [];
+
+			if (!fields.hasOwnProperty(field)) return values;
+
+			pos = fields[field][0].start;
+
+			while (pos < fields[field][0].end) values.push(readVarint());
+
+			return values;
+		};
+
+		return fields;
+	};
+
+	var readString = function(range) {
+
+		var text = '';
+
+		for (var i = range.start; i < range.end; i++) {
+
+			text += String.fromCharCode(bytes[i]);
+		}
+
+		return text;
+	};
+
+	var tile = readFields(0, bytes.length),
+		result = {extent: 4096, features: []};
+
+	if (!tile.hasOwnProperty(3)) return result;
+
+	var layer = readFields(tile[3][0].start, tile[3][0].end),
+		keys = [],
+		values = [];
+
+	if (layer.hasOwnProperty(5)) result.extent = layer[5][0];
+
+	for (var i = 0; layer.hasOwnProperty(3) && i < layer[3].length; i++) {
+
+		keys.push(readString(layer[3][i]));
+	}
+
+	for (var j = 0; layer.hasOwnProperty(4) && j < layer[4].length; j++) {
+
+		var value = readFields(layer[4][j].start, layer[4][j].end);
+
+		if (value.hasOwnProperty(1)) {
+
+			values.push(readString(value[1][0]));
+
+		} else if (value.hasOwnProperty(6)) {
+
+			var zigzag = value[6][0];
+			values.push(zigzag % 2 === 0 ? zigzag / 2 : -(zigzag + 1) / 2);
+
+		} else {
+
+			values.push(null);
+		}
+	}
+
+	for (var k = 0; layer.hasOwnProperty(2) && k < layer[2].length; k++) {
+
+		var fields = readFields(layer[2][k].start, layer[2][k].end),
+			tags = fields.varints(2),
+			commands = fields.varints(4),
+			feature = {properties: {}, rings: []},
+			ring = null,
+			x = 0,
+			y = 0,
+			c = 0;
+
+		for (var t = 0; t + 1 < tags.length; t += 2) {
+
+			feature.properties[keys[tags[t]]] = values[tags[t + 1]];
+		}
+
+		// Follow the commands, whose positions are relative to the last
+		while (c < commands.length) {
+
+			var command = commands[c] & 7,
+				count = commands[c] >> 3;
+
+			c++;
+
+			if (command === 7) {
+
+				if (ring !== null) feature.rings.push(ring);
+				ring = null;
+				continue;
+			}
+
+			for (var n = 0; n < count; n++) {
+
+				var dx = commands[c], 
+					dy = commands[c + 1];
+
+				x += dx % 2 === 0 ? dx / 2 : -(dx + 1) / 2;
+				y += dy % 2 === 0 ? dy / 2 : -(dy + 1) / 2;
+				c += 2;
+
+				if (command === 1) ring = [];
+				ring.push([x, y]);
+			}
+		}
+
+		result.features.push(feature);
+	}
+
+	return result;
 };
 
+/* The tile layer that draws the zone boundaries from vector tiles served by 
+the server, so that they can be shown at every zoom level. The zones whose 
+codes are keys of the selected object are filled. Decoded tiles are kept so 
+that the layer can be redrawn when the selection changes. */
+pb.ZoneTileLayer = L.GridLayer.extend({
+
+	options: {zIndex: 10},
+
+	selected: {},
+
+	maxDecodedTiles: 512,
+
+	initialize: function(options) {
+
+		L.setOptions(this, options);
+		this._decoded = {};
+		this._decodedCount = 0;
+	},
+
+	createTile: function(coords, done) {
+
+		var layer = this,
+			canvas = L.DomUtil.create('canvas', 'leaflet-tile'),
+			size = this.getTileSize(),
+			tiles = Math.pow(2, coords.z),
+			x = ((coords.x % tiles) + tiles) % tiles,
+			path = '/tiles/' + coords.z + '/' + x + '/' + coords.y + '.mvt';
+
+		canvas.width = size.x;
+		canvas.height = size.y;
+
+		// Tiles can only be drawn once they have been added to the map
+		var drawn = function(decoded) {
+
+			L.Util.requestAnimFrame(function() {
+
+				if (decoded) layer.drawZones(canvas, decoded, coords.z);
+				done(null, canvas);
+			});
+		};
+
+		if (coords.y < 0 || coords.y >= tiles) {
+
+			drawn(null);
+			return canvas;
+		}
+
+		if (this._decoded.hasOwnProperty(path)) {
+
+			drawn(this._decoded[path]);
+			return canvas;
+		}
+
+		var request = new XMLHttpRequest();
+		request.open('GET', path);
+		request.responseType = 'arraybuffer';
+
+		request.onload = function() {
+
+			if (request.status !== 200) return drawn(null);
+
+			var decoded = pb.decodeTile(request.response);
+
+			// Forget the decoded tiles when there are too many
+			if (layer._decodedCount >= layer.maxDecodedTiles) {
+
+				layer._decoded = {};
+				layer._decodedCount = 0;
+			}
+
+			layer._decoded[path] = decoded;
+			layer._decodedCount++;
+			drawn(decoded);
+		};
+
+		request.onerror = function() {
+
+			drawn(null);
+		};
+
+		request.send();
+
+		return canvas;
+	},
+
+	drawZones: function(canvas, decoded, zoom) {
+
+		var context = canvas.getContext('2d'),
+			scale = canvas.width / decoded.extent;
+
+		context.clearRect(0, 0, canvas.width, canvas.height);
+		context.strokeStyle = '#A000A0';
+		context.fillStyle = 'rgba(208, 128, 208, 0.4)';
+		context.lineWidth = zoom < 10 ? 0.5 : 1;
+
+		for (var i = 0; i < decoded.features.length; i++) {
+
+			var feature = decoded.features[i];
+
+			context.beginPath();
+
+			for (var j = 0; j < feature.rings.length; j++) {
+
+				var ring = feature.rings[j];
+
+				for (var k = 0; k < ring.length; k++) {
+
+					var x = ring[k][0] * scale, 
+						y = ring[k][1] * scale;
+
+					if (k === 0) {
+
+						context.moveTo(x, y);
+
+					} else {
+
+						context.lineTo(x, y);
+					}
+				}
+
+				context.closePath();
+			}
+
+			if (this.selected.hasOwnProperty(feature.properties.zone)) {
+
+				context.fill('evenodd');
+			}
+
+			context.stroke();
+		}
+	}
+});
+
 /* Constructor for the MapView object, a singleton that manages the state 
 of the Leaflet map. */
 pb.MapView = function(map) {
@@ -79,6 +471,40 @@ pb.MapView = function(map) {
 		this.map.removeLayer(districtLayer);	
 	};
 
+	// The layer of vector tiles that shows the zone boundaries when the 
+	// zones of each district are not on the map
+	this.zoneTiles = new pb.ZoneTileLayer();
+	this.zoneTilesSelection = '';
+
+	// Shows the zone tiles with the zones in the selected object filled, 
+	// redrawing them if the selection has changed
+	this.showZoneTiles = function(selected) {
+
+		var selection = Object.keys(selected).sort().join(',');
+
+		this.zoneTiles.selected = selected;
+
+		if (!this.map.hasLayer(this.zoneTiles)) {
+
+			this.zoneTilesSelection = selection;
+			this.zoneTiles.addTo(this.map);
+
+		} else if (selection !== this.zoneTilesSelection) {
+
+			this.zoneTilesSelection = selection;
+			this.zoneTiles.redraw();
+		}
+	};
+
+	// Hides the zone tiles
+	this.hideZoneTiles = function() {
+
+		if (this.map.hasLayer(this.zoneTiles)) {
+
+			this.map.removeLayer(this.zoneTiles);
+		}
+	};
+
 	// Settings for the population information control
 	this.popInfo.onAdd = function(map) {
 
@@ -140,83 +566,339 @@ pb.MapView = function(map) {
 
 		this.overlayControl.removeFrom(this.map);
 	};	
-};
 
-/* Constructor for the MapModel object, a singleton that manages the state 
-of the MapView. The MapController updates the MapModel with changes 
-arising from events, and the MapModel updates the MapView. */
-pb.MapModel = function(mapView) {
+	// Settings for the account control, which lists saved selections
+	this.accountControl = L.control({position: 'topright'});
 
-	this.mapView = mapView;
-	this.mapBounds = mapView.map.getBounds();
-	this.minimumZoom = 12;
-	this.zoomLevel = 5;
-	this.districtsInView = [];
-	this.districtsLoaded = {};
-	this.districtsOnMap = {};
-	this.selectedFeatures = {};
-	this.selectedZones = {};
-	this.selectedPopulation = 0;
-	this.overlayZoomLevel = 9;
-	this.overlayControlActive = false;
-	this.overlayStates = ['Auto', 'On', 'Off'];
-	this.currentOverlayState = 0;
-	this.highlightedZone = null;
-	this.highlightedZoneCode = '';
+	this.accountControl.onAdd = function(map) {
 
-	/* Method called when the map moves to update the map state.
-	The method is given the codes of the districts in the current
-	map view, as determined by the boundarySearch object. */
-	this.setDistrictsInView = function(districtsInView) {
+		this._div = L.DomUtil.create('div', 'accountcontrol');
+		L.DomEvent.disableClickPropagation(this._div);
+		this.showLogin('');
+		return this._div;
+	};
 
-		var distInView,
-			distOnMap,
-			districtsOnMap = Object.keys(this.districtsOnMap);
+	// Shows the login form with an optional message
+	this.accountControl.showLogin = function(message) {
 
-		// Keep a record of the districts in view
-		this.districtsInView = districtsInView;
+		this._div.innerHTML = '<h4>Saved Selections</h4>' + 
+			'<p><input id="pb-username" type="text" placeholder="Username">' + 
+			'</p><p><input id="pb-password" type="password" ' + 
+			'placeholder="Password"></p><p><span class="action" ' + 
+			'onclick="pb.mapController.login();">Log in</span> | ' + 
+			'<span class="action" onclick="pb.mapController.register();">' + 
+			'Register</span></p>' + pb.messageHTML(message);
+	};
 
-		// Find districts in view that are not on the map and add them
-		for (var i = 0; i < districtsInView.length; i++) {
+	// Shows the saved selections for the user with an optional message
+	this.accountControl.showSelections = function(username, selections, 
+		message) {
+
+		var html = '<h4>Saved Selections</h4><p>Logged in as <b>' + 
+			pb.escapeHTML(username) + '</b></p>',
+			selection;
+
+		for (var i = 0; i < selections.length; i++) {
+
+			selection = selections[i];
+			html += '<p class="selection"><span class="action" ' + 
+				'onclick="pb.mapController.loadSavedSelection(' + 
+				selection.id + ');">' + pb.escapeHTML(selection.name) + 
+				'</span> <span class="edit" ' + 
+				'onclick="pb.mapController.updateSavedSelection(' + 
+				selection.id + ');">Update</span> <span class="edit" ' + 
+				'onclick="pb.mapController.renameSavedSelection(' + 
+				selection.id + ');">Rename</span> <span class="edit" ' + 
+				'onclick="pb.mapController.deleteSavedSelection(' + 
+				selection.id + ');">Delete</span></p>';
+		}
 
-			distInView = districtsInView[i];
+		html += '<p><span class="action" ' + 
+			'onclick="pb.mapController.saveSelection();">' + 
+			'Save selection</span> | <span class="action" ' + 
+			'onclick="pb.mapController.logout();">Log out</span></p>' + 
+			pb.messageHTML(message);
 
-			if (districtsOnMap.indexOf(distInView) == -1) {
+		this._div.innerHTML = html;
+	};
 
-				this.addDistrictToMap(distInView);
-			}
-		}
+	// Add to map at start
+	this.accountControl.addTo(this.map);
+
+	// Settings for the postcode control, which finds postcodes and selects 
+	// the zones containing them
+	this.postcodeControl = L.control({position: 'topleft'});
+	this.postcodeMarker = null;
+
+	this.postcodeControl.onAdd = function(map) {
+
+		this._div = L.DomUtil.create('div', 'postcodecontrol');
+		L.DomEvent.disableClickPropagation(this._div);
+		this._div.innerHTML = '<h4>Find Postcode</h4>' + 
+			'<form onsubmit="pb.mapController.findPostcode(); ' + 
+			'return false;"><p><input type="text" id="pb-postcode" ' + 
+			'placeholder="Postcode or start of postcode"></p></form>' + 
+			'<p><span class="action" ' + 
+			'onclick="pb.mapController.findPostcode();">Find</span></p>' + 
+			'<p><textarea id="pb-postcode-list" rows="2" ' + 
+			'placeholder="Paste a list of postcodes"></textarea></p>' + 
+			'<p><span class="action" ' + 
+			'onclick="pb.mapController.selectPostcodes();">' + 
+			'Select postcodes</span></p><div class="postcodemessage"></div>';
+		return this._div;
+	};
 
-		// Find districts on the map that are not in view and remove them
-		for (var j = 0; j < districtsOnMap.length; j++) {
+	// Shows a message below the postcode search
+	this.postcodeControl.update = function(message) {
 
-			distOnMap = districtsOnMap[j];
+		this._div.lastChild.innerHTML = pb.messageHTML(message);
+	};
 
-			if (districtsInView.indexOf(distOnMap) == -1) {
+	// Returns the postcode entered in the search box
+	this.postcodeControl.getPostcode = function() {
 
-				this.removeDistrictFromMap(distOnMap);
-			}
-		}
+		return document.getElementById('pb-postcode').value;
 	};
 
-	// Handles adding layers to the map and tracking their state
-	this.addDistrictToMap = function(districtCode) {
+	// Returns the postcodes pasted into the list, one per line or separated 
+	// by commas
+	this.postcodeControl.getPostcodeList = function() {
 
-		var mapModel = this,
-			mapView = this.mapView,
-			districtLayer;
+		var lines = document.getElementById('pb-postcode-list').value
+				.split(/[\n\r,;\t]+/),
+			postcodes = [];
 
-		// The callback function used to retrieve json data for district layers
-		var downloadDistrict = function(error, json) {
+		for (var i = 0; i < lines.length; i++) {
 
-			// Stop and log an error if the json does not return
-			if (error) return console.warn(error);
+			if (lines[i].trim() !== '') postcodes.push(lines[i].trim());
+		}
 
-			if (!mapModel.districtsOnMap.hasOwnProperty(districtCode)) {
+		return postcodes;
+	};
 
-				districtLayer = L.geoJson(json, {
-					className: districtCode, 
-					color: '#A000A0', 
+	// Add to map at start
+	this.postcodeControl.addTo(this.map);
+
+	// Removes the marker for a postcode from the map
+	this.removePostcodeMarker = function() {
+
+		if (this.postcodeMarker !== null) {
+
+			this.map.removeLayer(this.postcodeMarker);
+			this.postcodeMarker = null;
+		}
+	};
+
+	// Settings for the upload control, which reads a file of zone codes
+	this.uploadControl = L.control({position: 'topleft'});
+
+	this.uploadControl.onAdd = function(map) {
+
+		this._div = L.DomUtil.create('div', 'uploadcontrol');
+		L.DomEvent.disableClickPropagation(this._div);
+		this._div.innerHTML = '<h4>Upload Codes or Boundary</h4>' + 
+			'<form id="pb-upload" method="post" action="/upload" ' + 
+			'enctype="multipart/form-data"><p><input type="file" ' + 
+			'name="file" accept=".csv,.txt,.geojson,.json,.kml,.zip,.shp,' + 
+			'text/csv,text/plain"></p>' + 
+			'<input type="hidden" name="target" value="">' + 
+			'<input type="hidden" name="rule" value=""></form>' + 
+			'<p><span class="action" ' + 
+			'onclick="pb.mapController.uploadResults();">Get data</span> | ' + 
+			'<span class="action" ' + 
+			'onclick="pb.mapController.uploadToMap();">Select on map</span>' + 
+			'</p><div class="uploadmessage"></div>';
+		return this._div;
+	};
+
+	// Shows a message below the upload form
+	this.uploadControl.update = function(message) {
+
+		this._div.lastChild.innerHTML = pb.messageHTML(message);
+	};
+
+	// Add to map at start
+	this.uploadControl.addTo(this.map);
+
+	// Settings for the radius control, which selects zones within a circle
+	this.radiusControl = L.control({position: 'topleft'});
+	this.radiusCircle = null;
+
+	this.radiusControl.onAdd = function(map) {
+
+		this._div = L.DomUtil.create('div', 'radiuscontrol');
+		L.DomEvent.disableClickPropagation(this._div);
+		this._div.innerHTML = '<h4>Select Radius</h4>' + 
+			'<p><select id="pb-radius-share">' + 
+			'<option value="0">Zone centres in circle</option>' + 
+			'<option value="0.25">25% of zone area in circle</option>' + 
+			'<option value="0.5">50% of zone area in circle</option>' + 
+			'<option value="0.75">75% of zone area in circle</option>' + 
+			'</select></p><p><span class="action" ' + 
+			'onclick="pb.mapController.drawRadius();">Draw circle</span>' + 
+			'</p><div class="radiusmessage"></div>';
+		return this._div;
+	};
+
+	// Shows a message below the radius settings
+	this.radiusControl.update = function(message) {
+
+		this._div.lastChild.innerHTML = pb.messageHTML(message);
+	};
+
+	// Returns the share of zone area chosen in the radius settings
+	this.radiusControl.getShare = function() {
+
+		return document.getElementById('pb-radius-share').value;
+	};
+
+	// Add to map at start
+	this.radiusControl.addTo(this.map);
+
+	// Removes the circle drawn with the radius control from the map
+	this.removeRadiusCircle = function() {
+
+		if (this.radiusCircle !== null) {
+
+			this.map.removeLayer(this.radiusCircle);
+			this.radiusCircle = null;
+		}
+	};
+
+	// Settings for the boundary control, which selects zones within a polygon
+	this.boundaryControl = L.control({position: 'topleft'});
+	this.boundaryPolygon = null;
+
+	this.boundaryControl.onAdd = function(map) {
+
+		this._div = L.DomUtil.create('div', 'boundarycontrol');
+		L.DomEvent.disableClickPropagation(this._div);
+		this._div.innerHTML = '<h4>Select Boundary</h4>' + 
+			'<p><select id="pb-boundary-rule">' + 
+			'<option value="centroid">Zone centres in boundary</option>' + 
+			'<option value="overlap">Zones overlapping boundary</option>' + 
+			'<option value="apportion">Apportion population by area</option>' + 
+			'</select></p><p><span class="action" ' + 
+			'onclick="pb.mapController.drawBoundary();">Draw boundary</span>' + 
+			'</p><div class="boundarymessage"></div>';
+		return this._div;
+	};
+
+	// Shows a message below the boundary settings
+	this.boundaryControl.update = function(message) {
+
+		this._div.lastChild.innerHTML = pb.messageHTML(message);
+	};
+
+	// Returns the rule for selecting zones chosen in the boundary settings
+	this.boundaryControl.getRule = function() {
+
+		return document.getElementById('pb-boundary-rule').value;
+	};
+
+	// Add to map at start
+	this.boundaryControl.addTo(this.map);
+
+	// Removes the polygon drawn with the boundary control from the map
+	this.removeBoundaryPolygon = function() {
+
+		if (this.boundaryPolygon !== null) {
+
+			this.map.removeLayer(this.boundaryPolygon);
+			this.boundaryPolygon = null;
+		}
+	};
+};
+
+/* Constructor for the MapModel object, a singleton that manages the state 
+of the MapView. The MapController updates the MapModel with changes 
+arising from events, and the MapModel updates the MapView. */
+pb.MapModel = function(mapView) {
+
+	this.mapView = mapView;
+	this.mapBounds = mapView.map.getBounds();
+	this.minimumZoom = 12;
+	this.zoomLevel = 5;
+	this.districtsInView = [];
+	this.districtsLoaded = {};
+	this.districtsLoadedZoom = {};
+	this.districtsLoading = {};
+	this.maximumDetailZoom = 16;
+	this.districtsOnMap = {};
+	this.selectedFeatures = {};
+	this.selectedZones = {};
+	this.pendingZones = {};
+	this.selectionBoundary = null;
+	this.selectedPopulation = 0;
+	this.overlayZoomLevel = 9;
+	this.overlayControlActive = false;
+	this.overlayStates = ['Auto', 'On', 'Off'];
+	this.currentOverlayState = 0;
+	this.highlightedZone = null;
+	this.highlightedZoneCode = '';
+
+	/* Method called when the map moves to update the map state.
+	The method is given the codes of the districts in the current
+	map view, as determined by the boundarySearch object. */
+	this.setDistrictsInView = function(districtsInView) {
+
+		var distInView,
+			distOnMap,
+			districtsOnMap = Object.keys(this.districtsOnMap);
+
+		// Keep a record of the districts in view
+		this.districtsInView = districtsInView;
+
+		// Add the districts in view that are not on the map, and load the 
+		// districts on the map again if they need more detail
+		for (var i = 0; i < districtsInView.length; i++) {
+
+			distInView = districtsInView[i];
+			this.addDistrictToMap(distInView);
+		}
+
+		// Find districts on the map that are not in view and remove them
+		for (var j = 0; j < districtsOnMap.length; j++) {
+
+			distOnMap = districtsOnMap[j];
+
+			if (districtsInView.indexOf(distOnMap) == -1) {
+
+				this.removeDistrictFromMap(distOnMap);
+			}
+		}
+	};
+
+	// Handles adding layers to the map and tracking their state
+	this.addDistrictToMap = function(districtCode) {
+
+		var mapModel = this,
+			mapView = this.mapView,
+			detailZoom = this.getDetailZoom(),
+			districtLayer;
+
+		// The callback function used to retrieve json data for district layers
+		var downloadDistrict = function(error, json) {
+
+			delete mapModel.districtsLoading[districtCode];
+
+			// Stop and log an error if the json does not return
+			if (error) return console.warn(error);
+
+			if (json.type === 'Topology') json = pb.topoFeatures(json, 'zones');
+
+			// Replace a layer loaded with less detail
+			if (mapModel.districtsLoaded.hasOwnProperty(districtCode) && 
+				mapModel.districtsLoadedZoom[districtCode] < detailZoom) {
+
+				mapModel.unloadDistrict(districtCode);
+			}
+
+			if (!mapModel.districtsLoaded.hasOwnProperty(districtCode)) {
+
+				districtLayer = L.geoJson(json, {
+					className: districtCode, 
+					color: '#A000A0', 
 					weight: 2, 
 					fillColor: '#D080D0',
 					fillOpacity: 0,
@@ -224,7 +906,19 @@ pb.MapModel = function(mapView) {
 
 						feature.properties.selected = false;
 
+						// Select zones from a saved selection as they load
+						var zoneCode = feature.properties.zone;
+
+						if (mapModel.pendingZones.hasOwnProperty(zoneCode)) {
+
+							delete mapModel.pendingZones[zoneCode];
+							mapModel.selectZone(feature, layer);
+						}
+
 						layer.on('click', function(e) {
+
+							// The selection no longer matches its boundary
+							mapModel.selectionBoundary = null;
 							
 							if (feature.properties.selected) {
 
@@ -243,275 +937,989 @@ pb.MapModel = function(mapView) {
 
 						layer.on('mousemove', function(e) {
 
-							mapModel.setCurrentZone(feature, e.target);
-						});
+							mapModel.setCurrentZone(feature, e.target);
+						});
+
+						layer.on('mouseout', function(e) {
+
+							mapModel.clearCurrentZone();
+						});
+
+						layer.on('contextmenu', function(e) {
+
+							mapModel.setHighlightedZone(feature, e.target);
+						});
+					}
+				});
+				
+				mapModel.districtsLoaded[districtCode] = districtLayer;
+				mapModel.districtsLoadedZoom[districtCode] = detailZoom;
+
+				if (mapModel.districtsInView.indexOf(districtCode) != -1) {
+
+					mapModel.districtsOnMap[districtCode] = districtLayer;
+					mapView.addDistrictLayer(districtLayer);
+				}
+			}
+		};
+
+		// If the layer has already been loaded in enough detail for the 
+		// zoom level then add it, unless it is already on the map
+		if (this.districtsLoaded.hasOwnProperty(districtCode) && 
+			this.districtsLoadedZoom[districtCode] >= detailZoom) {
+
+			if (!this.districtsOnMap.hasOwnProperty(districtCode)) {
+
+				districtLayer = this.districtsLoaded[districtCode];
+				this.districtsOnMap[districtCode] = districtLayer;
+				mapView.addDistrictLayer(districtLayer);
+			}
+
+		// Otherwise load the layer simplified for the zoom level then add it 
+		// with a callback, unless it is already loading
+		} else if (!this.districtsLoading.hasOwnProperty(districtCode)) {
+
+			this.districtsLoading[districtCode] = true;
+			var jsonPath = '/resources/popzones/' + districtCode + 
+				'.json?zoom=' + detailZoom;
+
+			// Ask for TopoJSON, which is smaller, but accept GeoJSON
+			d3.json(jsonPath)
+				.header('Accept', 'application/topo+json, application/json')
+				.get(downloadDistrict);
+		}
+	};
+
+	/* Returns the zoom level that district boundaries are loaded for, which 
+	is the zoom level of the map up to the most detailed zoom level that is 
+	loaded. Boundaries are simplified on the server to the detail that can 
+	be seen at that zoom level. */
+	this.getDetailZoom = function() {
+
+		var zoom = Math.round(this.mapView.map.getZoom());
+		return Math.min(zoom, this.maximumDetailZoom);
+	};
+
+	/* Removes the layer loaded for a district so that it can be replaced with 
+	one loaded in more detail. The selected zones in the district are kept as 
+	pending zones, to be selected again when the new layer loads. */
+	this.unloadDistrict = function(districtCode) {
+
+		var mapModel = this,
+			districtLayer = this.districtsLoaded[districtCode];
+
+		districtLayer.eachLayer(function(layer) {
+
+			if (layer.feature.properties.selected) {
+
+				mapModel.deselectZone(layer.feature, layer);
+				mapModel.pendingZones[layer.feature.properties.zone] = true;
+			}
+
+			if (layer === mapModel.highlightedZone) {
+
+				mapModel.clearCurrentZone();
+			}
+		});
+
+		this.removeDistrictFromMap(districtCode);
+		delete this.districtsLoaded[districtCode];
+		delete this.districtsLoadedZoom[districtCode];
+	};
+
+	// Handles removing layers from the map and tracking their state
+	this.removeDistrictFromMap = function(districtCode) {
+
+		var districtsLoaded = this.districtLoaded,
+			districtsOnMap = this.districtsOnMap,
+			districtLayer;
+
+		// Only remove the layer if it is already on the map
+		if (districtsOnMap.hasOwnProperty(districtCode)) {
+
+			districtLayer = districtsOnMap[districtCode];
+			this.mapView.removeDistrictLayer(districtLayer);
+			delete districtsOnMap[districtCode];
+		}
+	};
+
+	// Handles the selection of zones
+	this.selectZone = function(feature, layer) {
+
+		feature.properties.selected = true;
+		this.selectedFeatures[feature.properties.zone] = feature;
+		this.selectedZones[feature.properties.zone] = layer;
+		this.selectedPopulation += parseInt(feature.properties.population, 10);
+		layer.setStyle({fillOpacity: 0.4});
+		this.mapView.popInfo.update(this.selectedPopulation);
+	};
+
+	// Handles the deselection of zones
+	this.deselectZone = function(feature, layer) {
+
+		feature.properties.selected = false;
+		delete this.selectedFeatures[feature.properties.zone];
+		delete this.selectedZones[feature.properties.zone];
+		this.selectedPopulation -= parseInt(feature.properties.population, 10);
+		layer.setStyle({fillOpacity: 0.0});
+		this.mapView.popInfo.update(this.selectedPopulation);
+	};
+
+	// Deselects all sones on the map
+	this.deselectAllZones = function() {
+
+		this.pendingZones = {};
+		this.selectionBoundary = null;
+
+		for (var zoneCode in this.selectedFeatures) {
+
+			var feature = this.selectedFeatures[zoneCode];
+			var layer = this.selectedZones[zoneCode];
+			this.deselectZone(feature, layer)
+		}
+
+		this.refreshZoneTiles();
+	};
+
+	/* Shows the zone boundaries as vector tiles, with the selected zones and 
+	the zones waiting to be selected filled, or hides them when the zones 
+	of each district are on the map. */
+	this.setZoneTilesVisible = function(visible) {
+
+		var selected = {},
+			zoneCode;
+
+		if (!visible) return this.mapView.hideZoneTiles();
+
+		for (zoneCode in this.selectedZones) selected[zoneCode] = true;
+		for (zoneCode in this.pendingZones) selected[zoneCode] = true;
+
+		this.mapView.showZoneTiles(selected);
+	};
+
+	// Redraws the zone tiles if they are shown, after the selection changes
+	this.refreshZoneTiles = function() {
+
+		if (this.mapView.map.hasLayer(this.mapView.zoneTiles)) {
+
+			this.setZoneTilesVisible(true);
+		}
+	};
+
+	// Sets the overlay state control setting to active
+	this.activateOverlayControl = function() {
+
+		if (this.overlayControlActive == false) {
+
+			this.overlayControlActive = true;
+			this.mapView.addOverlayControl();
+			this.setOverlayState(this.currentOverlayState);
+		}
+	};
+
+	// Sets the overlay state control setting to inactive
+	this.deactivateOverlayControl = function() {
+
+		if (this.overlayControlActive == true) {
+
+			this.overlayControlActive = false;
+			this.mapView.removeOverlayControl();
+		}
+	};
+
+	// Sets the overlay state. Must be a valid overlay state.
+	this.setOverlayState = function(overlayState) {
+
+		this.currentOverlayState = overlayState;
+		var nextOverlayState = this.overlayStates[overlayState];
+		
+		this.mapView.overlayControl.update(
+			nextOverlayState, this.highlightedZoneCode);
+	};
+
+	// Sets the displayed zone code.
+	this.setZoneCode = function(zoneCode) {
+
+		var overlayState = this.currentOverlayState;
+		var nextOverlayState = this.overlayStates[overlayState];
+		this.mapView.overlayControl.update(nextOverlayState, zoneCode);
+	};
+
+	// Sets the current zone 
+	this.setCurrentZone = function(feature, layer) {
+
+		this.setZoneCode(feature.properties.zone);
+
+		if (this.highlightedZone === null) {
+
+			this.setHighlightedZone(feature, layer);
+		} 
+	};
+
+	// Resets the current zone
+	this.clearCurrentZone = function() {
+
+		if (this.highlightedZone !== null) {
+			
+			this.highlightedZone.setStyle({color: '#A000A0', weight: 2});
+			this.highlightedZone = null;
+			this.highlightedZoneCode = '';
+		}
+		
+		this.setZoneCode('');
+	};
+
+	// Sets the highlighted zone
+	this.setHighlightedZone = function(feature, layer) {	
+
+		if (this.highlightedZone !== null) {
+
+			if (this.highlightedZone === layer) {
+
+				this.clearCurrentZone();
+				return;
+			
+			} else {
+
+				this.highlightedZone.setStyle({color: '#A000A0', weight: 2});
+				this.clearCurrentZone();
+				this.highlightedZone = null;
+				this.highlightedZoneCode = '';
+			}
+		}
+
+		layer.setStyle({color: '#FF0080', weight: 8});
+		this.highlightedZone = layer;
+		this.highlightedZoneCode = feature.properties.zone;
+		this.setCurrentZone(feature, layer);
+	};
+};
+
+/* Constructor for the MapController object, a singleton that handles user 
+events and updates the MapModel accordingly. */
+pb.MapController = function(mapModel) {
+
+	this.mapModel = mapModel;
+
+	// Event handler called every time the user moves or zooms the map
+	this.updateMap = function(mapBounds, newZoomLevel) {
+		
+		pb.boundarySearch.updateBoundaryData(mapBounds);
+		var districtsInView = pb.boundarySearch.districtsInView;
+
+		// Check whether the overlay control should be active at this zoom
+		if (newZoomLevel > this.mapModel.overlayZoomLevel && 
+			districtsInView.length > 0) {
+
+			// If so, activate the overlay control
+			mapModel.activateOverlayControl();
+		
+			// And decide whether to show overlays for the districts in view
+			switch (this.mapModel.currentOverlayState) {
+
+				// Auto
+				case 0: 
+
+					if (newZoomLevel > this.mapModel.minimumZoom) {
+
+						this.mapModel.setDistrictsInView(districtsInView);
+						this.mapModel.setZoneTilesVisible(false);
+					
+					} else {
+
+						this.clearMap();
+						this.mapModel.setZoneTilesVisible(true);
+					}
+
+					break;
+
+				// On
+				case 1: 
+
+					this.mapModel.setDistrictsInView(districtsInView);
+					this.mapModel.setZoneTilesVisible(false);
+					break;
+
+				// Off
+				case 2: 
+
+					this.clearMap();
+					this.mapModel.setZoneTilesVisible(false);
+					break;
+			}
+
+		} else {
+
+			// If not, clear the map and deactivate the overlay control, 
+			// showing the zones as tiles instead
+			this.clearMap();
+			mapModel.deactivateOverlayControl();
+			this.mapModel.setZoneTilesVisible(true);
+		}
+
+		this.mapModel.mapBounds = mapBounds;
+		this.mapModel.zoomLevel = newZoomLevel;
+	};
+
+	// Clears overlays from map and the current zone code
+	this.clearMap = function() {
+
+		this.mapModel.setDistrictsInView([]);
+		this.mapModel.clearCurrentZone();
+	};
+
+	// Switches to the next overlay state, called by the overlay control
+	this.changeOverlaySetting = function() {
+
+		var overlayState = this.mapModel.currentOverlayState + 1;
+
+		if (overlayState > this.mapModel.overlayStates.length - 1) {
+
+			overlayState = 0;
+		} 
+
+		this.mapModel.setOverlayState(overlayState);
+		this.updateMap(this.mapModel.mapBounds, this.mapModel.zoomLevel);
+	};
+
+	// Clears the selected areas
+	this.deselectAll = function() {
+
+		this.mapModel.deselectAllZones();
+		this.mapModel.clearCurrentZone();
+	}
+
+	/* Reloads a saved selection. The zones are selected as the layers for 
+	their districts load, and the map is moved to show the districts. */
+	this.loadSelection = function(selectionId) {
+
+		var mapModel = this.mapModel,
+			jsonPath = '/s/' + encodeURIComponent(selectionId) + '.json';
+
+		var mapController = this;
+
+		d3.json(jsonPath, function(error, selection) {
+
+			// Stop and log an error if the json does not return
+			if (error) return console.warn(error);
+
+			mapController.selectZones(selection.zones, selection.districts);
+		});
+	};
+
+	/* Selects the given zones in the given districts. The zones are selected 
+	as the layers for their districts load, and the map is moved to show the 
+	districts unless keepView is true. */
+	this.selectZones = function(zones, districts, keepView) {
+
+		var mapModel = this.mapModel,
+			bounds = null,
+			districtBounds;
+
+		for (var i = 0; i < zones.length; i++) {
+
+			mapModel.pendingZones[zones[i]] = true;
+		}
+
+		for (var j = 0; j < districts.length; j++) {
+
+			districtBounds = pb.boundarySearch.getDistrictBounds(districts[j]);
+
+			if (districtBounds !== null) {
+
+				if (bounds === null) {
+
+					bounds = L.latLngBounds(districtBounds);
+				
+				} else {
+
+					bounds.extend(L.latLngBounds(districtBounds));
+				}
+			}
+
+			mapModel.addDistrictToMap(districts[j]);
+		}
+
+		mapModel.refreshZoneTiles();
+
+		if (bounds !== null && !keepView) {
+
+			mapModel.mapView.map.fitBounds(bounds);
+		}
+	};
+
+	// Shows the saved selections if the user is logged in
+	this.checkAccount = function() {
+
+		var mapController = this;
+
+		pb.requestJSON('GET', '/api/v1/account', null, function(error, account) {
+
+			if (error) return;
+
+			mapController.username = account.username;
+			mapController.listSavedSelections('');
+		});
+	};
+
+	// Logs in with the username and password in the account control
+	this.login = function() {
+
+		this.sendLogin('login');
+	};
+
+	// Registers with the username and password in the account control
+	this.register = function() {
+
+		this.sendLogin('register');
+	};
+
+	// Sends the username and password to log in or register
+	this.sendLogin = function(action) {
+
+		var mapController = this,
+			accountControl = this.mapModel.mapView.accountControl,
+			body = {
+				username: document.getElementById('pb-username').value,
+				password: document.getElementById('pb-password').value
+			};
+
+		pb.requestJSON('POST', '/api/v1/account/' + action, body, 
+			function(error, account) {
+
+			if (error) return accountControl.showLogin(error.message);
+
+			mapController.username = account.username;
+			mapController.listSavedSelections('');
+		});
+	};
+
+	// Logs out and shows the login form
+	this.logout = function() {
+
+		var accountControl = this.mapModel.mapView.accountControl;
+
+		pb.requestJSON('POST', '/api/v1/account/logout', null, function() {
+
+			accountControl.showLogin('');
+		});
+	};
+
+	// Shows the saved selections with an optional message
+	this.listSavedSelections = function(message) {
+
+		var mapController = this,
+			accountControl = this.mapModel.mapView.accountControl;
+
+		pb.requestJSON('GET', '/api/v1/selections', null, 
+			function(error, selections) {
+
+			if (error) return mapController.showAccountError(error);
+
+			accountControl.showSelections(mapController.username, 
+				selections, message);
+		});
+	};
+
+	// Saves the selected zones under a new name
+	this.saveSelection = function() {
+
+		var zones = Object.keys(this.mapModel.selectedZones);
+
+		if (zones.length === 0) {
+
+			return this.listSavedSelections('Select some areas to save.');
+		}
+
+		var name = window.prompt('Name for the selection');
+
+		if (name) {
+
+			this.sendSavedSelection('POST', '/api/v1/selections', 
+				{name: name, zones: zones}, 'Saved ' + name + '.');
+		}
+	};
+
+	// Replaces the zones of a saved selection with the selected zones
+	this.updateSavedSelection = function(id) {
+
+		var zones = Object.keys(this.mapModel.selectedZones);
+
+		if (zones.length === 0) {
+
+			return this.listSavedSelections('Select some areas to save.');
+		}
+
+		this.sendSavedSelection('PUT', '/api/v1/selections/' + id, 
+			{zones: zones}, 'Updated the selection.');
+	};
+
+	// Renames a saved selection
+	this.renameSavedSelection = function(id) {
+
+		var name = window.prompt('New name for the selection');
+
+		if (name) {
+
+			this.sendSavedSelection('PUT', '/api/v1/selections/' + id, 
+				{name: name}, 'Renamed the selection.');
+		}
+	};
+
+	// Deletes a saved selection
+	this.deleteSavedSelection = function(id) {
+
+		if (window.confirm('Delete this selection?')) {
+
+			this.sendSavedSelection('DELETE', '/api/v1/selections/' + id, 
+				null, 'Deleted the selection.');
+		}
+	};
+
+	// Sends a change to the saved selections and lists them again
+	this.sendSavedSelection = function(method, path, body, message) {
+
+		var mapController = this;
+
+		pb.requestJSON(method, path, body, function(error) {
+
+			if (error) return mapController.showAccountError(error);
+
+			mapController.listSavedSelections(message);
+		});
+	};
+
+	// Replaces the selected zones with a saved selection
+	this.loadSavedSelection = function(id) {
+
+		var mapController = this;
+
+		pb.requestJSON('GET', '/api/v1/selections/' + id, null, 
+			function(error, selection) {
+
+			if (error) return mapController.showAccountError(error);
+
+			mapController.deselectAll();
+			mapController.selectZones(selection.zones, selection.districts);
+		});
+	};
+
+	// Shows an error from the saved selections, or the login form if the 
+	// session has ended
+	this.showAccountError = function(error) {
+
+		var accountControl = this.mapModel.mapView.accountControl;
+
+		if (error.status === 401) {
+
+			accountControl.showLogin(error.message);
+
+		} else {
+
+			this.listSavedSelections(error.message);
+		}
+	};
+
+	/* Sets the path and rule of the upload form for the chosen file. Boundary 
+	files are sent with the rule chosen in the boundary settings. */
+	this.prepareUpload = function(form) {
+
+		var isBoundary = /\.(geojson|json|kml|zip|shp)$/i.test(
+			form.elements['file'].value);
+
+		form.action = isBoundary ? '/upload/boundary' : '/upload';
+		form.elements['rule'].value = isBoundary ? 
+			this.mapModel.mapView.boundaryControl.getRule() : '';
+
+		return isBoundary;
+	};
+
+	// Sends the uploaded file of zone codes or boundary to the results page
+	this.uploadResults = function() {
+
+		var form = document.getElementById('pb-upload');
+
+		if (form.elements['file'].value === '') {
+
+			return this.mapModel.mapView.uploadControl.update(
+				'Choose a file of zone codes or a boundary to upload.');
+		}
+
+		this.prepareUpload(form);
+		form.elements['target'].value = '';
+		form.submit();
+	};
+
+	// Selects the zones in the uploaded file of zone codes on the map
+	this.uploadToMap = function() {
+
+		var mapController = this,
+			uploadControl = this.mapModel.mapView.uploadControl,
+			form = document.getElementById('pb-upload');
+
+		if (form.elements['file'].value === '') {
+
+			return uploadControl.update(
+				'Choose a file of zone codes or a boundary to upload.');
+		}
+
+		var isBoundary = this.prepareUpload(form);
+		form.elements['target'].value = 'map';
+
+		pb.requestJSON('POST', form.action, new FormData(form), 
+			function(error, upload) {
+
+			if (error) return uploadControl.update(error.message);
+
+			if (isBoundary) return mapController.showBoundary(upload);
+
+			var report = upload.report,
+				skipped = report.unknown.length + report.malformed.length;
+
+			mapController.deselectAll();
+			mapController.selectZones(upload.zones, upload.districts);
+
+			uploadControl.update('Selected ' + 
+				pb.numberWithCommas(upload.zones.length) + ' zones.' + 
+				(skipped > 0 ? ' ' + pb.numberWithCommas(skipped) + 
+				' codes were not found.' : ''));
+		});
+	};
+
+	/* Lets the user draw a circle on the map by clicking at its centre and 
+	dragging to its edge, then selects the zones within it. The mouse events 
+	are captured on the map container so that they do not reach the zones. */
+	this.drawRadius = function() {
+
+		var mapController = this,
+			mapView = this.mapModel.mapView,
+			map = mapView.map,
+			container = map.getContainer(),
+			radiusControl = mapView.radiusControl,
+			centre = null,
+			radius = 0;
+
+		mapView.removeRadiusCircle();
+		map.dragging.disable();
+		radiusControl.update('Click the centre of the circle and drag ' + 
+			'to its edge.');
+
+		var onMouseDown = function(e) {
+
+			L.DomEvent.stop(e);
+			centre = map.mouseEventToLatLng(e);
+			mapView.radiusCircle = L.circle(centre, 0, {
+				color: '#A000A0', 
+				weight: 2, 
+				fillOpacity: 0.1, 
+				clickable: false}).addTo(map);
+		};
+
+		var onMouseMove = function(e) {
+
+			if (centre === null) return;
+
+			L.DomEvent.stop(e);
+			radius = centre.distanceTo(map.mouseEventToLatLng(e));
+			mapView.radiusCircle.setRadius(radius);
+			radiusControl.update('Radius: ' + 
+				(radius / 1000).toFixed(2) + 'km');
+		};
 
-						layer.on('mouseout', function(e) {
+		var onMouseUp = function(e) {
 
-							mapModel.clearCurrentZone();
-						});
+			if (centre === null) return;
 
-						layer.on('contextmenu', function(e) {
+			L.DomEvent.stop(e);
+			container.removeEventListener('mousedown', onMouseDown, true);
+			container.removeEventListener('mousemove', onMouseMove, true);
+			container.removeEventListener('mouseup', onMouseUp, true);
+			map.dragging.enable();
 
-							mapModel.setHighlightedZone(feature, e.target);
-						});
-					}
-				});
-				
-				mapModel.districtsLoaded[districtCode] = districtLayer;
+			// Stop the click that follows from selecting the zone beneath
+			container.addEventListener('click', onClick, true);
+			mapController.selectRadius(centre, radius / 1000);
+		};
 
-				if (mapModel.districtsInView.indexOf(districtCode) != -1) {
+		var onClick = function(e) {
 
-					mapModel.districtsOnMap[districtCode] = districtLayer;
-					mapView.addDistrictLayer(districtLayer);
-				}
-			}
+			L.DomEvent.stop(e);
+			container.removeEventListener('click', onClick, true);
 		};
 
-		// Only add the layer if it is not already on the map
-		if (!this.districtsOnMap.hasOwnProperty(districtCode)) {
+		container.addEventListener('mousedown', onMouseDown, true);
+		container.addEventListener('mousemove', onMouseMove, true);
+		container.addEventListener('mouseup', onMouseUp, true);
+	};
 
-			// If the layer has already been loaded then add it
-			if (this.districtsLoaded.hasOwnProperty(districtCode)) {
+	// Selects the zones within the given radius in km of the centre
+	this.selectRadius = function(centre, radius) {
 
-				districtLayer = this.districtsLoaded[districtCode];
-				this.districtsOnMap[districtCode] = districtLayer;
-				mapView.addDistrictLayer(districtLayer);
+		var mapController = this,
+			radiusControl = this.mapModel.mapView.radiusControl,
+			path = '/api/v1/radius?lat=' + centre.lat + '&lon=' + centre.lng + 
+				'&radius=' + radius + '&share=' + radiusControl.getShare();
 
-			// Otherwise load the layer then add it with a callback
-			} else {
+		if (radius <= 0) {
 
-				var jsonPath = '/resources/popzones/' + districtCode + '.json';
-				d3.json(jsonPath, downloadDistrict);
-			}
+			this.mapModel.mapView.removeRadiusCircle();
+			return radiusControl.update('Drag from the centre to draw a circle.');
 		}
-	};
 
-	// Handles removing layers from the map and tracking their state
-	this.removeDistrictFromMap = function(districtCode) {
+		pb.requestJSON('GET', path, null, function(error, result) {
 
-		var districtsLoaded = this.districtLoaded,
-			districtsOnMap = this.districtsOnMap,
-			districtLayer;
+			if (error) return radiusControl.update(error.message);
 
-		// Only remove the layer if it is already on the map
-		if (districtsOnMap.hasOwnProperty(districtCode)) {
+			mapController.deselectAll();
+			mapController.selectZones(result.zones, result.districts, true);
 
-			districtLayer = districtsOnMap[districtCode];
-			this.mapView.removeDistrictLayer(districtLayer);
-			delete districtsOnMap[districtCode];
-		}
+			radiusControl.update('Selected ' + 
+				pb.numberWithCommas(result.zones.length) + ' zones with ' + 
+				pb.numberWithCommas(result.population) + ' people within ' + 
+				radius.toFixed(2) + 'km.');
+		});
 	};
 
-	// Handles the selection of zones
-	this.selectZone = function(feature, layer) {
-
-		feature.properties.selected = true;
-		this.selectedFeatures[feature.properties.zone] = feature;
-		this.selectedZones[feature.properties.zone] = layer;
-		this.selectedPopulation += parseInt(feature.properties.population, 10);
-		layer.setStyle({fillOpacity: 0.4});
-		this.mapView.popInfo.update(this.selectedPopulation);
-	};
+	/* Lets the user draw a polygon on the map by clicking at each of its 
+	corners and double clicking at the last, then selects the zones within 
+	it. The mouse events are captured on the map container so that they do 
+	not reach the zones. */
+	this.drawBoundary = function() {
+
+		var mapController = this,
+			mapView = this.mapModel.mapView,
+			map = mapView.map,
+			container = map.getContainer(),
+			boundaryControl = mapView.boundaryControl,
+			corners = [];
+
+		mapView.removeBoundaryPolygon();
+		map.doubleClickZoom.disable();
+		boundaryControl.update('Click each corner of the boundary and ' + 
+			'double click the last.');
+
+		mapView.boundaryPolygon = L.polygon([], {
+			color: '#A000A0', 
+			weight: 2, 
+			fillOpacity: 0.1, 
+			clickable: false}).addTo(map);
+
+		var onClick = function(e) {
+
+			L.DomEvent.stop(e);
+			var corner = map.mouseEventToLatLng(e);
+
+			// Ignore the repeated click of a double click
+			if (corners.length > 0 && 
+				corners[corners.length - 1].equals(corner)) return;
+
+			corners.push(corner);
+			mapView.boundaryPolygon.setLatLngs(corners);
+		};
 
-	// Handles the deselection of zones
-	this.deselectZone = function(feature, layer) {
+		var onMouseMove = function(e) {
 
-		feature.properties.selected = false;
-		delete this.selectedFeatures[feature.properties.zone];
-		delete this.selectedZones[feature.properties.zone];
-		this.selectedPopulation -= parseInt(feature.properties.population, 10);
-		layer.setStyle({fillOpacity: 0.0});
-		this.mapView.popInfo.update(this.selectedPopulation);
-	};
+			if (corners.length === 0) return;
 
-	// Deselects all sones on the map
-	this.deselectAllZones = function() {
+			L.DomEvent.stop(e);
+			mapView.boundaryPolygon.setLatLngs(
+				corners.concat([map.mouseEventToLatLng(e)]));
+		};
 
-		for (var zoneCode in this.selectedFeatures) {
+		var onDoubleClick = function(e) {
 
-			var feature = this.selectedFeatures[zoneCode];
-			var layer = this.selectedZones[zoneCode];
-			this.deselectZone(feature, layer)
-		}
-	};
+			L.DomEvent.stop(e);
 
-	// Sets the overlay state control setting to active
-	this.activateOverlayControl = function() {
+			if (corners.length < 3) return;
 
-		if (this.overlayControlActive == false) {
+			container.removeEventListener('click', onClick, true);
+			container.removeEventListener('mousemove', onMouseMove, true);
+			container.removeEventListener('dblclick', onDoubleClick, true);
+			mapView.boundaryPolygon.setLatLngs(corners);
+			map.doubleClickZoom.enable();
+			mapController.selectBoundary(corners);
+		};
 
-			this.overlayControlActive = true;
-			this.mapView.addOverlayControl();
-			this.setOverlayState(this.currentOverlayState);
-		}
+		container.addEventListener('click', onClick, true);
+		container.addEventListener('mousemove', onMouseMove, true);
+		container.addEventListener('dblclick', onDoubleClick, true);
 	};
 
-	// Sets the overlay state control setting to inactive
-	this.deactivateOverlayControl = function() {
+	// Selects the zones within the polygon with the given corners
+	this.selectBoundary = function(corners) {
 
-		if (this.overlayControlActive == true) {
+		var mapController = this,
+			mapModel = this.mapModel,
+			boundaryControl = mapModel.mapView.boundaryControl,
+			ring = [];
 
-			this.overlayControlActive = false;
-			this.mapView.removeOverlayControl();
+		for (var i = 0; i < corners.length; i++) {
+
+			ring.push([corners[i].lng, corners[i].lat]);
 		}
-	};
 
-	// Sets the overlay state. Must be a valid overlay state.
-	this.setOverlayState = function(overlayState) {
+		ring.push(ring[0]);
 
-		this.currentOverlayState = overlayState;
-		var nextOverlayState = this.overlayStates[overlayState];
-		
-		this.mapView.overlayControl.update(
-			nextOverlayState, this.highlightedZoneCode);
-	};
+		var request = {
+			boundary: {type: 'Polygon', coordinates: [ring]}, 
+			rule: boundaryControl.getRule()};
 
-	// Sets the displayed zone code.
-	this.setZoneCode = function(zoneCode) {
+		pb.requestJSON('POST', '/api/v1/boundary', request, 
+			function(error, result) {
 
-		var overlayState = this.currentOverlayState;
-		var nextOverlayState = this.overlayStates[overlayState];
-		this.mapView.overlayControl.update(nextOverlayState, zoneCode);
-	};
+			if (error) return boundaryControl.update(error.message);
 
-	// Sets the current zone 
-	this.setCurrentZone = function(feature, layer) {
+			mapController.deselectAll();
+			mapController.selectZones(result.zones, result.districts, true);
 
-		this.setZoneCode(feature.properties.zone);
+			// Keep the boundary to show its apportioned population with the 
+			// results
+			if (result.rule === 'apportion') {
 
-		if (this.highlightedZone === null) {
+				mapModel.selectionBoundary = request;
 
-			this.setHighlightedZone(feature, layer);
-		} 
+				return boundaryControl.update('About ' + 
+					pb.numberWithCommas(Math.round(result.estimate)) + 
+					' people live inside the boundary, in parts of ' + 
+					pb.numberWithCommas(result.zones.length) + ' zones.');
+			}
+
+			boundaryControl.update('Selected ' + 
+				pb.numberWithCommas(result.zones.length) + ' zones with ' + 
+				pb.numberWithCommas(result.population) + ' people.');
+		});
 	};
 
-	// Resets the current zone
-	this.clearCurrentZone = function() {
+	// Draws an uploaded boundary on the map and selects its zones
+	this.showBoundary = function(upload) {
 
-		if (this.highlightedZone !== null) {
-			
-			this.highlightedZone.setStyle({color: '#A000A0', weight: 2});
-			this.highlightedZone = null;
-			this.highlightedZoneCode = '';
-		}
-		
-		this.setZoneCode('');
-	};
+		var mapView = this.mapModel.mapView;
 
-	// Sets the highlighted zone
-	this.setHighlightedZone = function(feature, layer) {	
+		this.deselectAll();
+		mapView.removeBoundaryPolygon();
 
-		if (this.highlightedZone !== null) {
+		mapView.boundaryPolygon = L.geoJson(upload.boundary, {
+			color: '#A000A0', 
+			weight: 2, 
+			fillOpacity: 0.1, 
+			clickable: false}).addTo(mapView.map);
 
-			if (this.highlightedZone === layer) {
+		this.selectZones(upload.zones, upload.districts, true);
+		mapView.map.fitBounds(mapView.boundaryPolygon.getBounds());
 
-				this.clearCurrentZone();
-				return;
-			
-			} else {
+		// Keep the boundary to show its apportioned population with the 
+		// results
+		if (upload.rule === 'apportion') {
 
-				this.highlightedZone.setStyle({color: '#A000A0', weight: 2});
-				this.clearCurrentZone();
-				this.highlightedZone = null;
-				this.highlightedZoneCode = '';
-			}
+			this.mapModel.selectionBoundary = {
+				boundary: upload.boundary, 
+				rule: upload.rule};
 		}
 
-		layer.setStyle({color: '#FF0080', weight: 8});
-		this.highlightedZone = layer;
-		this.highlightedZoneCode = feature.properties.zone;
-		this.setCurrentZone(feature, layer);
+		mapView.uploadControl.update('Selected ' + 
+			pb.numberWithCommas(upload.zones.length) + 
+			' zones in the boundary.');
 	};
-};
 
-/* Constructor for the MapController object, a singleton that handles user 
-events and updates the MapModel accordingly. */
-pb.MapController = function(mapModel) {
+	/* Finds the postcode in the search box. A full postcode is marked on 
+	the map and its zone is added to the selection, while the map is fitted 
+	to the postcodes that start with a partial postcode. */
+	this.findPostcode = function() {
 
-	this.mapModel = mapModel;
+		var mapController = this,
+			mapView = this.mapModel.mapView,
+			postcodeControl = mapView.postcodeControl,
+			postcode = postcodeControl.getPostcode().trim(),
+			path = '/api/v1/postcode?postcode=' + encodeURIComponent(postcode);
 
-	// Event handler called every time the user moves or zooms the map
-	this.updateMap = function(mapBounds, newZoomLevel) {
-		
-		pb.boundarySearch.updateBoundaryData(mapBounds);
-		var districtsInView = pb.boundarySearch.districtsInView;
+		if (postcode === '') {
 
-		// Check whether the overlay control should be active at this zoom
-		if (newZoomLevel > this.mapModel.overlayZoomLevel && 
-			districtsInView.length > 0) {
+			return postcodeControl.update('Enter a postcode to find.');
+		}
 
-			// If so, activate the overlay control
-			mapModel.activateOverlayControl();
-		
-			// And decide whether to show overlays for the districts in view
-			switch (this.mapModel.currentOverlayState) {
+		pb.requestJSON('GET', path, null, function(error, result) {
 
-				// Auto
-				case 0: 
+			if (error) return postcodeControl.update(error.message);
 
-					if (newZoomLevel > this.mapModel.minimumZoom) {
+			var located = [];
 
-						this.mapModel.setDistrictsInView(districtsInView);
-					
-					} else {
+			for (var i = 0; i < result.postcodes.length; i++) {
 
-						this.clearMap();
-					}
+				if (result.postcodes[i].lat !== null) {
 
-					break;
+					located.push([result.postcodes[i].lat, 
+						result.postcodes[i].lon]);
+				}
+			}
 
-				// On
-				case 1: 
+			mapView.removePostcodeMarker();
 
-					this.mapModel.setDistrictsInView(districtsInView);
-					break;
+			// Select the zone of a single postcode and mark it on the map
+			if (result.postcodes.length === 1) {
 
-				// Off
-				case 2: 
+				mapController.selectZones(result.zones, result.districts, 
+					located.length > 0);
 
-					this.clearMap();
-					break;
-			}
+				if (located.length > 0) {
 
-		} else {
+					mapView.postcodeMarker = L.circleMarker(located[0], {
+						color: '#A000A0', 
+						weight: 2, 
+						radius: 6, 
+						clickable: false}).addTo(mapView.map);
 
-			// If not, clear the map and deactivate the overlay control
-			this.clearMap();
-			mapModel.deactivateOverlayControl();
-		}
+					mapView.map.setView(located[0], 16);
+				}
 
-		this.mapModel.mapBounds = mapBounds;
-		this.mapModel.zoomLevel = newZoomLevel;
-	};
+				return postcodeControl.update('Selected zone ' + 
+					result.zones[0] + ' for ' + 
+					result.postcodes[0].postcode + '.');
+			}
 
-	// Clears overlays from map and the current zone code
-	this.clearMap = function() {
+			if (located.length > 0) {
 
-		this.mapModel.setDistrictsInView([]);
-		this.mapModel.clearCurrentZone();
+				mapView.map.fitBounds(L.latLngBounds(located));
+			}
+
+			postcodeControl.update('Showing ' + 
+				(result.more ? 'the first ' : '') + 
+				pb.numberWithCommas(result.postcodes.length) + 
+				' postcodes starting ' + result.query + '.');
+		});
 	};
 
-	// Switches to the next overlay state, called by the overlay control
-	this.changeOverlaySetting = function() {
+	// Selects the zones containing the postcodes pasted into the list
+	this.selectPostcodes = function() {
 
-		var overlayState = this.mapModel.currentOverlayState + 1;
+		var mapController = this,
+			mapView = this.mapModel.mapView,
+			postcodeControl = mapView.postcodeControl,
+			postcodes = postcodeControl.getPostcodeList();
 
-		if (overlayState > this.mapModel.overlayStates.length - 1) {
+		if (postcodes.length === 0) {
 
-			overlayState = 0;
-		} 
+			return postcodeControl.update('Paste a list of postcodes to ' + 
+				'select their zones.');
+		}
 
-		this.mapModel.setOverlayState(overlayState);
-		this.updateMap(this.mapModel.mapBounds, this.mapModel.zoomLevel);
-	};
+		pb.requestJSON('POST', '/api/v1/postcode', {postcodes: postcodes}, 
+			function(error, result) {
 
-	// Clears the selected areas
-	this.deselectAll = function() {
+			if (error) return postcodeControl.update(error.message);
 
-		this.mapModel.deselectAllZones();
-		this.mapModel.clearCurrentZone();
-	}
+			mapView.removePostcodeMarker();
+			mapController.deselectAll();
+			mapController.selectZones(result.zones, result.districts);
+
+			postcodeControl.update('Selected ' + 
+				pb.numberWithCommas(result.zones.length) + ' zones for ' + 
+				pb.numberWithCommas(postcodes.length - 
+					result.unknown.length) + ' postcodes.' + 
+				(result.unknown.length > 0 ? ' ' + 
+				pb.numberWithCommas(result.unknown.length) + 
+				' postcodes were not found.' : ''));
+		});
+	};
 
 	// Sends the selected areas to the results page
 	this.getResults = function() {
@@ -520,6 +1928,15 @@ pb.MapController = function(mapModel) {
 		var zoneCodeString = selectedZoneCodes.join(',');
 		var postParameters = {zones: zoneCodeString};
 		var resultsPage = 'results';
+		var selectionBoundary = this.mapModel.selectionBoundary;
+
+		// Send the boundary to show its apportioned population
+		if (selectionBoundary !== null) {
+
+			postParameters.boundary = JSON.stringify(selectionBoundary.boundary);
+			postParameters.rule = selectionBoundary.rule;
+		}
+
 		pb.submitForm(resultsPage, postParameters);
 	};
 };
@@ -557,6 +1974,17 @@ pb.run = function() {
 
 	mapController.updateMap(widerBounds, map.getZoom());
 
+	// Show the saved selections if the user is logged in
+	mapController.checkAccount();
+
+	// Reload a saved selection if one is given in the url
+	var selectionId = pb.queryParameter('s');
+
+	if (selectionId !== '') {
+
+		mapController.loadSelection(selectionId);
+	}
+
 	// Handle map movement
 	map.on('moveend', function(e) {
 
@@ -595,6 +2023,97 @@ pb.numberWithCommas = function(num) {
 	return parts.join(".");
 };
 
+// Utility function: Get a parameter from the query string
+pb.queryParameter = function(name) {
+
+	var pairs = window.location.search.substring(1).split('&'),
+		pair;
+
+	for (var i = 0; i < pairs.length; i++) {
+
+		pair = pairs[i].split('=');
+
+		if (decodeURIComponent(pair[0]) === name && pair.length > 1) {
+
+			return decodeURIComponent(pair[1].replace(/\+/g, ' '));
+		}
+	}
+
+	return '';
+};
+
+// Utility function: Escape text for use in html
+pb.escapeHTML = function(text) {
+
+	return String(text).replace(/&/g, '&amp;').replace(/</g, '&lt;')
+		.replace(/>/g, '&gt;').replace(/"/g, '&quot;')
+		.replace(/'/g, '&#39;');
+};
+
+// Utility function: Format a message for a control, if there is one
+pb.messageHTML = function(message) {
+
+	return message ? '<p class="message">' + pb.escapeHTML(message) + 
+		'</p>' : '';
+};
+
+/* Utility function: Send a request with an optional JSON or FormData body. 
+The callback is given an error with a status and message if the request 
+fails, and the decoded response otherwise. */
+pb.requestJSON = function(method, path, body, callback) {
+
+	var request = new XMLHttpRequest();
+	request.open(method, path);
+
+	request.onload = function() {
+
+		var response = null;
+
+		try {
+
+			response = request.responseText ? 
+				JSON.parse(request.responseText) : null;
+
+		} catch (e) {
+
+			response = null;
+		}
+
+		if (request.status >= 200 && request.status < 300) {
+
+			callback(null, response);
+
+		} else if (response !== null && response.error) {
+
+			callback(response.error);
+
+		} else {
+
+			callback({status: request.status, message: 'Sorry! An error ' + 
+				'has occurred.'});
+		}
+	};
+
+	request.onerror = function() {
+
+		callback({status: 0, message: 'Could not reach the server.'});
+	};
+
+	if (body instanceof FormData) {
+
+		request.send(body);
+
+	} else if (body !== null) {
+
+		request.setRequestHeader('Content-Type', 'application/json');
+		request.send(JSON.stringify(body));
+
+	} else {
+
+		request.send();
+	}
+};
+
 // Utility function: Submit a post request
 pb.submitForm = function(path, params, method) {
 
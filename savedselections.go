@@ -0,0 +1,409 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSelectionName is the greatest length of the name of a saved selection.
+const maxSelectionName = 100
+
+// Define the errors returned for saved selections, whose messages are shown
+// to the user
+var (
+	errSavedSelectionNotFound = errors.New("The saved selection could not " +
+		"be found.")
+	errSelectionNameInvalid = errors.New("Selection names must be 1 to 100 " +
+		"characters.")
+	errSelectionNameTaken = errors.New("You already have a selection with " +
+		"that name.")
+	errSelectionZonesInvalid = errors.New("Selections must contain at least " +
+		"one valid zone code.")
+)
+
+// SavedSelection holds a named selection of zones saved by a user. Districts
+// holds the districts containing the zones, which the map uses to reload
+// them, and is only set when a single selection is requested.
+type SavedSelection struct {
+	ID        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Zones     []string `json:"zones"`
+	Districts []string `json:"districts,omitempty"`
+	Created   int64    `json:"created"`
+	Updated   int64    `json:"updated"`
+}
+
+// savedSelectionRequest is the expected shape of a JSON request body sent to
+// create or update a saved selection. When updating, an empty Name or a nil
+// list of Zones leaves that value unchanged.
+type savedSelectionRequest struct {
+	Name  string   `json:"name"`
+	Zones []string `json:"zones"`
+}
+
+// ListSelections returns the selections saved by the account, in order of
+// name. The zones of each selection are included.
+func (a *AccountDb) ListSelections(account *Account) ([]*SavedSelection,
+	error) {
+
+	rows, err := a.db.Query("SELECT id, name, zones, created, updated "+
+		"FROM saved_selections WHERE user_id = ? ORDER BY name COLLATE "+
+		"NOCASE", account.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	selections := []*SavedSelection{}
+
+	for rows.Next() {
+
+		selection, err := scanSavedSelection(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		selections = append(selections, selection)
+	}
+
+	return selections, rows.Err()
+}
+
+// GetSelection returns the selection with the given ID saved by the account,
+// or errSavedSelectionNotFound if there is none.
+func (a *AccountDb) GetSelection(account *Account,
+	id int64) (*SavedSelection, error) {
+
+	row := a.db.QueryRow("SELECT id, name, zones, created, updated "+
+		"FROM saved_selections WHERE user_id = ? AND id = ?", account.ID, id)
+
+	selection, err := scanSavedSelection(row)
+
+	if err == sql.ErrNoRows {
+		return nil, errSavedSelectionNotFound
+	}
+
+	return selection, err
+}
+
+// CreateSelection saves a new selection with the given name and zones for
+// the account.
+func (a *AccountDb) CreateSelection(account *Account, name string,
+	zones []string) (*SavedSelection, error) {
+
+	name, zonestr, err := checkSavedSelection(name, zones)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	result, err := a.db.Exec("INSERT INTO saved_selections (user_id, name, "+
+		"zones, created, updated) SELECT ?, ?, ?, ?, ? WHERE NOT EXISTS ("+
+		"SELECT 1 FROM saved_selections WHERE user_id = ? AND name = ?)",
+		account.ID, name, zonestr, now, now, account.ID, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if count, err := result.RowsAffected(); err != nil || count == 0 {
+		return nil, errSelectionNameTaken
+	}
+
+	id, err := result.LastInsertId()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return a.GetSelection(account, id)
+}
+
+// UpdateSelection renames the selection with the given ID saved by the
+// account, or replaces its zones, or both. An empty name or a nil list of
+// zones leaves that value unchanged.
+func (a *AccountDb) UpdateSelection(account *Account, id int64, name string,
+	zones []string) (*SavedSelection, error) {
+
+	selection, err := a.GetSelection(account, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = selection.Name
+	}
+
+	if zones == nil {
+		zones = selection.Zones
+	}
+
+	name, zonestr, err := checkSavedSelection(name, zones)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the new name is not used by another of the account's selections
+	var count int
+	err = a.db.QueryRow("SELECT count(*) FROM saved_selections WHERE "+
+		"user_id = ? AND name = ? AND id != ?", account.ID, name,
+		id).Scan(&count)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, errSelectionNameTaken
+	}
+
+	_, err = a.db.Exec("UPDATE saved_selections SET name = ?, zones = ?, "+
+		"updated = ? WHERE user_id = ? AND id = ?", name, zonestr,
+		time.Now().Unix(), account.ID, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return a.GetSelection(account, id)
+}
+
+// DeleteSelection deletes the selection with the given ID saved by the
+// account.
+func (a *AccountDb) DeleteSelection(account *Account, id int64) error {
+
+	result, err := a.db.Exec("DELETE FROM saved_selections WHERE "+
+		"user_id = ? AND id = ?", account.ID, id)
+
+	if err != nil {
+		return err
+	}
+
+	if count, err := result.RowsAffected(); err != nil || count == 0 {
+		return errSavedSelectionNotFound
+	}
+
+	return nil
+}
+
+// scanSavedSelection scans a row of the saved_selections table.
+func scanSavedSelection(row interface {
+	Scan(dest ...interface{}) error
+}) (*SavedSelection, error) {
+
+	selection := &SavedSelection{}
+	var zonestr string
+
+	err := row.Scan(&selection.ID, &selection.Name, &zonestr,
+		&selection.Created, &selection.Updated)
+
+	if err != nil {
+		return nil, err
+	}
+
+	selection.Zones = strings.Split(zonestr, ",")
+	return selection, nil
+}
+
+// checkSavedSelection returns the trimmed name and the normalised list of
+// zones for a saved selection, or an error if either is not valid.
+func checkSavedSelection(name string, zones []string) (string, string,
+	error) {
+
+	name = strings.TrimSpace(name)
+
+	if name == "" || len([]rune(name)) > maxSelectionName {
+		return "", "", errSelectionNameInvalid
+	}
+
+	codes := canonicalZones(zones)
+
+	if len(codes) == 0 {
+		return "", "", errSelectionZonesInvalid
+	}
+
+	for _, code := range codes {
+
+		if !zonePattern.MatchString(code) {
+			return "", "", errSelectionZonesInvalid
+		}
+	}
+
+	return name, strings.Join(codes, ","), nil
+}
+
+// SavedSelectionsHandler handles requests to list, create, read, update and
+// delete the selections saved by the user that is logged in.
+type SavedSelectionsHandler struct {
+	accounts  *AccountDb
+	geography *Geography
+	prefix    string
+}
+
+// NewSavedSelectionsHandler returns a new SavedSelectionsHandler with the
+// values initialised. Requests are expected at the given path prefix. The
+// geography is used to find the districts containing the zones of a
+// selection, and may be nil.
+func NewSavedSelectionsHandler(prefix string, accounts *AccountDb,
+	geography *Geography) *SavedSelectionsHandler {
+
+	return &SavedSelectionsHandler{
+		accounts:  accounts,
+		geography: geography,
+		prefix:    prefix,
+	}
+}
+
+// ServeHTTP lists the user's saved selections for a GET request at the
+// prefix, and creates a selection from a JSON body holding a name and a list
+// of zones for a POST request at the prefix. A GET request at {prefix}/{id}
+// returns the selection with the districts containing its zones, a PUT
+// request renames it or replaces its zones, and a DELETE request deletes
+// it. The user must be logged in. Responses and errors are sent as JSON.
+func (h *SavedSelectionsHandler) ServeHTTP(w http.ResponseWriter,
+	r *http.Request) {
+
+	account, err := h.accounts.requestAccount(r)
+
+	if err != nil {
+
+		serveAccountError(w, err)
+		return
+	}
+
+	idstr := strings.Trim(strings.TrimPrefix(r.URL.Path, h.prefix), "/")
+
+	// Serve requests for the list of selections
+	if idstr == "" {
+
+		switch r.Method {
+		case "GET":
+
+			selections, err := h.accounts.ListSelections(account)
+
+			if err != nil {
+
+				serveAccountError(w, err)
+				return
+			}
+
+			writeJSON(w, http.StatusOK, selections)
+
+		case "POST":
+
+			body, ok := h.parseBody(w, r)
+
+			if !ok {
+				return
+			}
+
+			selection, err := h.accounts.CreateSelection(account, body.Name,
+				body.Zones)
+
+			if err != nil {
+
+				serveAccountError(w, err)
+				return
+			}
+
+			writeJSON(w, http.StatusCreated, selection)
+
+		default:
+
+			w.Header().Set("Allow", "GET, POST")
+			writeAPIError(w, http.StatusMethodNotAllowed,
+				"That method is not supported.")
+		}
+
+		return
+	}
+
+	// Serve requests for a single selection
+	id, err := strconv.ParseInt(idstr, 10, 64)
+
+	if err != nil {
+
+		serveAccountError(w, errSavedSelectionNotFound)
+		return
+	}
+
+	var selection *SavedSelection
+
+	switch r.Method {
+	case "GET":
+
+		selection, err = h.accounts.GetSelection(account, id)
+
+	case "PUT":
+
+		body, ok := h.parseBody(w, r)
+
+		if !ok {
+			return
+		}
+
+		selection, err = h.accounts.UpdateSelection(account, id, body.Name,
+			body.Zones)
+
+	case "DELETE":
+
+		err = h.accounts.DeleteSelection(account, id)
+
+		if err != nil {
+
+			serveAccountError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeAPIError(w, http.StatusMethodNotAllowed,
+			"That method is not supported.")
+
+		return
+	}
+
+	if err != nil {
+
+		serveAccountError(w, err)
+		return
+	}
+
+	selection.Districts = selectionDistricts(selection.Zones, h.geography)
+	writeJSON(w, http.StatusOK, selection)
+}
+
+// parseBody decodes the JSON body of a request to create or update a saved
+// selection. If it cannot be decoded an error is written and ok is false.
+func (h *SavedSelectionsHandler) parseBody(w http.ResponseWriter,
+	r *http.Request) (*savedSelectionRequest, bool) {
+
+	body := &savedSelectionRequest{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body,
+		maxRequestBody)).Decode(body)
+
+	if err != nil {
+
+		writeAPIError(w, http.StatusBadRequest,
+			"Could not parse the request: invalid JSON body.")
+
+		return nil, false
+	}
+
+	return body, true
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"github.com/olihawkins/handlers"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selectionIDLength is the shortest length of a selection ID. IDs are only
+// made longer if the short form is already used by another selection.
+const selectionIDLength = 8
+
+// selectionIDPattern matches a valid selection ID.
+var selectionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// errSelectionNotFound is returned when there is no selection with an ID.
+var errSelectionNotFound = errors.New("selection not found")
+
+// Selection is a saved selection of zones. If the zones were selected with a
+// boundary, the boundary and the rule used to select them are saved too, so
+// that the population can be apportioned again.
+type Selection struct {
+	Zones    []string
+	Boundary string
+	Rule     string
+}
+
+// SelectionDb encapsulates the sqlite database of saved selections, which
+// gives each selection a short and stable ID for use in permalinks.
+type SelectionDb struct {
+	db   *sql.DB
+	lock sync.Mutex
+}
+
+// NewSelectionDb returns a new SelectionDb with the database initialised.
+// The database and its table are created if they do not exist.
+func NewSelectionDb(dbPath string) *SelectionDb {
+
+	// Create a database handle
+	dbHandle, err := sql.Open("sqlite3", dbPath)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the selections table
+	_, err = dbHandle.Exec("CREATE TABLE IF NOT EXISTS selections (" +
+		"id TEXT PRIMARY KEY, zones TEXT NOT NULL, " +
+		"boundary TEXT NOT NULL, rule TEXT NOT NULL, " +
+		"created INTEGER NOT NULL, UNIQUE (zones, boundary, rule))")
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return &SelectionDb{db: dbHandle}
+}
+
+// Close closes the database handle held by the SelectionDb.
+func (s *SelectionDb) Close() {
+
+	s.db.Close()
+}
+
+// Save stores the given selection and returns its ID. The codes are
+// normalised, so the same set of codes in any order, with the same boundary
+// and rule, always has the same ID.
+func (s *SelectionDb) Save(selection *Selection) (string, error) {
+
+	codes := canonicalZones(selection.Zones)
+
+	if len(codes) == 0 {
+		return "", errors.New("the selection has no zones")
+	}
+
+	zonestr := strings.Join(codes, ",")
+	boundary := strings.TrimSpace(selection.Boundary)
+	rule := ""
+
+	if boundary != "" {
+		rule = selection.Rule
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Return the ID if the selection has already been saved
+	var id string
+	err := s.db.QueryRow("SELECT id FROM selections WHERE zones = ? AND "+
+		"boundary = ? AND rule = ?", zonestr, boundary, rule).Scan(&id)
+
+	if err == nil {
+		return id, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	// Otherwise use the shortest prefix of the hash that is not taken
+	key := zonestr
+
+	if boundary != "" {
+		key += "\n" + rule + "\n" + boundary
+	}
+
+	sum := sha1.Sum([]byte(key))
+	hash := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	for length := selectionIDLength; length <= len(hash); length++ {
+
+		id = hash[:length]
+		var count int
+		err = s.db.QueryRow("SELECT count(*) FROM selections WHERE id = ?",
+			id).Scan(&count)
+
+		if err != nil {
+			return "", err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		_, err = s.db.Exec("INSERT INTO selections (id, zones, boundary, "+
+			"rule, created) VALUES (?, ?, ?, ?, ?)", id, zonestr, boundary,
+			rule, time.Now().Unix())
+
+		if err != nil {
+			return "", err
+		}
+
+		return id, nil
+	}
+
+	return "", errors.New("could not find an unused selection ID")
+}
+
+// Load returns the selection with the given ID, or errSelectionNotFound if
+// there is no such selection.
+func (s *SelectionDb) Load(id string) (*Selection, error) {
+
+	if !selectionIDPattern.MatchString(id) {
+		return nil, errSelectionNotFound
+	}
+
+	var zonestr string
+	selection := &Selection{}
+	err := s.db.QueryRow("SELECT zones, boundary, rule FROM selections "+
+		"WHERE id = ?", id).Scan(&zonestr, &selection.Boundary,
+		&selection.Rule)
+
+	if err == sql.ErrNoRows {
+		return nil, errSelectionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	selection.Zones = strings.Split(zonestr, ",")
+	return selection, nil
+}
+
+// canonicalZones returns the unique non-empty codes in upper case and in
+// sorted order.
+func canonicalZones(zones []string) []string {
+
+	codes := []string{}
+	seen := map[string]bool{}
+
+	for _, zone := range zones {
+
+		code := strings.ToUpper(strings.TrimSpace(zone))
+
+		if code != "" && !seen[code] {
+
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+
+	sort.Strings(codes)
+	return codes
+}
+
+// SelectionMap holds the zones of a saved selection, and the districts
+// containing them, so that the map can reload the selection.
+type SelectionMap struct {
+	ID        string   `json:"id"`
+	Zones     []string `json:"zones"`
+	Districts []string `json:"districts"`
+}
+
+// SelectionHandler handles requests for saved selections.
+type SelectionHandler struct {
+	selections      *SelectionDb
+	results         *ResultsHandler
+	notFoundHandler *handlers.NotFoundHandler
+	prefix          string
+}
+
+// NewSelectionHandler returns a new SelectionHandler with the values
+// initialised. Requests are expected at the given path prefix.
+func NewSelectionHandler(prefix string, selections *SelectionDb,
+	results *ResultsHandler,
+	notFoundHandler *handlers.NotFoundHandler) *SelectionHandler {
+
+	return &SelectionHandler{
+		selections:      selections,
+		results:         results,
+		notFoundHandler: notFoundHandler,
+		prefix:          prefix,
+	}
+}
+
+// ServeHTTP serves the results page for the selection whose ID follows the
+// path prefix, as in /s/{id}. Selections made with a boundary are shown by
+// selecting the zones with the boundary again. Other results parameters
+// such as the year may be given in the query string. Requests for
+// /s/{id}.json are sent the zones of the selection and the districts
+// containing them, which the map uses to reload the selection.
+func (h *SelectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	id := strings.TrimPrefix(r.URL.Path, h.prefix)
+	asJSON := strings.HasSuffix(id, ".json")
+	id = strings.TrimSuffix(id, ".json")
+
+	selection, err := h.selections.Load(id)
+
+	if err == errSelectionNotFound {
+
+		h.notFoundHandler.ServeHTTP(w, r)
+		return
+	}
+
+	if err != nil {
+
+		h.results.errorHandler.ServeError(w,
+			"Could not get the selection from the SelectionDb.")
+
+		return
+	}
+
+	// Send the zones and districts for the map
+	if asJSON {
+
+		writeJSON(w, http.StatusOK, newSelectionMap(id, selection.Zones,
+			h.results.geography))
+
+		return
+	}
+
+	// Otherwise serve the results with the selection in the form
+	err = r.ParseForm()
+
+	if err != nil {
+
+		h.results.errorHandler.ServeError(w,
+			"Could not read the selection parameters.")
+
+		return
+	}
+
+	r.Form.Set(h.results.zoneForm, strings.Join(selection.Zones, ","))
+	r.Form.Set(h.results.boundaryForm, selection.Boundary)
+	r.Form.Set(h.results.ruleForm, selection.Rule)
+	h.results.serve(w, r, id)
+}
+
+// newSelectionMap returns the SelectionMap for the given zones, with the
+// codes of any countries, regions and districts expanded into their zones.
+func newSelectionMap(id string, zones []string,
+	geography *Geography) *SelectionMap {
+
+	zones, _ = geography.ExpandCodes(zones)
+	zones = canonicalZones(zones)
+
+	return &SelectionMap{
+		ID:        id,
+		Zones:     zones,
+		Districts: selectionDistricts(zones, geography),
+	}
+}
+
+// selectionDistricts returns the codes of the districts containing the given
+// zones in sorted order. A nil Geography finds no districts.
+func selectionDistricts(zones []string, geography *Geography) []string {
+
+	districts := []string{}
+
+	if geography == nil {
+		return districts
+	}
+
+	seen := map[string]bool{}
+
+	for _, zone := range zones {
+
+		district := geography.ZoneDistrict(zone)
+
+		if district != nil && !seen[district.Code] {
+
+			seen[district.Code] = true
+			districts = append(districts, district.Code)
+		}
+	}
+
+	sort.Strings(districts)
+	return districts
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"github.com/olihawkins/handlers"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test SelectionDb gives the same ID to the same zones in any order, and
+// different IDs to different zones or boundaries.
+func TestSelectionDb(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	sdb := NewSelectionDb(filepath.Join(dir, "selections.db"))
+	defer sdb.Close()
+
+	id, err := sdb.Save(&Selection{
+		Zones: []string{"E01000002", " e01000001", "E01000002"},
+	})
+
+	if err != nil || len(id) != selectionIDLength {
+		t.Fatalf("Expected an ID of %d characters from Save. Got: %q %v",
+			selectionIDLength, id, err)
+	}
+
+	sameID, _ := sdb.Save(&Selection{Zones: []string{"E01000001",
+		"E01000002"}})
+	otherID, _ := sdb.Save(&Selection{Zones: []string{"E01000001"}})
+
+	if sameID != id || otherID == id {
+		t.Errorf("Expected the same ID only for the same zones. Got: "+
+			"%q %q %q", id, sameID, otherID)
+	}
+
+	selection, err := sdb.Load(id)
+
+	if err != nil || strings.Join(selection.Zones, ",") !=
+		"E01000001,E01000002" || selection.Boundary != "" {
+
+		t.Errorf("Expected the saved zones from Load. Got: %v %v",
+			selection, err)
+	}
+
+	// The same zones selected with a boundary are a different selection
+	boundary := &Selection{
+		Zones:    []string{"E01000001", "E01000002"},
+		Boundary: `{"type": "Polygon"}`,
+		Rule:     ruleApportion,
+	}
+
+	boundaryID, _ := sdb.Save(boundary)
+	selection, err = sdb.Load(boundaryID)
+
+	if boundaryID == id || err != nil ||
+		selection.Boundary != boundary.Boundary ||
+		selection.Rule != ruleApportion {
+
+		t.Errorf("Expected a new ID for the boundary and the boundary from "+
+			"Load. Got: %q %v %v", boundaryID, selection, err)
+	}
+
+	for _, missing := range []string{"AAAAAAAA", "../a", ""} {
+
+		if _, err := sdb.Load(missing); err != errSelectionNotFound {
+			t.Errorf("Expected errSelectionNotFound for %q. Got: %v",
+				missing, err)
+		}
+	}
+
+	if _, err := sdb.Save(&Selection{Zones: []string{" ", ""}}); err == nil {
+		t.Errorf("Expected an error from Save with no zones.")
+	}
+}
+
+// Test the results page can be requested with GET, offers a permalink, that
+// requesting the permalink saves the zones that were found, and that the
+// permalink serves the results and the zones for the map.
+func TestSelectionHandler(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	codes := []string{"E01000001", "W01000001"}
+	buildTestDbs(t, dir, codes)
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	sdb := NewSelectionDb(filepath.Join(dir, "selections.db"))
+	defer sdb.Close()
+
+	errorHandler := handlers.LoadErrorHandler(errorPath, "", true)
+	notFoundHandler := handlers.LoadNotFoundHandler(notFoundPath)
+
+	rh := NewResultsHandler(resultsPath, rdb, nil, nil, errorHandler)
+	rh.UseSelections(sdb)
+	sh := NewSelectionHandler("/s/", sdb, rh, notFoundHandler)
+
+	// Request the results with the zones in the query string, which
+	// offers a permalink without saving the selection
+	request, _ := http.NewRequest("GET",
+		"/results?zones=W01000001,E01000001,E01999999", nil)
+	response := httptest.NewRecorder()
+
+	rh.ServeHTTP(response, request)
+
+	if !strings.Contains(response.Body.String(), "Create a permalink") ||
+		strings.Contains(response.Body.String(), `<a href="/s/`) {
+
+		t.Fatalf("Expected a permalink to be offered by ResultsHandler. "+
+			"Got: %s", response.Body.String())
+	}
+
+	// Request the permalink, which saves the zones that were found
+	request, _ = http.NewRequest("GET",
+		"/results?zones=W01000001,E01000001,E01999999&year=2020"+
+			"&permalink=true", nil)
+	response = httptest.NewRecorder()
+
+	rh.ServeHTTP(response, request)
+
+	id, _ := sdb.Save(&Selection{Zones: codes})
+	location := "/s/" + id + "?year=2020"
+
+	if response.Code != http.StatusSeeOther ||
+		response.Header().Get("Location") != location {
+
+		t.Fatalf("Expected a redirect to %s from ResultsHandler. Got: %d %s",
+			location, response.Code, response.Header().Get("Location"))
+	}
+
+	// Request the results from the permalink
+	request, _ = http.NewRequest("GET", location, nil)
+	response = httptest.NewRecorder()
+
+	sh.ServeHTTP(response, request)
+	permalink := `<a href="/s/` + id + `">`
+
+	if !strings.Contains(response.Body.String(), "E01000001,W01000001") ||
+		!strings.Contains(response.Body.String(), permalink) {
+
+		t.Errorf("Expected the saved zones and %q in body from "+
+			"SelectionHandler. Got: %s", permalink, response.Body.String())
+	}
+
+	// Request the zones for the map
+	request, _ = http.NewRequest("GET", "/s/"+id+".json", nil)
+	response = httptest.NewRecorder()
+
+	sh.ServeHTTP(response, request)
+	expected := `{"id":"` + id + `","zones":["E01000001","W01000001"],` +
+		`"districts":[]}`
+
+	if response.Body.String() != expected {
+		t.Errorf("Expected %s from SelectionHandler. Got: %s", expected,
+			response.Body.String())
+	}
+
+	// Request a selection that does not exist
+	request, _ = http.NewRequest("GET", "/s/missing", nil)
+	response = httptest.NewRecorder()
+
+	sh.ServeHTTP(response, request)
+
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for a missing selection. Got: %d",
+			http.StatusNotFound, response.Code)
+	}
+}
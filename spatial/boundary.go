@@ -0,0 +1,268 @@
+package spatial
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// maxBoundaryPoints is the largest number of points a Boundary can have.
+// Dividing a ring into triangles takes time that grows with the square of
+// its points.
+const maxBoundaryPoints = 10000
+
+// Boundary is an area drawn or uploaded by a user, such as a proposed
+// service boundary, prepared for measuring how much of each zone it covers.
+// Each of its rings is projected onto a plane and divided into triangles,
+// and a zone is clipped to each triangle in turn to find the overlap.
+type Boundary struct {
+	Geometry MultiPolygon
+	Bounds   Bounds
+	plane    *localPlane
+	outer    []Ring
+	holes    []Ring
+}
+
+// Overlap is a Feature that overlaps a Boundary, with the Share of its area
+// inside the Boundary from 0 to 1.
+type Overlap struct {
+	Feature *Feature
+	Share   float64
+}
+
+// NewBoundary returns a new Boundary for the geometry. An error is returned
+// if the geometry has no area, has more than maxBoundaryPoints points or a
+// ring crosses itself. The Polygons of the geometry should not overlap each
+// other.
+func NewBoundary(geometry MultiPolygon) (*Boundary, error) {
+
+	points := 0
+
+	for _, polygon := range geometry {
+
+		for _, ring := range polygon {
+			points += len(ring)
+		}
+	}
+
+	if points > maxBoundaryPoints {
+		return nil, errors.New("the boundary has more than " +
+			strconv.Itoa(maxBoundaryPoints) + " points")
+	}
+
+	bounds := geometry.Bounds()
+
+	if bounds.IsEmpty() || geometry.Area() <= 0 {
+		return nil, errors.New("the boundary has no area")
+	}
+
+	boundary := &Boundary{
+		Geometry: geometry,
+		Bounds:   bounds,
+		plane: newLocalPlane(Point{(bounds.Min.X + bounds.Max.X) / 2,
+			(bounds.Min.Y + bounds.Max.Y) / 2}),
+	}
+
+	for _, polygon := range boundary.plane.projectMulti(geometry) {
+
+		for i, ring := range polygon {
+
+			triangles, err := triangulate(ring)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if i == 0 {
+				boundary.outer = append(boundary.outer, triangles...)
+			} else {
+				boundary.holes = append(boundary.holes, triangles...)
+			}
+		}
+	}
+
+	return boundary, nil
+}
+
+// Contains returns true if the point is inside the Boundary.
+func (b *Boundary) Contains(p Point) bool {
+
+	return b.Bounds.Contains(p) && b.Geometry.Contains(p)
+}
+
+// AreaShare returns the share of the area of the geometry that is inside
+// the Boundary, from 0 to 1.
+func (b *Boundary) AreaShare(m MultiPolygon) float64 {
+
+	projected := b.plane.projectMulti(m)
+	total := projected.Area()
+
+	if total == 0 {
+		return 0
+	}
+
+	bounds := projected.Bounds()
+	overlap := 0.0
+
+	for _, triangle := range b.outer {
+
+		if triangle.Bounds().Intersects(bounds) {
+			overlap += clipMultiConvex(projected, triangle).Area()
+		}
+	}
+
+	for _, triangle := range b.holes {
+
+		if triangle.Bounds().Intersects(bounds) {
+			overlap -= clipMultiConvex(projected, triangle).Area()
+		}
+	}
+
+	return math.Max(0, math.Min(1, overlap/total))
+}
+
+// Overlapping returns the Features that overlap the Boundary with the share
+// of each that is inside it, in the order they were indexed. Features that
+// only touch the Boundary are not included.
+func (i *Index) Overlapping(b *Boundary) []Overlap {
+
+	results := []Overlap{}
+
+	for _, feature := range i.Search(b.Bounds) {
+
+		if share := b.AreaShare(feature.Geometry); share > 0 {
+			results = append(results, Overlap{feature, share})
+		}
+	}
+
+	return results
+}
+
+// triangulate divides a Ring into triangles whose points run anticlockwise,
+// by repeatedly clipping off a triangle at a convex corner that holds no
+// other point of the Ring. An error is returned if the Ring has fewer than
+// three distinct points or crosses itself.
+func triangulate(ring Ring) ([]Ring, error) {
+
+	// Remove repeated points, including the last point if it closes the Ring
+	points := Ring{}
+
+	for _, p := range ring {
+
+		if len(points) == 0 || p != points[len(points)-1] {
+			points = append(points, p)
+		}
+	}
+
+	if len(points) > 1 && points[0] == points[len(points)-1] {
+		points = points[:len(points)-1]
+	}
+
+	if len(points) < 3 {
+		return nil, errors.New("a ring of the boundary has fewer than " +
+			"three points")
+	}
+
+	area := points.signedArea()
+
+	if area < 0 {
+
+		for l, r := 0, len(points)-1; l < r; l, r = l+1, r-1 {
+			points[l], points[r] = points[r], points[l]
+		}
+
+		area = -area
+	}
+
+	remaining := make([]int, len(points))
+
+	for n := range remaining {
+		remaining[n] = n
+	}
+
+	triangles := []Ring{}
+	n, misses := 0, 0
+
+	for len(remaining) > 3 {
+
+		if misses > len(remaining) {
+			return nil, errors.New("a ring of the boundary crosses itself")
+		}
+
+		count := len(remaining)
+		n = n % count
+		a := points[remaining[(n+count-1)%count]]
+		b := points[remaining[n]]
+		c := points[remaining[(n+1)%count]]
+		turn := cross(a, b, c)
+
+		// Skip reflex corners, and drop points on a straight line, which
+		// enclose no area
+		if turn < 0 {
+
+			n++
+			misses++
+			continue
+		}
+
+		if turn > 0 {
+
+			for _, other := range remaining {
+
+				p := points[other]
+
+				if p != a && p != b && p != c && inTriangle(a, b, c, p) {
+
+					turn = -1
+					break
+				}
+			}
+
+			if turn < 0 {
+
+				n++
+				misses++
+				continue
+			}
+
+			triangles = append(triangles, Ring{a, b, c})
+		}
+
+		remaining = append(remaining[:n], remaining[n+1:]...)
+		misses = 0
+	}
+
+	last := Ring{points[remaining[0]], points[remaining[1]],
+		points[remaining[2]]}
+
+	if cross(last[0], last[1], last[2]) > 0 {
+		triangles = append(triangles, last)
+	}
+
+	// The triangles cover more or less than the Ring if it crosses itself
+	total := 0.0
+
+	for _, triangle := range triangles {
+		total += triangle.signedArea()
+	}
+
+	if math.Abs(total-area) > area*1e-6 {
+		return nil, errors.New("a ring of the boundary crosses itself")
+	}
+
+	return triangles, nil
+}
+
+// cross returns twice the signed area of the triangle abc, which is
+// positive if the points run anticlockwise.
+func cross(a Point, b Point, c Point) float64 {
+
+	return (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+}
+
+// inTriangle returns true if the point is inside or on the edge of the
+// triangle abc, whose points run anticlockwise.
+func inTriangle(a Point, b Point, c Point, p Point) bool {
+
+	return cross(a, b, p) >= 0 && cross(b, c, p) >= 0 && cross(c, a, p) >= 0
+}
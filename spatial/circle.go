@@ -0,0 +1,231 @@
+package spatial
+
+import (
+	"math"
+)
+
+// earthRadius is the mean radius of the Earth in kilometres.
+const earthRadius = 6371.0088
+
+// circleSegments is the number of sides of the polygon used to approximate
+// a Circle when measuring the share of an area inside it.
+const circleSegments = 128
+
+// Distance returns the great circle distance between two points in
+// kilometres.
+func Distance(a Point, b Point) float64 {
+
+	lat1 := a.Y * math.Pi / 180
+	lat2 := b.Y * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.X - a.X) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Circle is the area within a distance of a point, given by its Centre and
+// its Radius in kilometres.
+type Circle struct {
+	Centre Point
+	Radius float64
+}
+
+// Contains returns true if the point is within the Circle.
+func (c Circle) Contains(p Point) bool {
+
+	return Distance(c.Centre, p) <= c.Radius
+}
+
+// Bounds returns the Bounds of the Circle in degrees.
+func (c Circle) Bounds() Bounds {
+
+	dLat := c.Radius / earthRadius * 180 / math.Pi
+	cosLat := math.Cos(c.Centre.Y * math.Pi / 180)
+	dLon := 180.0
+
+	if cosLat > 1e-9 {
+		dLon = math.Min(180, dLat/cosLat)
+	}
+
+	return Bounds{
+		Min: Point{c.Centre.X - dLon, c.Centre.Y - dLat},
+		Max: Point{c.Centre.X + dLon, c.Centre.Y + dLat},
+	}
+}
+
+// AreaShare returns the share of the area of the geometry that is inside
+// the Circle, from 0 to 1. Areas are measured on a plane centred on the
+// Circle, which is accurate for circles and zones of the size of towns.
+func (c Circle) AreaShare(m MultiPolygon) float64 {
+
+	plane := newLocalPlane(c.Centre)
+	projected := plane.projectMulti(m)
+	total := projected.Area()
+
+	if total == 0 {
+		return 0
+	}
+
+	// Approximate the circle with a polygon of the same area
+	clip := make(Ring, circleSegments)
+	step := 2 * math.Pi / circleSegments
+	radius := c.Radius * math.Sqrt(step/math.Sin(step))
+
+	for i := range clip {
+
+		angle := float64(i) * step
+		clip[i] = Point{radius * math.Cos(angle), radius * math.Sin(angle)}
+	}
+
+	return math.Min(1, clipMultiConvex(projected, clip).Area()/total)
+}
+
+// localPlane projects points in degrees onto a plane in kilometres centred
+// on an origin, using an equirectangular projection.
+type localPlane struct {
+	origin Point
+	scaleX float64
+	scaleY float64
+}
+
+// newLocalPlane returns a localPlane centred on the origin.
+func newLocalPlane(origin Point) *localPlane {
+
+	scaleY := earthRadius * math.Pi / 180
+
+	return &localPlane{
+		origin: origin,
+		scaleX: scaleY * math.Cos(origin.Y*math.Pi/180),
+		scaleY: scaleY,
+	}
+}
+
+// project returns the position of a point on the plane.
+func (l *localPlane) project(p Point) Point {
+
+	return Point{(p.X - l.origin.X) * l.scaleX, (p.Y - l.origin.Y) * l.scaleY}
+}
+
+// projectMulti returns the MultiPolygon projected onto the plane.
+func (l *localPlane) projectMulti(m MultiPolygon) MultiPolygon {
+
+	return m.Transform(l.project)
+}
+
+// clipMultiConvex returns the parts of the MultiPolygon inside a convex
+// Ring, whose points must run anticlockwise. Each ring is clipped on its
+// own, so the result is only suitable for measuring area.
+func clipMultiConvex(m MultiPolygon, clip Ring) MultiPolygon {
+
+	result := MultiPolygon{}
+
+	for _, polygon := range m {
+
+		clipped := Polygon{}
+
+		for _, ring := range polygon {
+			clipped = append(clipped, clipRingConvex(ring, clip))
+		}
+
+		result = append(result, clipped)
+	}
+
+	return result
+}
+
+// clipRingConvex clips a Ring to a convex Ring whose points run
+// anticlockwise, using the Sutherland-Hodgman algorithm.
+func clipRingConvex(ring Ring, clip Ring) Ring {
+
+	output := ring
+
+	for i := range clip {
+
+		a := clip[i]
+		b := clip[(i+1)%len(clip)]
+		input := output
+		output = Ring{}
+
+		if len(input) == 0 {
+			break
+		}
+
+		prev := input[len(input)-1]
+
+		for _, p := range input {
+
+			pInside := isLeft(a, b, p)
+			prevInside := isLeft(a, b, prev)
+
+			if pInside {
+
+				if !prevInside {
+					output = append(output, intersection(prev, p, a, b))
+				}
+
+				output = append(output, p)
+
+			} else if prevInside {
+
+				output = append(output, intersection(prev, p, a, b))
+			}
+
+			prev = p
+		}
+	}
+
+	return output
+}
+
+// isLeft returns true if the point is on or to the left of the line from a
+// to b.
+func isLeft(a Point, b Point, p Point) bool {
+
+	return (b.X-a.X)*(p.Y-a.Y)-(b.Y-a.Y)*(p.X-a.X) >= 0
+}
+
+// intersection returns the point where the segment from p to q crosses the
+// line through a and b.
+func intersection(p Point, q Point, a Point, b Point) Point {
+
+	dx, dy := q.X-p.X, q.Y-p.Y
+	ex, ey := b.X-a.X, b.Y-a.Y
+	denominator := dx*ey - dy*ex
+
+	if denominator == 0 {
+		return p
+	}
+
+	t := ((a.X-p.X)*ey - (a.Y-p.Y)*ex) / denominator
+	return Point{p.X + t*dx, p.Y + t*dy}
+}
+
+// WithinCircle returns the Features within the Circle, in the order they
+// were indexed. If share is zero, a Feature is within the Circle if its
+// centroid is inside it. Otherwise a Feature is within the Circle if at
+// least the given share of its area, from 0 to 1, is inside it.
+func (i *Index) WithinCircle(c Circle, share float64) []*Feature {
+
+	results := []*Feature{}
+
+	for _, feature := range i.Search(c.Bounds()) {
+
+		if share <= 0 {
+
+			if c.Contains(feature.Centroid) {
+				results = append(results, feature)
+			}
+
+			continue
+		}
+
+		if c.AreaShare(feature.Geometry) >= share {
+			results = append(results, feature)
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,331 @@
+package spatial
+
+import (
+	"math"
+	"sort"
+)
+
+// edge is a directed line between two points of a Ring.
+type edge struct {
+	from Point
+	to   Point
+}
+
+// Dissolve returns the outline of a set of geometries that share edges,
+// such as neighbouring zones, as a single MultiPolygon. Each Ring is turned
+// to run anticlockwise, or clockwise for holes, so that an edge shared by
+// two geometries runs in opposite directions in each and both copies can be
+// removed. The edges that remain are joined into the outer Rings and holes
+// of the outline. Points are first rounded to the nearest multiple of the
+// precision, unless it is zero, and edges are split at any point that lies
+// along them, so that neighbours with different points along a shared edge
+// still share its pieces. Geometries that overlap may leave slivers in the
+// outline.
+func Dissolve(geometries []MultiPolygon, precision float64) MultiPolygon {
+
+	geometries = snapGeometries(geometries, precision)
+	all := []edge{}
+
+	for _, geometry := range geometries {
+
+		for _, polygon := range geometry {
+
+			for i, ring := range polygon {
+				all = append(all, ringEdges(ring, i == 0)...)
+			}
+		}
+	}
+
+	// Count the edges in each direction, keeping the order they were found
+	counts := map[edge]int{}
+	edges := []edge{}
+
+	for _, e := range splitEdges(all, precision/2) {
+
+		if counts[e] == 0 {
+			edges = append(edges, e)
+		}
+
+		counts[e]++
+	}
+
+	// Remove the edges that are matched by an edge in the other direction
+	for _, e := range edges {
+
+		reverse := edge{e.to, e.from}
+
+		if n := counts[reverse]; n > 0 && counts[e] > 0 {
+
+			shared := n
+
+			if counts[e] < shared {
+				shared = counts[e]
+			}
+
+			counts[e] -= shared
+			counts[reverse] -= shared
+		}
+	}
+
+	// List the remaining edges leaving each point
+	outgoing := map[Point][]Point{}
+	starts := []Point{}
+
+	for _, e := range edges {
+
+		for n := 0; n < counts[e]; n++ {
+
+			if len(outgoing[e.from]) == 0 {
+				starts = append(starts, e.from)
+			}
+
+			outgoing[e.from] = append(outgoing[e.from], e.to)
+		}
+	}
+
+	// Follow the edges from each point until they return to it
+	rings := []Ring{}
+
+	for _, start := range starts {
+
+		for len(outgoing[start]) > 0 {
+
+			ring := Ring{start}
+			current := start
+
+			for {
+
+				next := outgoing[current]
+
+				if len(next) == 0 {
+					break
+				}
+
+				outgoing[current] = next[1:]
+				current = next[0]
+				ring = append(ring, current)
+
+				if current == start {
+					break
+				}
+			}
+
+			if len(ring) > 3 && current == start {
+				rings = append(rings, ring)
+			}
+		}
+	}
+
+	return assembleRings(rings)
+}
+
+// ringEdges returns the edges of a Ring, turned to run anticlockwise if it
+// is an outer Ring and clockwise if it is a hole. Repeated points are
+// skipped.
+func ringEdges(ring Ring, outer bool) []edge {
+
+	edges := []edge{}
+
+	if len(ring) < 3 {
+		return edges
+	}
+
+	reverse := (ring.signedArea() > 0) != outer
+
+	for i := range ring {
+
+		from, to := ring[i], ring[(i+1)%len(ring)]
+
+		if from == to {
+			continue
+		}
+
+		if reverse {
+			from, to = to, from
+		}
+
+		edges = append(edges, edge{from, to})
+	}
+
+	return edges
+}
+
+// snapGeometries returns the MultiPolygons with their points rounded to the
+// nearest multiple of the precision, or as they are if the precision is
+// zero.
+func snapGeometries(geometries []MultiPolygon,
+	precision float64) []MultiPolygon {
+
+	if precision <= 0 {
+		return geometries
+	}
+
+	snapped := make([]MultiPolygon, len(geometries))
+	scale := 1 / precision
+
+	for i, geometry := range geometries {
+
+		snapped[i] = geometry.Transform(func(p Point) Point {
+			return Point{math.Round(p.X*scale) / scale,
+				math.Round(p.Y*scale) / scale}
+		})
+	}
+
+	return snapped
+}
+
+// splitEdges returns the edges split at each of their points that lies on
+// another edge, within the tolerance of it and between its ends.
+func splitEdges(edges []edge, tolerance float64) []edge {
+
+	// List each point once, in order of x
+	points := []Point{}
+	seen := map[Point]bool{}
+
+	for _, e := range edges {
+
+		for _, p := range []Point{e.from, e.to} {
+
+			if !seen[p] {
+
+				seen[p] = true
+				points = append(points, p)
+			}
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].X < points[j].X
+	})
+
+	// cut is a point on an edge, at its fraction of the way along
+	type cut struct {
+		along float64
+		point Point
+	}
+
+	split := []edge{}
+
+	for _, e := range edges {
+
+		dx, dy := e.to.X-e.from.X, e.to.Y-e.from.Y
+		length2 := dx*dx + dy*dy
+		minX := math.Min(e.from.X, e.to.X) - tolerance
+		maxX := math.Max(e.from.X, e.to.X) + tolerance
+		minY := math.Min(e.from.Y, e.to.Y) - tolerance
+		maxY := math.Max(e.from.Y, e.to.Y) + tolerance
+
+		// Find the points within the box around the edge that are close
+		// enough to the line through it
+		cuts := []cut{}
+		start := sort.Search(len(points), func(i int) bool {
+			return points[i].X >= minX
+		})
+
+		for _, p := range points[start:] {
+
+			if p.X > maxX {
+				break
+			}
+
+			if p.Y < minY || p.Y > maxY || p == e.from || p == e.to {
+				continue
+			}
+
+			cross := dx*(p.Y-e.from.Y) - dy*(p.X-e.from.X)
+
+			if cross*cross > tolerance*tolerance*length2 {
+				continue
+			}
+
+			along := (dx*(p.X-e.from.X) + dy*(p.Y-e.from.Y)) / length2
+
+			if along > 0 && along < 1 {
+				cuts = append(cuts, cut{along, p})
+			}
+		}
+
+		if len(cuts) == 0 {
+
+			split = append(split, e)
+			continue
+		}
+
+		sort.Slice(cuts, func(i, j int) bool {
+			return cuts[i].along < cuts[j].along
+		})
+
+		from := e.from
+
+		for _, c := range cuts {
+
+			if c.point != from {
+				split = append(split, edge{from, c.point})
+			}
+
+			from = c.point
+		}
+
+		split = append(split, edge{from, e.to})
+	}
+
+	return split
+}
+
+// assembleRings returns the Polygons made from a set of Rings, where the
+// Rings that run anticlockwise are outer Rings and those that run clockwise
+// are holes. Each hole is added to the smallest outer Ring containing it,
+// and holes outside every outer Ring are left out.
+func assembleRings(rings []Ring) MultiPolygon {
+
+	multi := MultiPolygon{}
+	areas := []float64{}
+
+	for _, ring := range rings {
+
+		if area := ring.signedArea(); area > 0 {
+
+			multi = append(multi, Polygon{ring})
+			areas = append(areas, area)
+		}
+	}
+
+	for _, ring := range rings {
+
+		if ring.signedArea() >= 0 {
+			continue
+		}
+
+		// Test a point inside the hole near its first edge, as its points
+		// may touch the outer Ring
+		inside := -1
+		p := holePoint(ring)
+
+		for i, polygon := range multi {
+
+			if polygon[0].Contains(p) &&
+				(inside == -1 || areas[i] < areas[inside]) {
+
+				inside = i
+			}
+		}
+
+		if inside != -1 {
+			multi[inside] = append(multi[inside], ring)
+		}
+	}
+
+	return multi
+}
+
+// holePoint returns a point just inside a hole that runs clockwise, to the
+// right of the middle of its first edge.
+func holePoint(ring Ring) Point {
+
+	a, b := ring[0], ring[1]
+	mid := Point{(a.X + b.X) / 2, (a.Y + b.Y) / 2}
+	dx, dy := b.X-a.X, b.Y-a.Y
+
+	// The right of an edge of a clockwise Ring is inside it
+	return Point{mid.X + dy*1e-6, mid.Y - dx*1e-6}
+}
@@ -0,0 +1,487 @@
+package spatial
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature is a population zone with its boundary.
+type Feature struct {
+	Zone       string
+	District   string
+	Population int64
+	Geometry   MultiPolygon
+	Bounds     Bounds
+	Centroid   Point
+}
+
+// NewFeature returns a new Feature with its Bounds and Centroid set from
+// the geometry.
+func NewFeature(zone string, district string, population int64,
+	geometry MultiPolygon) *Feature {
+
+	return &Feature{
+		Zone:       zone,
+		District:   district,
+		Population: population,
+		Geometry:   geometry,
+		Bounds:     geometry.Bounds(),
+		Centroid:   geometry.Centroid(),
+	}
+}
+
+// geoJSONGeometry is a GeoJSON geometry whose coordinates are decoded
+// according to its type.
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// geoJSONCollection is the structure of a popzones file.
+type geoJSONCollection struct {
+	Features []struct {
+		Properties struct {
+			Zone       string          `json:"zone"`
+			Population json.RawMessage `json:"population"`
+		} `json:"properties"`
+		Geometry geoJSONGeometry `json:"geometry"`
+	} `json:"features"`
+}
+
+// ReadFeatures reads the zones in a popzones GeoJSON file, which holds a
+// FeatureCollection of Polygons and MultiPolygons with the zone code and
+// population of each zone in its properties. The features are given the
+// district code.
+func ReadFeatures(r io.Reader, district string) ([]*Feature, error) {
+
+	var collection geoJSONCollection
+	err := json.NewDecoder(r).Decode(&collection)
+
+	if err != nil {
+		return nil, err
+	}
+
+	features := []*Feature{}
+
+	for _, f := range collection.Features {
+
+		geometry, err := decodeGeometry(f.Geometry)
+
+		if err != nil {
+			return nil, errors.New("zone " + f.Properties.Zone + ": " +
+				err.Error())
+		}
+
+		// The population may be a number or a string holding a number
+		population, _ := strconv.ParseInt(strings.Trim(
+			string(f.Properties.Population), `"`), 10, 64)
+
+		features = append(features, NewFeature(f.Properties.Zone, district,
+			population, geometry))
+	}
+
+	return features, nil
+}
+
+// LoadFeatures reads the zones in every popzones file in a directory. Each
+// file is named after the code of the district containing its zones. The
+// features are returned in order of district and then as in each file.
+func LoadFeatures(dir string) ([]*Feature, error) {
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+	features := []*Feature{}
+
+	for _, path := range paths {
+
+		district := strings.TrimSuffix(filepath.Base(path), ".json")
+		fileFeatures, err := readFeaturesFile(path, district)
+
+		if err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+
+		features = append(features, fileFeatures...)
+	}
+
+	return features, nil
+}
+
+// readFeaturesFile reads the zones in the popzones file at the given path.
+func readFeaturesFile(path string, district string) ([]*Feature, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ReadFeatures(f, district)
+}
+
+// decodeGeometry returns the MultiPolygon for a GeoJSON Polygon or
+// MultiPolygon.
+func decodeGeometry(g geoJSONGeometry) (MultiPolygon, error) {
+
+	switch g.Type {
+	case "Polygon":
+
+		var coordinates [][][]float64
+		err := json.Unmarshal(g.Coordinates, &coordinates)
+
+		if err != nil {
+			return nil, err
+		}
+
+		polygon, err := toPolygon(coordinates)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return MultiPolygon{polygon}, nil
+
+	case "MultiPolygon":
+
+		var coordinates [][][][]float64
+		err := json.Unmarshal(g.Coordinates, &coordinates)
+
+		if err != nil {
+			return nil, err
+		}
+
+		multi := MultiPolygon{}
+
+		for _, c := range coordinates {
+
+			polygon, err := toPolygon(c)
+
+			if err != nil {
+				return nil, err
+			}
+
+			multi = append(multi, polygon)
+		}
+
+		return multi, nil
+	}
+
+	return nil, errors.New("unsupported geometry type " + g.Type)
+}
+
+// toPolygon returns the Polygon for the coordinates of a GeoJSON Polygon.
+func toPolygon(coordinates [][][]float64) (Polygon, error) {
+
+	polygon := Polygon{}
+
+	for _, c := range coordinates {
+
+		ring := make(Ring, 0, len(c))
+
+		for _, position := range c {
+
+			if len(position) < 2 {
+				return nil, errors.New("invalid position")
+			}
+
+			ring = append(ring, Point{position[0], position[1]})
+		}
+
+		polygon = append(polygon, ring)
+	}
+
+	return polygon, nil
+}
+
+// ParseGeometry returns the MultiPolygon for a GeoJSON object holding a
+// Polygon or MultiPolygon, a Feature with one of these geometries, or a
+// FeatureCollection of such Features, whose polygons are combined. The
+// coordinates are returned as WGS84 longitude and latitude. Coordinates on
+// the British National Grid are converted, if the object names EPSG:27700
+// as its crs or if the coordinates are only valid on the grid. Other
+// coordinate reference systems are not supported.
+func ParseGeometry(data []byte) (MultiPolygon, error) {
+
+	var object struct {
+		CRS *struct {
+			Properties struct {
+				Name string `json:"name"`
+			} `json:"properties"`
+		} `json:"crs"`
+	}
+
+	json.Unmarshal(data, &object)
+	geometry, err := parseGeoJSON(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	crs := ""
+
+	if object.CRS != nil {
+		crs = object.CRS.Properties.Name
+	}
+
+	transform, err := lonLatTransform(crs, geometry.Bounds())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if transform != nil {
+		return geometry.Transform(transform), nil
+	}
+
+	return geometry, nil
+}
+
+// lonLatTransform returns the function that converts points in the named
+// coordinate reference system, which lie within the Bounds, to WGS84
+// longitude and latitude, or nil if they are already longitude and
+// latitude. Points on the British National Grid are converted if the crs
+// is EPSG:27700 and they are within the extent of the grid, or if it is
+// not named and the points are only valid on the grid. Coordinates that are
+// not finite are an error.
+func lonLatTransform(crs string, bounds Bounds) (func(Point) Point, error) {
+
+	if !bounds.IsEmpty() && !isFinite(bounds) {
+		return nil, errors.New("the coordinates are not finite numbers")
+	}
+
+	switch {
+	case strings.HasSuffix(crs, ":27700"):
+
+		if !bounds.IsEmpty() && !isGrid(bounds) {
+			return nil, errors.New("the coordinates are outside the " +
+				"British National Grid")
+		}
+
+		return FromBritishNationalGrid, nil
+
+	case crs == "" || strings.HasSuffix(crs, ":4326") ||
+		strings.HasSuffix(crs, ":CRS84"):
+
+		if bounds.IsEmpty() || isLonLat(bounds) {
+			return nil, nil
+		}
+
+		if crs == "" && isGrid(bounds) {
+			return FromBritishNationalGrid, nil
+		}
+
+		return nil, errors.New("the coordinates are not longitude and " +
+			"latitude")
+	}
+
+	return nil, errors.New("unsupported coordinate reference system " + crs)
+}
+
+// Record is a geometry read from a boundary file, with its attributes.
+type Record struct {
+	Attributes map[string]string
+	Geometry   MultiPolygon
+}
+
+// ReadRecords returns a Record for each Feature in a GeoJSON
+// FeatureCollection with a Polygon or MultiPolygon geometry, with the
+// properties of the Feature as its attributes. Properties that are not
+// strings are kept as they are written in the JSON. Other Features are
+// skipped. Coordinates are converted to longitude and latitude as in
+// ParseGeometry.
+func ReadRecords(data []byte) ([]Record, error) {
+
+	var collection struct {
+		Type string `json:"type"`
+		CRS  *struct {
+			Properties struct {
+				Name string `json:"name"`
+			} `json:"properties"`
+		} `json:"crs"`
+		Features []struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+			Geometry   *geoJSONGeometry           `json:"geometry"`
+		} `json:"features"`
+	}
+
+	err := json.Unmarshal(data, &collection)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if collection.Type != "FeatureCollection" {
+		return nil, errors.New("the file is not a FeatureCollection")
+	}
+
+	records := []Record{}
+	bounds := emptyBounds()
+
+	for _, feature := range collection.Features {
+
+		if feature.Geometry == nil || (feature.Geometry.Type != "Polygon" &&
+			feature.Geometry.Type != "MultiPolygon") {
+
+			continue
+		}
+
+		geometry, err := decodeGeometry(*feature.Geometry)
+
+		if err != nil {
+			return nil, err
+		}
+
+		attributes := map[string]string{}
+
+		for name, value := range feature.Properties {
+
+			var text string
+
+			if json.Unmarshal(value, &text) != nil {
+				text = string(value)
+			}
+
+			attributes[name] = text
+		}
+
+		records = append(records, Record{attributes, geometry})
+		bounds = bounds.Extend(geometry.Bounds())
+	}
+
+	crs := ""
+
+	if collection.CRS != nil {
+		crs = collection.CRS.Properties.Name
+	}
+
+	transform, err := lonLatTransform(crs, bounds)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if transform != nil {
+
+		for i := range records {
+			records[i].Geometry = records[i].Geometry.Transform(transform)
+		}
+	}
+
+	return records, nil
+}
+
+// parseGeoJSON returns the MultiPolygon for a GeoJSON object without
+// converting its coordinates.
+func parseGeoJSON(data []byte) (MultiPolygon, error) {
+
+	var object struct {
+		geoJSONGeometry
+		Geometry json.RawMessage   `json:"geometry"`
+		Features []json.RawMessage `json:"features"`
+	}
+
+	err := json.Unmarshal(data, &object)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch object.Type {
+	case "Feature":
+
+		return parseGeoJSON(object.Geometry)
+
+	case "FeatureCollection":
+
+		multi := MultiPolygon{}
+
+		for _, feature := range object.Features {
+
+			geometry, err := parseGeoJSON(feature)
+
+			if err != nil {
+				return nil, err
+			}
+
+			multi = append(multi, geometry...)
+		}
+
+		return multi, nil
+	}
+
+	return decodeGeometry(object.geoJSONGeometry)
+}
+
+// isLonLat returns true if the Bounds are within the range of longitude and
+// latitude.
+func isLonLat(b Bounds) bool {
+
+	return b.Min.X >= -180 && b.Max.X <= 180 && b.Min.Y >= -90 && b.Max.Y <= 90
+}
+
+// isFinite returns true if the Bounds are finite numbers, which they are
+// only if every point within them is.
+func isFinite(b Bounds) bool {
+
+	for _, v := range []float64{b.Min.X, b.Min.Y, b.Max.X, b.Max.Y} {
+
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isGrid returns true if the Bounds are within the extent of the British
+// National Grid.
+func isGrid(b Bounds) bool {
+
+	return b.Min.X >= -100000 && b.Max.X <= 800000 && b.Min.Y >= -100000 &&
+		b.Max.Y <= 1400000
+}
+
+// MarshalJSON encodes the MultiPolygon as a GeoJSON MultiPolygon geometry,
+// closing any Rings whose last point does not repeat the first.
+func (m MultiPolygon) MarshalJSON() ([]byte, error) {
+
+	coordinates := make([][][][2]float64, len(m))
+
+	for i, polygon := range m {
+
+		coordinates[i] = make([][][2]float64, len(polygon))
+
+		for j, ring := range polygon {
+
+			positions := make([][2]float64, 0, len(ring)+1)
+
+			for _, p := range ring {
+				positions = append(positions, [2]float64{p.X, p.Y})
+			}
+
+			if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+				positions = append(positions, [2]float64{ring[0].X, ring[0].Y})
+			}
+
+			coordinates[i][j] = positions
+		}
+	}
+
+	return json.Marshal(&struct {
+		Type        string           `json:"type"`
+		Coordinates [][][][2]float64 `json:"coordinates"`
+	}{"MultiPolygon", coordinates})
+}
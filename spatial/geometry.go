@@ -0,0 +1,281 @@
+/*
+Package spatial provides the geometry of the population zones used by
+popbuilder: polygons read from the GeoJSON boundary files, and an index for
+finding the zones at a point or within an area.
+*/
+package spatial
+
+import (
+	"math"
+)
+
+// Point is a position given by its longitude X and latitude Y in degrees.
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Bounds is the rectangle from Min to Max that contains a geometry.
+type Bounds struct {
+	Min Point
+	Max Point
+}
+
+// emptyBounds returns Bounds that contain nothing, which can be extended.
+func emptyBounds() Bounds {
+
+	return Bounds{
+		Min: Point{math.Inf(1), math.Inf(1)},
+		Max: Point{math.Inf(-1), math.Inf(-1)},
+	}
+}
+
+// IsEmpty returns true if the Bounds contain nothing.
+func (b Bounds) IsEmpty() bool {
+
+	return b.Min.X > b.Max.X || b.Min.Y > b.Max.Y
+}
+
+// Contains returns true if the point is inside or on the edge of the Bounds.
+func (b Bounds) Contains(p Point) bool {
+
+	return p.X >= b.Min.X && p.X <= b.Max.X &&
+		p.Y >= b.Min.Y && p.Y <= b.Max.Y
+}
+
+// Intersects returns true if the Bounds overlap or touch the other Bounds.
+func (b Bounds) Intersects(other Bounds) bool {
+
+	return b.Min.X <= other.Max.X && b.Max.X >= other.Min.X &&
+		b.Min.Y <= other.Max.Y && b.Max.Y >= other.Min.Y
+}
+
+// Extend returns the Bounds enlarged to contain the other Bounds.
+func (b Bounds) Extend(other Bounds) Bounds {
+
+	return Bounds{
+		Min: Point{math.Min(b.Min.X, other.Min.X),
+			math.Min(b.Min.Y, other.Min.Y)},
+		Max: Point{math.Max(b.Max.X, other.Max.X),
+			math.Max(b.Max.Y, other.Max.Y)},
+	}
+}
+
+// Ring is a closed line of points. The last point may repeat the first.
+type Ring []Point
+
+// Polygon is an outer Ring followed by any Rings of holes within it.
+type Polygon []Ring
+
+// MultiPolygon is a set of Polygons that together make up one geometry.
+type MultiPolygon []Polygon
+
+// Bounds returns the Bounds of the points in the Ring.
+func (r Ring) Bounds() Bounds {
+
+	b := emptyBounds()
+
+	for _, p := range r {
+		b = b.Extend(Bounds{p, p})
+	}
+
+	return b
+}
+
+// Contains returns true if the point is inside the Ring, using the even-odd
+// rule. Points exactly on an edge may be counted as inside or outside.
+func (r Ring) Contains(p Point) bool {
+
+	inside := false
+
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+
+		a, b := r[i], r[j]
+
+		if (a.Y > p.Y) != (b.Y > p.Y) &&
+			p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// signedArea returns the area of the Ring in square degrees, which is
+// positive if the points run anticlockwise.
+func (r Ring) signedArea() float64 {
+
+	var area float64
+
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		area += (r[j].X - r[i].X) * (r[j].Y + r[i].Y)
+	}
+
+	return area / 2
+}
+
+// Transform returns a copy of the Ring with the function applied to each
+// point.
+func (r Ring) Transform(f func(Point) Point) Ring {
+
+	ring := make(Ring, len(r))
+
+	for i, p := range r {
+		ring[i] = f(p)
+	}
+
+	return ring
+}
+
+// reverse returns a copy of the Ring with its points in reverse order.
+func (r Ring) reverse() Ring {
+
+	ring := make(Ring, len(r))
+
+	for i, p := range r {
+		ring[len(r)-1-i] = p
+	}
+
+	return ring
+}
+
+// Bounds returns the Bounds of the outer Ring of the Polygon.
+func (p Polygon) Bounds() Bounds {
+
+	if len(p) == 0 {
+		return emptyBounds()
+	}
+
+	return p[0].Bounds()
+}
+
+// Contains returns true if the point is inside the outer Ring of the Polygon
+// and not inside any of its holes.
+func (p Polygon) Contains(pt Point) bool {
+
+	if len(p) == 0 || !p[0].Contains(pt) {
+		return false
+	}
+
+	for _, hole := range p[1:] {
+
+		if hole.Contains(pt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Area returns the area of the Polygon in square degrees, excluding holes.
+func (p Polygon) Area() float64 {
+
+	var area float64
+
+	for i, ring := range p {
+
+		if i == 0 {
+			area += math.Abs(ring.signedArea())
+		} else {
+			area -= math.Abs(ring.signedArea())
+		}
+	}
+
+	return area
+}
+
+// Bounds returns the Bounds of the MultiPolygon.
+func (m MultiPolygon) Bounds() Bounds {
+
+	b := emptyBounds()
+
+	for _, polygon := range m {
+		b = b.Extend(polygon.Bounds())
+	}
+
+	return b
+}
+
+// Contains returns true if the point is inside any of the Polygons.
+func (m MultiPolygon) Contains(pt Point) bool {
+
+	for _, polygon := range m {
+
+		if polygon.Contains(pt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Area returns the total area of the Polygons in square degrees.
+func (m MultiPolygon) Area() float64 {
+
+	var area float64
+
+	for _, polygon := range m {
+		area += polygon.Area()
+	}
+
+	return area
+}
+
+// Transform returns a copy of the MultiPolygon with each point converted by
+// the given function, such as a change of projection.
+func (m MultiPolygon) Transform(f func(Point) Point) MultiPolygon {
+
+	result := make(MultiPolygon, len(m))
+
+	for i, polygon := range m {
+
+		result[i] = make(Polygon, len(polygon))
+
+		for j, ring := range polygon {
+			result[i][j] = ring.Transform(f)
+		}
+	}
+
+	return result
+}
+
+// Centroid returns the centre of mass of the MultiPolygon in degrees. Holes
+// are subtracted. The centroid of a geometry with no area is the centre of
+// its Bounds.
+func (m MultiPolygon) Centroid() Point {
+
+	var area, x, y float64
+
+	for _, polygon := range m {
+
+		for i, ring := range polygon {
+
+			// Weight outer rings positively and holes negatively, whichever
+			// way their points run
+			ringArea := ring.signedArea()
+			sign := 1.0
+
+			if (i == 0) != (ringArea > 0) {
+				sign = -1.0
+			}
+
+			for k, j := 0, len(ring)-1; k < len(ring); j, k = k, k+1 {
+
+				cross := ring[j].X*ring[k].Y - ring[k].X*ring[j].Y
+				x += sign * (ring[k].X + ring[j].X) * cross
+				y += sign * (ring[k].Y + ring[j].Y) * cross
+			}
+
+			area += sign * ringArea
+		}
+	}
+
+	if area == 0 {
+
+		b := m.Bounds()
+		return Point{(b.Min.X + b.Max.X) / 2, (b.Min.Y + b.Max.Y) / 2}
+	}
+
+	return Point{x / (6 * area), y / (6 * area)}
+}
@@ -0,0 +1,154 @@
+package spatial
+
+import (
+	"math"
+	"sort"
+)
+
+// defaultCellSize is the width and height in degrees of the cells in the
+// grid used by an Index.
+const defaultCellSize = 0.02
+
+// cell is the position of a cell in the grid used by an Index.
+type cell struct {
+	x int
+	y int
+}
+
+// Index finds the Features at a point or within an area. Each Feature is
+// listed in every cell of a regular grid that its Bounds overlap, so only
+// the Features listed in the cells near a point need to be tested.
+type Index struct {
+	features []*Feature
+	zones    map[string]*Feature
+	cells    map[cell][]int
+	cellSize float64
+}
+
+// NewIndex returns a new Index of the given Features.
+func NewIndex(features []*Feature) *Index {
+
+	index := &Index{
+		features: features,
+		zones:    map[string]*Feature{},
+		cells:    map[cell][]int{},
+		cellSize: defaultCellSize,
+	}
+
+	for i, feature := range features {
+
+		index.zones[feature.Zone] = feature
+
+		if feature.Bounds.IsEmpty() {
+			continue
+		}
+
+		min, max := index.cellRange(feature.Bounds)
+
+		for x := min.x; x <= max.x; x++ {
+
+			for y := min.y; y <= max.y; y++ {
+
+				c := cell{x, y}
+				index.cells[c] = append(index.cells[c], i)
+			}
+		}
+	}
+
+	return index
+}
+
+// Features returns every Feature in the Index.
+func (i *Index) Features() []*Feature {
+
+	return i.features
+}
+
+// Feature returns the Feature for the given zone, or nil if there is none.
+func (i *Index) Feature(zone string) *Feature {
+
+	return i.zones[zone]
+}
+
+// Locate returns the Feature containing the point, or nil if no Feature
+// contains it. If the boundaries overlap, the first Feature is returned.
+func (i *Index) Locate(p Point) *Feature {
+
+	for _, n := range i.cells[i.cellAt(p)] {
+
+		feature := i.features[n]
+
+		if feature.Bounds.Contains(p) && feature.Geometry.Contains(p) {
+			return feature
+		}
+	}
+
+	return nil
+}
+
+// Search returns the Features whose Bounds intersect the given Bounds, in
+// the order they were indexed. Their geometries may not intersect.
+func (i *Index) Search(b Bounds) []*Feature {
+
+	results := []*Feature{}
+
+	if b.IsEmpty() {
+		return results
+	}
+
+	seen := map[int]bool{}
+	min, max := i.cellRange(b)
+
+	// Searches larger than the grid are quicker without it
+	if (max.x-min.x+1)*(max.y-min.y+1) > len(i.cells) {
+
+		for _, feature := range i.features {
+
+			if feature.Bounds.Intersects(b) {
+				results = append(results, feature)
+			}
+		}
+
+		return results
+	}
+
+	matches := []int{}
+
+	for x := min.x; x <= max.x; x++ {
+
+		for y := min.y; y <= max.y; y++ {
+
+			for _, n := range i.cells[cell{x, y}] {
+
+				if !seen[n] && i.features[n].Bounds.Intersects(b) {
+
+					seen[n] = true
+					matches = append(matches, n)
+				}
+			}
+		}
+	}
+
+	sort.Ints(matches)
+
+	for _, n := range matches {
+		results = append(results, i.features[n])
+	}
+
+	return results
+}
+
+// cellAt returns the cell containing the point.
+func (i *Index) cellAt(p Point) cell {
+
+	return cell{
+		int(math.Floor(p.X / i.cellSize)),
+		int(math.Floor(p.Y / i.cellSize)),
+	}
+}
+
+// cellRange returns the first and last cells overlapped by the Bounds.
+func (i *Index) cellRange(b Bounds) (cell, cell) {
+
+	return i.cellAt(b.Min), i.cellAt(b.Max)
+}
@@ -0,0 +1,133 @@
+package spatial
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseKML returns the MultiPolygon made up of every Polygon in a KML
+// document, including those in MultiGeometry elements. KML coordinates are
+// always WGS84 longitude and latitude, and any altitudes are ignored.
+func ParseKML(r io.Reader) (MultiPolygon, error) {
+
+	decoder := xml.NewDecoder(r)
+	multi := MultiPolygon{}
+
+	// The rings of the Polygon being read, and the text of its coordinates
+	var outer Ring
+	var holes []Ring
+	var text strings.Builder
+	inPolygon, inHole, inCoordinates := false, false, false
+
+	for {
+
+		token, err := decoder.Token()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, errors.New("the file could not be read as KML")
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+
+			switch t.Name.Local {
+			case "Polygon":
+
+				inPolygon = true
+				outer, holes = nil, nil
+
+			case "outerBoundaryIs":
+
+				inHole = false
+
+			case "innerBoundaryIs":
+
+				inHole = true
+
+			case "coordinates":
+
+				inCoordinates = inPolygon
+				text.Reset()
+			}
+
+		case xml.CharData:
+
+			if inCoordinates {
+				text.Write(t)
+			}
+
+		case xml.EndElement:
+
+			switch t.Name.Local {
+			case "coordinates":
+
+				if !inCoordinates {
+					continue
+				}
+
+				inCoordinates = false
+				ring, err := parseKMLCoordinates(text.String())
+
+				if err != nil {
+					return nil, err
+				}
+
+				if inHole {
+					holes = append(holes, ring)
+				} else {
+					outer = ring
+				}
+
+			case "Polygon":
+
+				if outer == nil {
+					return nil, errors.New("a KML polygon has no outer boundary")
+				}
+
+				inPolygon = false
+				multi = append(multi, append(Polygon{outer}, holes...))
+			}
+		}
+	}
+
+	if len(multi) == 0 {
+		return nil, errors.New("the KML has no polygons")
+	}
+
+	return multi, nil
+}
+
+// parseKMLCoordinates returns the Ring for the text of a KML coordinates
+// element, which lists longitude,latitude[,altitude] tuples separated by
+// white space.
+func parseKMLCoordinates(text string) (Ring, error) {
+
+	ring := Ring{}
+
+	for _, tuple := range strings.Fields(text) {
+
+		values := strings.Split(tuple, ",")
+
+		if len(values) < 2 {
+			return nil, errors.New("invalid KML coordinates " + tuple)
+		}
+
+		x, errX := strconv.ParseFloat(values[0], 64)
+		y, errY := strconv.ParseFloat(values[1], 64)
+
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid KML coordinates " + tuple)
+		}
+
+		ring = append(ring, Point{x, y})
+	}
+
+	return ring, nil
+}
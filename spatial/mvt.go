@@ -0,0 +1,193 @@
+package spatial
+
+// protobuf is a Protocol Buffers message being written, which is all that
+// is needed to encode vector tiles.
+type protobuf []byte
+
+// varint appends an unsigned integer in the variable length encoding.
+func (b *protobuf) varint(v uint64) {
+
+	for v >= 0x80 {
+
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+
+	*b = append(*b, byte(v))
+}
+
+// key appends the key of a field with the given number and wire type.
+func (b *protobuf) key(field int, wireType int) {
+
+	b.varint(uint64(field<<3 | wireType))
+}
+
+// uint appends a field holding an unsigned integer.
+func (b *protobuf) uint(field int, v uint64) {
+
+	b.key(field, 0)
+	b.varint(v)
+}
+
+// message appends a field holding bytes, such as an embedded message.
+func (b *protobuf) message(field int, data []byte) {
+
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+// packed appends a field holding a packed list of unsigned integers.
+func (b *protobuf) packed(field int, values []uint32) {
+
+	var list protobuf
+
+	for _, v := range values {
+		list.varint(uint64(v))
+	}
+
+	b.message(field, list)
+}
+
+// zigzag returns a signed integer encoded so that small negative numbers
+// are small, as vector tile geometries require.
+func zigzag(v int) uint32 {
+
+	return uint32((int32(v) << 1) ^ (int32(v) >> 31))
+}
+
+// The commands used to draw a vector tile geometry, and the type of polygon
+// geometries.
+const (
+	commandMoveTo    = 1
+	commandLineTo    = 2
+	commandClosePath = 7
+	geometryPolygon  = 3
+)
+
+// tileProperty is the name and value of a property of a feature in a vector
+// tile. The value is a string or an int64.
+type tileProperty struct {
+	key   string
+	value interface{}
+}
+
+// tileLayer is a layer of a vector tile being written. The property names
+// and values are each stored once and referred to by their position.
+type tileLayer struct {
+	name     string
+	features []protobuf
+	keys     []string
+	keyIndex map[string]int
+	values   []interface{}
+	valIndex map[interface{}]int
+}
+
+// newTileLayer returns an empty tileLayer with the given name.
+func newTileLayer(name string) *tileLayer {
+
+	return &tileLayer{
+		name:     name,
+		keyIndex: map[string]int{},
+		valIndex: map[interface{}]int{},
+	}
+}
+
+// addFeature adds a polygon feature with the given properties to the
+// tileLayer. The geometry must be in tile coordinates, rounded to whole
+// numbers, with outer Rings that have a positive area.
+func (l *tileLayer) addFeature(m MultiPolygon, properties []tileProperty) {
+
+	tags := []uint32{}
+
+	for _, property := range properties {
+
+		k, ok := l.keyIndex[property.key]
+
+		if !ok {
+
+			k = len(l.keys)
+			l.keys = append(l.keys, property.key)
+			l.keyIndex[property.key] = k
+		}
+
+		v, ok := l.valIndex[property.value]
+
+		if !ok {
+
+			v = len(l.values)
+			l.values = append(l.values, property.value)
+			l.valIndex[property.value] = v
+		}
+
+		tags = append(tags, uint32(k), uint32(v))
+	}
+
+	// Draw each Ring with positions relative to the end of the last
+	commands := []uint32{}
+	x, y := 0, 0
+
+	for _, polygon := range m {
+
+		for _, ring := range polygon {
+
+			for i, p := range ring {
+
+				if i == 0 {
+					commands = append(commands, commandMoveTo|1<<3)
+				} else if i == 1 {
+					commands = append(commands,
+						uint32(commandLineTo|(len(ring)-1)<<3))
+				}
+
+				px, py := int(p.X), int(p.Y)
+				commands = append(commands, zigzag(px-x), zigzag(py-y))
+				x, y = px, py
+			}
+
+			commands = append(commands, commandClosePath|1<<3)
+		}
+	}
+
+	var feature protobuf
+	feature.packed(2, tags)
+	feature.uint(3, geometryPolygon)
+	feature.packed(4, commands)
+
+	l.features = append(l.features, feature)
+}
+
+// encode returns the tileLayer as a vector tile Layer message.
+func (l *tileLayer) encode() []byte {
+
+	var layer protobuf
+	layer.uint(15, 2)
+	layer.message(1, []byte(l.name))
+
+	for _, feature := range l.features {
+		layer.message(2, feature)
+	}
+
+	for _, key := range l.keys {
+		layer.message(3, []byte(key))
+	}
+
+	for _, value := range l.values {
+
+		var encoded protobuf
+
+		switch v := value.(type) {
+		case string:
+			encoded.message(1, []byte(v))
+		case int64:
+			encoded.key(6, 0)
+			encoded.varint(uint64((v << 1) ^ (v >> 63)))
+		}
+
+		layer.message(4, encoded)
+	}
+
+	layer.uint(5, tileExtent)
+
+	return layer
+}
@@ -0,0 +1,151 @@
+package spatial
+
+import (
+	"math"
+)
+
+// Constants of the Airy 1830 ellipsoid and the National Grid projection used
+// by the Ordnance Survey, and of the GRS80 ellipsoid used by WGS84.
+const (
+	airyA        = 6377563.396
+	airyB        = 6356256.909
+	gridScale    = 0.9996012717
+	gridLat      = 49 * math.Pi / 180
+	gridLon      = -2 * math.Pi / 180
+	gridEasting  = 400000
+	gridNorthing = -100000
+	wgs84A       = 6378137
+	wgs84B       = 6356752.3141
+)
+
+// gridIterations is the most iterations used to find the latitude of a
+// northing on the National Grid, which normally takes fewer than ten.
+const gridIterations = 100
+
+// The Helmert transformation from OSGB36 to WGS84, with the translations in
+// metres, the scale in parts per million and the rotations in arc seconds.
+const (
+	helmertX     = 446.448
+	helmertY     = -125.157
+	helmertZ     = 542.060
+	helmertScale = -20.4894
+	helmertRX    = 0.1502
+	helmertRY    = 0.2470
+	helmertRZ    = 0.8421
+)
+
+// FromBritishNationalGrid returns the WGS84 longitude and latitude of a
+// point given by its easting X and northing Y in metres on the British
+// National Grid (EPSG:27700). The conversion uses a Helmert transformation,
+// which is accurate to within about five metres.
+func FromBritishNationalGrid(p Point) Point {
+
+	lat, lon := inverseGrid(p.X, p.Y)
+	x, y, z := toCartesian(lat, lon, airyA, airyB)
+
+	// Apply the Helmert transformation
+	s := helmertScale / 1e6
+	rx := helmertRX / 3600 * math.Pi / 180
+	ry := helmertRY / 3600 * math.Pi / 180
+	rz := helmertRZ / 3600 * math.Pi / 180
+
+	x2 := helmertX + (1+s)*x - rz*y + ry*z
+	y2 := helmertY + rz*x + (1+s)*y - rx*z
+	z2 := helmertZ - ry*x + rx*y + (1+s)*z
+
+	lat, lon = fromCartesian(x2, y2, z2, wgs84A, wgs84B)
+	return Point{lon * 180 / math.Pi, lat * 180 / math.Pi}
+}
+
+// inverseGrid returns the OSGB36 latitude and longitude in radians of an
+// easting and northing on the National Grid, by inverting the transverse
+// Mercator projection. The search for the latitude stops after
+// gridIterations, so that points far outside the grid still return.
+func inverseGrid(easting float64, northing float64) (float64, float64) {
+
+	a, b := airyA, airyB
+	e2 := 1 - (b*b)/(a*a)
+	n := (a - b) / (a + b)
+
+	// Find the latitude at which the meridional arc matches the northing
+	lat := gridLat
+	m := 0.0
+
+	for i := 0; i < gridIterations; i++ {
+
+		lat = (northing-gridNorthing-m)/(a*gridScale) + lat
+		m = meridionalArc(lat, n, b)
+
+		if math.Abs(northing-gridNorthing-m) < 0.00001 {
+			break
+		}
+	}
+
+	sinLat, cosLat, tanLat := math.Sin(lat), math.Cos(lat), math.Tan(lat)
+	nu := a * gridScale / math.Sqrt(1-e2*sinLat*sinLat)
+	rho := a * gridScale * (1 - e2) / math.Pow(1-e2*sinLat*sinLat, 1.5)
+	eta2 := nu/rho - 1
+
+	secLat := 1 / cosLat
+	tan2, tan4 := tanLat*tanLat, math.Pow(tanLat, 4)
+
+	vii := tanLat / (2 * rho * nu)
+	viii := tanLat / (24 * rho * math.Pow(nu, 3)) *
+		(5 + 3*tan2 + eta2 - 9*tan2*eta2)
+	ix := tanLat / (720 * rho * math.Pow(nu, 5)) * (61 + 90*tan2 + 45*tan4)
+	x := secLat / nu
+	xi := secLat / (6 * math.Pow(nu, 3)) * (nu/rho + 2*tan2)
+	xii := secLat / (120 * math.Pow(nu, 5)) * (5 + 28*tan2 + 24*tan4)
+	xiia := secLat / (5040 * math.Pow(nu, 7)) *
+		(61 + 662*tan2 + 1320*tan4 + 720*math.Pow(tanLat, 6))
+
+	dE := easting - gridEasting
+
+	return lat - vii*dE*dE + viii*math.Pow(dE, 4) - ix*math.Pow(dE, 6),
+		gridLon + x*dE - xi*math.Pow(dE, 3) + xii*math.Pow(dE, 5) -
+			xiia*math.Pow(dE, 7)
+}
+
+// meridionalArc returns the distance in metres along the central meridian
+// of the National Grid from its true origin to the given latitude.
+func meridionalArc(lat float64, n float64, b float64) float64 {
+
+	dLat, sLat := lat-gridLat, lat+gridLat
+
+	return b * gridScale * ((1+n+5.0/4*n*n+5.0/4*n*n*n)*dLat -
+		(3*n+3*n*n+21.0/8*n*n*n)*math.Sin(dLat)*math.Cos(sLat) +
+		(15.0/8*n*n+15.0/8*n*n*n)*math.Sin(2*dLat)*math.Cos(2*sLat) -
+		35.0/24*n*n*n*math.Sin(3*dLat)*math.Cos(3*sLat))
+}
+
+// toCartesian returns the geocentric cartesian coordinates of a latitude
+// and longitude in radians on the ellipsoid with axes a and b.
+func toCartesian(lat float64, lon float64, a float64,
+	b float64) (float64, float64, float64) {
+
+	e2 := 1 - (b*b)/(a*a)
+	sinLat, cosLat := math.Sin(lat), math.Cos(lat)
+	nu := a / math.Sqrt(1-e2*sinLat*sinLat)
+
+	return nu * cosLat * math.Cos(lon), nu * cosLat * math.Sin(lon),
+		(1 - e2) * nu * sinLat
+}
+
+// fromCartesian returns the latitude and longitude in radians of geocentric
+// cartesian coordinates on the ellipsoid with axes a and b.
+func fromCartesian(x float64, y float64, z float64, a float64,
+	b float64) (float64, float64) {
+
+	e2 := 1 - (b*b)/(a*a)
+	p := math.Sqrt(x*x + y*y)
+	lat := math.Atan2(z, p*(1-e2))
+
+	for i := 0; i < 10; i++ {
+
+		sinLat := math.Sin(lat)
+		nu := a / math.Sqrt(1-e2*sinLat*sinLat)
+		lat = math.Atan2(z+e2*nu*sinLat, p)
+	}
+
+	return lat, math.Atan2(y, x)
+}
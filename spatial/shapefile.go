@@ -0,0 +1,462 @@
+package spatial
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// shapefileCode is the file code at the start of .shp and .shx files.
+const shapefileCode = 9994
+
+// shapefileHeaderSize is the length of the header of .shp and .shx files.
+const shapefileHeaderSize = 100
+
+// The shape types of polygons in a shapefile, without and with measures
+// and heights, and of null shapes, which have no geometry.
+const (
+	shapeNull     = 0
+	shapePolygon  = 5
+	shapePolygonZ = 15
+	shapePolygonM = 25
+)
+
+// IsShapefile returns true if the data starts with the header of a .shp
+// file.
+func IsShapefile(data []byte) bool {
+
+	return len(data) >= shapefileHeaderSize &&
+		binary.BigEndian.Uint32(data) == shapefileCode
+}
+
+// ReadShapefile returns a Record for each polygon in the shapefile at the
+// path, which names its .shp file. The .shx, .dbf and .prj files are read
+// from beside it if they exist, as in ParseShapefile.
+func ReadShapefile(path string) ([]Record, error) {
+
+	shp, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// sibling returns the file with the same name and another extension,
+	// in lower or upper case, or nil if there is none
+	sibling := func(ext string) ([]byte, error) {
+
+		base := strings.TrimSuffix(path, filepath.Ext(path))
+
+		for _, name := range []string{base + ext,
+			base + strings.ToUpper(ext)} {
+
+			data, err := ioutil.ReadFile(name)
+
+			if err == nil {
+				return data, nil
+			}
+
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	}
+
+	files := [3][]byte{}
+
+	for i, ext := range []string{".shx", ".dbf", ".prj"} {
+
+		files[i], err = sibling(ext)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ParseShapefile(shp, files[0], files[1], files[2])
+}
+
+// ParseShapefile returns a Record for each polygon in a shapefile, given the
+// contents of its .shp, .shx, .dbf and .prj files, with the fields of the
+// .dbf file as its attributes. Shapes are read in the order of the .shx
+// index, or of the .shp file if the index is nil. The .dbf file may be nil,
+// in which case the Records have no attributes, and the .prj file may be
+// nil, in which case the coordinates are treated as in ParseGeometry
+// without a crs. Otherwise coordinates on the British National Grid are
+// converted to WGS84 longitude and latitude, and coordinates outside the
+// extent of the grid are an error. Null shapes are skipped, and shapefiles
+// of other types than polygons, or with coordinates that are not finite, are
+// an error.
+func ParseShapefile(shp []byte, shx []byte, dbf []byte,
+	prj []byte) ([]Record, error) {
+
+	if !IsShapefile(shp) {
+		return nil, errors.New("the file is not a shapefile")
+	}
+
+	switch binary.LittleEndian.Uint32(shp[32:]) {
+	case shapeNull, shapePolygon, shapePolygonZ, shapePolygonM:
+	default:
+		return nil, errors.New("the shapefile does not hold polygons")
+	}
+
+	offsets, err := shapeOffsets(shp, shx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []dbfField
+	var attributes [][]string
+
+	if dbf != nil {
+
+		fields, attributes, err = parseDBF(dbf)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(attributes) != len(offsets) {
+			return nil, errors.New("the .dbf file does not have a record " +
+				"for each shape")
+		}
+	}
+
+	crs, err := prjCRS(string(prj))
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := []Record{}
+	bounds := emptyBounds()
+
+	for i, offset := range offsets {
+
+		geometry, err := parseShape(shp, offset)
+
+		if err != nil {
+			return nil, errors.New("shape " + strconv.Itoa(i+1) + ": " +
+				err.Error())
+		}
+
+		if geometry == nil {
+			continue
+		}
+
+		record := Record{Attributes: map[string]string{}, Geometry: geometry}
+
+		if attributes != nil {
+
+			for j, field := range fields {
+				record.Attributes[field.name] = attributes[i][j]
+			}
+		}
+
+		records = append(records, record)
+		bounds = bounds.Extend(geometry.Bounds())
+	}
+
+	transform, err := lonLatTransform(crs, bounds)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if transform != nil {
+
+		for i := range records {
+			records[i].Geometry = records[i].Geometry.Transform(transform)
+		}
+	}
+
+	return records, nil
+}
+
+// shapeOffsets returns the offset in the .shp file of each shape record,
+// read from the .shx index if it is not nil, or by stepping through the
+// records of the .shp file.
+func shapeOffsets(shp []byte, shx []byte) ([]int, error) {
+
+	offsets := []int{}
+
+	if shx != nil {
+
+		if !IsShapefile(shx) || (len(shx)-shapefileHeaderSize)%8 != 0 {
+			return nil, errors.New("the .shx file is not a shapefile index")
+		}
+
+		for i := shapefileHeaderSize; i < len(shx); i += 8 {
+
+			// Offsets are in 16-bit words
+			offset := int(binary.BigEndian.Uint32(shx[i:])) * 2
+
+			if offset < shapefileHeaderSize || offset+8 > len(shp) {
+				return nil, errors.New("the .shx file does not match the " +
+					".shp file")
+			}
+
+			offsets = append(offsets, offset)
+		}
+
+		return offsets, nil
+	}
+
+	for offset := shapefileHeaderSize; offset+8 <= len(shp); {
+
+		offsets = append(offsets, offset)
+		offset += 8 + int(binary.BigEndian.Uint32(shp[offset+4:]))*2
+	}
+
+	return offsets, nil
+}
+
+// parseShape returns the MultiPolygon of the shape record at the offset in
+// the .shp file, or nil for a null shape. The rings of a polygon shape run
+// clockwise for outer rings and anticlockwise for holes, and each hole is
+// put in the Polygon of the outer ring that contains it.
+func parseShape(shp []byte, offset int) (MultiPolygon, error) {
+
+	length := int(binary.BigEndian.Uint32(shp[offset+4:])) * 2
+	content := shp[offset+8:]
+
+	if length < 4 || length > len(content) {
+		return nil, errors.New("the record is truncated")
+	}
+
+	content = content[:length]
+
+	switch binary.LittleEndian.Uint32(content) {
+	case shapeNull:
+		return nil, nil
+	case shapePolygon, shapePolygonZ, shapePolygonM:
+	default:
+		return nil, errors.New("the shape is not a polygon")
+	}
+
+	// The type and the box are followed by the numbers of parts and points
+	if len(content) < 44 {
+		return nil, errors.New("the record is truncated")
+	}
+
+	numParts := int(binary.LittleEndian.Uint32(content[36:]))
+	numPoints := int(binary.LittleEndian.Uint32(content[40:]))
+	pointsStart := 44 + numParts*4
+
+	if numParts < 0 || numPoints < 0 ||
+		pointsStart+numPoints*16 > len(content) {
+
+		return nil, errors.New("the record is truncated")
+	}
+
+	rings := make([]Ring, numParts)
+
+	for i := range rings {
+
+		start := int(binary.LittleEndian.Uint32(content[44+i*4:]))
+		end := numPoints
+
+		if i+1 < numParts {
+			end = int(binary.LittleEndian.Uint32(content[48+i*4:]))
+		}
+
+		if start < 0 || start > end || end > numPoints {
+			return nil, errors.New("the parts are not in order")
+		}
+
+		rings[i] = make(Ring, end-start)
+
+		for j := range rings[i] {
+
+			p := content[pointsStart+(start+j)*16:]
+			x := math.Float64frombits(binary.LittleEndian.Uint64(p))
+			y := math.Float64frombits(binary.LittleEndian.Uint64(p[8:]))
+
+			if math.IsNaN(x) || math.IsInf(x, 0) || math.IsNaN(y) ||
+				math.IsInf(y, 0) {
+
+				return nil, errors.New("the coordinates are not finite " +
+					"numbers")
+			}
+
+			rings[i][j] = Point{x, y}
+		}
+	}
+
+	return assemblePolygons(rings), nil
+}
+
+// assemblePolygons returns the MultiPolygon made from the rings of a polygon
+// shape. Clockwise rings are outer rings, and each anticlockwise ring is a
+// hole in the smallest outer ring that contains it. Holes outside every
+// outer ring are kept as outer rings.
+func assemblePolygons(rings []Ring) MultiPolygon {
+
+	multi := MultiPolygon{}
+	holes := []Ring{}
+
+	for _, ring := range rings {
+
+		if len(ring) < 3 {
+			continue
+		}
+
+		if ring.signedArea() < 0 {
+			multi = append(multi, Polygon{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+
+	for _, hole := range holes {
+
+		outer := -1
+
+		for i, polygon := range multi {
+
+			if polygon[0].Contains(hole[0]) && (outer == -1 ||
+				math.Abs(polygon[0].signedArea()) <
+					math.Abs(multi[outer][0].signedArea())) {
+
+				outer = i
+			}
+		}
+
+		if outer == -1 {
+			multi = append(multi, Polygon{hole})
+		} else {
+			multi[outer] = append(multi[outer], hole)
+		}
+	}
+
+	return multi
+}
+
+// dbfField is a field in a .dbf file.
+type dbfField struct {
+	name   string
+	length int
+}
+
+// parseDBF returns the fields of a .dbf file and the values of each of its
+// records, including those marked as deleted, which still have a shape.
+// Values are trimmed of padding, and text that is not UTF-8 is read as
+// Latin-1.
+func parseDBF(dbf []byte) ([]dbfField, [][]string, error) {
+
+	if len(dbf) < 32 {
+		return nil, nil, errors.New("the .dbf file is truncated")
+	}
+
+	numRecords := int(binary.LittleEndian.Uint32(dbf[4:]))
+	headerLength := int(binary.LittleEndian.Uint16(dbf[8:]))
+	recordLength := int(binary.LittleEndian.Uint16(dbf[10:]))
+
+	fields := []dbfField{}
+	total := 1
+
+	// The field descriptors end with a carriage return
+	for i := 32; i+32 <= headerLength && i+32 <= len(dbf) &&
+		dbf[i] != '\r'; i += 32 {
+
+		name := dbf[i : i+11]
+
+		if n := strings.IndexByte(string(name), 0); n >= 0 {
+			name = name[:n]
+		}
+
+		field := dbfField{
+			name:   strings.TrimSpace(string(name)),
+			length: int(dbf[i+16]),
+		}
+
+		fields = append(fields, field)
+		total += field.length
+	}
+
+	if total > recordLength ||
+		headerLength+numRecords*recordLength > len(dbf) {
+
+		return nil, nil, errors.New("the .dbf file is truncated")
+	}
+
+	records := make([][]string, numRecords)
+
+	for i := range records {
+
+		record := dbf[headerLength+i*recordLength:]
+		position := 1
+		records[i] = make([]string, len(fields))
+
+		for j, field := range fields {
+
+			value := record[position : position+field.length]
+			records[i][j] = strings.TrimSpace(dbfText(value))
+			position += field.length
+		}
+	}
+
+	return fields, records, nil
+}
+
+// dbfText returns the text of a value in a .dbf file, without any null
+// padding, reading it as Latin-1 if it is not UTF-8.
+func dbfText(value []byte) string {
+
+	if n := strings.IndexByte(string(value), 0); n >= 0 {
+		value = value[:n]
+	}
+
+	if utf8.Valid(value) {
+		return string(value)
+	}
+
+	runes := make([]rune, len(value))
+
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+
+	return string(runes)
+}
+
+// prjCRS returns the name of the coordinate reference system described by
+// the well-known text of a .prj file, as the crs of a GeoJSON object would
+// name it, or an empty string if the text is empty. The British National
+// Grid is recognised by its name, or by the EPSG code of the projected
+// system, which is its last element.
+func prjCRS(prj string) (string, error) {
+
+	prj = strings.TrimSpace(prj)
+	normalised := strings.ToLower(strings.NewReplacer(" ", "", "_", "",
+		"\"", "").Replace(prj))
+
+	switch {
+	case prj == "":
+		return "", nil
+	case strings.Contains(normalised, "britishnationalgrid") ||
+		strings.HasSuffix(normalised, "authority[epsg,27700]]"):
+		return "EPSG:27700", nil
+	case strings.HasPrefix(normalised, "geogcs[") &&
+		(strings.Contains(normalised, "wgs1984") ||
+			strings.Contains(normalised, "wgs84")):
+		return "EPSG:4326", nil
+	}
+
+	// Name the system by the first quoted name in the text
+	name := strings.SplitN(prj, "\"", 3)
+
+	if len(name) == 3 {
+		prj = name[1]
+	}
+
+	return "", errors.New("unsupported coordinate reference system " + prj)
+}
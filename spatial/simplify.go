@@ -0,0 +1,124 @@
+package spatial
+
+import (
+	"math"
+)
+
+// Simplify returns the Ring with the points removed that are within the
+// tolerance of the line through their neighbours, using the Douglas-Peucker
+// algorithm. The tolerance is in the units of the points. The first point
+// is always kept, and the Ring is returned closed, with its last point
+// repeating the first. Rings that simplify to fewer than three points are
+// returned with just those points, so callers should drop them.
+func (r Ring) Simplify(tolerance float64) Ring {
+
+	points := r.open()
+
+	if len(points) < 3 {
+		return points
+	}
+
+	// Split the ring at the point furthest from its start, so that each
+	// half is a line with distinct ends
+	far, farDistance := 0, -1.0
+
+	for i, p := range points {
+
+		if d := squaredDistance(points[0], p); d > farDistance {
+			far, farDistance = i, d
+		}
+	}
+
+	keep := make([]bool, len(points)+1)
+	keep[0], keep[far], keep[len(points)] = true, true, true
+
+	closed := append(append(Ring{}, points...), points[0])
+	simplifyLine(closed, 0, far, tolerance*tolerance, keep)
+	simplifyLine(closed, far, len(points), tolerance*tolerance, keep)
+
+	simplified := Ring{}
+
+	for i, p := range closed {
+
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+
+	if len(simplified) < 4 {
+		return simplified[:len(simplified)-1]
+	}
+
+	return simplified
+}
+
+// open returns the points of the Ring without a last point repeating the
+// first, and without consecutive repeated points.
+func (r Ring) open() Ring {
+
+	points := Ring{}
+
+	for _, p := range r {
+
+		if len(points) == 0 || p != points[len(points)-1] {
+			points = append(points, p)
+		}
+	}
+
+	for len(points) > 1 && points[len(points)-1] == points[0] {
+		points = points[:len(points)-1]
+	}
+
+	return points
+}
+
+// simplifyLine marks the points between the start and end of a line that
+// must be kept to stay within the squared tolerance of it.
+func simplifyLine(points Ring, start int, end int, tolerance float64,
+	keep []bool) {
+
+	if end-start < 2 {
+		return
+	}
+
+	index, furthest := -1, tolerance
+
+	for i := start + 1; i < end; i++ {
+
+		d := segmentDistance(points[i], points[start], points[end])
+
+		if d > furthest {
+			index, furthest = i, d
+		}
+	}
+
+	if index == -1 {
+		return
+	}
+
+	keep[index] = true
+	simplifyLine(points, start, index, tolerance, keep)
+	simplifyLine(points, index, end, tolerance, keep)
+}
+
+// squaredDistance returns the square of the distance between two points.
+func squaredDistance(a Point, b Point) float64 {
+
+	dx, dy := b.X-a.X, b.Y-a.Y
+	return dx*dx + dy*dy
+}
+
+// segmentDistance returns the square of the distance from a point to the
+// nearest point on the segment from a to b.
+func segmentDistance(p Point, a Point, b Point) float64 {
+
+	dx, dy := b.X-a.X, b.Y-a.Y
+	length := dx*dx + dy*dy
+
+	if length == 0 {
+		return squaredDistance(p, a)
+	}
+
+	t := math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/length))
+	return squaredDistance(p, Point{a.X + t*dx, a.Y + t*dy})
+}
@@ -0,0 +1,1038 @@
+package spatial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+// square returns a Ring for the square with the given corner and size.
+func square(x float64, y float64, size float64) Ring {
+
+	return Ring{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size},
+		{x, y}}
+}
+
+// Test the area, centroid and containment of a polygon with a hole and a
+// multipolygon.
+func TestGeometry(t *testing.T) {
+
+	// A 4x4 square with a 2x2 hole in its lower left quarter, and a 1x1
+	// square some way off
+	withHole := Polygon{square(0, 0, 4), square(0, 0, 2)}
+	multi := MultiPolygon{withHole, Polygon{square(10, 10, 1)}}
+
+	if area := multi.Area(); area != 13 {
+		t.Errorf("Expected an area of 13. Got: %f", area)
+	}
+
+	// The L shape has its centroid at (7/3, 7/3), and the small square at
+	// (10.5, 10.5)
+	expected := Point{(12*7.0/3 + 10.5) / 13, (12*7.0/3 + 10.5) / 13}
+	centroid := multi.Centroid()
+
+	if math.Abs(centroid.X-expected.X) > 1e-9 ||
+		math.Abs(centroid.Y-expected.Y) > 1e-9 {
+
+		t.Errorf("Expected a centroid of %v. Got: %v", expected, centroid)
+	}
+
+	// Reversing the rings does not change the area or centroid
+	reversed := MultiPolygon{}
+
+	for _, polygon := range multi {
+
+		rings := Polygon{}
+
+		for _, ring := range polygon {
+
+			r := Ring{}
+
+			for i := len(ring) - 1; i >= 0; i-- {
+				r = append(r, ring[i])
+			}
+
+			rings = append(rings, r)
+		}
+
+		reversed = append(reversed, rings)
+	}
+
+	if reversed.Area() != 13 || reversed.Centroid() != centroid {
+		t.Errorf("Expected the same area and centroid for reversed rings.")
+	}
+
+	for _, test := range []struct {
+		point    Point
+		expected bool
+	}{
+		{Point{3, 3}, true},
+		{Point{1, 1}, false},
+		{Point{1, 3}, true},
+		{Point{10.5, 10.5}, true},
+		{Point{5, 5}, false},
+		{Point{-1, 1}, false},
+	} {
+
+		if multi.Contains(test.point) != test.expected {
+			t.Errorf("Expected Contains(%v) to be %t.", test.point,
+				test.expected)
+		}
+	}
+
+	bounds := multi.Bounds()
+
+	if bounds != (Bounds{Point{0, 0}, Point{11, 11}}) {
+		t.Errorf("Unexpected bounds: %v", bounds)
+	}
+}
+
+// Test ReadFeatures and the Index with a small FeatureCollection.
+func TestIndex(t *testing.T) {
+
+	collection := `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "properties": {"zone": "E01000001",
+			"population": "1500"}, "geometry": {"type": "Polygon",
+			"coordinates": [[[0, 0], [0.05, 0], [0.05, 0.05], [0, 0.05],
+			[0, 0]]]}},
+		{"type": "Feature", "properties": {"zone": "E01000002",
+			"population": 1200}, "geometry": {"type": "MultiPolygon",
+			"coordinates": [[[[0.05, 0], [0.1, 0], [0.1, 0.05], [0.05, 0.05],
+			[0.05, 0]]], [[[1, 1], [1.01, 1], [1.01, 1.01], [1, 1]]]]}}
+	]}`
+
+	features, err := ReadFeatures(strings.NewReader(collection), "E06000001")
+
+	if err != nil || len(features) != 2 {
+		t.Fatalf("Expected two features from ReadFeatures. Got: %v %v",
+			features, err)
+	}
+
+	if features[0].Population != 1500 || features[1].Population != 1200 ||
+		features[1].District != "E06000001" {
+
+		t.Errorf("Unexpected properties from ReadFeatures: %+v %+v",
+			features[0], features[1])
+	}
+
+	index := NewIndex(features)
+
+	for _, test := range []struct {
+		point    Point
+		expected string
+	}{
+		{Point{0.01, 0.01}, "E01000001"},
+		{Point{0.07, 0.04}, "E01000002"},
+		{Point{1.008, 1.001}, "E01000002"},
+		{Point{1.001, 1.008}, ""},
+		{Point{0.5, 0.5}, ""},
+	} {
+
+		feature := index.Locate(test.point)
+		zone := ""
+
+		if feature != nil {
+			zone = feature.Zone
+		}
+
+		if zone != test.expected {
+			t.Errorf("Expected %q from Locate(%v). Got: %q", test.expected,
+				test.point, zone)
+		}
+	}
+
+	found := index.Search(Bounds{Point{0.06, 0.01}, Point{0.07, 0.02}})
+
+	if len(found) != 1 || found[0].Zone != "E01000002" {
+		t.Errorf("Expected E01000002 from Search. Got: %v", found)
+	}
+
+	if found := index.Search(Bounds{Point{-10, -10},
+		Point{10, 10}}); len(found) != 2 {
+
+		t.Errorf("Expected both features from a large Search. Got: %d",
+			len(found))
+	}
+
+	if index.Feature("E01000002") != features[1] ||
+		index.Feature("E01000003") != nil {
+
+		t.Errorf("Unexpected result from Feature.")
+	}
+
+	if _, err := ReadFeatures(strings.NewReader(`{"features": [
+		{"properties": {"zone": "E01000001"}, "geometry": {"type": "Point",
+		"coordinates": [0, 0]}}]}`), ""); err == nil {
+
+		t.Errorf("Expected an error for an unsupported geometry.")
+	}
+}
+
+// Test distances, the share of an area inside a circle, and finding the
+// features within a circle by their centroids and by their areas.
+func TestCircle(t *testing.T) {
+
+	// One degree of latitude is about 111.2km
+	if d := Distance(Point{0, 51}, Point{0, 52}); math.Abs(d-111.2) > 0.1 {
+		t.Errorf("Expected a distance of 111.2km. Got: %f", d)
+	}
+
+	if d := Distance(Point{-0.1276, 51.5072},
+		Point{-2.2426, 53.4808}); math.Abs(d-262.5) > 1 {
+
+		t.Errorf("Expected about 262.5km from London to Manchester. Got: %f",
+			d)
+	}
+
+	// A circle of radius 1km at the equator, where a degree is about 111km
+	c := Circle{Centre: Point{0, 0}, Radius: 1}
+	degree := 1 / (earthRadius * math.Pi / 180)
+
+	for _, test := range []struct {
+		geometry MultiPolygon
+		expected float64
+	}{
+		// A small square at the centre is entirely inside
+		{MultiPolygon{Polygon{square(-0.1*degree, -0.1*degree, 0.2*degree)}},
+			1},
+		// A large square around the circle holds its whole area
+		{MultiPolygon{Polygon{square(-2*degree, -2*degree, 4*degree)}},
+			math.Pi / 16},
+		// The square to the upper right of the centre holds a quarter
+		{MultiPolygon{Polygon{square(0, 0, 2*degree)}}, math.Pi / 16},
+		// A square far away is outside
+		{MultiPolygon{Polygon{square(1, 1, degree)}}, 0},
+	} {
+
+		share := c.AreaShare(test.geometry)
+
+		if math.Abs(share-test.expected) > 0.001 {
+			t.Errorf("Expected an area share of %f. Got: %f", test.expected,
+				share)
+		}
+	}
+
+	// A row of squares 1km wide along the equator, with centroids at 0.5km,
+	// 1.5km and 2.5km from the origin
+	features := []*Feature{}
+
+	for i, zone := range []string{"E01000001", "E01000002", "E01000003"} {
+
+		features = append(features, NewFeature(zone, "", 100,
+			MultiPolygon{Polygon{square(float64(i)*degree, -0.5*degree,
+				degree)}}))
+	}
+
+	index := NewIndex(features)
+
+	for _, test := range []struct {
+		radius   float64
+		share    float64
+		expected []string
+	}{
+		{1, 0, []string{"E01000001"}},
+		{2, 0, []string{"E01000001", "E01000002"}},
+		{2, 0.5, []string{"E01000001", "E01000002"}},
+		{1.5, 0.5, []string{"E01000001"}},
+		{1.2, 0.01, []string{"E01000001", "E01000002"}},
+		{0.1, 0, []string{}},
+	} {
+
+		zones := []string{}
+
+		for _, feature := range index.WithinCircle(Circle{Point{0, 0},
+			test.radius}, test.share) {
+
+			zones = append(zones, feature.Zone)
+		}
+
+		if strings.Join(zones, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Expected %v within %fkm with a share of %f. Got: %v",
+				test.expected, test.radius, test.share, zones)
+		}
+	}
+}
+
+// Test the share of each zone inside a concave boundary with a hole, and
+// that boundaries that cross themselves are rejected.
+func TestBoundary(t *testing.T) {
+
+	// An L shape made of three 0.1 degree squares near the equator, with a
+	// small square hole in its corner square, as a clockwise outer ring
+	l := Ring{{0, 0}, {0, 0.2}, {0.1, 0.2}, {0.1, 0.1}, {0.2, 0.1},
+		{0.2, 0}, {0, 0}}
+	hole := square(0.025, 0.025, 0.05)
+	boundary, err := NewBoundary(MultiPolygon{Polygon{l, hole}})
+
+	if err != nil {
+		t.Fatalf("Could not create the boundary: %s", err)
+	}
+
+	for _, test := range []struct {
+		geometry MultiPolygon
+		expected float64
+	}{
+		{MultiPolygon{Polygon{square(0.1, 0.1, 0.1)}}, 0},
+		{MultiPolygon{Polygon{square(0.1, 0, 0.1)}}, 1},
+		{MultiPolygon{Polygon{square(0, 0, 0.1)}}, 0.75},
+		{MultiPolygon{Polygon{square(0.05, 0.15, 0.1)}}, 0.25},
+		{MultiPolygon{Polygon{square(0.15, 0.05, 0.1)}}, 0.5 * 0.5},
+	} {
+
+		share := boundary.AreaShare(test.geometry)
+
+		if math.Abs(share-test.expected) > 1e-3 {
+			t.Errorf("Expected an area share of %f for %v. Got: %f",
+				test.expected, test.geometry, share)
+		}
+	}
+
+	index := NewIndex([]*Feature{
+		NewFeature("E01000001", "", 100, MultiPolygon{Polygon{
+			square(0.1, 0.1, 0.1)}}),
+		NewFeature("E01000002", "", 100, MultiPolygon{Polygon{
+			square(0.15, 0.05, 0.1)}}),
+	})
+
+	overlaps := index.Overlapping(boundary)
+
+	if len(overlaps) != 1 || overlaps[0].Feature.Zone != "E01000002" ||
+		math.Abs(overlaps[0].Share-0.25) > 1e-3 {
+
+		t.Errorf("Expected E01000002 to overlap a quarter. Got: %v", overlaps)
+	}
+
+	if !boundary.Contains(Point{0.05, 0.15}) ||
+		boundary.Contains(Point{0.05, 0.05}) {
+
+		t.Errorf("Unexpected result from Contains.")
+	}
+
+	// A circle with too many points
+	circle := Ring{}
+
+	for i := 0; i <= maxBoundaryPoints; i++ {
+
+		angle := 2 * math.Pi * float64(i) / maxBoundaryPoints
+		circle = append(circle, Point{math.Cos(angle), math.Sin(angle)})
+	}
+
+	for _, invalid := range []MultiPolygon{
+		{Polygon{Ring{{0, 0}, {1, 1}, {1, 0}, {0, 1}, {0, 0}}}},
+		{Polygon{Ring{{0, 0}, {1, 1}, {0, 0}}}},
+		{Polygon{circle}},
+		{},
+	} {
+
+		if _, err := NewBoundary(invalid); err == nil {
+			t.Errorf("Expected an error from NewBoundary for %v.", invalid)
+		}
+	}
+
+	// Parse a FeatureCollection as a single geometry
+	geometry, err := ParseGeometry([]byte(`{"type": "FeatureCollection",
+		"features": [{"type": "Feature", "geometry": {"type": "Polygon",
+		"coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}}, {"type":
+		"Feature", "geometry": {"type": "MultiPolygon", "coordinates":
+		[[[[2, 2], [3, 2], [3, 3], [2, 2]]]]}}]}`))
+
+	if err != nil || len(geometry) != 2 || geometry.Area() != 1 {
+		t.Errorf("Expected two polygons from ParseGeometry. Got: %v %v",
+			geometry, err)
+	}
+}
+
+// Test KML polygons are read with their holes, and that GeoJSON on the
+// British National Grid is converted to WGS84.
+func TestFormats(t *testing.T) {
+
+	kml := `<?xml version="1.0" encoding="UTF-8"?>
+		<kml xmlns="http://www.opengis.net/kml/2.2"><Document><Placemark>
+		<name>Area</name><MultiGeometry><Polygon><outerBoundaryIs>
+		<LinearRing><coordinates>0,0,0 4,0,0 4,4,0 0,4,0 0,0,0</coordinates>
+		</LinearRing></outerBoundaryIs><innerBoundaryIs><LinearRing>
+		<coordinates>0,0 2,0 2,2 0,2 0,0</coordinates></LinearRing>
+		</innerBoundaryIs></Polygon><Polygon><outerBoundaryIs><LinearRing>
+		<coordinates>10,10 11,10 11,11 10,11 10,10</coordinates></LinearRing>
+		</outerBoundaryIs></Polygon></MultiGeometry></Placemark></Document>
+		</kml>`
+
+	geometry, err := ParseKML(strings.NewReader(kml))
+
+	if err != nil || len(geometry) != 2 || geometry.Area() != 13 {
+		t.Errorf("Expected two polygons with an area of 13 from ParseKML. "+
+			"Got: %v %v", geometry, err)
+	}
+
+	if _, err := ParseKML(strings.NewReader(
+		`<kml><Placemark><Point><coordinates>0,0</coordinates></Point>` +
+			`</Placemark></kml>`)); err == nil {
+
+		t.Errorf("Expected an error from ParseKML without polygons.")
+	}
+
+	// The grid reference of Big Ben, with and without a crs
+	for _, geoJSON := range []string{
+		`{"type": "Feature", "crs": {"type": "name", "properties": {"name": ` +
+			`"urn:ogc:def:crs:EPSG::27700"}}, "geometry": {"type": ` +
+			`"Polygon", "coordinates": [[[530268, 179640], [530278, 179640], ` +
+			`[530278, 179650], [530268, 179640]]]}}`,
+		`{"type": "Polygon", "coordinates": [[[530268, 179640], ` +
+			`[530278, 179640], [530278, 179650], [530268, 179640]]]}`,
+	} {
+
+		geometry, err := ParseGeometry([]byte(geoJSON))
+
+		if err != nil {
+			t.Fatalf("Could not parse the geometry: %s", err)
+		}
+
+		p := geometry[0][0][0]
+
+		if Distance(p, Point{-0.124625, 51.500729}) > 0.01 {
+			t.Errorf("Expected Big Ben from ParseGeometry. Got: %v", p)
+		}
+	}
+
+	if _, err := ParseGeometry([]byte(`{"type": "Polygon", "crs": ` +
+		`{"type": "name", "properties": {"name": "EPSG:3857"}}, ` +
+		`"coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}`)); err == nil {
+
+		t.Errorf("Expected an error from ParseGeometry for EPSG:3857.")
+	}
+
+	// Coordinates named as on the grid must be within its extent
+	_, err = ParseGeometry([]byte(`{"type": "Polygon", "crs": ` +
+		`{"type": "name", "properties": {"name": "EPSG:27700"}}, ` +
+		`"coordinates": [[[0, 0], [1e12, 0], [1e12, 1e12], [0, 0]]]}`))
+
+	if err == nil {
+		t.Errorf("Expected an error from ParseGeometry outside the grid.")
+	}
+
+	// Coordinates that are not finite are an error whatever the crs
+	bounds := Bounds{Point{0, 0}, Point{math.NaN(), 1}}
+
+	for _, crs := range []string{"", "EPSG:27700", "EPSG:4326"} {
+
+		if _, err := lonLatTransform(crs, bounds); err == nil {
+			t.Errorf("Expected an error from lonLatTransform for NaN with "+
+				"%q.", crs)
+		}
+	}
+
+	// The search for the latitude stops for a northing that never matches
+	inverseGrid(0, math.NaN())
+
+	// Read the records of a FeatureCollection on the grid, skipping points
+	records, err := ReadRecords([]byte(`{"type": "FeatureCollection", ` +
+		`"features": [{"type": "Feature", "properties": {"code": ` +
+		`"E01000001", "id": 7}, "geometry": {"type": "Polygon", ` +
+		`"coordinates": [[[530268, 179640], [530278, 179640], ` +
+		`[530278, 179650], [530268, 179640]]]}}, {"type": "Feature", ` +
+		`"properties": {}, "geometry": {"type": "Point", ` +
+		`"coordinates": [530268, 179640]}}]}`))
+
+	if err != nil || len(records) != 1 ||
+		records[0].Attributes["code"] != "E01000001" ||
+		records[0].Attributes["id"] != "7" ||
+		Distance(records[0].Geometry[0][0][0],
+			Point{-0.124625, 51.500729}) > 0.01 {
+
+		t.Errorf("Expected a record for Big Ben from ReadRecords. Got: %v %v",
+			records, err)
+	}
+
+	// Encode a MultiPolygon as GeoJSON, closing its rings
+	encoded, err := json.Marshal(MultiPolygon{Polygon{Ring{{0, 0}, {1, 0},
+		{1, 1}}}})
+	expected := `{"type":"MultiPolygon","coordinates":` +
+		`[[[[0,0],[1,0],[1,1],[0,0]]]]}`
+
+	if err != nil || string(encoded) != expected {
+		t.Errorf("Expected %s from MarshalJSON. Got: %s %v", expected,
+			encoded, err)
+	}
+}
+
+// Test neighbouring polygons are dissolved into their outline, keeping the
+// holes between them and the polygons that do not touch.
+func TestDissolve(t *testing.T) {
+
+	// A 2x2 block of squares, one of which runs clockwise
+	clockwise := Ring{{0.1, 0.1}, {0.1, 0.2}, {0.2, 0.2}, {0.2, 0.1},
+		{0.1, 0.1}}
+
+	block := []MultiPolygon{
+		{Polygon{square(0, 0, 0.1)}},
+		{Polygon{square(0.1, 0, 0.1)}},
+		{Polygon{square(0, 0.1, 0.1)}},
+		{Polygon{clockwise}},
+	}
+
+	// A 3x3 ring of squares around an empty square, and a separate square
+	surround := []MultiPolygon{{Polygon{square(1, 1, 0.1)}}}
+
+	for i := 0; i < 3; i++ {
+
+		for j := 0; j < 3; j++ {
+
+			if i != 1 || j != 1 {
+
+				surround = append(surround, MultiPolygon{Polygon{
+					square(float64(i)*0.1, float64(j)*0.1, 0.1)}})
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		geometries []MultiPolygon
+		polygons   int
+		holes      int
+		area       float64
+	}{
+		{block, 1, 0, 0.04},
+		{surround, 2, 1, 0.09},
+		{[]MultiPolygon{}, 0, 0, 0},
+	} {
+
+		outline := Dissolve(test.geometries, 0)
+		holes := 0
+
+		for _, polygon := range outline {
+			holes += len(polygon) - 1
+		}
+
+		if len(outline) != test.polygons || holes != test.holes ||
+			math.Abs(outline.Area()-test.area) > 1e-9 {
+
+			t.Errorf("Expected %d polygons with %d holes and an area of %f "+
+				"from Dissolve. Got: %v", test.polygons, test.holes,
+				test.area, outline)
+		}
+	}
+
+	// The centre of the block, where the four squares meet, is inside it
+	outline := Dissolve(block, 0)
+
+	if len(outline) != 1 || !outline.Contains(Point{0.1, 0.1}) {
+		t.Errorf("Expected the centre of the block inside the outline.")
+	}
+
+	// Two squares that share an edge, where one has an extra point along
+	// the edge and the points of the other differ by rounding errors
+	left := Ring{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}
+	right := Ring{{1 + 1e-12, 0}, {2, 0}, {2, 1}, {1, 1 - 1e-12},
+		{1, 0.5}, {1 + 1e-12, 0}}
+
+	outline = Dissolve([]MultiPolygon{{Polygon{left}}, {Polygon{right}}},
+		1e-9)
+
+	if len(outline) != 1 || len(outline[0]) != 1 ||
+		math.Abs(outline.Area()-2) > 1e-9 || len(outline[0][0]) != 7 {
+
+		t.Errorf("Expected one rectangle of six points from Dissolve for "+
+			"squares with unequal points. Got: %v", outline)
+	}
+}
+
+// Test rings are simplified to within the tolerance.
+func TestSimplify(t *testing.T) {
+
+	// A square with extra points along its edges and a small bump
+	ring := Ring{{0, 0}, {5, 0}, {10, 0}, {10, 5}, {10.1, 6}, {10, 7},
+		{10, 10}, {5, 10}, {0, 10}, {0, 0}}
+
+	for _, test := range []struct {
+		tolerance float64
+		expected  int
+	}{
+		{0.01, 8},
+		{0.5, 5},
+		{20, 2},
+	} {
+
+		simplified := ring.Simplify(test.tolerance)
+
+		if len(simplified) != test.expected {
+			t.Errorf("Expected %d points from Simplify with tolerance %f. "+
+				"Got: %v", test.expected, test.tolerance, simplified)
+		}
+	}
+
+	if simplified := ring.Simplify(0.5); simplified[0] != simplified[4] ||
+		math.Abs(simplified.signedArea()-100) > 1e-9 {
+
+		t.Errorf("Expected a closed square from Simplify. Got: %v",
+			simplified)
+	}
+}
+
+// readVarint reads a varint from the start of the data, and returns it with
+// the rest of the data.
+func readVarint(data []byte) (uint64, []byte) {
+
+	var v uint64
+
+	for i, b := range data {
+
+		v |= uint64(b&0x7f) << (7 * uint(i))
+
+		if b < 0x80 {
+			return v, data[i+1:]
+		}
+	}
+
+	return v, nil
+}
+
+// readFields returns the values of the fields of a protobuf message, keyed
+// by field number, as integers or bytes.
+func readFields(data []byte) map[int][]interface{} {
+
+	fields := map[int][]interface{}{}
+
+	for len(data) > 0 {
+
+		var key, v uint64
+		key, data = readVarint(data)
+		field := int(key >> 3)
+
+		if key&7 == 2 {
+
+			v, data = readVarint(data)
+			fields[field] = append(fields[field], data[:v])
+			data = data[v:]
+
+		} else {
+
+			v, data = readVarint(data)
+			fields[field] = append(fields[field], v)
+		}
+	}
+
+	return fields
+}
+
+// Test tiles are encoded as Mapbox Vector Tiles with the zone properties
+// and geometries clipped to the tile.
+func TestEncodeTile(t *testing.T) {
+
+	// A zone covering the tile 16/32768/32767 to the north east of 0, 0,
+	// and a zone far away
+	index := NewIndex([]*Feature{
+		NewFeature("E01000001", "E06000001", 1500, MultiPolygon{Polygon{
+			square(-0.001, -0.001, 0.01)}}),
+		NewFeature("E01000002", "E06000001", 1600, MultiPolygon{Polygon{
+			square(1, 1, 0.01)}}),
+	})
+
+	tile := Tile{16, 32768, 32767}
+
+	if !tile.Valid() || (Tile{2, 4, 0}).Valid() {
+		t.Errorf("Unexpected result from Valid.")
+	}
+
+	if len(index.EncodeTile(Tile{16, 0, 0})) != 0 {
+		t.Errorf("Expected an empty tile away from the zones.")
+	}
+
+	layers := readFields(index.EncodeTile(tile))[3]
+
+	if len(layers) != 1 {
+		t.Fatalf("Expected one layer in the tile. Got: %d", len(layers))
+	}
+
+	layer := readFields(layers[0].([]byte))
+
+	if string(layer[1][0].([]byte)) != "zones" || len(layer[2]) != 1 ||
+		len(layer[3]) != 3 || layer[5][0] != uint64(tileExtent) {
+
+		t.Fatalf("Unexpected layer in the tile: %v", layer)
+	}
+
+	if string(layer[3][0].([]byte)) != "zone" ||
+		string(readFields(layer[4][0].([]byte))[1][0].([]byte)) !=
+			"E01000001" {
+
+		t.Errorf("Expected the zone E01000001 in the tile.")
+	}
+
+	// The zone is clipped to the square of the tile and its buffer, drawn
+	// with a move, three lines and a close
+	feature := readFields(layer[2][0].([]byte))
+	geometry := []uint64{}
+	data := feature[4][0].([]byte)
+
+	for len(data) > 0 {
+
+		var v uint64
+		v, data = readVarint(data)
+		geometry = append(geometry, v)
+	}
+
+	if feature[3][0] != uint64(geometryPolygon) || len(geometry) != 11 ||
+		geometry[0] != 9 || geometry[3] != 26 || geometry[10] != 15 {
+
+		t.Errorf("Unexpected geometry in the tile: %v", geometry)
+	}
+}
+
+// Test neighbouring geometries share their arcs in a Topology, and still
+// meet when simplified.
+func TestTopology(t *testing.T) {
+
+	// Two squares sharing a wiggly edge, whose points differ by a rounding
+	// error, and a square in a hole, starting at a different point and
+	// running the other way
+	noise := 1e-12
+	left := Ring{{0, 0}, {1, 0}, {1.01, 0.25}, {0.99, 0.5}, {1.01, 0.75},
+		{1, 1}, {0, 1}, {0, 0}}
+	right := Ring{{1, 0}, {2, 0}, {2, 1}, {1, 1}, {1.01 + noise, 0.75},
+		{0.99 - noise, 0.5}, {1.01, 0.25 + noise}, {1, 0}}
+	hole := Ring{{3.5, 0.5}, {3.5, 0.25}, {3.25, 0.25}, {3.25, 0.5},
+		{3.5, 0.5}}
+
+	geometries := []MultiPolygon{
+		{Polygon{left}},
+		{Polygon{right}},
+		{Polygon{square(3, 0, 1), hole}},
+		{Polygon{square(3.25, 0.25, 0.25)}},
+	}
+
+	// The shared edge, the outsides of the squares, and the hole
+	topology := NewTopology(geometries, 1e-9)
+
+	if len(topology.Arcs) != 5 {
+		t.Errorf("Expected 5 arcs in the Topology. Got: %d",
+			len(topology.Arcs))
+	}
+
+	// Without rounding the wiggly edge is not shared
+	if arcs := len(NewTopology(geometries, 0).Arcs); arcs != 6 {
+		t.Errorf("Expected 6 arcs without rounding. Got: %d", arcs)
+	}
+
+	for i, geometry := range geometries {
+
+		area := topology.Geometry(i).Area()
+
+		if math.Abs(area-geometry.Area()) > 1e-6 {
+			t.Errorf("Expected geometry %d to have an area of %f. Got: %f",
+				i, geometry.Area(), area)
+		}
+	}
+
+	// Simplified, the shared edge is straight, and the squares still meet
+	simplified := topology.Simplify(0.02)
+	squares := []MultiPolygon{simplified.Geometry(0), simplified.Geometry(1)}
+	outline := Dissolve(squares, 0)
+
+	if math.Abs(squares[0].Area()-1) > 1e-6 || len(outline) != 1 ||
+		len(outline[0]) != 1 || math.Abs(outline.Area()-2) > 1e-6 {
+
+		t.Errorf("Expected the simplified squares to meet. Got: %v",
+			squares)
+	}
+
+	// Simplifying one geometry gives the same result as the Topology
+	for i := range geometries {
+
+		a, _ := json.Marshal(topology.SimplifyGeometry(i, 0.02))
+		b, _ := json.Marshal(simplified.Geometry(i))
+
+		if string(a) != string(b) {
+			t.Errorf("Expected geometry %d to match the simplified "+
+				"Topology. Got: %s", i, a)
+		}
+	}
+
+	// Rings too small for the tolerance are dropped
+	if geometry := topology.SimplifyGeometry(3, 1); len(geometry) != 0 {
+		t.Errorf("Expected the small square to be dropped. Got: %v",
+			geometry)
+	}
+}
+
+// Test a subset of a Topology is encoded as TopoJSON, with quantized arcs
+// that decode to the points of the geometries.
+func TestTopoJSON(t *testing.T) {
+
+	geometries := []MultiPolygon{
+		{Polygon{square(0, 0, 0.1)}},
+		{Polygon{square(0.1, 0, 0.1)}},
+		{Polygon{square(0.2, 0, 0.1)}},
+	}
+
+	// The middle square is split into four arcs at the corners it shares,
+	// and the square on the right shares one of them
+	subset := NewTopology(geometries, 0).Subset([]int{2, 1}, 0)
+
+	if len(subset.Geometries) != 2 || len(subset.Arcs) != 5 {
+		t.Fatalf("Expected 2 geometries with 5 arcs in the subset. Got: %v",
+			subset)
+	}
+
+	if math.Abs(subset.Geometry(0).Area()-0.01) > 1e-9 {
+		t.Errorf("Expected the subset to start with the third square. "+
+			"Got: %v", subset.Geometry(0))
+	}
+
+	properties := []map[string]interface{}{{"zone": "c"}, {"zone": "b"}}
+	data, err := json.Marshal(subset.TopoJSON("zones", properties, 0.001))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var topology struct {
+		Type      string
+		Transform struct {
+			Scale     [2]float64
+			Translate [2]float64
+		}
+		Objects map[string]struct {
+			Type       string
+			Geometries []struct {
+				Type       string
+				Arcs       [][][]int
+				Properties map[string]string
+			}
+		}
+		Arcs [][][2]float64
+	}
+
+	err = json.Unmarshal(data, &topology)
+
+	if err != nil || topology.Type != "Topology" ||
+		topology.Transform.Scale != [2]float64{0.001, 0.001} ||
+		len(topology.Objects["zones"].Geometries) != 2 ||
+		topology.Objects["zones"].Geometries[1].Properties["zone"] != "b" {
+
+		t.Fatalf("Expected a TopoJSON Topology. Got: %s", data)
+	}
+
+	// Decode each arc, which should hold the positions of the subset
+	for i, arc := range topology.Arcs {
+
+		x, y := 0.0, 0.0
+
+		for j, position := range arc {
+
+			x, y = x+position[0], y+position[1]
+			p := Point{x*0.001 + topology.Transform.Translate[0],
+				y*0.001 + topology.Transform.Translate[1]}
+
+			if math.Abs(p.X-subset.Arcs[i][j].X) > 1e-9 ||
+				math.Abs(p.Y-subset.Arcs[i][j].Y) > 1e-9 {
+
+				t.Errorf("Expected arc %d to decode to %v. Got: %v", i,
+					subset.Arcs[i], arc)
+
+				break
+			}
+		}
+	}
+}
+
+// encodeShapefile returns the .shp, .shx and .dbf files of a shapefile of
+// polygons, each given by its rings, with a code field for each shape. A
+// shape without rings is written as a null shape.
+func encodeShapefile(shapes [][]Ring, codes []string) ([]byte, []byte,
+	[]byte) {
+
+	var shp, shx, dbf bytes.Buffer
+
+	header := func(length int) []byte {
+
+		h := make([]byte, shapefileHeaderSize)
+		binary.BigEndian.PutUint32(h, shapefileCode)
+		binary.BigEndian.PutUint32(h[24:], uint32(length/2))
+		binary.LittleEndian.PutUint32(h[28:], 1000)
+		binary.LittleEndian.PutUint32(h[32:], shapePolygon)
+		return h
+	}
+
+	records := [][]byte{}
+
+	for _, rings := range shapes {
+
+		var content bytes.Buffer
+
+		if len(rings) == 0 {
+
+			binary.Write(&content, binary.LittleEndian, uint32(shapeNull))
+			records = append(records, content.Bytes())
+			continue
+		}
+
+		points := 0
+
+		for _, ring := range rings {
+			points += len(ring)
+		}
+
+		binary.Write(&content, binary.LittleEndian, uint32(shapePolygon))
+		binary.Write(&content, binary.LittleEndian, [4]float64{})
+		binary.Write(&content, binary.LittleEndian, uint32(len(rings)))
+		binary.Write(&content, binary.LittleEndian, uint32(points))
+		start := 0
+
+		for _, ring := range rings {
+
+			binary.Write(&content, binary.LittleEndian, uint32(start))
+			start += len(ring)
+		}
+
+		for _, ring := range rings {
+
+			for _, p := range ring {
+				binary.Write(&content, binary.LittleEndian, [2]float64{p.X, p.Y})
+			}
+		}
+
+		records = append(records, content.Bytes())
+	}
+
+	length := shapefileHeaderSize
+
+	for _, record := range records {
+		length += 8 + len(record)
+	}
+
+	shp.Write(header(length))
+	shx.Write(header(shapefileHeaderSize + 8*len(records)))
+	offset := shapefileHeaderSize
+
+	for i, record := range records {
+
+		binary.Write(&shp, binary.BigEndian, [2]uint32{uint32(i + 1),
+			uint32(len(record) / 2)})
+		shp.Write(record)
+		binary.Write(&shx, binary.BigEndian, [2]uint32{uint32(offset / 2),
+			uint32(len(record) / 2)})
+		offset += 8 + len(record)
+	}
+
+	// A dbf file with a ten character code field
+	dbfHeader := make([]byte, 32)
+	dbfHeader[0] = 3
+	binary.LittleEndian.PutUint32(dbfHeader[4:], uint32(len(codes)))
+	binary.LittleEndian.PutUint16(dbfHeader[8:], 65)
+	binary.LittleEndian.PutUint16(dbfHeader[10:], 11)
+	dbf.Write(dbfHeader)
+
+	field := make([]byte, 32)
+	copy(field, "CODE")
+	field[11], field[16] = 'C', 10
+	dbf.Write(field)
+	dbf.WriteByte('\r')
+
+	for _, code := range codes {
+		dbf.WriteString(" " + code + strings.Repeat(" ", 10-len(code)))
+	}
+
+	dbf.WriteByte(0x1a)
+	return shp.Bytes(), shx.Bytes(), dbf.Bytes()
+}
+
+// Test shapefiles of polygons on the British National Grid are read with
+// their holes and attributes, and converted to WGS84.
+func TestShapefile(t *testing.T) {
+
+	// A clockwise square around Big Ben with an anticlockwise hole, a null
+	// shape and a square to the east
+	outer := square(530200, 179600, 200).reverse()
+	hole := square(530250, 179650, 10)
+	east := square(531000, 179600, 100).reverse()
+
+	shp, shx, dbf := encodeShapefile([][]Ring{{hole, outer}, {}, {east}},
+		[]string{"E01004736", "", "E01004737"})
+
+	prj := []byte(`PROJCS["British_National_Grid",GEOGCS["GCS_OSGB_1936",` +
+		`DATUM["D_OSGB_1936",SPHEROID["Airy_1830",6377563.396,299.3249646]]` +
+		`,PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]],` +
+		`PROJECTION["Transverse_Mercator"]]`)
+
+	for _, files := range [][3][]byte{{shx, dbf, prj}, {nil, dbf, nil}} {
+
+		records, err := ParseShapefile(shp, files[0], files[1], files[2])
+
+		if err != nil || len(records) != 2 {
+			t.Fatalf("Expected two records from ParseShapefile. Got: %v %v",
+				records, err)
+		}
+
+		geometry := records[0].Geometry
+
+		if records[0].Attributes["CODE"] != "E01004736" ||
+			records[1].Attributes["CODE"] != "E01004737" ||
+			len(geometry) != 1 || len(geometry[0]) != 2 ||
+			Distance(geometry.Centroid(), Point{-0.124625, 51.500729}) > 0.5 {
+
+			t.Errorf("Expected a polygon with a hole at Big Ben. Got: %v",
+				records)
+		}
+	}
+
+	// Shapes without the dbf file have no attributes
+	records, err := ParseShapefile(shp, nil, nil, nil)
+
+	if err != nil || len(records) != 2 || len(records[0].Attributes) != 0 {
+		t.Errorf("Expected records without attributes. Got: %v %v", records,
+			err)
+	}
+
+	if !IsShapefile(shp) || IsShapefile(dbf) {
+		t.Errorf("Expected IsShapefile to recognise the .shp file.")
+	}
+
+	// Other projections and truncated files are errors
+	mercator := []byte(`PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",` +
+		`GEOGCS["GCS_WGS_1984"]]`)
+
+	if _, err := ParseShapefile(shp, shx, dbf, mercator); err == nil {
+		t.Errorf("Expected an error for a shapefile in Web Mercator.")
+	}
+
+	if _, err := ParseShapefile(shp[:len(shp)-8], nil, nil, nil); err == nil {
+		t.Errorf("Expected an error for a truncated shapefile.")
+	}
+
+	// Coordinates that are not finite, or outside the grid when it is
+	// named, are errors
+	nan := Ring{{0, 0}, {0, math.NaN()}, {1, 1}, {0, 0}}
+	far := square(1e9, 1e9, 100).reverse()
+
+	for _, ring := range []Ring{nan, far} {
+
+		bad, _, _ := encodeShapefile([][]Ring{{ring}}, []string{""})
+
+		if _, err := ParseShapefile(bad, nil, nil, prj); err == nil {
+			t.Errorf("Expected an error for the coordinates %v.", ring)
+		}
+	}
+
+	// The grid is recognised by its name or its own EPSG code, but not by
+	// other numbers that contain the code
+	for text, expected := range map[string]string{
+		`PROJCS["OSGB 1936 / British National Grid",GEOGCS["OSGB 1936"],` +
+			`AUTHORITY["EPSG","27700"]]`: "EPSG:27700",
+		`PROJCS["Grid",GEOGCS["OSGB 1936"],` +
+			`AUTHORITY["EPSG","27700"]]`: "EPSG:27700",
+		`PROJCS["Local",GEOGCS["Local"],PARAMETER["False_Easting",` +
+			`277000.0]]`: "",
+		`GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984"]]`: "EPSG:4326",
+	} {
+
+		crs, err := prjCRS(text)
+
+		if crs != expected || (expected == "") != (err != nil) {
+			t.Errorf("Expected %q from prjCRS for %s. Got: %q %v", expected,
+				text, crs, err)
+		}
+	}
+}
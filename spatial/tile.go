@@ -0,0 +1,170 @@
+package spatial
+
+import (
+	"math"
+)
+
+// The size of a vector tile in its own coordinates, the width of the buffer
+// around it that geometries are clipped to so that their edges are not
+// drawn at the tile boundary, the tolerance in tile coordinates used to
+// simplify geometries, and the smallest area of a ring that is kept, which
+// is a pixel of a tile drawn 256 pixels wide.
+const (
+	tileExtent    = 4096
+	tileBuffer    = 64
+	tileTolerance = 2
+	tileMinArea   = (tileExtent / 256) * (tileExtent / 256)
+)
+
+// MaxTileZoom is the largest zoom level of a Tile.
+const MaxTileZoom = 20
+
+// Tile is a square of the map in the Web Mercator tiling scheme used by
+// Leaflet, at zoom level Z, with X and Y counted from the north west.
+type Tile struct {
+	Z int
+	X int
+	Y int
+}
+
+// Valid returns true if the Tile exists at its zoom level.
+func (t Tile) Valid() bool {
+
+	if t.Z < 0 || t.Z > MaxTileZoom {
+		return false
+	}
+
+	n := 1 << uint(t.Z)
+	return t.X >= 0 && t.X < n && t.Y >= 0 && t.Y < n
+}
+
+// project returns the position of a point in the coordinates of the Tile,
+// from 0 to tileExtent across the Tile with y increasing southwards.
+func (t Tile) project(p Point) Point {
+
+	scale := float64(uint(1)<<uint(t.Z)) * tileExtent
+	lat := math.Max(-85.0511, math.Min(85.0511, p.Y)) * math.Pi / 180
+
+	x := (p.X + 180) / 360 * scale
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * scale
+
+	return Point{x - float64(t.X)*tileExtent, y - float64(t.Y)*tileExtent}
+}
+
+// unproject returns the longitude and latitude of a point in the
+// coordinates of the Tile.
+func (t Tile) unproject(p Point) Point {
+
+	scale := float64(uint(1)<<uint(t.Z)) * tileExtent
+	x := (p.X + float64(t.X)*tileExtent) / scale
+	y := (p.Y + float64(t.Y)*tileExtent) / scale
+
+	lat := math.Atan(math.Sinh(math.Pi * (1 - 2*y)))
+	return Point{x*360 - 180, lat * 180 / math.Pi}
+}
+
+// Bounds returns the Bounds of the Tile and its buffer in degrees.
+func (t Tile) Bounds() Bounds {
+
+	nw := t.unproject(Point{-tileBuffer, -tileBuffer})
+	se := t.unproject(Point{tileExtent + tileBuffer, tileExtent + tileBuffer})
+
+	return Bounds{Point{nw.X, se.Y}, Point{se.X, nw.Y}}
+}
+
+// tileGeometry returns the MultiPolygon in the coordinates of the Tile,
+// clipped to the Tile and its buffer, simplified and rounded to whole
+// numbers. Outer Rings have a positive area in tile coordinates and holes a
+// negative area, as vector tiles require. Rings smaller than a pixel are
+// dropped, so at low zoom levels only the larger zones are drawn.
+func (t Tile) tileGeometry(m MultiPolygon) MultiPolygon {
+
+	min, max := float64(-tileBuffer), float64(tileExtent+tileBuffer)
+	clip := Ring{{min, min}, {max, min}, {max, max}, {min, max}}
+	geometry := MultiPolygon{}
+
+	for _, polygon := range m {
+
+		clipped := Polygon{}
+
+		for i, ring := range polygon {
+
+			ring = clipRingConvex(ring.Transform(t.project), clip)
+			ring = ring.Simplify(tileTolerance)
+
+			for j := range ring {
+				ring[j] = Point{math.Round(ring[j].X), math.Round(ring[j].Y)}
+			}
+
+			ring = ring.open()
+			area := ring.signedArea()
+
+			if len(ring) < 3 || math.Abs(area) < tileMinArea {
+
+				// Drop the holes of an outer Ring that is too small
+				if i == 0 {
+					break
+				}
+
+				continue
+			}
+
+			if (i == 0) != (area > 0) {
+				ring = ring.reverse()
+			}
+
+			clipped = append(clipped, ring)
+		}
+
+		if len(clipped) > 0 {
+			geometry = append(geometry, clipped)
+		}
+	}
+
+	return geometry
+}
+
+// EncodeTile returns the Mapbox Vector Tile for the Tile, holding a layer
+// named "zones" with the boundary of each Feature that overlaps it and the
+// zone, district and population of the Feature as properties. Boundaries
+// are simplified to the detail that can be seen at the zoom level of the
+// Tile. The tile is empty if no Features overlap it.
+func (i *Index) EncodeTile(t Tile) []byte {
+
+	layer := newTileLayer("zones")
+
+	for _, feature := range i.Search(t.Bounds()) {
+
+		geometry := t.tileGeometry(feature.Geometry)
+
+		if len(geometry) == 0 {
+			continue
+		}
+
+		layer.addFeature(geometry, []tileProperty{
+			{"zone", feature.Zone},
+			{"district", feature.District},
+			{"population", feature.Population},
+		})
+	}
+
+	if len(layer.features) == 0 {
+		return []byte{}
+	}
+
+	var tile protobuf
+	tile.message(3, layer.encode())
+
+	return tile
+}
+
+// ZoomTolerance returns the tolerance in degrees for simplifying boundaries
+// drawn on a map at the zoom level, which is half the height of a pixel at
+// the latitude of Great Britain. A degree of latitude is taller than a
+// degree of longitude is wide on the map, so this is less than half the
+// width of a pixel.
+func ZoomTolerance(zoom int) float64 {
+
+	pixel := 360 / (256 * math.Exp2(float64(zoom)))
+	return pixel * math.Cos(54*math.Pi/180) / 2
+}
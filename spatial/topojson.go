@@ -0,0 +1,147 @@
+package spatial
+
+import (
+	"math"
+)
+
+// TopoJSON is a TopoJSON Topology, which stores the arcs shared by its
+// geometries once rather than repeating them in each geometry.
+type TopoJSON struct {
+	Type      string                     `json:"type"`
+	Transform *TopoJSONTransform         `json:"transform,omitempty"`
+	Objects   map[string]*TopoJSONObject `json:"objects"`
+	Arcs      [][][2]float64             `json:"arcs"`
+}
+
+// TopoJSONTransform converts the quantized positions of a TopoJSON Topology
+// back to coordinates, by multiplying them by the scale and adding the
+// translation.
+type TopoJSONTransform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+// TopoJSONObject is a GeometryCollection in a TopoJSON Topology.
+type TopoJSONObject struct {
+	Type       string              `json:"type"`
+	Geometries []*TopoJSONGeometry `json:"geometries"`
+}
+
+// TopoJSONGeometry is a MultiPolygon in a TopoJSON Topology, whose Rings are
+// lists of references to its arcs.
+type TopoJSONGeometry struct {
+	Type       string                 `json:"type"`
+	Arcs       [][][]int              `json:"arcs"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// TopoJSON returns the Topology as a TopoJSON Topology holding a single
+// GeometryCollection with the given name, with the properties given for
+// each geometry in the same order. If the precision is more than zero the
+// arcs are quantized: each position is rounded to a whole number of steps
+// of the precision in degrees from the south west corner of the arcs,
+// which is also rounded to the precision, so Topologies quantized with the
+// same precision share the positions of the points they share. Quantized
+// arcs are delta-encoded, with each position after the first relative to
+// the one before, as TopoJSON requires.
+func (t *Topology) TopoJSON(name string,
+	properties []map[string]interface{}, precision float64) *TopoJSON {
+
+	topology := &TopoJSON{
+		Type:    "Topology",
+		Objects: map[string]*TopoJSONObject{},
+		Arcs:    make([][][2]float64, len(t.Arcs)),
+	}
+
+	object := &TopoJSONObject{
+		Type:       "GeometryCollection",
+		Geometries: make([]*TopoJSONGeometry, len(t.Geometries)),
+	}
+
+	for i, polygons := range t.Geometries {
+
+		object.Geometries[i] = &TopoJSONGeometry{
+			Type: "MultiPolygon",
+			Arcs: polygons,
+		}
+
+		if i < len(properties) {
+			object.Geometries[i].Properties = properties[i]
+		}
+	}
+
+	topology.Objects[name] = object
+
+	if precision <= 0 {
+
+		for i, arc := range t.Arcs {
+
+			topology.Arcs[i] = make([][2]float64, len(arc))
+
+			for j, p := range arc {
+				topology.Arcs[i][j] = [2]float64{p.X, p.Y}
+			}
+		}
+
+		return topology
+	}
+
+	bounds := emptyBounds()
+
+	for _, arc := range t.Arcs {
+		bounds = bounds.Extend(arc.Bounds())
+	}
+
+	origin := Point{}
+
+	if !bounds.IsEmpty() {
+
+		origin = Point{math.Floor(bounds.Min.X/precision) * precision,
+			math.Floor(bounds.Min.Y/precision) * precision}
+	}
+
+	topology.Transform = &TopoJSONTransform{
+		Scale:     [2]float64{precision, precision},
+		Translate: [2]float64{origin.X, origin.Y},
+	}
+
+	for i, arc := range t.Arcs {
+		topology.Arcs[i] = quantizeArc(arc, origin, precision)
+	}
+
+	return topology
+}
+
+// quantizeArc returns the positions of the arc quantized to steps of the
+// precision from the origin and delta-encoded. Positions that quantize to
+// the same position as the one before are left out, but an arc always
+// keeps at least two positions.
+func quantizeArc(arc Ring, origin Point, precision float64) [][2]float64 {
+
+	positions := [][2]float64{}
+	var x, y float64
+
+	for i, p := range arc {
+
+		qx := math.Round((p.X - origin.X) / precision)
+		qy := math.Round((p.Y - origin.Y) / precision)
+
+		if i > 0 && qx == x && qy == y {
+			continue
+		}
+
+		if i == 0 {
+			positions = append(positions, [2]float64{qx, qy})
+		} else {
+			positions = append(positions, [2]float64{qx - x, qy - y})
+		}
+
+		x, y = qx, qy
+	}
+
+	if len(positions) == 1 {
+		positions = append(positions, [2]float64{0, 0})
+	}
+
+	return positions
+}
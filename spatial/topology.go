@@ -0,0 +1,475 @@
+package spatial
+
+// Topology holds a set of MultiPolygons as arcs, the lines between the
+// junctions where the boundaries of three or more Rings meet, or where a
+// boundary stops being shared. An arc shared by neighbouring geometries is
+// stored once, so simplifying the arcs rather than each Ring keeps the
+// boundaries of neighbours identical, without gaps or overlaps.
+//
+// Each Ring of a geometry is a list of arc references. A reference i is to
+// Arcs[i], and a reference ^i, which is negative, is to Arcs[i] reversed,
+// following TopoJSON. Arcs run from one junction to the next, so the last
+// point of each arc in a Ring is the first point of the next. A Ring with
+// no junctions is a single arc whose last point repeats its first.
+type Topology struct {
+	Arcs       []Ring
+	Geometries [][][][]int
+}
+
+// arcKey is the ends and length of an arc, which find the arcs that may be
+// the same as another.
+type arcKey struct {
+	first  Point
+	second Point
+	last   Point
+	length int
+}
+
+// NewTopology returns the Topology of the MultiPolygons, with the arcs for
+// the geometries in the same order. Points are first rounded to the nearest
+// multiple of the precision, so that the points of neighbouring geometries
+// that differ only by rounding errors are joined. A precision of zero keeps
+// the points as they are.
+func NewTopology(geometries []MultiPolygon, precision float64) *Topology {
+
+	geometries = snapGeometries(geometries, precision)
+
+	topology := &Topology{
+		Arcs:       []Ring{},
+		Geometries: make([][][][]int, len(geometries)),
+	}
+
+	junctions := findJunctions(geometries)
+	arcs := map[arcKey][]int{}
+
+	for g, geometry := range geometries {
+
+		polygons := [][][]int{}
+
+		for _, polygon := range geometry {
+
+			rings := [][]int{}
+
+			for _, ring := range polygon {
+
+				refs := []int{}
+
+				for _, arc := range splitRing(ring.open(), junctions) {
+					refs = append(refs, topology.addArc(arc, arcs))
+				}
+
+				rings = append(rings, refs)
+			}
+
+			polygons = append(polygons, rings)
+		}
+
+		topology.Geometries[g] = polygons
+	}
+
+	return topology
+}
+
+// findJunctions returns the points where the boundaries of the geometries
+// meet, which have more than two different neighbours along the Rings
+// they are in.
+func findJunctions(geometries []MultiPolygon) map[Point]bool {
+
+	// Keep the first two neighbours of each point, which is enough to tell
+	// whether it has more
+	type neighbours struct {
+		points [2]Point
+		count  int
+	}
+
+	found := map[Point]neighbours{}
+	junctions := map[Point]bool{}
+
+	add := func(p Point, neighbour Point) {
+
+		n := found[p]
+
+		for i := 0; i < n.count; i++ {
+
+			if n.points[i] == neighbour {
+				return
+			}
+		}
+
+		if n.count == 2 {
+			junctions[p] = true
+			return
+		}
+
+		n.points[n.count] = neighbour
+		n.count++
+		found[p] = n
+	}
+
+	for _, geometry := range geometries {
+
+		for _, polygon := range geometry {
+
+			for _, ring := range polygon {
+
+				points := ring.open()
+
+				for i, p := range points {
+
+					if len(points) < 2 {
+						break
+					}
+
+					add(p, points[(i+len(points)-1)%len(points)])
+					add(p, points[(i+1)%len(points)])
+				}
+			}
+		}
+	}
+
+	return junctions
+}
+
+// splitRing returns the arcs of an open Ring, split at its junctions. If
+// the Ring has no junctions it is returned closed as a single arc, starting
+// at its lowest point, so that Rings with the same points give the same
+// arc whichever point they start at.
+func splitRing(points Ring, junctions map[Point]bool) []Ring {
+
+	if len(points) == 0 {
+		return []Ring{}
+	}
+
+	start := -1
+
+	for i, p := range points {
+
+		if junctions[p] {
+			start = i
+			break
+		}
+	}
+
+	if start == -1 {
+
+		lowest := 0
+
+		for i, p := range points {
+
+			if lessPoint(p, points[lowest]) {
+				lowest = i
+			}
+		}
+
+		closed := append(Ring{}, points[lowest:]...)
+		closed = append(closed, points[:lowest]...)
+		return []Ring{append(closed, closed[0])}
+	}
+
+	arcs := []Ring{}
+	arc := Ring{points[start]}
+
+	for i := 1; i <= len(points); i++ {
+
+		p := points[(start+i)%len(points)]
+		arc = append(arc, p)
+
+		if junctions[p] || i == len(points) {
+
+			arcs = append(arcs, arc)
+			arc = Ring{p}
+		}
+	}
+
+	return arcs
+}
+
+// addArc returns the reference to the arc in the Topology, adding it if
+// the Topology does not already hold it in either direction. The arcs map
+// lists the arcs with each key.
+func (t *Topology) addArc(arc Ring, arcs map[arcKey][]int) int {
+
+	reversed := false
+	n := len(arc)
+
+	// Store arcs in the direction that starts at the lower end, or for
+	// arcs that end where they start, the lower second point
+	if lessPoint(arc[n-1], arc[0]) ||
+		(arc[n-1] == arc[0] && n > 2 && lessPoint(arc[n-2], arc[1])) {
+
+		arc = arc.reverse()
+		reversed = true
+	}
+
+	key := arcKey{arc[0], arc[1%n], arc[n-1], n}
+
+	for _, i := range arcs[key] {
+
+		if equalRings(t.Arcs[i], arc) {
+
+			if reversed {
+				return ^i
+			}
+
+			return i
+		}
+	}
+
+	i := len(t.Arcs)
+	t.Arcs = append(t.Arcs, arc)
+	arcs[key] = append(arcs[key], i)
+
+	if reversed {
+		return ^i
+	}
+
+	return i
+}
+
+// Simplify returns a Topology with each arc simplified to the tolerance
+// with the Douglas-Peucker algorithm, keeping the junctions at the ends of
+// the arcs so that neighbouring geometries still meet. The tolerance is in
+// the units of the points.
+func (t *Topology) Simplify(tolerance float64) *Topology {
+
+	arcs := make([]Ring, len(t.Arcs))
+
+	for i := range t.Arcs {
+		arcs[i] = t.SimplifyArc(i, tolerance)
+	}
+
+	return &Topology{Arcs: arcs, Geometries: t.Geometries}
+}
+
+// SimplifyArc returns the arc with the given index simplified to the
+// tolerance, as in Simplify. Simplifying only the arcs of the geometries
+// that are needed gives the same result as simplifying the Topology.
+func (t *Topology) SimplifyArc(i int, tolerance float64) Ring {
+
+	arc := t.Arcs[i]
+	n := len(arc)
+
+	if n > 1 && arc[0] == arc[n-1] {
+
+		simplified := arc.Simplify(tolerance)
+
+		if len(simplified) < 4 {
+			return Ring{}
+		}
+
+		return simplified
+	}
+
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+	simplifyLine(arc, 0, n-1, tolerance*tolerance, keep)
+
+	simplified := Ring{}
+
+	for j, p := range arc {
+
+		if keep[j] {
+			simplified = append(simplified, p)
+		}
+	}
+
+	return simplified
+}
+
+// Geometry returns the MultiPolygon with the given index, made from the arcs
+// of the Topology. Rings that have fewer than three points, which may be
+// left by simplifying them, are dropped, along with the Polygons whose
+// outer Rings are dropped.
+func (t *Topology) Geometry(i int) MultiPolygon {
+
+	return t.geometry(i, func(arc int) Ring {
+		return t.Arcs[arc]
+	})
+}
+
+// SimplifyGeometry returns the MultiPolygon with the given index with its
+// arcs simplified to the tolerance, as if the Topology had been simplified.
+func (t *Topology) SimplifyGeometry(i int, tolerance float64) MultiPolygon {
+
+	return t.geometry(i, func(arc int) Ring {
+		return t.SimplifyArc(arc, tolerance)
+	})
+}
+
+// Subset returns a Topology holding only the geometries with the given
+// indices, in that order, and the arcs they use, simplified to the
+// tolerance. A tolerance of zero keeps the arcs as they are. Rings and
+// Polygons are dropped as in Geometry.
+func (t *Topology) Subset(geometries []int, tolerance float64) *Topology {
+
+	subset := &Topology{
+		Arcs:       []Ring{},
+		Geometries: make([][][][]int, len(geometries)),
+	}
+
+	// Simplify each arc once, and number the arcs in the order they are used
+	simplified := map[int]Ring{}
+	numbers := map[int]int{}
+
+	arc := func(i int) Ring {
+
+		points, ok := simplified[i]
+
+		if !ok {
+
+			points = t.Arcs[i]
+
+			if tolerance > 0 {
+				points = t.SimplifyArc(i, tolerance)
+			}
+
+			simplified[i] = points
+		}
+
+		return points
+	}
+
+	for n, g := range geometries {
+
+		subset.Geometries[n] = t.filterRings(g, arc, func(refs []int) {
+
+			for k, ref := range refs {
+
+				i := ref
+
+				if ref < 0 {
+					i = ^ref
+				}
+
+				number, ok := numbers[i]
+
+				if !ok {
+
+					number = len(subset.Arcs)
+					subset.Arcs = append(subset.Arcs, arc(i))
+					numbers[i] = number
+				}
+
+				if ref < 0 {
+					number = ^number
+				}
+
+				refs[k] = number
+			}
+		})
+	}
+
+	return subset
+}
+
+// geometry returns the MultiPolygon with the given index, made from the
+// arcs returned by the function.
+func (t *Topology) geometry(i int, arc func(int) Ring) MultiPolygon {
+
+	multi := MultiPolygon{}
+
+	for _, polygon := range t.filterRings(i, arc, nil) {
+
+		rings := Polygon{}
+
+		for _, refs := range polygon {
+			rings = append(rings, assembleRing(refs, arc))
+		}
+
+		multi = append(multi, rings)
+	}
+
+	return multi
+}
+
+// filterRings returns copies of the arc references of the geometry with the
+// given index, without the Rings made from the arcs returned by the
+// function that have fewer than three points, or the Polygons whose outer
+// Rings have fewer than three points. If renumber is not nil it is called
+// with the references of each Ring that is kept, which it may change.
+func (t *Topology) filterRings(i int, arc func(int) Ring,
+	renumber func([]int)) [][][]int {
+
+	polygons := [][][]int{}
+
+	for _, polygon := range t.Geometries[i] {
+
+		rings := [][]int{}
+
+		for j, refs := range polygon {
+
+			if len(assembleRing(refs, arc).open()) < 3 {
+
+				if j == 0 {
+					break
+				}
+
+				continue
+			}
+
+			refs = append([]int{}, refs...)
+
+			if renumber != nil {
+				renumber(refs)
+			}
+
+			rings = append(rings, refs)
+		}
+
+		if len(rings) > 0 {
+			polygons = append(polygons, rings)
+		}
+	}
+
+	return polygons
+}
+
+// assembleRing returns the Ring made by joining the arcs with the given
+// references, which are returned by the function.
+func assembleRing(refs []int, arc func(int) Ring) Ring {
+
+	ring := Ring{}
+
+	for _, ref := range refs {
+
+		var points Ring
+
+		if ref < 0 {
+			points = arc(^ref).reverse()
+		} else {
+			points = arc(ref)
+		}
+
+		if len(ring) > 0 && len(points) > 0 {
+			points = points[1:]
+		}
+
+		ring = append(ring, points...)
+	}
+
+	return ring
+}
+
+// lessPoint returns true if a is before b, ordered by x and then y.
+func lessPoint(a Point, b Point) bool {
+
+	return a.X < b.X || (a.X == b.X && a.Y < b.Y)
+}
+
+// equalRings returns true if the Rings have the same points in the same
+// order.
+func equalRings(a Ring, b Ring) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
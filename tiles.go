@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/olihawkins/handlers"
+	"github.com/olihawkins/popbuilder/spatial"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxCachedTiles is the largest number of encoded tiles kept in memory.
+const maxCachedTiles = 4096
+
+// encodedTile holds an encoded tile and the same tile compressed with gzip.
+type encodedTile struct {
+	data    []byte
+	gzipped []byte
+}
+
+// TileHandler serves vector tiles of the zone boundaries.
+type TileHandler struct {
+	index           *spatial.Index
+	notFoundHandler *handlers.NotFoundHandler
+	prefix          string
+	cache           map[spatial.Tile]*encodedTile
+	cached          []spatial.Tile
+	cacheLock       sync.Mutex
+}
+
+// NewTileHandler returns a new TileHandler with the values initialised.
+// Requests are expected at the given path prefix. The index holds the zone
+// boundaries, and may be nil if they could not be loaded.
+func NewTileHandler(prefix string, index *spatial.Index,
+	notFoundHandler *handlers.NotFoundHandler) *TileHandler {
+
+	return &TileHandler{
+		index:           index,
+		notFoundHandler: notFoundHandler,
+		prefix:          prefix,
+		cache:           map[spatial.Tile]*encodedTile{},
+	}
+}
+
+// ServeHTTP serves the Mapbox Vector Tile whose zoom level and position
+// follow the path prefix, as in /tiles/{z}/{x}/{y}.mvt. Each tile has a
+// layer named "zones" holding the boundaries of the zones that overlap it,
+// simplified for its zoom level, with the zone, district and population of
+// each zone as properties. Tiles are compressed with gzip when the client
+// accepts it, and the most recently generated tiles are kept in memory with
+// and without compression.
+func (h *TileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if h.index == nil {
+
+		http.Error(w, "The zone boundaries are not available.",
+			http.StatusServiceUnavailable)
+
+		return
+	}
+
+	var tile spatial.Tile
+	path := strings.TrimPrefix(r.URL.Path, h.prefix)
+	n, err := fmt.Sscanf(path, "%d/%d/%d.mvt", &tile.Z, &tile.X, &tile.Y)
+
+	if err != nil || n != 3 || !tile.Valid() ||
+		path != fmt.Sprintf("%d/%d/%d.mvt", tile.Z, tile.X, tile.Y) {
+
+		h.notFoundHandler.ServeHTTP(w, r)
+		return
+	}
+
+	encoded := h.getTile(tile)
+
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Vary", "Accept-Encoding")
+
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+
+		w.Write(encoded.data)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Write(encoded.gzipped)
+}
+
+// getTile returns the encoded tile from the cache, or encodes and compresses
+// it and adds it to the cache, removing the oldest tile if the cache is
+// full.
+func (h *TileHandler) getTile(tile spatial.Tile) *encodedTile {
+
+	h.cacheLock.Lock()
+	encoded, ok := h.cache[tile]
+	h.cacheLock.Unlock()
+
+	if ok {
+		return encoded
+	}
+
+	data := h.index.EncodeTile(tile)
+
+	var buffer bytes.Buffer
+	writer, _ := gzip.NewWriterLevel(&buffer, gzip.BestSpeed)
+	writer.Write(data)
+	writer.Close()
+
+	encoded = &encodedTile{data: data, gzipped: buffer.Bytes()}
+
+	h.cacheLock.Lock()
+	defer h.cacheLock.Unlock()
+
+	if _, ok := h.cache[tile]; !ok {
+
+		if len(h.cached) >= maxCachedTiles {
+
+			delete(h.cache, h.cached[0])
+			h.cached = h.cached[1:]
+		}
+
+		h.cache[tile] = encoded
+		h.cached = append(h.cached, tile)
+	}
+
+	return encoded
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/olihawkins/handlers"
+	"github.com/olihawkins/popbuilder/spatial"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test TileHandler serves vector tiles, compressed when accepted, and
+// rejects paths that are not tiles.
+func TestTileHandler(t *testing.T) {
+
+	index := spatial.NewIndex([]*spatial.Feature{
+		spatial.NewFeature("E01000001", "", 0, spatial.MultiPolygon{
+			spatial.Polygon{spatial.Ring{{X: -0.001, Y: -0.001},
+				{X: 0.009, Y: -0.001}, {X: 0.009, Y: 0.009},
+				{X: -0.001, Y: 0.009}}}})})
+
+	notFoundHandler := handlers.LoadNotFoundHandler(notFoundPath)
+	h := NewTileHandler("/tiles/", index, notFoundHandler)
+
+	// serve requests a path with the given Accept-Encoding header
+	serve := func(path string, encoding string) *httptest.ResponseRecorder {
+
+		request, _ := http.NewRequest("GET", path, nil)
+		request.Header.Set("Accept-Encoding", encoding)
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+
+		return response
+	}
+
+	response := serve("/tiles/16/32768/32767.mvt", "")
+	tile := response.Body.Bytes()
+
+	if response.Code != http.StatusOK || len(tile) == 0 ||
+		response.Header().Get("Content-Type") !=
+			"application/vnd.mapbox-vector-tile" {
+
+		t.Fatalf("Expected a vector tile from TileHandler. Got: %d %q",
+			response.Code, response.Body.String())
+	}
+
+	response = serve("/tiles/16/32768/32767.mvt", "gzip, deflate")
+	compressed := response.Body.Bytes()
+	reader, err := gzip.NewReader(response.Body)
+
+	if err != nil || response.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a compressed tile from TileHandler.")
+	}
+
+	decompressed, err := ioutil.ReadAll(reader)
+
+	if err != nil || !bytes.Equal(decompressed, tile) {
+		t.Errorf("Expected the compressed tile to match the tile.")
+	}
+
+	// The compressed tile is kept with the tile rather than compressed for
+	// each request
+	cached := h.cache[spatial.Tile{Z: 16, X: 32768, Y: 32767}]
+
+	if cached == nil || !bytes.Equal(cached.gzipped, compressed) {
+		t.Errorf("Expected the compressed tile in the cache.")
+	}
+
+	if response = serve("/tiles/10/0/0.mvt", ""); response.Code !=
+		http.StatusOK || response.Body.Len() != 0 {
+
+		t.Errorf("Expected an empty tile away from the zones. Got: %d %d",
+			response.Code, response.Body.Len())
+	}
+
+	for _, path := range []string{"/tiles/2/4/0.mvt", "/tiles/21/0/0.mvt",
+		"/tiles/1/0/0.png", "/tiles/1/0/0.mvt/x", "/tiles/a/b/c.mvt"} {
+
+		if response = serve(path, ""); response.Code != http.StatusNotFound {
+			t.Errorf("Expected StatusNotFound for %s. Got: %d", path,
+				response.Code)
+		}
+	}
+
+	h = NewTileHandler("/tiles/", nil, notFoundHandler)
+
+	if response = serve("/tiles/1/0/0.mvt", ""); response.Code !=
+		http.StatusServiceUnavailable {
+
+		t.Errorf("Expected StatusServiceUnavailable without boundaries. "+
+			"Got: %d", response.Code)
+	}
+}
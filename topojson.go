@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/olihawkins/popbuilder/spatial"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// runBuildTopoJSON implements the build-topojson command, which converts
+// every popzones file in a directory to TopoJSON at full resolution, for
+// the BoundaryFileHandler to serve to clients that accept it.
+func runBuildTopoJSON(args []string) error {
+
+	flags := flag.NewFlagSet("build-topojson", flag.ContinueOnError)
+	popzones := flags.String("popzones", popzonesDir,
+		"directory of popzones files to convert")
+	outDir := flags.String("out", topozonesDir,
+		"directory to write the TopoJSON files to")
+
+	flags.Usage = func() {
+
+		fmt.Fprintln(flags.Output(), "Usage: popbuilder build-topojson "+
+			"[-popzones DIR] [-out DIR]")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	features, err := spatial.LoadFeatures(*popzones)
+
+	if err != nil {
+		return err
+	}
+
+	if len(features) == 0 {
+		return fmt.Errorf("build-topojson: no popzones files in %s",
+			*popzones)
+	}
+
+	boundaries := newDistrictBoundaries(features)
+	districts := []string{}
+
+	for district := range boundaries.districts {
+		districts = append(districts, district)
+	}
+
+	sort.Strings(districts)
+	var sourceSize, topoSize int64
+
+	for _, district := range districts {
+
+		data, err := json.Marshal(boundaries.topoJSON(district,
+			fullResolution))
+
+		if err != nil {
+			return err
+		}
+
+		err = writeFileAtomically(filepath.Join(*outDir, district+".json"), data)
+
+		if err != nil {
+			return err
+		}
+
+		source, err := os.Stat(filepath.Join(*popzones, district+".json"))
+
+		if err == nil {
+			sourceSize += source.Size()
+		}
+
+		topoSize += int64(len(data))
+	}
+
+	log.Printf("Wrote %d TopoJSON files to %s, %d bytes from %d bytes of "+
+		"GeoJSON", len(districts), *outDir, topoSize, sourceSize)
+
+	return nil
+}
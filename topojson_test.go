@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test runBuildTopoJSON converts each popzones file in a directory.
+func TestRunBuildTopoJSON(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "popzones")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// Two districts, each with a zone, whose zones share an edge
+	for district, zone := range map[string]string{
+		"E09000001": `{"zone": "E01000001", "population": "1500"}, ` +
+			`"geometry": {"type": "Polygon", "coordinates": ` +
+			`[[[0, 0], [0.01, 0], [0.01, 0.01], [0, 0.01], [0, 0]]]}`,
+		"E09000002": `{"zone": "E01000002", "population": "1600"}, ` +
+			`"geometry": {"type": "Polygon", "coordinates": ` +
+			`[[[0.01, 0], [0.02, 0], [0.02, 0.01], [0.01, 0.01], ` +
+			`[0.01, 0]]]}`,
+	} {
+
+		ioutil.WriteFile(filepath.Join(dir, district+".json"), []byte(
+			`{"type": "FeatureCollection", "features": [{"type": "Feature", `+
+				`"properties": `+zone+`}]}`), 0644)
+	}
+
+	outDir := filepath.Join(dir, "topozones")
+	err = runBuildTopoJSON([]string{"-popzones", dir, "-out", outDir})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for district, zone := range map[string]string{
+		"E09000001": "E01000001",
+		"E09000002": "E01000002",
+	} {
+
+		data, err := ioutil.ReadFile(filepath.Join(outDir, district+".json"))
+
+		var topology struct {
+			Type    string
+			Objects map[string]struct {
+				Geometries []struct {
+					Arcs       [][][]int
+					Properties map[string]string
+				}
+			}
+			Arcs [][][2]float64
+		}
+
+		if err == nil {
+			err = json.Unmarshal(data, &topology)
+		}
+
+		// The square is made of the edge it shares and the rest of its
+		// outline
+		if err != nil || topology.Type != "Topology" ||
+			len(topology.Arcs) != 2 ||
+			len(topology.Objects["zones"].Geometries) != 1 ||
+			topology.Objects["zones"].Geometries[0].Properties["zone"] !=
+				zone {
+
+			t.Errorf("Expected a TopoJSON file for %s. Got: %s %v",
+				district, data, err)
+		}
+	}
+
+	err = runBuildTopoJSON([]string{"-popzones", outDir + "-missing"})
+
+	if err == nil {
+		t.Errorf("Expected an error for a directory without popzones files.")
+	}
+}
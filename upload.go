@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxUploadSize is the largest file of zone codes that can be uploaded.
+const maxUploadSize = 10 << 20
+
+// codeHeaders are the names of header columns that are taken to hold zone
+// codes, compared in lower case without spaces or underscores.
+var codeHeaders = []string{
+	"code", "zone", "zonecode", "lsoa", "lsoacode", "lsoa11cd", "lsoa21cd",
+	"datazone", "datazonecode", "datazone2011", "dz2011", "dzcode",
+}
+
+// parseZoneFile reads zone codes from a csv or plain text file. The file may
+// have a header row and extra columns. The column holding the codes is the
+// column whose header names it as a code, or otherwise the first column with
+// a zone code in the first row of data. Files with tabs but no commas on the
+// first line are read as tab separated, and blank lines are skipped.
+func parseZoneFile(file io.Reader) ([]string, error) {
+
+	content, err := ioutil.ReadAll(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove a byte order mark and choose the separator from the first line
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	firstLine, _ := bufio.NewReader(bytes.NewReader(content)).ReadString('\n')
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	if strings.Contains(firstLine, "\t") && !strings.Contains(firstLine, ",") {
+		reader.Comma = '\t'
+	}
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, errors.New("the file could not be read as csv")
+	}
+
+	// Find the column of codes from the header or the first row of data
+	column := -1
+	start := 0
+
+	for i, record := range records {
+
+		if isBlankRecord(record) {
+			continue
+		}
+
+		column = codeColumn(record)
+
+		if column == -1 && start == 0 {
+
+			// Treat the first row without a code as a header
+			column = headerColumn(record)
+			start = i + 1
+
+			if column != -1 {
+				break
+			}
+
+			continue
+		}
+
+		break
+	}
+
+	if column == -1 {
+		return nil, errors.New("the file does not contain any zone codes")
+	}
+
+	// Read the codes from the column, skipping rows that are too short
+	zones := []string{}
+
+	for _, record := range records[start:] {
+
+		if column < len(record) && !isBlankRecord(record) {
+
+			if code := strings.TrimSpace(record[column]); code != "" {
+				zones = append(zones, code)
+			}
+		}
+	}
+
+	if len(zones) == 0 {
+		return nil, errors.New("the file does not contain any zone codes")
+	}
+
+	return zones, nil
+}
+
+// codeColumn returns the index of the first field in the record that is a
+// zone code, or -1 if there is none.
+func codeColumn(record []string) int {
+
+	for i, field := range record {
+
+		code := strings.ToUpper(strings.TrimSpace(field))
+
+		if zonePattern.MatchString(code) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// headerColumn returns the index of the first field in a header record that
+// names a column of zone codes, or -1 if there is none.
+func headerColumn(record []string) int {
+
+	for i, field := range record {
+
+		name := strings.ToLower(strings.TrimSpace(field))
+		name = strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name)
+
+		for _, header := range codeHeaders {
+
+			if name == header {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// isBlankRecord returns true if every field in the record is empty.
+func isBlankRecord(record []string) bool {
+
+	for _, field := range record {
+
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// UploadMap holds the zones read from an uploaded file that were found in the
+// population data, and the districts containing them, so that the map can
+// select them. The report describes how each code in the file was matched.
+type UploadMap struct {
+	Zones     []string    `json:"zones"`
+	Districts []string    `json:"districts"`
+	Report    *ZoneReport `json:"report"`
+}
+
+// UploadHandler handles uploads of files of zone codes.
+type UploadHandler struct {
+	rdb        *ResultsDb
+	results    *ResultsHandler
+	fileForm   string
+	targetForm string
+	mapTarget  string
+}
+
+// NewUploadHandler returns a new UploadHandler with the values initialised.
+func NewUploadHandler(database *ResultsDb,
+	results *ResultsHandler) *UploadHandler {
+
+	return &UploadHandler{
+		rdb:        database,
+		results:    results,
+		fileForm:   "file",
+		targetForm: "target",
+		mapTarget:  "map",
+	}
+}
+
+// ServeHTTP expects a csv or plain text file of zone codes as a multipart
+// POST form, which may have a header row and extra columns. The codes are
+// checked against the population table for the latest year. By default the
+// results page is served for the codes, and any other results parameters
+// such as the year may be given in the form. If the target is "map", the
+// zones that were found and the districts containing them are returned as
+// JSON for the map to select, with a report of how each code was matched,
+// and errors are also reported as JSON.
+func (h *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	asJSON := r.FormValue(h.targetForm) == h.mapTarget
+
+	// serveError reports an error in the form expected by the target
+	serveError := func(status int, message string) {
+
+		if asJSON {
+			writeAPIError(w, status, message)
+		} else {
+			h.results.errorHandler.ServeError(w, message)
+		}
+	}
+
+	if r.Method != "POST" {
+
+		w.Header().Set("Allow", "POST")
+		serveError(http.StatusMethodNotAllowed,
+			"Files of zone codes must be uploaded with a POST request.")
+
+		return
+	}
+
+	// Read the codes from the uploaded file
+	file, _, err := r.FormFile(h.fileForm)
+
+	if err != nil {
+
+		serveError(http.StatusBadRequest,
+			"Could not read the uploaded file of zone codes.")
+
+		return
+	}
+
+	defer file.Close()
+
+	zones, err := parseZoneFile(file)
+
+	if err != nil {
+
+		serveError(http.StatusBadRequest,
+			"Could not read zone codes from the file: "+err.Error()+".")
+
+		return
+	}
+
+	// Serve the results page for the codes
+	if !asJSON {
+
+		r.Form.Set(h.results.zoneForm, strings.Join(zones, ","))
+		h.results.ServeHTTP(w, r)
+		return
+	}
+
+	// Otherwise check the codes and send the zones that were found
+	report, err := h.rdb.CheckZones(zones, h.rdb.LatestYear())
+
+	if err != nil {
+
+		serveError(http.StatusInternalServerError,
+			"Could not check zones against the ResultsDb.")
+
+		return
+	}
+
+	if len(report.Matched) == 0 {
+
+		writeJSON(w, http.StatusBadRequest, &APIError{
+			Error: APIErrorDetail{
+				Status:  http.StatusBadRequest,
+				Message: "None of the uploaded zones could be found.",
+				Report:  report,
+			},
+		})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &UploadMap{
+		Zones:     report.Matched,
+		Districts: selectionDistricts(report.Matched, h.rdb.Geography()),
+		Report:    report,
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"github.com/olihawkins/handlers"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Test parseZoneFile reads codes from plain lists, csv files with headers
+// and extra columns, and tab separated files.
+func TestParseZoneFile(t *testing.T) {
+
+	for _, test := range []struct {
+		file     string
+		expected string
+	}{
+		{"E01000001\nW01000001\n\nS01000001\n",
+			"E01000001,W01000001,S01000001"},
+		{"\xef\xbb\xbfname,LSOA11CD,population\nA,E01000001,10\n" +
+			"B,e01000002,20\n", "E01000001,e01000002"},
+		{"id,area\n1,E01000001\n2,unknown\n", "E01000001,unknown"},
+		{"lsoa code\tname\r\nE01000001\tA\r\nE01000002\tB\r\n",
+			"E01000001,E01000002"},
+		{"\"E01000001\", \"first\"\n\"E01000002\"\n", "E01000001,E01000002"},
+	} {
+
+		zones, err := parseZoneFile(strings.NewReader(test.file))
+
+		if err != nil || strings.Join(zones, ",") != test.expected {
+			t.Errorf("Expected %s from parseZoneFile for %q. Got: %v %v",
+				test.expected, test.file, zones, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "name,value\nA,1\nB,2\n", "code\n"} {
+
+		if _, err := parseZoneFile(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error from parseZoneFile for %q.", invalid)
+		}
+	}
+}
+
+// Test UploadHandler serves the results page for an uploaded file, and sends
+// the matched zones as JSON for the map.
+func TestUploadHandler(t *testing.T) {
+
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	buildTestDbs(t, dir, []string{"E01000001", "W01000001"})
+
+	rdb := NewResultsDb(testDbPath(dir, resultsDbPath))
+	defer rdb.Close()
+
+	errorHandler := handlers.LoadErrorHandler(errorPath, "", true)
+	rh := NewResultsHandler(resultsPath, rdb, nil, nil, errorHandler)
+	h := NewUploadHandler(rdb, rh)
+
+	// upload sends the file contents with the given target
+	upload := func(contents string,
+		target string) *httptest.ResponseRecorder {
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField(h.targetForm, target)
+		part, _ := writer.CreateFormFile(h.fileForm, "zones.csv")
+		part.Write([]byte(contents))
+		writer.Close()
+
+		request, _ := http.NewRequest("POST", "/upload", &body)
+		request.Header.Set("Content-Type", writer.FormDataContentType())
+		response := httptest.NewRecorder()
+
+		h.ServeHTTP(response, request)
+		return response
+	}
+
+	file := "name,lsoa\nA,E01000001\nB,W01000001\nC,E01999999\n"
+	response := upload(file, "")
+
+	if !strings.Contains(response.Body.String(), "The selected population") ||
+		!strings.Contains(response.Body.String(), "E01999999") {
+
+		t.Errorf("Expected the results page from UploadHandler. Got: %s",
+			response.Body.String())
+	}
+
+	response = upload(file, h.mapTarget)
+
+	if response.Code != http.StatusOK || !strings.HasPrefix(
+		response.Body.String(),
+		`{"zones":["E01000001","W01000001"],"districts":[],"report":{`) ||
+		!strings.Contains(response.Body.String(),
+			`"unknown":["E01999999"]`) {
+
+		t.Errorf("Expected the matched zones from UploadHandler. Got: %d %s",
+			response.Code, response.Body.String())
+	}
+
+	response = upload("E01999999\n", h.mapTarget)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest when no zones match. Got: %d",
+			response.Code)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// xlsxWorkbook is the part of xl/workbook.xml that lists the sheets.
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxRelationships is the content of xl/_rels/workbook.xml.rels.
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxSharedStrings is the content of xl/sharedStrings.xml.
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+// xlsxText is a string that may be split into several formatted runs.
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+// String returns the full text of the xlsxText.
+func (t xlsxText) String() string {
+
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+
+	text := ""
+
+	for _, run := range t.Runs {
+		text += run.Text
+	}
+
+	return text
+}
+
+// xlsxRow is a row element from a worksheet.
+type xlsxRow struct {
+	Cells []struct {
+		Ref    string   `xml:"r,attr"`
+		Type   string   `xml:"t,attr"`
+		Value  string   `xml:"v"`
+		Inline xlsxText `xml:"is"`
+	} `xml:"c"`
+}
+
+// xlsxFile provides read access to the sheets of an xlsx workbook. It reads
+// cell values only, which is enough for the tabular data exports published
+// by ONS and NRS.
+type xlsxFile struct {
+	reader  *zip.ReadCloser
+	files   map[string]*zip.File
+	sheets  map[string]string
+	names   []string
+	strings []string
+}
+
+// openXLSX opens the xlsx workbook at the given path.
+func openXLSX(filePath string) (*xlsxFile, error) {
+
+	reader, err := zip.OpenReader(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	x := &xlsxFile{
+		reader: reader,
+		files:  map[string]*zip.File{},
+		sheets: map[string]string{},
+	}
+
+	for _, f := range reader.File {
+		x.files[f.Name] = f
+	}
+
+	err = x.readIndex()
+
+	if err != nil {
+
+		reader.Close()
+		return nil, err
+	}
+
+	return x, nil
+}
+
+// Close closes the underlying zip file.
+func (x *xlsxFile) Close() {
+
+	x.reader.Close()
+}
+
+// SheetNames returns the names of the sheets in the workbook in order.
+func (x *xlsxFile) SheetNames() []string {
+
+	return x.names
+}
+
+// ReadSheet returns the cell values of the named sheet as rows of strings.
+// Empty cells are returned as empty strings.
+func (x *xlsxFile) ReadSheet(name string) ([][]string, error) {
+
+	sheetPath, ok := x.sheets[name]
+
+	if !ok {
+		return nil, errors.New("xlsx: no sheet named " + name)
+	}
+
+	f, ok := x.files[sheetPath]
+
+	if !ok {
+		return nil, errors.New("xlsx: missing worksheet " + sheetPath)
+	}
+
+	r, err := f.Open()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	// Decode one row at a time, as sheets can be very large
+	rows := [][]string{}
+	decoder := xml.NewDecoder(r)
+
+	for {
+
+		token, err := decoder.Token()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		var row xlsxRow
+		err = decoder.DecodeElement(&row, &start)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values := []string{}
+
+		for i, cell := range row.Cells {
+
+			// Place the cell in its column, leaving gaps for empty cells
+			column := xlsxColumn(cell.Ref)
+
+			if column < 0 {
+				column = i
+			}
+
+			for len(values) <= column {
+				values = append(values, "")
+			}
+
+			values[column] = x.cellValue(cell.Type, cell.Value, cell.Inline)
+		}
+
+		rows = append(rows, values)
+	}
+
+	return rows, nil
+}
+
+// readIndex reads the sheet names, sheet paths and shared strings.
+func (x *xlsxFile) readIndex() error {
+
+	var workbook xlsxWorkbook
+	var relationships xlsxRelationships
+	var sharedStrings xlsxSharedStrings
+
+	err := x.decodeFile("xl/workbook.xml", &workbook)
+
+	if err != nil {
+		return err
+	}
+
+	err = x.decodeFile("xl/_rels/workbook.xml.rels", &relationships)
+
+	if err != nil {
+		return err
+	}
+
+	// The shared strings file is absent if the workbook has no strings
+	if _, ok := x.files["xl/sharedStrings.xml"]; ok {
+
+		err = x.decodeFile("xl/sharedStrings.xml", &sharedStrings)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	targets := map[string]string{}
+
+	for _, rel := range relationships.Relationships {
+
+		target := strings.TrimPrefix(rel.Target, "/")
+
+		if !strings.HasPrefix(target, "xl/") {
+			target = path.Join("xl", target)
+		}
+
+		targets[rel.ID] = target
+	}
+
+	for _, sheet := range workbook.Sheets {
+
+		x.names = append(x.names, sheet.Name)
+		x.sheets[sheet.Name] = targets[sheet.ID]
+	}
+
+	for _, item := range sharedStrings.Items {
+		x.strings = append(x.strings, item.String())
+	}
+
+	return nil
+}
+
+// decodeFile decodes the named xml file in the workbook into v.
+func (x *xlsxFile) decodeFile(name string, v interface{}) error {
+
+	f, ok := x.files[name]
+
+	if !ok {
+		return errors.New("xlsx: missing " + name)
+	}
+
+	r, err := f.Open()
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// cellValue returns the string value of a cell given its type.
+func (x *xlsxFile) cellValue(cellType string, value string,
+	inline xlsxText) string {
+
+	switch cellType {
+
+	case "s":
+
+		index := 0
+
+		for _, c := range value {
+			index = index*10 + int(c-'0')
+		}
+
+		if index >= 0 && index < len(x.strings) {
+			return x.strings[index]
+		}
+
+		return ""
+
+	case "inlineStr":
+
+		return inline.String()
+
+	default:
+
+		return value
+	}
+}
+
+// xlsxColumn returns the zero based column index of a cell reference such as
+// "C12", or -1 if the reference is missing.
+func xlsxColumn(ref string) int {
+
+	column := 0
+	letters := 0
+
+	for _, c := range ref {
+
+		if c < 'A' || c > 'Z' {
+			break
+		}
+
+		column = column*26 + int(c-'A') + 1
+		letters++
+	}
+
+	if letters == 0 {
+		return -1
+	}
+
+	return column - 1
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// defaultYear is the estimate year of databases built before the population
+// table had a year column, which hold the estimates for a single year.
+const defaultYear = 2020
+
+// populationYears describes the estimate years held in a population table.
+// It is embedded in ResultsDb and DownloadDb.
+type populationYears struct {
+	hasYearColumn bool
+	years         []int
+}
+
+// readPopulationYears returns the estimate years in the population table of
+// the database in ascending order.
+func readPopulationYears(db *sql.DB) (*populationYears, error) {
+
+	p := &populationYears{}
+
+	// Check whether the population table has a year column
+	rows, err := db.Query("PRAGMA table_info(population)")
+
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+
+		rows.Close()
+		return nil, err
+	}
+
+	for rows.Next() {
+
+		// Scan every column of the table info but keep only the name
+		values := make([]interface{}, len(columns))
+		var name string
+
+		for i := range values {
+
+			if columns[i] == "name" {
+				values[i] = &name
+			} else {
+				values[i] = new(interface{})
+			}
+		}
+
+		err = rows.Scan(values...)
+
+		if err != nil {
+
+			rows.Close()
+			return nil, err
+		}
+
+		if name == "year" {
+			p.hasYearColumn = true
+		}
+	}
+
+	rows.Close()
+
+	if !p.hasYearColumn {
+
+		p.years = []int{defaultYear}
+		return p, nil
+	}
+
+	// Get the distinct years in the table
+	rows, err = db.Query("SELECT DISTINCT year FROM population ORDER BY year")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var year int
+		err = rows.Scan(&year)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.years = append(p.years, year)
+	}
+
+	return p, rows.Err()
+}
+
+// Years returns the estimate years held in the database in ascending order.
+func (p *populationYears) Years() []int {
+
+	return p.years
+}
+
+// LatestYear returns the most recent estimate year held in the database.
+func (p *populationYears) LatestYear() int {
+
+	if len(p.years) == 0 {
+		return defaultYear
+	}
+
+	return p.years[len(p.years)-1]
+}
+
+// HasYear returns true if the database holds estimates for the given year.
+func (p *populationYears) HasYear(year int) bool {
+
+	for _, y := range p.years {
+
+		if y == year {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseYear returns the year given in a request parameter, or the latest
+// year if the parameter is empty. An error is returned if the database does
+// not hold estimates for the year.
+func (p *populationYears) ParseYear(value string) (int, error) {
+
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return p.LatestYear(), nil
+	}
+
+	year, err := strconv.Atoi(value)
+
+	if err != nil || !p.HasYear(year) {
+		return 0, errors.New("population estimates are not available " +
+			"for the year " + value)
+	}
+
+	return year, nil
+}
+
+// yearCondition returns a condition restricting a query of the population
+// table to the given year, for use before "code IN (", and its arguments.
+func (p *populationYears) yearCondition(year int) (string, []interface{}) {
+
+	if !p.hasYearColumn {
+		return "", nil
+	}
+
+	return "year = ? AND ", []interface{}{year}
+}
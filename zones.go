@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// maxQueryZones is the largest number of zone codes bound to a single query.
+// SQLite limits the number of host parameters in a statement, to 999 in
+// versions before 3.32.0, so larger selections are queried in chunks.
+const maxQueryZones = 500
+
+// zonePattern matches the codes of LSOAs in England and Wales and of Data
+// Zones in Scotland.
+var zonePattern = regexp.MustCompile(`^(E01|W01|S01)[0-9]{6}$`)
+
+// ZoneReport describes how a requested set of zone codes was matched against
+// the population table. Each list holds codes in the order first requested.
+// Areas holds the codes of any countries, regions and districts that were
+// requested, whose zones are reported in place of the area code.
+type ZoneReport struct {
+	Matched    []string `json:"matched"`
+	Unknown    []string `json:"unknown"`
+	Duplicated []string `json:"duplicated"`
+	Malformed  []string `json:"malformed"`
+	Areas      []string `json:"areas"`
+	statuses   map[string]string
+	counted    int
+}
+
+// HasProblems returns true if any requested codes were not simply matched.
+func (z *ZoneReport) HasProblems() bool {
+
+	return len(z.Unknown) > 0 || len(z.Duplicated) > 0 || len(z.Malformed) > 0
+}
+
+// Status returns a one word description of how the given code was matched.
+// The status of every code is found once, and found again only if codes
+// have been added to the report since, so that a status can be given for
+// each row of a large download.
+func (z *ZoneReport) Status(code string) string {
+
+	lists := []struct {
+		codes  []string
+		status string
+	}{
+		{z.Matched, "matched"},
+		{z.Duplicated, "duplicated"},
+		{z.Unknown, "unknown"},
+		{z.Malformed, "malformed"},
+	}
+
+	counted := 0
+
+	for _, list := range lists {
+		counted += len(list.codes)
+	}
+
+	if z.statuses == nil || counted != z.counted {
+
+		// Later lists take precedence over earlier ones for codes in both
+		z.statuses = make(map[string]string, counted)
+		z.counted = counted
+
+		for _, list := range lists {
+
+			for _, c := range list.codes {
+				z.statuses[c] = list.status
+			}
+		}
+	}
+
+	return z.statuses[code]
+}
+
+// parseZoneCodes normalises the requested codes and returns the unique well
+// formed codes in a new ZoneReport. Matched and Unknown are left empty, and
+// should be filled in by checking the codes against the population table.
+func parseZoneCodes(zones []string) ([]string, *ZoneReport) {
+
+	report := &ZoneReport{
+		Matched:    []string{},
+		Unknown:    []string{},
+		Duplicated: []string{},
+		Malformed:  []string{},
+		Areas:      []string{},
+	}
+
+	codes := []string{}
+	seen := map[string]int{}
+
+	for _, zone := range zones {
+
+		code := strings.ToUpper(strings.TrimSpace(zone))
+
+		if code == "" {
+			continue
+		}
+
+		// Record duplicates only once however often they are repeated
+		seen[code]++
+
+		if seen[code] > 1 {
+
+			if seen[code] == 2 {
+				report.Duplicated = append(report.Duplicated, code)
+			}
+
+			continue
+		}
+
+		if !zonePattern.MatchString(code) {
+
+			report.Malformed = append(report.Malformed, code)
+			continue
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, report
+}
+
+// checkZones returns a ZoneReport for the requested codes, using the given
+// database to find which of the well formed codes are in the population table
+// for the given year. The codes of any areas in the geography are first
+// expanded into the zones they contain.
+func checkZones(db *sql.DB, years *populationYears, geography *Geography,
+	year int, zones []string) (*ZoneReport, error) {
+
+	zones, areas := geography.ExpandCodes(zones)
+	codes, report := parseZoneCodes(zones)
+	report.Areas = areas
+
+	if len(codes) == 0 {
+		return report, nil
+	}
+
+	// Query each chunk of codes and record the codes that were found
+	found := map[string]bool{}
+	condition, conditionArgs := years.yearCondition(year)
+
+	for _, chunk := range chunkZones(codes) {
+
+		query, args := inQuery("SELECT code FROM population WHERE "+
+			condition+"code IN (", chunk, conditionArgs...)
+
+		err := findCodes(db, query, args, found)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Sort the codes into matched and unknown in the order requested
+	for _, code := range codes {
+
+		if found[code] {
+			report.Matched = append(report.Matched, code)
+		} else {
+			report.Unknown = append(report.Unknown, code)
+		}
+	}
+
+	return report, nil
+}
+
+// findCodes executes a query returning a column of codes and records each
+// code in the found map.
+func findCodes(db *sql.DB, query string, args []interface{},
+	found map[string]bool) error {
+
+	rows, err := db.Query(query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var code string
+		err := rows.Scan(&code)
+
+		if err != nil {
+			return err
+		}
+
+		found[code] = true
+	}
+
+	return rows.Err()
+}
+
+// chunkZones splits the zones into slices of at most maxQueryZones codes.
+func chunkZones(zones []string) [][]string {
+
+	chunks := [][]string{}
+
+	for start := 0; start < len(zones); start += maxQueryZones {
+
+		end := start + maxQueryZones
+
+		if end > len(zones) {
+			end = len(zones)
+		}
+
+		chunks = append(chunks, zones[start:end])
+	}
+
+	return chunks
+}
+
+// inQuery completes a base query ending in "IN (" with a placeholder for each
+// zone, and returns the query with an interface slice of args to pass to Query.
+// Any args for placeholders in the base query are placed before the zones.
+func inQuery(baseQuery string, zones []string,
+	baseArgs ...interface{}) (string, []interface{}) {
+
+	query := baseQuery
+	args := append([]interface{}{}, baseArgs...)
+
+	for i := 0; i < len(zones); i++ {
+
+		query += "?,"
+		args = append(args, zones[i])
+	}
+
+	query = query[:len(query)-1] + ")"
+
+	return query, args
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Test ResultsDb.CheckZones with a mix of matched, unknown, duplicated and
+// malformed codes. This test implicitly tests parseZoneCodes() and
+// checkZones().
+func TestCheckZones(t *testing.T) {
+
+	zones := []string{
+		"E01004731",
+		" e01004732 ",
+		"E01004731",
+		"E01004731",
+		"E01999999",
+		"S01006506",
+		"X01004731",
+		"E0100473",
+		"",
+	}
+
+	expected := &ZoneReport{
+		Matched:    []string{"E01004731", "E01004732", "S01006506"},
+		Unknown:    []string{"E01999999"},
+		Duplicated: []string{"E01004731"},
+		Malformed:  []string{"X01004731", "E0100473"},
+		Areas:      []string{},
+	}
+
+	// Create a ResultsDb
+	rdb := NewResultsDb(resultsDbPath)
+	defer rdb.Close()
+
+	report, err := rdb.CheckZones(zones, rdb.LatestYear())
+
+	if err != nil {
+		t.Fatalf("Could not check zones against ResultsDb.")
+	}
+
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected %v from ResultsDb.CheckZones. Got: %v",
+			expected, report)
+	}
+
+	// Check the status reported for each kind of code
+	statuses := map[string]string{
+		"E01004731": "duplicated",
+		"E01004732": "matched",
+		"E01999999": "unknown",
+		"X01004731": "malformed",
+		"W01000001": "",
+	}
+
+	for code, status := range statuses {
+
+		if report.Status(code) != status {
+			t.Errorf("Expected status %s for %s. Got: %s",
+				status, code, report.Status(code))
+		}
+	}
+
+	// Codes added after a status has been found are also reported
+	report.Unknown = append(report.Unknown, "W01000001")
+
+	if report.Status("W01000001") != "unknown" {
+		t.Errorf("Expected status unknown for a code added to the report. "+
+			"Got: %s", report.Status("W01000001"))
+	}
+
+	// Check that unmatched codes give a zero population rather than an error
+	results, err := rdb.GetPopulationData([]string{"E01999999", "X01004731"})
+
+	if err != nil || results.Population != "0" {
+		t.Errorf("Expected a zero population for unmatched codes. Got: %v",
+			results)
+	}
+}
+
+// Test chunkZones splits selections into chunks no larger than the limit.
+func TestChunkZones(t *testing.T) {
+
+	sizes := []int{0, 1, maxQueryZones, maxQueryZones + 1, maxQueryZones*3 + 7}
+	expected := []int{0, 1, 1, 2, 4}
+
+	for i, size := range sizes {
+
+		zones := make([]string, size)
+		chunks := chunkZones(zones)
+		count := 0
+
+		for _, chunk := range chunks {
+
+			if len(chunk) > maxQueryZones {
+				t.Errorf("Expected chunks of at most %d zones. Got: %d",
+					maxQueryZones, len(chunk))
+			}
+
+			count += len(chunk)
+		}
+
+		if len(chunks) != expected[i] || count != size {
+			t.Errorf("Expected %d chunks holding %d zones. Got: %d holding %d",
+				expected[i], size, len(chunks), count)
+		}
+	}
+}